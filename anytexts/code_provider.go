@@ -616,6 +616,13 @@ func (c CodeProvider) Parts(
 
 	}
 
+	// The file contents are the stable prefix; mark its end for providers
+	// with explicit prompt caching. See
+	// generators.TheoryOfCacheBreakpoints.
+	if len(parts) > 0 {
+		parts = append(parts, generators.CacheBreakpoint{})
+	}
+
 	// The working directory hint is appended after all file contents so
 	// the model can construct correct absolute paths for change block
 	// file-path attributes. The path is dynamic — it changes per
@@ -32,10 +32,15 @@ func TestContextPrompt(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		// The parts are the file contents followed by the working
-		// directory hint. See TheoryOfWorkingDirectoryHint.
-		if len(parts) != 2 {
-			t.Fatalf("expected 2 parts (file content, working directory hint), got %d", len(parts))
+		// The parts are the file contents, the cache breakpoint ending
+		// the stable prefix, and the working directory hint. See
+		// TheoryOfWorkingDirectoryHint and
+		// generators.TheoryOfCacheBreakpoints.
+		if len(parts) != 3 {
+			t.Fatalf("expected 3 parts (file content, cache breakpoint, working directory hint), got %d", len(parts))
+		}
+		if _, ok := parts[1].(generators.CacheBreakpoint); !ok {
+			t.Fatalf("expected cache breakpoint after file contents, got %#v", parts[1])
 		}
 		text, ok := parts[0].(generators.Text)
 		if !ok {
@@ -73,10 +78,15 @@ func TestCodeProviderFromCurrentDir(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		// The parts are the file contents followed by the working
-		// directory hint. See TheoryOfWorkingDirectoryHint.
-		if len(parts) != 2 {
-			t.Fatalf("expected 2 parts (file content, working directory hint), got %d", len(parts))
+		// The parts are the file contents, the cache breakpoint ending
+		// the stable prefix, and the working directory hint. See
+		// TheoryOfWorkingDirectoryHint and
+		// generators.TheoryOfCacheBreakpoints.
+		if len(parts) != 3 {
+			t.Fatalf("expected 3 parts (file content, cache breakpoint, working directory hint), got %d", len(parts))
+		}
+		if _, ok := parts[1].(generators.CacheBreakpoint); !ok {
+			t.Fatalf("expected cache breakpoint after file contents, got %#v", parts[1])
 		}
 		text, ok := parts[0].(generators.Text)
 		if !ok {
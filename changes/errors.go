@@ -20,3 +20,21 @@ func (e *ApplyError) Error() string {
 func (e *ApplyError) Unwrap() error {
 	return e.Err
 }
+
+// VerifyError is returned by the verification of a round's changes before
+// they are flushed (e.g., the type check of -typecheck). Like ApplyError it
+// triggers a retry, whose feedback carries the verification diagnostics and
+// instructs the model to re-emit every intended change block with the
+// errors fixed. See loops.RunOptions.VerifyRound and
+// gotools.TheoryOfTypeCheck.
+type VerifyError struct {
+	Err error
+}
+
+func (e *VerifyError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *VerifyError) Unwrap() error {
+	return e.Err
+}
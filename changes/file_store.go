@@ -2,6 +2,7 @@ package changes
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"maps"
 	"os"
@@ -358,27 +359,10 @@ func splitLines(content []byte) []string {
 // while keeping large unchanged regions out of the diff. See
 // TheoryOfReviewDiffContext.
 func buildDiffHunks(oldLines, newLines []string, ops []diffOp) []diffHunk {
-	// Find maximal runs of changed (non-equal) ops.
-	var runs [][2]int // [start, end) indices into ops
-	runStart := -1
-	for i, op := range ops {
-		if op.kind != diffOpEqual {
-			if runStart == -1 {
-				runStart = i
-			}
-		} else if runStart != -1 {
-			runs = append(runs, [2]int{runStart, i})
-			runStart = -1
-		}
-	}
-	if runStart != -1 {
-		runs = append(runs, [2]int{runStart, len(ops)})
-	}
-
 	// Extend each run with context and merge overlapping ranges.
 	type hunkRange struct{ start, end int }
 	var ranges []hunkRange
-	for _, run := range runs {
+	for _, run := range diffRuns(ops) {
 		start := max(0, run[0]-diffContextLines)
 		end := min(len(ops), run[1]+diffContextLines)
 		if len(ranges) > 0 && start <= ranges[len(ranges)-1].end {
@@ -416,6 +400,27 @@ func buildDiffHunks(oldLines, newLines []string, ops []diffOp) []diffHunk {
 	return hunks
 }
 
+// diffRuns returns the maximal runs of changed (non-equal) ops, as
+// [start, end) indices into ops.
+func diffRuns(ops []diffOp) [][2]int {
+	var runs [][2]int
+	runStart := -1
+	for i, op := range ops {
+		if op.kind != diffOpEqual {
+			if runStart == -1 {
+				runStart = i
+			}
+		} else if runStart != -1 {
+			runs = append(runs, [2]int{runStart, i})
+			runStart = -1
+		}
+	}
+	if runStart != -1 {
+		runs = append(runs, [2]int{runStart, len(ops)})
+	}
+	return runs
+}
+
 // computeDiffOps computes the line-level edit script between oldLines and
 // newLines using an LCS table. When the matrix exceeds maxDiffMatrixCells,
 // the fallback lists all old lines as deletions followed by all new lines
@@ -515,6 +520,8 @@ type MemoryStore struct {
 	underlying FileStore
 	files      map[string]*memoryFile
 	originals  map[string]*memoryFile
+	renames    map[string]string // new path -> old path, in the round
+	journal    *UndoJournal
 }
 
 // NewMemoryStore creates a MemoryStore that wraps the given underlying
@@ -527,11 +534,23 @@ func NewMemoryStore(underlying FileStore) *MemoryStore {
 		underlying: underlying,
 		files:      make(map[string]*memoryFile),
 		originals:  make(map[string]*memoryFile),
+		renames:    make(map[string]string),
 	}
 }
 
+// SetJournal sets the undo journal recording the rounds flushed into a
+// root store. See TheoryOfUndoJournal.
+func (s *MemoryStore) SetJournal(journal *UndoJournal) {
+	s.journal = journal
+}
+
 func (s *MemoryStore) isFileStore() {}
 
+// Underlying returns the store s flushes to.
+func (s *MemoryStore) Underlying() FileStore {
+	return s.underlying
+}
+
 func (s *MemoryStore) ReadFile(path string) ([]byte, error) {
 	if mf, ok := s.files[path]; ok {
 		if !mf.exists {
@@ -575,24 +594,66 @@ func (s *MemoryStore) Rename(oldPath, newPath string) error {
 	}
 	s.files[newPath] = &memoryFile{content: content, exists: true}
 	s.files[oldPath] = &memoryFile{exists: false}
+	from := oldPath
+	if prev, ok := s.renames[oldPath]; ok {
+		from = prev
+	}
+	s.renames[newPath] = from
 	return nil
 }
 
 // Flush writes all cached file modifications to the underlying store,
-// committing the in-memory changes to disk in a single batch.
+// committing the in-memory changes to disk in a single batch. With a
+// journal set and a root store underlying, the changed paths are recorded
+// in the journal as a round, the paths written before a failure included.
+// See TheoryOfUndoJournal.
 func (s *MemoryStore) Flush() error {
-	for path, mf := range s.files {
+	root, journaled := s.underlying.(rootStore)
+	journaled = journaled && s.journal != nil
+	var files []UndoFile
+	var flushErr error
+	for _, path := range slices.Sorted(maps.Keys(s.files)) {
+		mf := s.files[path]
+		var file UndoFile
+		if journaled {
+			before, err := root.ReadFile(path)
+			if err == nil {
+				file.Before = before
+				file.BeforeExists = true
+			}
+		}
 		if !mf.exists {
 			if err := s.underlying.Remove(path); err != nil && !os.IsNotExist(err) {
-				return err
+				flushErr = err
+				break
 			}
 		} else {
 			if err := s.underlying.WriteFile(path, mf.content, 0644); err != nil {
-				return err
+				flushErr = err
+				break
+			}
+		}
+		if !journaled ||
+			file.BeforeExists == mf.exists && bytes.Equal(file.Before, mf.content) {
+			continue
+		}
+		file.Path = path
+		file.RenamedFrom = s.renames[path]
+		file.After = mf.content
+		file.AfterExists = mf.exists
+		if mf.exists {
+			if info, err := root.root.Stat(path); err == nil {
+				file.ModTime = info.ModTime()
 			}
 		}
+		files = append(files, file)
 	}
-	return nil
+	if len(files) > 0 {
+		if err := s.journal.record(root.trackedPath("."), files); err != nil {
+			return errors.Join(flushErr, fmt.Errorf("undo journal: %w", err))
+		}
+	}
+	return flushErr
 }
 
 // Reset discards all per-round cached modifications, restoring the store
@@ -602,6 +663,7 @@ func (s *MemoryStore) Flush() error {
 // session, not the state before the current round. See TheoryOfInMemoryApply.
 func (s *MemoryStore) Reset() {
 	s.files = make(map[string]*memoryFile)
+	s.renames = make(map[string]string)
 }
 
 // captureOriginal records the pre-session content of a path the first time
@@ -622,6 +684,41 @@ func (s *MemoryStore) captureOriginal(path string) {
 	s.originals[path] = &memoryFile{content: slices.Clone(content), exists: true}
 }
 
+// FileOriginal is the pre-session state of a path modified in the
+// session. Session checkpoints persist the originals so a resumed session
+// diffs against the state before the interrupted run (see
+// codes.TheoryOfCheckpoint).
+type FileOriginal struct {
+	Path    string `json:"path"`
+	Content []byte `json:"content,omitempty"`
+	Exists  bool   `json:"exists"`
+}
+
+// Originals returns the session originals sorted by path.
+func (s *MemoryStore) Originals() []FileOriginal {
+	ret := make([]FileOriginal, 0, len(s.originals))
+	for _, path := range slices.Sorted(maps.Keys(s.originals)) {
+		orig := s.originals[path]
+		ret = append(ret, FileOriginal{
+			Path:    path,
+			Content: orig.content,
+			Exists:  orig.exists,
+		})
+	}
+	return ret
+}
+
+// RestoreOriginals records originals as the session originals, replacing
+// the originals already captured for the same paths.
+func (s *MemoryStore) RestoreOriginals(originals []FileOriginal) {
+	for _, orig := range originals {
+		s.originals[orig.Path] = &memoryFile{
+			content: slices.Clone(orig.Content),
+			exists:  orig.Exists,
+		}
+	}
+}
+
 // Diffs returns the accumulated session changes as FileDiff entries,
 // comparing the pre-session original state against the current state.
 // Paths are sorted for deterministic ordering. A path modified in an
@@ -1093,3 +1093,45 @@ func makeBaseLines(n int) (oldLines, newLines []string) {
 	}
 	return
 }
+
+func TestMemoryStoreRestoreOriginals(t *testing.T) {
+	dir := t.TempDir()
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	if err := root.WriteFile("a.go", []byte("package a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// a session modifies a.go and creates b.go, then is interrupted
+	store := NewMemoryStore(NewRootStore(root))
+	if err := store.WriteFile("a.go", []byte("package a // changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WriteFile("b.go", []byte("package a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	originals := store.Originals()
+	if len(originals) != 2 || originals[0].Path != "a.go" || !originals[0].Exists || originals[1].Exists {
+		t.Fatalf("got originals %+v", originals)
+	}
+
+	// the resumed session diffs against the pre-session state
+	resumed := NewMemoryStore(NewRootStore(root))
+	resumed.RestoreOriginals(originals)
+	diffs := resumed.Diffs()
+	if len(diffs) != 2 {
+		t.Fatalf("expecting 2 diffs, got %+v", diffs)
+	}
+	if string(diffs[0].Original) != "package a\n" || string(diffs[0].Current) != "package a // changed\n" {
+		t.Fatalf("got diff %+v", diffs[0])
+	}
+	if diffs[1].OriginalExists || !diffs[1].CurrentExists {
+		t.Fatalf("expecting new file diff, got %+v", diffs[1])
+	}
+}
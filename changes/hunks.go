@@ -0,0 +1,279 @@
+package changes
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// approvalContextLines is the number of unchanged context lines shown
+// around a hunk presented for approval, matching `git add -p`.
+const approvalContextLines = 3
+
+// Hunk is a changed region of a pending file change, the unit the user
+// accepts, rejects or edits before a flush. A new or deleted file is a
+// single hunk. See codes.TheoryOfHunkApproval.
+type Hunk struct {
+	Path string
+	// Index is the position of the hunk in its file, Count the number of
+	// hunks of the file.
+	Index int
+	Count int
+	// NewFile and Deleted mark a hunk creating or deleting the file.
+	NewFile bool
+	Deleted bool
+	// OldStart and NewStart are the 1-based line numbers where the
+	// removed and added lines start.
+	OldStart int
+	NewStart int
+	Removed  []string
+	Added    []string
+	// Before and After are the unchanged context lines around the hunk.
+	Before []string
+	After  []string
+}
+
+// Format renders the hunk as a unified diff hunk with a header naming
+// the file and the hunk's position in it.
+func (h Hunk) Format() string {
+	var b strings.Builder
+	switch {
+	case h.NewFile:
+		fmt.Fprintf(&b, "=== %s (new file) ===\n", h.Path)
+	case h.Deleted:
+		fmt.Fprintf(&b, "=== %s (deleted) ===\n", h.Path)
+	default:
+		fmt.Fprintf(&b, "=== %s (hunk %d/%d) ===\n", h.Path, h.Index+1, h.Count)
+	}
+	fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n",
+		h.OldStart-len(h.Before), len(h.Before)+len(h.Removed)+len(h.After),
+		h.NewStart-len(h.Before), len(h.Before)+len(h.Added)+len(h.After),
+	)
+	for _, line := range h.Before {
+		b.WriteString("  " + line + "\n")
+	}
+	for _, line := range h.Removed {
+		b.WriteString("- " + line + "\n")
+	}
+	for _, line := range h.Added {
+		b.WriteString("+ " + line + "\n")
+	}
+	for _, line := range h.After {
+		b.WriteString("  " + line + "\n")
+	}
+	return b.String()
+}
+
+// HunkAction is the user's decision on a hunk.
+type HunkAction int
+
+const (
+	HunkAccept HunkAction = iota
+	HunkReject
+	HunkEdit
+)
+
+// HunkDecision is the user's decision on a hunk. When Action is HunkEdit,
+// Lines are the user's rewrite of the hunk's added lines, and replace the
+// whole hunk.
+type HunkDecision struct {
+	Action HunkAction
+	Lines  []string
+}
+
+// SplitHunks splits a file change into the hunks presented for approval.
+// See codes.TheoryOfHunkApproval.
+func SplitHunks(diff FileDiff) []Hunk {
+	oldLines := splitLines(diff.Original)
+	newLines := splitLines(diff.Current)
+	if diff.OriginalExists != diff.CurrentExists {
+		return []Hunk{
+			{
+				Path:     diff.Path,
+				Count:    1,
+				NewFile:  !diff.OriginalExists,
+				Deleted:  !diff.CurrentExists,
+				OldStart: 1,
+				NewStart: 1,
+				Removed:  oldLines,
+				Added:    newLines,
+			},
+		}
+	}
+	ops := computeDiffOps(oldLines, newLines)
+	runs := diffRuns(ops)
+	hunks := make([]Hunk, 0, len(runs))
+	for i, run := range runs {
+		hunk := Hunk{
+			Path:     diff.Path,
+			Index:    i,
+			Count:    len(runs),
+			OldStart: ops[run[0]].oldIdx + 1,
+			NewStart: ops[run[0]].newIdx + 1,
+		}
+		for _, op := range ops[run[0]:run[1]] {
+			switch op.kind {
+			case diffOpDelete:
+				hunk.Removed = append(hunk.Removed, oldLines[op.oldIdx])
+			case diffOpInsert:
+				hunk.Added = append(hunk.Added, newLines[op.newIdx])
+			}
+		}
+		for j := run[0] - 1; j >= max(0, run[0]-approvalContextLines) && ops[j].kind == diffOpEqual; j-- {
+			hunk.Before = slices.Insert(hunk.Before, 0, oldLines[ops[j].oldIdx])
+		}
+		for _, op := range ops[run[1]:min(len(ops), run[1]+approvalContextLines)] {
+			if op.kind != diffOpEqual {
+				break
+			}
+			hunk.After = append(hunk.After, oldLines[op.oldIdx])
+		}
+		hunks = append(hunks, hunk)
+	}
+	return hunks
+}
+
+// ApplyHunkDecisions returns the file change with the decisions on its
+// hunks, as split by SplitHunks, applied: accepted hunks keep the change,
+// rejected hunks keep the original lines, and edited hunks have the
+// edited lines in place of the original ones. An empty edit of a new or
+// deleted file keeps the original; any other edit writes the file.
+func ApplyHunkDecisions(diff FileDiff, decisions []HunkDecision) FileDiff {
+	ret := diff
+	all := func(action HunkAction) bool {
+		return !slices.ContainsFunc(decisions, func(d HunkDecision) bool {
+			return d.Action != action
+		})
+	}
+	switch {
+	case all(HunkAccept):
+		return ret
+	case all(HunkReject):
+		ret.Current = diff.Original
+		ret.CurrentExists = diff.OriginalExists
+		return ret
+	}
+
+	oldLines := splitLines(diff.Original)
+	newLines := splitLines(diff.Current)
+	var lines []string
+	if diff.OriginalExists != diff.CurrentExists {
+		// the single hunk of a new or deleted file is edited: an empty
+		// edit keeps the original, any other edit writes the file
+		lines = decisions[0].Lines
+		if len(lines) == 0 {
+			ret.Current = diff.Original
+			ret.CurrentExists = diff.OriginalExists
+			return ret
+		}
+	} else {
+		ops := computeDiffOps(oldLines, newLines)
+		runs := diffRuns(ops)
+		next := 0
+		for i, run := range runs {
+			for _, op := range ops[next:run[0]] {
+				lines = append(lines, oldLines[op.oldIdx])
+			}
+			for _, op := range ops[run[0]:run[1]] {
+				switch {
+				case decisions[i].Action == HunkAccept && op.kind == diffOpInsert:
+					lines = append(lines, newLines[op.newIdx])
+				case decisions[i].Action == HunkReject && op.kind == diffOpDelete:
+					lines = append(lines, oldLines[op.oldIdx])
+				}
+			}
+			if decisions[i].Action == HunkEdit {
+				lines = append(lines, decisions[i].Lines...)
+			}
+			next = run[1]
+		}
+		for _, op := range ops[next:] {
+			lines = append(lines, oldLines[op.oldIdx])
+		}
+	}
+
+	content := strings.Join(lines, "\n")
+	if len(lines) > 0 && (bytes.HasSuffix(diff.Current, []byte("\n")) ||
+		!diff.CurrentExists && bytes.HasSuffix(diff.Original, []byte("\n"))) {
+		content += "\n"
+	}
+	ret.Current = []byte(content)
+	ret.CurrentExists = true
+	return ret
+}
+
+// FormatHunkFeedback renders the rejected and edited hunks as the user
+// message fed back to the model in the next round, or returns the empty
+// string when every hunk is accepted. See codes.TheoryOfHunkApproval.
+func FormatHunkFeedback(hunks []Hunk, decisions []HunkDecision) string {
+	var rejected, edited strings.Builder
+	for i, hunk := range hunks {
+		switch decisions[i].Action {
+		case HunkReject:
+			rejected.WriteString("\n" + hunk.Format())
+		case HunkEdit:
+			edited.WriteString("\n" + hunk.Format())
+			edited.WriteString("用户修改后的内容：\n")
+			for _, line := range decisions[i].Lines {
+				edited.WriteString(line + "\n")
+			}
+		}
+	}
+	if rejected.Len() == 0 && edited.Len() == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("用户在写入前审阅了本轮的改动。")
+	if rejected.Len() > 0 {
+		b.WriteString("\n\n以下改动被用户拒绝，没有写入文件，请不要原样重复这些改动：\n")
+		b.WriteString(rejected.String())
+	}
+	if edited.Len() > 0 {
+		b.WriteString("\n\n以下改动被用户修改，文件中写入的是用户修改后的内容：\n")
+		b.WriteString(edited.String())
+	}
+	return b.String()
+}
+
+// PendingDiffs returns the changes of the round not yet flushed, each
+// path's pending state against its state in the underlying store, sorted
+// by path. No-op changes are skipped.
+func (s *MemoryStore) PendingDiffs() []FileDiff {
+	var ret []FileDiff
+	for _, path := range slices.Sorted(maps.Keys(s.files)) {
+		mf := s.files[path]
+		diff := FileDiff{
+			Path:          path,
+			Current:       mf.content,
+			CurrentExists: mf.exists,
+		}
+		if content, err := s.underlying.ReadFile(path); err == nil {
+			diff.Original = content
+			diff.OriginalExists = true
+		}
+		if diff.OriginalExists == diff.CurrentExists && bytes.Equal(diff.Original, diff.Current) {
+			continue
+		}
+		ret = append(ret, diff)
+	}
+	return ret
+}
+
+// Revise replaces the pending change of diff.Path with diff.Current,
+// dropping it when it equals the state in the underlying store.
+func (s *MemoryStore) Revise(diff FileDiff) {
+	content, err := s.underlying.ReadFile(diff.Path)
+	exists := err == nil
+	if exists == diff.CurrentExists && bytes.Equal(content, diff.Current) {
+		delete(s.files, diff.Path)
+		delete(s.renames, diff.Path)
+		return
+	}
+	s.captureOriginal(diff.Path)
+	s.files[diff.Path] = &memoryFile{
+		content: diff.Current,
+		exists:  diff.CurrentExists,
+	}
+}
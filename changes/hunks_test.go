@@ -0,0 +1,182 @@
+package changes
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplitHunks(t *testing.T) {
+	diff := FileDiff{
+		Path:           "a.txt",
+		Original:       []byte("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"),
+		OriginalExists: true,
+		Current:        []byte("1\nTWO\n3\n4\n5\n6\n7\n8\n9\n10\n11\n"),
+		CurrentExists:  true,
+	}
+	hunks := SplitHunks(diff)
+	if len(hunks) != 2 {
+		t.Fatalf("got %d hunks", len(hunks))
+	}
+	first := hunks[0]
+	if first.Index != 0 || first.Count != 2 || first.OldStart != 2 ||
+		!slices.Equal(first.Removed, []string{"2"}) ||
+		!slices.Equal(first.Added, []string{"TWO"}) ||
+		!slices.Equal(first.Before, []string{"1"}) ||
+		!slices.Equal(first.After, []string{"3", "4", "5"}) {
+		t.Fatalf("got first hunk %+v", first)
+	}
+	second := hunks[1]
+	if second.Index != 1 || second.NewStart != 11 ||
+		len(second.Removed) != 0 ||
+		!slices.Equal(second.Added, []string{"11"}) ||
+		!slices.Equal(second.Before, []string{"8", "9", "10"}) ||
+		len(second.After) != 0 {
+		t.Fatalf("got second hunk %+v", second)
+	}
+	if got := first.Format(); !strings.Contains(got, "=== a.txt (hunk 1/2) ===\n@@ -1,5 +1,5 @@\n  1\n- 2\n+ TWO\n") {
+		t.Fatalf("got %q", got)
+	}
+
+	// a new file is a single hunk
+	hunks = SplitHunks(FileDiff{
+		Path:          "b.txt",
+		Current:       []byte("x\ny\n"),
+		CurrentExists: true,
+	})
+	if len(hunks) != 1 || !hunks[0].NewFile || !slices.Equal(hunks[0].Added, []string{"x", "y"}) {
+		t.Fatalf("got %+v", hunks)
+	}
+}
+
+func TestApplyHunkDecisions(t *testing.T) {
+	diff := FileDiff{
+		Path:           "a.txt",
+		Original:       []byte("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"),
+		OriginalExists: true,
+		Current:        []byte("1\nTWO\n3\n4\n5\n6\n7\n8\n9\nTEN\n"),
+		CurrentExists:  true,
+	}
+	for _, c := range []struct {
+		decisions []HunkDecision
+		expected  string
+	}{
+		{
+			decisions: []HunkDecision{{Action: HunkAccept}, {Action: HunkAccept}},
+			expected:  "1\nTWO\n3\n4\n5\n6\n7\n8\n9\nTEN\n",
+		},
+		{
+			decisions: []HunkDecision{{Action: HunkReject}, {Action: HunkReject}},
+			expected:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+		},
+		{
+			decisions: []HunkDecision{{Action: HunkReject}, {Action: HunkAccept}},
+			expected:  "1\n2\n3\n4\n5\n6\n7\n8\n9\nTEN\n",
+		},
+		{
+			decisions: []HunkDecision{{Action: HunkEdit, Lines: []string{"two", "2.5"}}, {Action: HunkReject}},
+			expected:  "1\ntwo\n2.5\n3\n4\n5\n6\n7\n8\n9\n10\n",
+		},
+	} {
+		got := ApplyHunkDecisions(diff, c.decisions)
+		if string(got.Current) != c.expected || !got.CurrentExists {
+			t.Fatalf("decisions %+v: got %q", c.decisions, got.Current)
+		}
+	}
+
+	// rejecting a new file keeps it absent
+	got := ApplyHunkDecisions(FileDiff{
+		Path:          "b.txt",
+		Current:       []byte("x\n"),
+		CurrentExists: true,
+	}, []HunkDecision{{Action: HunkReject}})
+	if got.CurrentExists {
+		t.Fatalf("got %+v", got)
+	}
+
+	// an empty edit of a deleted file keeps it
+	deleted := FileDiff{
+		Path:           "c.txt",
+		Original:       []byte("y\n"),
+		OriginalExists: true,
+	}
+	got = ApplyHunkDecisions(deleted, []HunkDecision{{Action: HunkEdit}})
+	if !got.CurrentExists || string(got.Current) != "y\n" {
+		t.Fatalf("got %+v", got)
+	}
+	// and any other edit writes it
+	got = ApplyHunkDecisions(deleted, []HunkDecision{{Action: HunkEdit, Lines: []string{"z"}}})
+	if !got.CurrentExists || string(got.Current) != "z\n" {
+		t.Fatalf("got %+v", got)
+	}
+
+	// an empty edit of a new file keeps it absent
+	got = ApplyHunkDecisions(FileDiff{
+		Path:          "d.txt",
+		Current:       []byte("x\n"),
+		CurrentExists: true,
+	}, []HunkDecision{{Action: HunkEdit}})
+	if got.CurrentExists {
+		t.Fatalf("got %+v", got)
+	}
+}
+
+func TestMemoryStorePendingDiffsRevise(t *testing.T) {
+	dir := t.TempDir()
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	if err := root.WriteFile("a.txt", []byte("a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewMemoryStore(NewRootStore(root))
+	if err := store.WriteFile("a.txt", []byte("A\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WriteFile("b.txt", []byte("b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	diffs := store.PendingDiffs()
+	if len(diffs) != 2 || diffs[0].Path != "a.txt" || diffs[1].Path != "b.txt" || diffs[1].OriginalExists {
+		t.Fatalf("got %+v", diffs)
+	}
+
+	// rejecting every change leaves nothing to flush
+	for _, diff := range diffs {
+		store.Revise(ApplyHunkDecisions(diff, []HunkDecision{{Action: HunkReject}}))
+	}
+	if diffs := store.PendingDiffs(); len(diffs) != 0 {
+		t.Fatalf("got %+v", diffs)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := root.ReadFile("a.txt"); err != nil || string(content) != "a\n" {
+		t.Fatalf("got %q, %v", content, err)
+	}
+	if _, err := root.Stat("b.txt"); !os.IsNotExist(err) {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestFormatHunkFeedback(t *testing.T) {
+	hunks := []Hunk{
+		{Path: "a.txt", Count: 2, Removed: []string{"old"}, Added: []string{"new"}},
+		{Path: "a.txt", Index: 1, Count: 2, Added: []string{"more"}},
+	}
+	if got := FormatHunkFeedback(hunks, []HunkDecision{{Action: HunkAccept}, {Action: HunkAccept}}); got != "" {
+		t.Fatalf("got %q", got)
+	}
+	got := FormatHunkFeedback(hunks, []HunkDecision{
+		{Action: HunkReject},
+		{Action: HunkEdit, Lines: []string{"edited"}},
+	})
+	if !strings.Contains(got, "被用户拒绝") || !strings.Contains(got, "+ new") ||
+		!strings.Contains(got, "被用户修改") || !strings.Contains(got, "edited\n") {
+		t.Fatalf("got %q", got)
+	}
+}
@@ -0,0 +1,582 @@
+package changes
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cuelang.org/go/cue"
+	"github.com/reusee/tai/configs"
+	"github.com/reusee/tai/flags"
+	"github.com/reusee/tai/generators"
+	"github.com/reusee/tai/modes"
+)
+
+const TheoryOfUndoJournal = `
+A flushed round is on disk, and without a record of what it replaced the
+only way back is git. Every MemoryStore.Flush into a working tree (a root
+store) appends the round to a persistent undo journal: for each changed
+path, the content before and after the flush or their absence, the path it
+was renamed from, and its mtime after the flush. A flush that fails partway
+still journals the paths it wrote before the failure, so they can be
+reverted like a complete round. A journal session is one
+process; its rounds are the lines of <session>.jsonl under tai/undo in the
+user config directory, with session ids counting up from 1 (UndoDir
+overrides the directory; development mode without one keeps no journal).
+
+The journal holds the full contents of every flushed file, so it is kept
+bounded and out of confidential sessions. Starting a session prunes the
+oldest sessions, by id, beyond the UndoMaxSessions most recent ones
+(undo.max_sessions, default 50, zero for no limit); the new session counts
+as one of them. In confidential mode (see
+generators.TheoryOfConfidentialMode) nothing is journaled: the contents
+would outlive the session on disk, and tai undo finds no rounds of it.
+
+tai undo reverts the last flushed round under the current directory, the
+last N with -rounds N, or every round of a journal session with
+-undo-session ID; -list prints the journal with the journal session ids.
+Journal session ids are not records session ids (see records.SessionID):
+they count separately, so the journal has its own flag. The selected
+rounds are reverted together: each path is restored to its content before
+the earliest selected round that touched it, after checking that it is
+unchanged since the latest one. The check reuses write conflict detection
+(see TheoryOfWriteConflictDetection): the recorded mtimes seed a
+FileWriteTimes, so a file modified since — by an editor, git, or a later
+round that is not being undone — is a write conflict, and a file the round
+deleted must still be absent. A file whose mtime moved but whose content
+is still what the round wrote (rewritten with the same content, or
+restored by undoing a later round) is not a conflict. Any conflict refuses
+the whole undo before a file is touched, unless -force is given. Reverted
+rounds are dropped from the journal.
+`
+
+// UndoRound is a flushed round in the undo journal. See
+// TheoryOfUndoJournal.
+type UndoRound struct {
+	Session int64      `json:"session"`
+	Round   int        `json:"round"`
+	Time    time.Time  `json:"time"`
+	Dir     string     `json:"dir"`
+	Files   []UndoFile `json:"files"`
+}
+
+// UndoFile is a path changed by a flushed round.
+type UndoFile struct {
+	Path         string    `json:"path"`
+	RenamedFrom  string    `json:"renamed_from,omitempty"`
+	Before       []byte    `json:"before,omitempty"`
+	BeforeExists bool      `json:"before_exists"`
+	After        []byte    `json:"after,omitempty"`
+	AfterExists  bool      `json:"after_exists"`
+	ModTime      time.Time `json:"mod_time,omitzero"`
+}
+
+// UndoDir is the undo journal directory. Empty means tai/undo in the user
+// config directory, or no journal in development mode. See
+// TheoryOfUndoJournal.
+type UndoDir string
+
+func (Module) UndoDir() UndoDir {
+	return ""
+}
+
+// UndoJournal records the rounds flushed by MemoryStores with the journal
+// set, and reverts them. A nil UndoJournal records nothing. See
+// TheoryOfUndoJournal.
+type UndoJournal struct {
+	dir         string
+	maxSessions int
+
+	mu      sync.Mutex
+	session int64
+	rounds  int
+}
+
+// processUndoJournals holds the journals opened in the process, keyed by
+// directory, so the sessions of scopes recreated by dscope.Reset (goal
+// loops, reviews) are rounds of one journal session.
+var processUndoJournals sync.Map
+
+func (Module) UndoJournal(
+	dir UndoDir,
+	mode modes.Mode,
+	maxSessions UndoMaxSessions,
+	confidential generators.ConfidentialMode,
+) *UndoJournal {
+	if confidential {
+		return nil
+	}
+	path := string(dir)
+	if path == "" && mode == modes.ModeProduction {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return nil
+		}
+		path = filepath.Join(configDir, "tai", "undo")
+	}
+	if path == "" {
+		return nil
+	}
+	v, _ := processUndoJournals.LoadOrStore(path, &UndoJournal{
+		dir:         path,
+		maxSessions: int(maxSessions),
+	})
+	return v.(*UndoJournal)
+}
+
+// record appends a round flushed into dir.
+func (j *UndoJournal) record(dir string, files []UndoFile) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.session == 0 {
+		session, err := j.newSession()
+		if err != nil {
+			return err
+		}
+		j.session = session
+	}
+	j.rounds++
+	data, err := json.Marshal(UndoRound{
+		Session: j.session,
+		Round:   j.rounds,
+		Time:    time.Now(),
+		Dir:     dir,
+		Files:   files,
+	})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(j.sessionPath(j.session), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// newSession creates the file of the next session id and prunes the
+// sessions beyond the retention limit.
+func (j *UndoJournal) newSession() (int64, error) {
+	if err := os.MkdirAll(j.dir, 0755); err != nil {
+		return 0, err
+	}
+	sessions, err := j.sessions()
+	if err != nil {
+		return 0, err
+	}
+	next := int64(1)
+	if len(sessions) > 0 {
+		next = slices.Max(sessions) + 1
+	}
+	for ; ; next++ {
+		f, err := os.OpenFile(j.sessionPath(next), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if errors.Is(err, fs.ErrExist) {
+			// created by another process
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if err := f.Close(); err != nil {
+			return 0, err
+		}
+		return next, j.prune(next)
+	}
+}
+
+// prune deletes the oldest sessions beyond maxSessions, keeping current.
+func (j *UndoJournal) prune(current int64) error {
+	if j.maxSessions <= 0 {
+		return nil
+	}
+	sessions, err := j.sessions()
+	if err != nil {
+		return err
+	}
+	slices.Sort(sessions)
+	for len(sessions) > j.maxSessions {
+		if sessions[0] != current {
+			if err := os.Remove(j.sessionPath(sessions[0])); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		sessions = sessions[1:]
+	}
+	return nil
+}
+
+func (j *UndoJournal) sessions() ([]int64, error) {
+	entries, err := os.ReadDir(j.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ret []int64
+	for _, entry := range entries {
+		id, err := strconv.ParseInt(strings.TrimSuffix(entry.Name(), ".jsonl"), 10, 64)
+		if err != nil || !strings.HasSuffix(entry.Name(), ".jsonl") {
+			continue
+		}
+		ret = append(ret, id)
+	}
+	return ret, nil
+}
+
+func (j *UndoJournal) sessionPath(session int64) string {
+	return filepath.Join(j.dir, strconv.FormatInt(session, 10)+".jsonl")
+}
+
+// Rounds returns the journaled rounds in flush order.
+func (j *UndoJournal) Rounds() ([]UndoRound, error) {
+	if j == nil {
+		return nil, nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	sessions, err := j.sessions()
+	if err != nil {
+		return nil, err
+	}
+	var ret []UndoRound
+	for _, session := range sessions {
+		rounds, err := j.readSession(session)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, rounds...)
+	}
+	slices.SortStableFunc(ret, func(a, b UndoRound) int {
+		return cmp.Or(
+			a.Time.Compare(b.Time),
+			cmp.Compare(a.Session, b.Session),
+			cmp.Compare(a.Round, b.Round),
+		)
+	})
+	return ret, nil
+}
+
+func (j *UndoJournal) readSession(session int64) ([]UndoRound, error) {
+	path := j.sessionPath(session)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ret []UndoRound
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<30)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var round UndoRound
+		if err := json.Unmarshal(scanner.Bytes(), &round); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+		ret = append(ret, round)
+	}
+	return ret, scanner.Err()
+}
+
+// SelectUndoRounds selects from rounds in flush order the rounds of the
+// session, or else the last n rounds flushed into dir.
+func SelectUndoRounds(rounds []UndoRound, dir string, n int, session int64) []UndoRound {
+	var ret []UndoRound
+	for _, round := range rounds {
+		if session != 0 && round.Session == session ||
+			session == 0 && round.Dir == dir {
+			ret = append(ret, round)
+		}
+	}
+	if session == 0 && len(ret) > n {
+		ret = ret[len(ret)-n:]
+	}
+	return ret
+}
+
+// undoTarget is a path to restore: its content before the earliest
+// selected round, and its state after the latest.
+type undoTarget struct {
+	dir    string
+	path   string
+	before UndoFile
+	after  UndoFile
+}
+
+// Undo reverts rounds, in flush order, together. Unless force is true, a
+// path changed since the latest round refuses the undo before any file is
+// touched. The reverted rounds are dropped from the journal. See
+// TheoryOfUndoJournal.
+func (j *UndoJournal) Undo(rounds []UndoRound, force bool) error {
+	if j == nil || len(rounds) == 0 {
+		return nil
+	}
+
+	// targets
+	var targets []*undoTarget
+	byKey := make(map[[2]string]*undoTarget)
+	for _, round := range rounds {
+		for _, file := range round.Files {
+			key := [2]string{round.Dir, file.Path}
+			target, ok := byKey[key]
+			if !ok {
+				target = &undoTarget{
+					dir:    round.Dir,
+					path:   file.Path,
+					before: file,
+				}
+				byKey[key] = target
+				targets = append(targets, target)
+			}
+			target.after = file
+		}
+	}
+
+	stores := make(map[string]rootStore)
+	for _, target := range targets {
+		if _, ok := stores[target.dir]; ok {
+			continue
+		}
+		root, err := os.OpenRoot(target.dir)
+		if err != nil {
+			return err
+		}
+		defer root.Close()
+		stores[target.dir] = rootStore{root: root}
+	}
+
+	// conflicts: the recorded mtimes are the write conflict baselines
+	if !force {
+		writeTimes := NewFileWriteTimes()
+		var errs []error
+		for _, target := range targets {
+			store := stores[target.dir]
+			store.writeTimes = writeTimes
+			if !target.after.AfterExists {
+				if _, err := store.root.Stat(target.path); err == nil {
+					errs = append(errs, fmt.Errorf("write conflict: %s was created by another process since it was deleted", filepath.Join(target.dir, target.path)))
+				}
+				continue
+			}
+			if target.after.ModTime.IsZero() {
+				continue
+			}
+			key := store.trackedPath(target.path)
+			writeTimes.Set(key, target.after.ModTime)
+			if err := store.checkWriteConflict(key, target.path); err != nil {
+				// rewritten with the same content
+				if content, readErr := store.ReadFile(target.path); readErr == nil && bytes.Equal(content, target.after.After) {
+					continue
+				}
+				errs = append(errs, err)
+			}
+		}
+		if len(errs) > 0 {
+			return errors.Join(errs...)
+		}
+	}
+
+	// restore
+	for _, target := range targets {
+		store := stores[target.dir]
+		if target.before.BeforeExists {
+			if err := store.WriteFile(target.path, target.before.Before, 0644); err != nil {
+				return err
+			}
+		} else if err := store.Remove(target.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return j.drop(rounds)
+}
+
+// drop removes rounds from the journal.
+func (j *UndoJournal) drop(rounds []UndoRound) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	dropped := make(map[int64]map[int]bool)
+	for _, round := range rounds {
+		if dropped[round.Session] == nil {
+			dropped[round.Session] = make(map[int]bool)
+		}
+		dropped[round.Session][round.Round] = true
+	}
+	for session, numbers := range dropped {
+		existing, err := j.readSession(session)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		for _, round := range existing {
+			if numbers[round.Round] {
+				continue
+			}
+			data, err := json.Marshal(round)
+			if err != nil {
+				return err
+			}
+			buf.Write(append(data, '\n'))
+		}
+		path := j.sessionPath(session)
+		if buf.Len() == 0 && session != j.session {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			continue
+		}
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UndoRounds is the number of last rounds flushed into the current
+// directory the undo subcommand reverts.
+type UndoRounds int
+
+func (Module) UndoRounds() UndoRounds {
+	return 1
+}
+
+var _ flags.Flag = UndoRounds(0)
+
+func (u UndoRounds) Keys() map[string]string {
+	return map[string]string{
+		"-rounds": "Undo the last N flushed rounds (with undo)",
+	}
+}
+
+func (u UndoRounds) Handle(key string, args []string) (newDef any, remainArgs []string, err error) {
+	if len(args) == 0 {
+		return nil, nil, fmt.Errorf("expecting int argument, got empty")
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	if n < 1 {
+		return nil, nil, fmt.Errorf("expecting a positive number of rounds, got %d", n)
+	}
+	ret := UndoRounds(n)
+	return &ret, args[1:], nil
+}
+
+// UndoSession selects an undo journal session for the undo subcommand.
+// Zero selects by -rounds instead. Journal session ids are not records
+// session ids. See TheoryOfUndoJournal.
+type UndoSession int64
+
+func (Module) UndoSession() UndoSession {
+	return 0
+}
+
+var _ flags.Flag = UndoSession(0)
+
+func (u UndoSession) Keys() map[string]string {
+	return map[string]string{
+		"-undo-session": "Undo every round of an undo journal session, as listed by -list (with undo)",
+	}
+}
+
+func (u UndoSession) Handle(key string, args []string) (newDef any, remainArgs []string, err error) {
+	if len(args) == 0 {
+		return nil, nil, fmt.Errorf("expecting int argument, got empty")
+	}
+	n, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return nil, nil, err
+	}
+	if n < 1 {
+		return nil, nil, fmt.Errorf("expecting a positive undo session id, got %d", n)
+	}
+	ret := UndoSession(n)
+	return &ret, args[1:], nil
+}
+
+// UndoMaxSessions is the number of most recent undo journal sessions kept.
+// Zero means no limit. See TheoryOfUndoJournal.
+type UndoMaxSessions int
+
+func (Module) UndoMaxSessions() UndoMaxSessions {
+	return 50
+}
+
+var _ configs.Config = UndoMaxSessions(0)
+
+func (u UndoMaxSessions) ConfigPaths() []string {
+	return []string{"undo.max_sessions"}
+}
+
+func (u UndoMaxSessions) HandleConfig(path string, values []*cue.Value) (any, error) {
+	var n int
+	if err := values[0].Decode(&n); err != nil {
+		return nil, err
+	}
+	ret := UndoMaxSessions(n)
+	return &ret, nil
+}
+
+// UndoForce makes the undo subcommand restore files modified since the
+// undone rounds.
+type UndoForce bool
+
+func (Module) UndoForce() UndoForce {
+	return false
+}
+
+var _ flags.Flag = UndoForce(false)
+
+func (u UndoForce) Keys() map[string]string {
+	return map[string]string{
+		"-force": "Undo even when files were modified since the rounds (with undo)",
+	}
+}
+
+func (u UndoForce) Handle(key string, args []string) (newDef any, remainArgs []string, err error) {
+	ret := UndoForce(true)
+	return &ret, args, nil
+}
+
+// UndoList makes the undo subcommand list the journaled rounds instead of
+// reverting them.
+type UndoList bool
+
+func (Module) UndoList() UndoList {
+	return false
+}
+
+var _ flags.Flag = UndoList(false)
+
+func (u UndoList) Keys() map[string]string {
+	return map[string]string{
+		"-list": "List the journaled rounds (with undo)",
+	}
+}
+
+func (u UndoList) Handle(key string, args []string) (newDef any, remainArgs []string, err error) {
+	ret := UndoList(true)
+	return &ret, args, nil
+}
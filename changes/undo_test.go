@@ -0,0 +1,295 @@
+package changes
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/reusee/dscope"
+	"github.com/reusee/tai/generators"
+	"github.com/reusee/tai/modes"
+)
+
+func TestUndoJournal(t *testing.T) {
+	dir := t.TempDir()
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	if err := root.WriteFile("a.txt", []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var journal *UndoJournal
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Fork(
+		func() UndoDir { return UndoDir(t.TempDir()) },
+	).Call(func(j *UndoJournal) {
+		journal = j
+	})
+	store := NewMemoryStore(NewRootStoreWithWriteTimes(root, NewFileWriteTimes()))
+	store.SetJournal(journal)
+
+	// round 1 modifies a.txt and creates b.txt
+	if err := store.WriteFile("a.txt", []byte("a1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WriteFile("b.txt", []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	store.Reset()
+
+	// round 2 renames b.txt and rewrites a.txt with the same content
+	if err := store.Rename("b.txt", "c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WriteFile("a.txt", []byte("a1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	rounds, err := journal.Rounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rounds) != 2 || rounds[0].Dir != dir {
+		t.Fatalf("got rounds %+v", rounds)
+	}
+	if len(rounds[1].Files) != 2 || rounds[1].Files[1].Path != "c.txt" || rounds[1].Files[1].RenamedFrom != "b.txt" {
+		t.Fatalf("expecting the no-op write skipped, got %+v", rounds[1].Files)
+	}
+
+	read := func(path string) string {
+		content, err := root.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return "<none>"
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	// undo the last round
+	last := SelectUndoRounds(rounds, rounds[0].Dir, 1, 0)
+	if len(last) != 1 || last[0].Round != 2 {
+		t.Fatalf("got %+v", last)
+	}
+	if err := journal.Undo(last, false); err != nil {
+		t.Fatal(err)
+	}
+	if read("b.txt") != "b" || read("c.txt") != "<none>" || read("a.txt") != "a1" {
+		t.Fatalf("got a=%s b=%s c=%s", read("a.txt"), read("b.txt"), read("c.txt"))
+	}
+
+	// undo the session
+	rounds, err = journal.Rounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rounds) != 1 {
+		t.Fatalf("expecting the undone round dropped, got %+v", rounds)
+	}
+	if err := journal.Undo(SelectUndoRounds(rounds, "", 0, rounds[0].Session), false); err != nil {
+		t.Fatal(err)
+	}
+	if read("a.txt") != "a" || read("b.txt") != "<none>" {
+		t.Fatalf("got a=%s b=%s", read("a.txt"), read("b.txt"))
+	}
+	rounds, err = journal.Rounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rounds) != 0 {
+		t.Fatalf("got %+v", rounds)
+	}
+}
+
+func TestUndoJournalConflict(t *testing.T) {
+	dir := t.TempDir()
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	if err := root.WriteFile("a.txt", []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.WriteFile("b.txt", []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	journal := &UndoJournal{
+		dir: t.TempDir(),
+	}
+	store := NewMemoryStore(NewRootStore(root))
+	store.SetJournal(journal)
+	if err := store.WriteFile("a.txt", []byte("a1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Remove("b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	rounds, err := journal.Rounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// modified externally
+	if err := root.WriteFile("a.txt", []byte("edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "a.txt"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.WriteFile("b.txt", []byte("recreated"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = journal.Undo(rounds, false)
+	if err == nil || !strings.Contains(err.Error(), "a.txt") || !strings.Contains(err.Error(), "b.txt") {
+		t.Fatalf("expecting write conflicts, got %v", err)
+	}
+	if content, _ := root.ReadFile("a.txt"); string(content) != "edited" {
+		t.Fatalf("expecting no file touched, got %q", content)
+	}
+
+	if err := journal.Undo(rounds, true); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := root.ReadFile("a.txt"); string(content) != "a" {
+		t.Fatalf("got %q", content)
+	}
+	if content, _ := root.ReadFile("b.txt"); string(content) != "b" {
+		t.Fatalf("got %q", content)
+	}
+}
+
+func TestUndoJournalPartialFlush(t *testing.T) {
+	dir := t.TempDir()
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	if err := root.WriteFile("b", []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var journal *UndoJournal
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Fork(
+		func() UndoDir { return UndoDir(t.TempDir()) },
+	).Call(func(j *UndoJournal) {
+		journal = j
+	})
+	store := NewMemoryStore(NewRootStoreWithWriteTimes(root, NewFileWriteTimes()))
+	store.SetJournal(journal)
+
+	// a.txt is written, then b/c.txt fails because b is a file
+	if err := store.WriteFile("a.txt", []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WriteFile("b/c.txt", []byte("c"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Flush(); err == nil {
+		t.Fatal("expecting the flush to fail")
+	}
+	if _, err := root.Stat("a.txt"); err != nil {
+		t.Fatalf("expecting a.txt written, got %v", err)
+	}
+
+	rounds, err := journal.Rounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rounds) != 1 || len(rounds[0].Files) != 1 || rounds[0].Files[0].Path != "a.txt" {
+		t.Fatalf("got rounds %+v", rounds)
+	}
+	if err := journal.Undo(rounds, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := root.Stat("a.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expecting a.txt reverted, got %v", err)
+	}
+}
+
+func TestUndoRoundsRejectsNonPositive(t *testing.T) {
+	for _, arg := range []string{"0", "-1"} {
+		if _, _, err := UndoRounds(0).Handle("-rounds", []string{arg}); err == nil {
+			t.Fatalf("expected error for %s", arg)
+		}
+	}
+	v, _, err := UndoRounds(0).Handle("-rounds", []string{"2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *v.(*UndoRounds) != 2 {
+		t.Fatalf("got %v", v)
+	}
+}
+
+func TestUndoSessionFlag(t *testing.T) {
+	if _, _, err := UndoSession(0).Handle("-undo-session", []string{"0"}); err == nil {
+		t.Fatal("expected error for 0")
+	}
+	v, rest, err := UndoSession(0).Handle("-undo-session", []string{"7", "next"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *v.(*UndoSession) != 7 || len(rest) != 1 {
+		t.Fatalf("got %v %v", v, rest)
+	}
+}
+
+func TestUndoJournalRetention(t *testing.T) {
+	journal := &UndoJournal{
+		dir:         t.TempDir(),
+		maxSessions: 2,
+	}
+	for range 3 {
+		if _, err := journal.newSession(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sessions, err := journal.sessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(sessions)
+	if !slices.Equal(sessions, []int64{2, 3}) {
+		t.Fatalf("got %v", sessions)
+	}
+
+	// nothing is journaled in confidential mode
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Fork(
+		func() UndoDir { return UndoDir(t.TempDir()) },
+		func() generators.ConfidentialMode { return true },
+	).Call(func(j *UndoJournal) {
+		if j != nil {
+			t.Fatal("expecting no journal in confidential mode")
+		}
+	})
+}
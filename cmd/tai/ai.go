@@ -14,6 +14,7 @@ import (
 	"github.com/reusee/tai/generators"
 	"github.com/reusee/tai/logs"
 	"github.com/reusee/tai/loops"
+	"github.com/reusee/tai/mcps"
 	"github.com/reusee/tai/memories"
 	"github.com/reusee/tai/modes"
 	"github.com/reusee/tai/nets"
@@ -133,6 +134,7 @@ var AICommand = Command{
 		getDefaultSummarizer states.GetDefaultSummarizer,
 		summarizeThoughts flags.SummarizeThoughts,
 		thoughtSummaryWriter states.ThoughtSummaryWriter,
+		connectMCP mcps.Connect,
 	) {
 		ctx := context.Background()
 
@@ -208,6 +210,15 @@ var AICommand = Command{
 				},
 			},
 		)
+		// Tools of the configured MCP servers. See mcps.TheoryOfMCPClient.
+		if spec := generator.Spec(); spec.DisableTools == nil || !*spec.DisableTools {
+			mcpConn, err := connectMCP(ctx)
+			ce(err)
+			defer mcpConn.Close()
+			if fns := mcpConn.Functions(); len(fns) > 0 {
+				baseState = generators.WithFunctions(baseState, fns...)
+			}
+		}
 		buf := new(strings.Builder)
 		// When -summarize-thoughts is enabled, the stdout Output layer
 		// suppresses raw thoughts and the summarizer writes periodic
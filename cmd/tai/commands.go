@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"slices"
+	"strings"
 
 	"github.com/reusee/tai/flags"
 )
@@ -32,6 +34,10 @@ func (c Command) Keys() map[string]string {
 		"ping":   "Test whether a model is reachable and can emit blocks in the required format",
 		"goal":   "Work toward a goal through multiple independent generation loops",
 		"record": "Record interaction sessions and analyze them for self-improvement",
+		"mcp":    "Serve the change engine and Go tools over the Model Context Protocol",
+		"models": "Print the model spec tree and check models against provider listings",
+		"resume": "Resume an interrupted session or goal from its checkpoint",
+		"undo":   "Revert rounds flushed to disk from the undo journal",
 	}
 }
 
@@ -70,6 +76,33 @@ func (c Command) Handle(key string, args []string) (newDef any, remainArgs []str
 		ret := RecordCommand
 		return &ret, args, nil
 
+	case "mcp":
+		ret := MCPCommand
+		return &ret, args, nil
+
+	case "models":
+		ret := ModelsCommand
+		return &ret, args, nil
+
+	case "resume":
+		// tai resume [id] [flags]: the flags are parsed after the
+		// checkpointed arguments. See TheoryOfResumeCommand.
+		var resumeArgs ResumeArgs
+		if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+			resumeArgs.ID = args[0]
+			args = args[1:]
+		}
+		resumeArgs.Flags = args
+		ret := ResumeCommand
+		ret.Defs = append(slices.Clone(ret.Defs), func() ResumeArgs {
+			return resumeArgs
+		})
+		return &ret, nil, nil
+
+	case "undo":
+		ret := UndoCommand
+		return &ret, args, nil
+
 	}
 
 	panic(fmt.Errorf("command not handle: %s", key))
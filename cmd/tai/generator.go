@@ -16,3 +16,11 @@ import (
 func eventRecorderDef(recorder *records.Recorder) generators.EventRecorder {
 	return recorder
 }
+
+// replaySessionsDef binds the interaction database to the generators-level
+// ReplaySessions interface, so a "replay" generator with replay_session
+// serves the responses of a recorded session. It is forked alongside
+// eventRecorderDef. See generators.TheoryOfReplay.
+func replaySessionsDef(recorder *records.Recorder) generators.ReplaySessions {
+	return recorder
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/reusee/tai/codes"
 	"github.com/reusee/tai/codes/codetypes"
 	"github.com/reusee/tai/gotools"
+	"github.com/reusee/tai/loops"
 	"github.com/reusee/tai/modes"
 )
 
@@ -72,6 +73,19 @@ number — and prints them once more, aggregated, after the goal completes. The
 aggregated report lets the user review the entire process in a single table:
 token usage, durations, and round summaries across all loops, with the Loop
 column identifying which goal loop produced each round.
+
+The session budget (see loops.TheoryOfSessionBudget) spans the whole goal:
+one Budget is forked into every loop's reset scope, so the spending of all
+loops accumulates. When a loop ends with the budget exhausted, the goal
+stops with a budget-exhausted message instead of running the remaining
+iterations; the loop's last round has already flushed its changes.
+
+The goal is checkpointed like a session (see codes.TheoryOfCheckpoint): one
+Checkpoints is forked into every loop's reset scope, so each loop's rounds
+and the loop state saved after each loop go to the same checkpoint. tai
+resume continues an interrupted goal with the loop after the last completed
+one, or inside the interrupted loop from its last completed round. A goal
+stopped early keeps its checkpoint.
 `
 
 const maxGoalIterations = 20
@@ -161,6 +175,8 @@ var GoalCommand = Command{
 		output Output,
 		reset dscope.Reset,
 		runReview codes.RunReview,
+		budget *loops.Budget,
+		checkpoints *codes.Checkpoints,
 	) {
 		ctx := context.Background()
 
@@ -218,11 +234,47 @@ var GoalCommand = Command{
 		// Loop column of the aggregated statistics reflect the true count.
 		loopsRun := 0
 
+		// A resumed goal continues after its last completed loop with the
+		// loop state checkpointed then. See codes.TheoryOfCheckpoint.
+		if resumed := checkpoints.BeginGoal(); resumed != nil {
+			loopsRun = resumed.Loop
+			feedback = GoalFeedback(resumed.Feedback)
+			pendingDoneVerification = resumed.PendingDone
+			lastErrMsg = resumed.LastError
+			consecutiveErrors = resumed.ConsecutiveErrors
+			allStats = resumed.Stats
+			allDiffs = resumed.Diffs
+			fmt.Fprintf(output, "\n=== Goal Resumed after %d loop(s) ===\n", loopsRun)
+		}
+
+		// saveCheckpoint checkpoints the loop state after each loop.
+		saveCheckpoint := func() {
+			if err := checkpoints.SaveGoal(codes.GoalCheckpoint{
+				Loop:              loopsRun,
+				Feedback:          string(feedback),
+				PendingDone:       pendingDoneVerification,
+				LastError:         lastErrMsg,
+				ConsecutiveErrors: consecutiveErrors,
+				Stats:             allStats,
+				Diffs:             allDiffs,
+			}); err != nil {
+				fmt.Fprintf(os.Stderr, "Goal checkpoint not saved: %v\n", err)
+			}
+		}
+
 		// runOneLoop executes a single generation loop and updates the
 		// shared loop state. It returns true when the goal command should
 		// stop after this loop (goal confirmed or repeated-error stop).
 		runOneLoop := func() bool {
-			scope := reset()
+			// The session budget is shared by every loop, so spending
+			// accumulates across goal iterations. See
+			// loops.TheoryOfSessionBudget.
+			// The loops share the checkpoint of the goal. See
+			// codes.TheoryOfCheckpoint.
+			scope := reset().Fork(
+				func() *loops.Budget { return budget },
+				func() *codes.Checkpoints { return checkpoints },
+			)
 			if feedback != "" {
 				scope = scope.Fork(func() GoalFeedback { return feedback })
 			}
@@ -252,6 +304,19 @@ var GoalCommand = Command{
 				// Retain this loop's session diffs for the review loop.
 				// See TheoryOfReviewLoop.
 				allDiffs = append(allDiffs, result.Diffs...)
+
+				// An exhausted session budget stops the goal: the loop
+				// finished its last round and flushed its changes, and
+				// further loops would exceed the budget. See
+				// loops.TheoryOfSessionBudget.
+				if budget.Exhausted() {
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "Goal loop %d failed: %v\n", loopsRun, err)
+					}
+					fmt.Fprintf(output, "\n=== Goal Stopped: session budget exhausted after %d loop(s) (%s) ===\n", loopsRun, budget)
+					stopRequested = true
+					return
+				}
 				if err != nil {
 					// Print the error and continue to the next loop.
 					// Transient errors (API rate limits) may resolve in
@@ -367,6 +432,7 @@ var GoalCommand = Command{
 				feedback = ""
 			})
 
+			saveCheckpoint()
 			return achieved || stopRequested
 		}
 
@@ -394,6 +460,14 @@ var GoalCommand = Command{
 			fmt.Fprintf(output, "\n=== Goal Not Achieved after %d loops ===\n", loopsRun)
 		}
 
+		// A goal stopped early keeps its checkpoint for resumption. See
+		// codes.TheoryOfCheckpoint.
+		if !stopRequested {
+			if err := checkpoints.Remove(); err != nil {
+				fmt.Fprintf(os.Stderr, "Goal checkpoint not removed: %v\n", err)
+			}
+		}
+
 		// Review all changes made during the goal after the goal completes.
 		// See TheoryOfReviewLoop.
 		if err := runReview(ctx, os.Stdout, allDiffs); err != nil {
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"io"
@@ -14,6 +15,7 @@ import (
 	"github.com/reusee/tai/changes"
 	"github.com/reusee/tai/codes"
 	"github.com/reusee/tai/loops"
+	"github.com/reusee/tai/modes"
 )
 
 func TestGoalCommandRegistered(t *testing.T) {
@@ -106,10 +108,10 @@ func TestGoalCommandStopsAfterDoneBlock(t *testing.T) {
 	}
 	os.Stdout = w
 
-	mainFn := GoalCommand.Main.(func(Output, dscope.Reset, codes.RunReview))
+	mainFn := GoalCommand.Main.(func(Output, dscope.Reset, codes.RunReview, *loops.Budget, *codes.Checkpoints))
 	mainFn(Output(os.Stdout), reset, codes.RunReview(func(ctx context.Context, output io.Writer, diffs []changes.FileDiff) error {
 		return nil
-	}))
+	}), new(loops.Budget), nil)
 
 	w.Close()
 	os.Stdout = oldStdout
@@ -163,10 +165,10 @@ func TestGoalCommandReportsParseErrors(t *testing.T) {
 	os.Stdout = wOut
 	os.Stderr = wErr
 
-	mainFn := GoalCommand.Main.(func(Output, dscope.Reset, codes.RunReview))
+	mainFn := GoalCommand.Main.(func(Output, dscope.Reset, codes.RunReview, *loops.Budget, *codes.Checkpoints))
 	mainFn(Output(os.Stdout), reset, codes.RunReview(func(ctx context.Context, output io.Writer, diffs []changes.FileDiff) error {
 		return nil
-	}))
+	}), new(loops.Budget), nil)
 
 	wOut.Close()
 	wErr.Close()
@@ -222,10 +224,10 @@ func TestGoalCommandUnattendedErrorRecovery(t *testing.T) {
 		os.Stdout = wOut
 		os.Stderr = wErr
 
-		mainFn := GoalCommand.Main.(func(Output, dscope.Reset, codes.RunReview))
+		mainFn := GoalCommand.Main.(func(Output, dscope.Reset, codes.RunReview, *loops.Budget, *codes.Checkpoints))
 		mainFn(Output(os.Stdout), reset, codes.RunReview(func(ctx context.Context, output io.Writer, diffs []changes.FileDiff) error {
 			return nil
-		}))
+		}), new(loops.Budget), nil)
 
 		wOut.Close()
 		wErr.Close()
@@ -298,10 +300,10 @@ func TestGoalCommandUnattendedErrorRecovery(t *testing.T) {
 		os.Stdout = wOut
 		os.Stderr = wErr
 
-		mainFn := GoalCommand.Main.(func(Output, dscope.Reset, codes.RunReview))
+		mainFn := GoalCommand.Main.(func(Output, dscope.Reset, codes.RunReview, *loops.Budget, *codes.Checkpoints))
 		mainFn(Output(os.Stdout), reset, codes.RunReview(func(ctx context.Context, output io.Writer, diffs []changes.FileDiff) error {
 			return nil
-		}))
+		}), new(loops.Budget), nil)
 
 		wOut.Close()
 		wErr.Close()
@@ -370,10 +372,10 @@ func TestGoalCommandAggregatesStatistics(t *testing.T) {
 	}
 	os.Stdout = w
 
-	mainFn := GoalCommand.Main.(func(Output, dscope.Reset, codes.RunReview))
+	mainFn := GoalCommand.Main.(func(Output, dscope.Reset, codes.RunReview, *loops.Budget, *codes.Checkpoints))
 	mainFn(Output(os.Stdout), reset, codes.RunReview(func(ctx context.Context, output io.Writer, diffs []changes.FileDiff) error {
 		return nil
-	}))
+	}), new(loops.Budget), nil)
 
 	w.Close()
 	os.Stdout = oldStdout
@@ -442,10 +444,10 @@ func TestGoalCommandVerifiesDoneBlockInFreshLoop(t *testing.T) {
 	}
 	os.Stdout = w
 
-	mainFn := GoalCommand.Main.(func(Output, dscope.Reset, codes.RunReview))
+	mainFn := GoalCommand.Main.(func(Output, dscope.Reset, codes.RunReview, *loops.Budget, *codes.Checkpoints))
 	mainFn(Output(os.Stdout), reset, codes.RunReview(func(ctx context.Context, output io.Writer, diffs []changes.FileDiff) error {
 		return nil
-	}))
+	}), new(loops.Budget), nil)
 
 	w.Close()
 	os.Stdout = oldStdout
@@ -467,3 +469,127 @@ func TestGoalCommandVerifiesDoneBlockInFreshLoop(t *testing.T) {
 		t.Fatal("expected goal not achieved message when the verification loop overturns the declaration")
 	}
 }
+
+func TestGoalCommandStopsOnBudget(t *testing.T) {
+	// The session budget spans every goal loop: each loop's spending
+	// accumulates in the shared Budget, and the goal stops once it is
+	// exhausted instead of running the remaining iterations. See
+	// loops.TheoryOfSessionBudget.
+	calls := 0
+	fakeScope := dscope.New(
+		func() *loops.Budget {
+			return nil
+		},
+		func(budget *loops.Budget) codes.GenerateWithResultWithStats {
+			return func(ctx context.Context, output io.Writer) (loops.Result, []codes.RoundStat, error) {
+				calls++
+				budget.Add(100, 0)
+				return loops.Result{}, nil, nil
+			}
+		},
+	)
+	reset := dscope.Reset(func() dscope.Scope { return fakeScope })
+
+	oldStdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+
+	mainFn := GoalCommand.Main.(func(Output, dscope.Reset, codes.RunReview, *loops.Budget, *codes.Checkpoints))
+	mainFn(Output(os.Stdout), reset, codes.RunReview(func(ctx context.Context, output io.Writer, diffs []changes.FileDiff) error {
+		return nil
+	}), &loops.Budget{MaxTokens: 250}, nil)
+
+	w.Close()
+	os.Stdout = oldStdout
+	output, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	if calls != 3 {
+		t.Fatalf("expected 3 loops within the budget, got %d", calls)
+	}
+	if !strings.Contains(string(output), "session budget exhausted after 3 loop(s)") {
+		t.Fatalf("expected budget-exhausted message, got: %s", output)
+	}
+	if strings.Contains(string(output), "Goal Not Achieved") {
+		t.Fatalf("budget stop must not report the goal as not achieved, got: %s", output)
+	}
+}
+
+func TestGoalCommandResumesFromCheckpoint(t *testing.T) {
+	// A resumed goal continues after the last completed loop with the
+	// checkpointed feedback, and removes the checkpoint once the goal is
+	// achieved. See codes.TheoryOfCheckpoint.
+	dir := codes.CheckpointDir(t.TempDir())
+	scope := dscope.New(
+		modes.ForTest(t),
+		new(codes.Module),
+	).Fork(
+		func() codes.CheckpointDir { return dir },
+	)
+	var cp *codes.Checkpoint
+	scope.Call(func(checkpoints *codes.Checkpoints, loadCheckpoint codes.LoadCheckpoint) {
+		checkpoints.BeginGoal()
+		if err := checkpoints.SaveGoal(codes.GoalCheckpoint{
+			Loop:     3,
+			Feedback: "fix it",
+			Stats:    []codes.RoundStat{{Loop: 3, Round: 1}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		cp, err = loadCheckpoint(checkpoints.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	var feedbacks []GoalFeedback
+	fakeScope := dscope.New(
+		func() GoalFeedback {
+			return ""
+		},
+		func(feedback GoalFeedback) codes.GenerateWithResultWithStats {
+			return func(ctx context.Context, output io.Writer) (loops.Result, []codes.RoundStat, error) {
+				feedbacks = append(feedbacks, feedback)
+				return loops.Result{
+					RemainingBlocks: []blocks.Block{{Kind: "done"}},
+				}, []codes.RoundStat{{Round: 1}}, nil
+			}
+		},
+	)
+	reset := dscope.Reset(func() dscope.Scope { return fakeScope })
+
+	scope.Fork(func() codes.ResumeCheckpoint {
+		return codes.ResumeCheckpoint{
+			Checkpoint: cp,
+		}
+	}).Call(func(checkpoints *codes.Checkpoints, loadCheckpoint codes.LoadCheckpoint) {
+		var output bytes.Buffer
+		mainFn := GoalCommand.Main.(func(Output, dscope.Reset, codes.RunReview, *loops.Budget, *codes.Checkpoints))
+		mainFn(Output(&output), reset, codes.RunReview(func(ctx context.Context, output io.Writer, diffs []changes.FileDiff) error {
+			return nil
+		}), new(loops.Budget), checkpoints)
+
+		if len(feedbacks) != 2 || feedbacks[0] != "fix it" {
+			t.Fatalf("got feedbacks %q", feedbacks)
+		}
+		for _, want := range []string{
+			"Goal Resumed after 3 loop(s)",
+			"Goal Loop 4/20",
+			"Goal Achieved after 5 loop(s)",
+		} {
+			if !strings.Contains(output.String(), want) {
+				t.Fatalf("missing %q in output:\n%s", want, output.String())
+			}
+		}
+		if _, err := loadCheckpoint(""); err == nil {
+			t.Fatal("expecting the checkpoint removed")
+		}
+	})
+}
@@ -54,8 +54,9 @@ func main() {
 	// wire it individually; generators record API-level events
 	// (api_call, api_error) through their dscope-injected EventRecorder
 	// instead of receiving the recorder through the context. See
-	// generators.TheoryOfEventRecorder.
-	scope = scope.Fork(eventRecorderDef)
+	// generators.TheoryOfEventRecorder. The same database serves recorded
+	// sessions to replay generators (see generators.TheoryOfReplay).
+	scope = scope.Fork(eventRecorderDef, replaySessionsDef)
 
 	if bool(scope.Get[Tui]()) {
 		runWithTUI(command, scope)
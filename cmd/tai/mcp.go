@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/reusee/dscope"
+	"github.com/reusee/tai/blocks"
+	"github.com/reusee/tai/changes"
+	"github.com/reusee/tai/codes/codetypes"
+	"github.com/reusee/tai/generators"
+	"github.com/reusee/tai/gotools"
+	"github.com/reusee/tai/mcps"
+	"github.com/reusee/tai/modes"
+	"github.com/reusee/tai/security"
+)
+
+const TheoryOfMCPCommand = `
+The "mcp" subcommand serves tai's editing engine to editors and other
+agents as an MCP server over stdin and stdout (see mcps.TheoryOfMCPServer),
+without any model. Logs go to stderr, so stdout carries only protocol
+messages. The tools:
+
+- apply_changes applies the change blocks in its text through
+  changes.ApplyChangeBlocksStore to a fresh MemoryStore over the working
+  tree and returns the resulting diffs as a preview. With write set, the
+  MemoryStore is flushed, with the same write conflict detection the
+  generation pipeline uses; a failing block writes nothing.
+- resolve_go_symbols resolves go-src symbols with gotools.ResolveGoSymbols.
+- package_docs renders the focus and context package documentation that
+  gotools.CodeProvider assembles for the model, within a token budget.
+- validate_shell_command checks a command line with
+  security.ValidateShellCommand, the policy applied to shell blocks.
+
+The Go tools resolve their providers from a reset scope on every call, so
+each call loads the packages from the current working tree instead of a
+snapshot taken when the server started. The flags of the command line
+(such as -focus) apply to every call.
+`
+
+// mcpPackageDocsTokens is the default token budget of package_docs.
+const mcpPackageDocsTokens = 64 * 1024
+
+var MCPCommand = Command{
+	Defs: []any{
+		modes.ForProduction(),
+		func(
+			provider gotools.CodeProvider,
+		) codetypes.CodeProvider {
+			return provider
+		},
+	},
+	Main: func(
+		reset dscope.Reset,
+		applyChangeBlocksStore changes.ApplyChangeBlocksStore,
+		writeTimes *changes.FileWriteTimes,
+		undoJournal *changes.UndoJournal,
+		countTokens generators.BPETokenCounter,
+	) {
+		root, err := os.OpenRoot(".")
+		ce(err)
+		defer root.Close()
+
+		functions := []*generators.Function{
+			{
+				Decl: generators.FuncDecl{
+					Name:        "apply_changes",
+					Description: "Apply tai change blocks to the working tree and return the resulting diffs. Without write, nothing is written and the diffs are a preview.",
+					Params: generators.Vars{
+						{
+							Name:        "blocks",
+							Type:        generators.TypeString,
+							Description: "Text containing heredoc-delimited change blocks.",
+						},
+						{
+							Name:        "write",
+							Type:        generators.TypeBoolean,
+							Optional:    true,
+							Description: "Write the changes to disk instead of previewing them.",
+						},
+					},
+				},
+				Func: func(args map[string]any) (map[string]any, error) {
+					text, _ := args["blocks"].(string)
+					write, _ := args["write"].(bool)
+					bs, err := blocks.ParseBlocks([]byte(text))
+					if err != nil {
+						return nil, err
+					}
+					var changeBlocks []blocks.Block
+					for _, block := range bs {
+						if block.Kind == "change" {
+							changeBlocks = append(changeBlocks, block)
+						}
+					}
+					if len(changeBlocks) == 0 {
+						return nil, fmt.Errorf("no change blocks found")
+					}
+					store := changes.NewMemoryStore(changes.NewRootStoreWithWriteTimes(root, writeTimes))
+					store.SetJournal(undoJournal)
+					if err := applyChangeBlocksStore(changeBlocks, store); err != nil {
+						return nil, err
+					}
+					diffs := changes.FormatFileDiffs(store.Diffs())
+					if write {
+						if err := store.Flush(); err != nil {
+							return nil, err
+						}
+					}
+					return map[string]any{
+						"output":  diffs,
+						"written": write,
+					}, nil
+				},
+			},
+
+			{
+				Decl: generators.FuncDecl{
+					Name:        "resolve_go_symbols",
+					Description: "Return the declaration source of Go symbols, or the documentation of Go packages. Symbol forms follow go doc: Name, Type.Method, optionally qualified by a package import path.",
+					Params: generators.Vars{
+						{
+							Name: "symbols",
+							Type: generators.TypeArray,
+							ItemType: &generators.Var{
+								Name: "symbol",
+								Type: generators.TypeString,
+							},
+						},
+					},
+				},
+				Func: func(args map[string]any) (ret map[string]any, err error) {
+					var symbols []string
+					list, _ := args["symbols"].([]any)
+					for _, symbol := range list {
+						symbols = append(symbols, fmt.Sprint(symbol))
+					}
+					reset().Call(func(resolve gotools.ResolveGoSymbols) {
+						var parts []generators.Part
+						parts, err = resolve(symbols)
+						ret = map[string]any{
+							"output": partsText(parts),
+						}
+					})
+					return
+				},
+			},
+
+			{
+				Decl: generators.FuncDecl{
+					Name:        "package_docs",
+					Description: "Render the documentation of the focus and context Go packages of the working directory, as tai presents them to a model.",
+					Params: generators.Vars{
+						{
+							Name:        "max_tokens",
+							Type:        generators.TypeInteger,
+							Optional:    true,
+							Description: fmt.Sprintf("Token budget of the rendered documentation. Default %d.", mcpPackageDocsTokens),
+						},
+					},
+				},
+				Func: func(args map[string]any) (ret map[string]any, err error) {
+					maxTokens := mcpPackageDocsTokens
+					if n, ok := args["max_tokens"].(float64); ok && n > 0 {
+						maxTokens = int(n)
+					}
+					reset().Call(func(provider codetypes.CodeProvider) {
+						var parts []generators.Part
+						parts, err = provider.Parts(maxTokens, countTokens, nil)
+						ret = map[string]any{
+							"output": partsText(parts),
+						}
+					})
+					return
+				},
+			},
+
+			{
+				Decl: generators.FuncDecl{
+					Name:        "validate_shell_command",
+					Description: "Check whether a shell command line passes tai's shell security policy.",
+					Params: generators.Vars{
+						{
+							Name: "command",
+							Type: generators.TypeString,
+						},
+					},
+				},
+				Func: func(args map[string]any) (map[string]any, error) {
+					command, _ := args["command"].(string)
+					if err := security.ValidateShellCommand(command); err != nil {
+						return map[string]any{
+							"valid": false,
+							"error": err.Error(),
+						}, nil
+					}
+					return map[string]any{
+						"output": "ok",
+						"valid":  true,
+					}, nil
+				},
+			},
+		}
+
+		server := mcps.NewServer("tai", functions...)
+		ce(server.Serve(context.Background(), os.Stdin, os.Stdout))
+	},
+}
+
+// partsText renders parts as text for tool results: text parts verbatim,
+// file parts as placeholders.
+func partsText(parts []generators.Part) string {
+	b := new(strings.Builder)
+	for _, part := range parts {
+		switch part := part.(type) {
+		case generators.Text:
+			b.WriteString(string(part))
+		case generators.FileContent:
+			fmt.Fprintf(b, "[file content omitted: %s, %d bytes]\n", part.MimeType, len(part.Content))
+		case generators.FileURL:
+			fmt.Fprintf(b, "[file] %s\n", part)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+
+	"github.com/reusee/tai/generators"
+	"github.com/reusee/tai/modes"
+)
+
+const TheoryOfModelsCommand = `
+The models subcommand prints the model inventory: what the -model flag
+resolves to, the configured spec tree with aliases, redirect targets, the
+merged fields and confidential mode eligibility of every spec a path can
+resolve to, and the built-in model names. With -check it also asks each
+provider for its model listing and flags specs whose model is no longer
+served.
+
+- tai models                 -> print the spec tree
+- tai models -model fast     -> also show what fast resolves to
+- tai models -check          -> flag models missing from provider listings
+
+See generators.TheoryOfModelInventory.
+`
+
+var ModelsCommand = Command{
+	Defs: []any{
+		modes.ForProduction(),
+	},
+	Main: func(
+		output Output,
+		check generators.CheckModels,
+		modelInventory generators.ModelInventory,
+	) {
+		ce(modelInventory(context.Background(), bool(check), output))
+	},
+}
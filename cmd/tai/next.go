@@ -156,6 +156,7 @@ var NextCommand = Command{
 		loopRun loops.Run,
 		recorder *records.Recorder,
 		writeTimes *changes.FileWriteTimes,
+		undoJournal *changes.UndoJournal,
 		getDefaultSummarizer states.GetDefaultSummarizer,
 		summarizeThoughts flags.SummarizeThoughts,
 		thoughtSummaryWriter states.ThoughtSummaryWriter,
@@ -177,7 +178,10 @@ var NextCommand = Command{
 		// file modified externally since the last write is rejected at
 		// flush time. See changes.TheoryOfInMemoryApply and
 		// changes.TheoryOfWriteConflictDetection.
+		// The flushed round is journaled for tai undo. See
+		// changes.TheoryOfUndoJournal.
 		memStore := changes.NewMemoryStore(changes.NewRootStoreWithWriteTimes(root, writeTimes))
+		memStore.SetJournal(undoJournal)
 
 		// generate
 		logger.Info("generate", "model", generator.Spec().Model)
@@ -17,6 +17,9 @@ analysis.
 - tai record -session 5 -> show the full transcript of session 5
 - tai record -analyze [-session 5] -> analyze session 5 (or the most
   recent session) with the model
+- tai record -analyze -last 20 [-batch] -> analyze the 20 most recent
+  sessions together, as provider batch jobs at batch prices with -batch
+  (see generators.TheoryOfBatch)
 
 Recording itself is enabled by the -record flag; every generation command
 (go, any, ai, next, goal) records through the unified generation loop. The
@@ -34,14 +37,24 @@ var RecordCommand = Command{
 		sessionID records.SessionID,
 		analyze records.Analyze,
 		limit records.SessionLimit,
+		last records.LastSessions,
 		runAnalysis records.RunAnalysis,
+		recentSessionIDs records.RecentSessionIDs,
 		showSession records.ShowSession,
 		listSessions records.ListSessions,
 	) {
 		ctx := context.Background()
 
 		if bool(analyze) {
-			ce(runAnalysis(ctx, int64(sessionID), output))
+			var ids []int64
+			if last > 0 {
+				var err error
+				ids, err = recentSessionIDs(int(last))
+				ce(err)
+			} else if sessionID != 0 {
+				ids = []int64{int64(sessionID)}
+			}
+			ce(runAnalysis(ctx, ids, output))
 			return
 		}
 
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/reusee/dscope"
+	"github.com/reusee/tai/codes"
+	"github.com/reusee/tai/configs"
+	"github.com/reusee/tai/flags"
+	"github.com/reusee/tai/generators"
+	"github.com/reusee/tai/modes"
+	"github.com/reusee/tai/taiconfigs"
+)
+
+const TheoryOfResumeCommand = `
+The resume subcommand continues a session or goal interrupted between
+rounds from its checkpoint (see codes.TheoryOfCheckpoint).
+
+- tai resume               -> resume the most recently updated checkpoint
+- tai resume <id>          -> resume the checkpoint with the id
+- tai resume <id> -model x -> resume with flags added after the
+  checkpointed arguments
+
+The command reconstructs the scope the interrupted run had: it changes to
+the checkpoint's directory, loads the config files found from there, and
+parses the checkpointed arguments, then the flags following resume, over
+the scope with the default command, as main does. The resumed command
+runs with codes.ResumeCheckpoint set, in the TUI when the arguments ask
+for it. A run in confidential mode is refused, since it keeps no
+checkpoint.
+`
+
+// ResumeArgs are the checkpoint id and the flags given to the resume
+// command. See TheoryOfResumeCommand.
+type ResumeArgs struct {
+	ID    string
+	Flags []string
+}
+
+func (Module) ResumeArgs() ResumeArgs {
+	return ResumeArgs{}
+}
+
+var ResumeCommand = Command{
+	Defs: []any{
+		modes.ForProduction(),
+	},
+	Main: func(
+		output Output,
+		scope dscope.Scope,
+		args ResumeArgs,
+		loadCheckpoint codes.LoadCheckpoint,
+		tui Tui,
+	) {
+		cp, err := loadCheckpoint(args.ID)
+		ce(err)
+		fmt.Fprintf(output, "Resuming %s in %s: tai %s\n", cp.ID, cp.Dir, strings.Join(cp.Args, " "))
+		ce(os.Chdir(cp.Dir))
+
+		// the providers depending on the working directory are
+		// re-evaluated
+		m := new(Module)
+		scope = scope.Fork(
+			new(taiconfigs.Module),
+			m.InGoModule,
+			m.Command,
+		)
+		scope, err = configs.Load(scope.Get[configs.Loader](), scope)
+		ce(err)
+		scope, err = flags.Parse(scope, slices.Concat(cp.Args, args.Flags))
+		ce(err)
+		if bool(scope.Get[generators.ConfidentialMode]()) {
+			// no checkpoints in confidential mode. See
+			// codes.TheoryOfCheckpoint.
+			ce(fmt.Errorf("checkpoints are not resumed in confidential mode"))
+		}
+		scope = scope.Fork(func() codes.ResumeCheckpoint {
+			return codes.ResumeCheckpoint{
+				Checkpoint: cp,
+			}
+		})
+
+		command := scope.Get[Command]()
+		if command.Main == nil {
+			return
+		}
+		if bool(scope.Get[Tui]()) && !bool(tui) {
+			runWithTUI(command, scope)
+			return
+		}
+		scope.Fork(command.Defs...).Call(command.Main)
+	},
+}
@@ -10,11 +10,13 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gdamore/tcell/v3/color"
 	"github.com/gdamore/tcell/v3/tty"
 	"github.com/reusee/dscope"
 	"github.com/reusee/tai/blocks"
+	"github.com/reusee/tai/changes"
 	"github.com/reusee/tai/codes"
 	"github.com/reusee/tai/flags"
 	"github.com/reusee/tai/generators"
@@ -150,6 +152,21 @@ states.HandoffObserver provider drives the title state: HandoffStart sets
 the handoff flag, HandoffEnd clears it.
 `
 
+const TheoryOfTUIApproval = `
+Under -approve (see codes.TheoryOfHunkApproval) the TUI provides
+codes.ApproveHunks: each hunk is written to the Output tab and an approval
+bar at the bottom of the screen names the hunk and the answer keys. While
+a hunk waits for its answer, y, n, e, a and d answer it as in the plain
+CLI prompt; every other key keeps its binding, so the user can scroll the
+Output tab or open the help before deciding. Editing a hunk suspends the
+TUI: Run stops the key reader, restores the terminal, runs the editor,
+then restarts the terminal and redraws the whole screen; an edit Run never
+takes, because the context is done or Run has returned, fails instead of
+blocking the generation. The stdio terminal writes to os.Stdout, which
+runWithTUI redirects to the null device, so the real stdout is put back
+while the terminal restarts.
+`
+
 const TheoryOfSummaryExtraction = `
 taiui summary extraction theory:
 - Summary blocks are extracted into the Summary tab only from the model's
@@ -577,6 +594,16 @@ type TUI struct {
 	mouseDragStartY      int
 	mouseDragStartOffset int
 
+	// approval is the hunk waiting for the user's decision, nil when no
+	// decision is pending. suspendCh carries the functions to run with the
+	// TUI suspended, runDone is closed when Run returns, and stdout is the
+	// terminal's stdout before runWithTUI redirected it. See
+	// TheoryOfTUIApproval.
+	approval  *tuiApproval
+	suspendCh chan tuiSuspend
+	runDone   chan struct{}
+	stdout    *os.File
+
 	tty      tty.Tty
 	screen   *taiui.TerminalScreen
 	updateCh chan struct{}
@@ -624,6 +651,8 @@ func newTUI() (*TUI, error) {
 		tty:          t,
 		screen:       taiui.NewTerminalScreen(t, width, height),
 		updateCh:     make(chan struct{}, 1),
+		suspendCh:    make(chan tuiSuspend),
+		runDone:      make(chan struct{}),
 		width:        width,
 		height:       height,
 	}, nil
@@ -888,6 +917,8 @@ func (t *TUI) toggleSplit() {
 }
 
 func (t *TUI) Run(gen func()) error {
+	// a pending edit must not wait for a TUI that is gone
+	defer close(t.runDone)
 	io.WriteString(t.tty, "\x1b[?25l")
 	defer func() {
 		io.WriteString(t.tty, "\x1b[0m\x1b[?25h")
@@ -903,7 +934,7 @@ func (t *TUI) Run(gen func()) error {
 	resizeCh := make(chan bool, 4)
 	t.tty.NotifyResize(resizeCh)
 	keyCh := make(chan string, 16)
-	go taiui.ReadKeys(t.tty, keyCh)
+	keysDone := t.readKeys(keyCh)
 
 	go func() {
 		defer func() {
@@ -945,6 +976,10 @@ func (t *TUI) Run(gen func()) error {
 			if key != "quit" {
 				t.cancelConfirmQuit()
 			}
+			// a pending hunk approval takes its answer keys
+			if t.answerApproval(key) {
+				continue
+			}
 			switch {
 			case strings.HasPrefix(key, taiui.MouseKeyPrefix):
 				t.handleMouseKey(key)
@@ -989,17 +1024,165 @@ func (t *TUI) Run(gen func()) error {
 				}
 			}
 		case <-t.updateCh:
-		case <-resizeCh:
-			if ws, err := t.tty.WindowSize(); err == nil && ws.Width > 0 && ws.Height > 0 {
-				t.mu.Lock()
-				t.width, t.height = ws.Width, ws.Height
-				t.mu.Unlock()
-				t.screen.Resize(ws.Width, ws.Height)
+		case s := <-t.suspendCh:
+			err := t.runSuspended(s.fn, keysDone, resizeCh)
+			close(s.done)
+			if err != nil {
+				return err
 			}
+			keysDone = t.readKeys(keyCh)
+		case <-resizeCh:
+			t.resize()
 		}
 	}
 }
 
+// resize fits the screen to the terminal's window size.
+func (t *TUI) resize() {
+	if ws, err := t.tty.WindowSize(); err == nil && ws.Width > 0 && ws.Height > 0 {
+		t.mu.Lock()
+		t.width, t.height = ws.Width, ws.Height
+		t.mu.Unlock()
+		t.screen.Resize(ws.Width, ws.Height)
+	}
+}
+
+// readKeys reads the keys of the terminal into keyCh, returning a channel
+// closed when the reading stops.
+func (t *TUI) readKeys(keyCh chan string) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		taiui.ReadKeys(t.tty, keyCh)
+	}()
+	return done
+}
+
+// tuiApproval is a hunk waiting for the user's answer. See
+// TheoryOfTUIApproval.
+type tuiApproval struct {
+	hunk    changes.Hunk
+	answers chan string
+}
+
+// tuiSuspend is a function to run with the TUI suspended; done is closed
+// when the TUI is restored. See TheoryOfTUIApproval.
+type tuiSuspend struct {
+	fn   func()
+	done chan struct{}
+}
+
+// approveHunks is the TUI's codes.ApproveHunks: the hunks are shown in
+// the Output tab and answered with the approval keys. See
+// TheoryOfTUIApproval.
+func (t *TUI) approveHunks(ctx context.Context, hunks []changes.Hunk) ([]changes.HunkDecision, error) {
+	defer func() {
+		t.mu.Lock()
+		t.approval = nil
+		t.mu.Unlock()
+		t.notify()
+	}()
+	return codes.PromptHunks(
+		ctx,
+		t.Writer(),
+		hunks,
+		func(hunk changes.Hunk) (string, error) {
+			approval := &tuiApproval{
+				hunk:    hunk,
+				answers: make(chan string, 1),
+			}
+			t.mu.Lock()
+			t.approval = approval
+			t.mu.Unlock()
+			t.notify()
+			select {
+			case key := <-approval.answers:
+				return key, nil
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		},
+		func(hunk changes.Hunk) ([]string, error) {
+			return t.editHunk(ctx, hunk)
+		},
+	)
+}
+
+// answerApproval answers the pending hunk approval with key, reporting
+// whether the key is taken. Keys other than the answer keys keep their
+// bindings. See TheoryOfTUIApproval.
+func (t *TUI) answerApproval(key string) bool {
+	switch key {
+	case "y", "n", "e", "a", "d":
+	default:
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.approval == nil {
+		return false
+	}
+	t.approval.answers <- key
+	t.approval = nil
+	return true
+}
+
+// editHunk edits the hunk with the TUI suspended. It fails when ctx is
+// done or Run has returned before the TUI takes the edit. See
+// TheoryOfTUIApproval.
+func (t *TUI) editHunk(ctx context.Context, hunk changes.Hunk) (lines []string, err error) {
+	done := make(chan struct{})
+	select {
+	case t.suspendCh <- tuiSuspend{
+		fn: func() {
+			lines, err = codes.EditHunk(hunk)
+		},
+		done: done,
+	}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-t.runDone:
+		return nil, fmt.Errorf("edit hunk: TUI stopped")
+	}
+	<-done
+	return
+}
+
+// runSuspended runs fn with the terminal restored to its normal mode and
+// then restarts the TUI. keysDone is the key reader's done channel; the
+// reader stops when the terminal stops and is restarted by the caller.
+// See TheoryOfTUIApproval.
+func (t *TUI) runSuspended(fn func(), keysDone chan struct{}, resizeCh chan bool) error {
+	t.disableMouse()
+	io.WriteString(t.tty, "\x1b[0m\x1b[2J\x1b[H\x1b[?25h")
+	if err := t.tty.Stop(); err != nil {
+		return err
+	}
+	select {
+	case <-keysDone:
+	case <-time.After(time.Second):
+	}
+
+	fn()
+
+	// the stdio terminal takes os.Stdout as its output when it starts
+	if t.stdout != nil {
+		redirected := os.Stdout
+		os.Stdout = t.stdout
+		defer func() {
+			os.Stdout = redirected
+		}()
+	}
+	if err := t.tty.Start(); err != nil {
+		return err
+	}
+	t.tty.NotifyResize(resizeCh)
+	io.WriteString(t.tty, "\x1b[?25l")
+	t.enableMouse()
+	t.resize()
+	return nil
+}
+
 // enableMouse switches the terminal into SGR mouse reporting (button
 // events, button-held motion, and extended coordinates). It is called
 // when the TUI starts, so wheel, click, and drag events arrive as input.
@@ -1400,6 +1583,7 @@ func runWithTUI(command Command, scope dscope.Scope) {
 	// and the wrapper appends the decorator to the options before
 	// delegating. See TheoryOfTUI.
 	originalRun := scope.Get[loops.Run]()
+	tui.stdout = oldOut
 	// The TUI's raw-thought display is governed by -no-thoughts alone:
 	// -summarize-thoughts adds periodic summaries in the Summary tab but
 	// never suppresses the raw stream, because blanking the focused
@@ -1433,6 +1617,9 @@ func runWithTUI(command Command, scope dscope.Scope) {
 		// redirected null device in TUI mode. See
 		// codes.TheoryOfRoundStatistics.
 		func() codes.RoundStatsWriter { return codes.RoundStatsWriter(tui.Writer()) },
+		// Hunks of -approve are answered with the TUI's keys. See
+		// TheoryOfTUIApproval.
+		func() codes.ApproveHunks { return tui.approveHunks },
 		func() loops.Run {
 			return withTUIOutputObserver(originalRun, tui)
 		},
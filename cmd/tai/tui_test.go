@@ -12,6 +12,7 @@ import (
 
 	"github.com/gdamore/tcell/v3/color"
 	"github.com/gdamore/tcell/v3/tty"
+	"github.com/reusee/tai/changes"
 	"github.com/reusee/tai/flags"
 	"github.com/reusee/tai/generators"
 	"github.com/reusee/tai/loops"
@@ -2824,3 +2825,66 @@ func TestTUIWrappedDisplayLargeOutputPerformance(t *testing.T) {
 		t.Fatalf("expected 50001 cached lines, got %d", tui.outputCache.count)
 	}
 }
+
+func TestTUIApproveHunks(t *testing.T) {
+	tui := newTUIForTest()
+	tui.updateCh = make(chan struct{}, 1)
+	hunks := []changes.Hunk{
+		{Path: "a.txt", Count: 2, Added: []string{"a"}},
+		{Path: "a.txt", Index: 1, Count: 2, Added: []string{"b"}},
+	}
+	done := make(chan []changes.HunkDecision)
+	go func() {
+		decisions, err := tui.approveHunks(context.Background(), hunks)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- decisions
+	}()
+
+	answer := func(key string) {
+		for {
+			tui.mu.Lock()
+			pending := tui.approval != nil
+			tui.mu.Unlock()
+			if pending {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if !tui.answerApproval(key) {
+			t.Fatalf("key %q not taken", key)
+		}
+	}
+	// keys other than the answer keys keep their bindings
+	answer("y")
+	if tui.answerApproval("split") {
+		t.Fatal("expecting split not taken")
+	}
+	answer("n")
+	decisions := <-done
+	if len(decisions) != 2 || decisions[0].Action != changes.HunkAccept || decisions[1].Action != changes.HunkReject {
+		t.Fatalf("got %+v", decisions)
+	}
+	if tui.approval != nil {
+		t.Fatal("expecting no pending approval")
+	}
+	if tui.answerApproval("y") {
+		t.Fatal("expecting y not taken without a pending approval")
+	}
+}
+
+func TestTUIEditHunkNotTaken(t *testing.T) {
+	// an edit the TUI never takes must not block the generation
+	tui := newTUIForTest()
+	tui.runDone = make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := tui.editHunk(ctx, changes.Hunk{Path: "a.txt"}); err == nil {
+		t.Fatal("expecting error when the context is done")
+	}
+	close(tui.runDone)
+	if _, err := tui.editHunk(context.Background(), changes.Hunk{Path: "a.txt"}); err == nil {
+		t.Fatal("expecting error when Run has returned")
+	}
+}
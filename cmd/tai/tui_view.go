@@ -198,6 +198,8 @@ var tuiHelpLines = []string{
 	"wheel / drag\tscroll pane under cursor",
 	"q / Ctrl-C\tquit (press again to confirm)",
 	"?\ttoggle this help overlay",
+	"y / n / e\taccept / reject / edit the pending hunk (-approve)",
+	"a / d\taccept / reject the rest of the hunk's file",
 }
 
 func buildRoot(t *TUI, width, height int, displays [3][]taiui.Line) taiui.Element {
@@ -239,6 +241,25 @@ func buildRoot(t *TUI, width, height int, displays [3][]taiui.Line) taiui.Elemen
 			),
 		)
 	}
+	if t.approval != nil {
+		// A pending hunk approval draws an approval bar over the bottom
+		// row of the screen, naming the hunk and the answer keys. See
+		// TheoryOfTUIApproval.
+		hunk := t.approval.hunk
+		root = taiui.Overlay(
+			root,
+			taiui.Rect(
+				taiui.Box{Top: height - 1, Left: 0, Bottom: height, Right: width},
+				taiui.Fill(true),
+				taiui.BGColor(taiui.HexColor(0x005f00)),
+				taiui.Bold(true),
+				taiui.Text(fmt.Sprintf(
+					" %s hunk %d/%d: y accept  n reject  e edit  a accept file  d reject file ",
+					hunk.Path, hunk.Index+1, hunk.Count,
+				)),
+			),
+		)
+	}
 	if t.confirmQuit {
 		// A pending quit confirmation draws a confirmation bar over the
 		// bottom row of the screen, on top of every tab, so it is always
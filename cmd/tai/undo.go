@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/reusee/tai/changes"
+	"github.com/reusee/tai/modes"
+)
+
+const TheoryOfUndoCommand = `
+The undo subcommand reverts rounds flushed to disk, from the undo journal
+every flush into the working tree appends to (see
+changes.TheoryOfUndoJournal).
+
+- tai undo               -> revert the last round flushed under the
+  current directory
+- tai undo -rounds 3     -> revert the last 3 rounds flushed under the
+  current directory
+- tai undo -undo-session 7
+                         -> revert every round of journal session 7
+                            (the session id printed by -list, not a
+                            records session id)
+- tai undo -list         -> list the journaled rounds
+- tai undo -force        -> revert even files modified since the rounds
+
+The reverted rounds are printed before the files are restored. A file
+modified since the rounds refuses the undo without touching any file,
+unless -force is given.
+`
+
+var UndoCommand = Command{
+	Defs: []any{
+		modes.ForProduction(),
+	},
+	Main: func(
+		output Output,
+		journal *changes.UndoJournal,
+		rounds changes.UndoRounds,
+		session changes.UndoSession,
+		force changes.UndoForce,
+		list changes.UndoList,
+	) {
+		if journal == nil {
+			ce(fmt.Errorf("no undo journal"))
+		}
+		all, err := journal.Rounds()
+		ce(err)
+
+		if bool(list) {
+			for _, round := range all {
+				printUndoRound(output, round)
+			}
+			return
+		}
+
+		dir, err := os.Getwd()
+		ce(err)
+		selected := changes.SelectUndoRounds(all, dir, int(rounds), int64(session))
+		if len(selected) == 0 {
+			ce(fmt.Errorf("no round to undo"))
+		}
+		for _, round := range selected {
+			printUndoRound(output, round)
+		}
+		ce(journal.Undo(selected, bool(force)))
+		fmt.Fprintf(output, "Undone %d round(s)\n", len(selected))
+	},
+}
+
+func printUndoRound(w io.Writer, round changes.UndoRound) {
+	fmt.Fprintf(w, "undo-session %d round %d  %s  %s\n",
+		round.Session,
+		round.Round,
+		round.Time.Format("2006-01-02 15:04:05"),
+		round.Dir,
+	)
+	for _, file := range round.Files {
+		switch {
+		case file.RenamedFrom != "":
+			fmt.Fprintf(w, "  R %s -> %s\n", file.RenamedFrom, file.Path)
+		case !file.BeforeExists:
+			fmt.Fprintf(w, "  A %s\n", file.Path)
+		case !file.AfterExists:
+			fmt.Fprintf(w, "  D %s\n", file.Path)
+		default:
+			fmt.Fprintf(w, "  M %s\n", file.Path)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package codes
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/reusee/tai/changes"
+)
+
+const TheoryOfHunkApproval = `
+-apply writes a round's changes to the working tree as soon as the round
+succeeds and -no-apply writes nothing; -approve sits between them. Before
+the MemoryStore of a successful round is flushed, the round's pending
+changes (MemoryStore.PendingDiffs, against the files on disk) are split
+into hunks (changes.SplitHunks) — each maximal run of changed lines with
+three lines of context, or a whole new or deleted file — and presented to
+the user one by one through ApproveHunks: the plain CLI prompts on the
+terminal, the TUI shows the hunk in the Output tab with an approval bar.
+
+Each hunk is accepted, rejected, or edited in $VISUAL or $EDITOR, where
+the user rewrites the hunk's added lines; the rest of the file's hunks can
+be accepted or rejected at once. A new or deleted file is one hunk: an
+empty edit keeps the original, any other edit writes the file. The
+decisions are applied to the pending changes (changes.ApplyHunkDecisions,
+MemoryStore.Revise) and only the result is flushed, so the undo journal,
+the write conflict checks and the session diffs see exactly what was
+written.
+
+Rejected and edited hunks are fed back to the model as a user message in
+the next round (loops.RunOptions.RoundFeedback), which starts the round
+even when no component triggers, so the model knows which changes were
+declined instead of building on changes that are not on disk. Review
+sessions ask for approval like the session they review; candidate sessions
+flush into their candidate stores and are not approved, the winning
+candidate's store is approved before it is flushed to disk (see
+TheoryOfCandidates).
+`
+
+// ApproveHunks asks the user to decide on each hunk of a round's pending
+// changes, returning one decision per hunk. See TheoryOfHunkApproval.
+type ApproveHunks func(ctx context.Context, hunks []changes.Hunk) ([]changes.HunkDecision, error)
+
+func (Module) ApproveHunks() ApproveHunks {
+	return func(ctx context.Context, hunks []changes.Hunk) ([]changes.HunkDecision, error) {
+		terminal, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+		if err != nil {
+			return nil, fmt.Errorf("approval needs a terminal: %w", err)
+		}
+		// closing the terminal ends a read left pending by a canceled
+		// ctx
+		defer terminal.Close()
+		readLine := lineReader(terminal)
+		return PromptHunks(
+			ctx,
+			terminal,
+			hunks,
+			func(hunk changes.Hunk) (string, error) {
+				fmt.Fprint(terminal, "Accept this hunk [y,n,e,a,d,?]? ")
+				line, err := readLine(ctx)
+				if err != nil {
+					return "", fmt.Errorf("approval: %w", err)
+				}
+				return strings.TrimSpace(line), nil
+			},
+			EditHunk,
+		)
+	}
+}
+
+// lineReader returns a function reading the next line of r, returning
+// early when ctx is done. The read runs in a goroutine and is kept for the
+// next call, so no line is lost, and no read is pending between calls,
+// so an editor run between the answers has the terminal to itself.
+func lineReader(r io.Reader) func(ctx context.Context) (string, error) {
+	type result struct {
+		line string
+		err  error
+	}
+	reader := bufio.NewReader(r)
+	var pending chan result
+	return func(ctx context.Context) (string, error) {
+		if pending == nil {
+			pending = make(chan result, 1)
+			go func(ch chan<- result) {
+				line, err := reader.ReadString('\n')
+				ch <- result{line, err}
+			}(pending)
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case res := <-pending:
+			pending = nil
+			return res.line, res.err
+		}
+	}
+}
+
+const hunkPromptHelp = `y - accept this hunk
+n - reject this hunk
+e - edit the hunk's added lines
+a - accept this hunk and the rest of the file's hunks
+d - reject this hunk and the rest of the file's hunks
+`
+
+// PromptHunks shows each hunk on out and decides on it by the user's
+// answer, like git add -p: y accepts, n rejects, e edits, a and d accept
+// or reject the rest of the file's hunks. The plain CLI reads the
+// answers from the terminal and the TUI from its keys. See
+// TheoryOfHunkApproval.
+func PromptHunks(
+	ctx context.Context,
+	out io.Writer,
+	hunks []changes.Hunk,
+	answer func(hunk changes.Hunk) (string, error),
+	edit func(hunk changes.Hunk) ([]string, error),
+) ([]changes.HunkDecision, error) {
+	decisions := make([]changes.HunkDecision, len(hunks))
+	for i := 0; i < len(hunks); i++ {
+		hunk := hunks[i]
+		fmt.Fprintf(out, "\n%s", hunk.Format())
+	prompt:
+		for {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			key, err := answer(hunk)
+			if err != nil {
+				return nil, err
+			}
+			switch key {
+			case "y":
+				decisions[i].Action = changes.HunkAccept
+			case "n":
+				decisions[i].Action = changes.HunkReject
+			case "e":
+				lines, err := edit(hunk)
+				if err != nil {
+					fmt.Fprintf(out, "edit: %v\n", err)
+					continue
+				}
+				decisions[i] = changes.HunkDecision{
+					Action: changes.HunkEdit,
+					Lines:  lines,
+				}
+			case "a", "d":
+				action := changes.HunkAccept
+				if key == "d" {
+					action = changes.HunkReject
+				}
+				for ; i < len(hunks) && hunks[i].Path == hunk.Path; i++ {
+					decisions[i].Action = action
+				}
+				i--
+			default:
+				fmt.Fprint(out, hunkPromptHelp)
+				continue
+			}
+			break prompt
+		}
+	}
+	return decisions, nil
+}
+
+// EditHunk opens the hunk's added lines in $VISUAL or $EDITOR (vi by
+// default) on the terminal and returns the edited lines. See
+// TheoryOfHunkApproval.
+func EditHunk(hunk changes.Hunk) ([]string, error) {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	// the extension of the file keeps the editor's syntax highlighting
+	f, err := os.CreateTemp("", "tai-hunk-*"+filepath.Ext(hunk.Path))
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	content := strings.Join(hunk.Added, "\n")
+	if len(hunk.Added) > 0 {
+		content += "\n"
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	terminal, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer terminal.Close()
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", f.Name())
+	cmd.Stdin = terminal
+	cmd.Stdout = terminal
+	cmd.Stderr = terminal
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+
+	edited, err := os.ReadFile(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimSuffix(string(edited), "\n")
+	if text == "" {
+		return nil, nil
+	}
+	return strings.Split(text, "\n"), nil
+}
+
+// approveRound asks for the approval of the round's pending changes in
+// memStore and revises them with the decisions. It returns the feedback
+// for the model on the declined changes, or the empty string. See
+// TheoryOfHunkApproval.
+func approveRound(ctx context.Context, memStore *changes.MemoryStore, approve ApproveHunks) (string, error) {
+	diffs := memStore.PendingDiffs()
+	var hunks []changes.Hunk
+	var fileHunks [][]changes.Hunk
+	for _, diff := range diffs {
+		split := changes.SplitHunks(diff)
+		fileHunks = append(fileHunks, split)
+		hunks = append(hunks, split...)
+	}
+	if len(hunks) == 0 {
+		return "", nil
+	}
+	decisions, err := approve(ctx, hunks)
+	if err != nil {
+		return "", err
+	}
+	if len(decisions) != len(hunks) {
+		return "", errors.New("approval: decision count mismatch")
+	}
+	next := 0
+	for i, diff := range diffs {
+		fileDecisions := decisions[next : next+len(fileHunks[i])]
+		next += len(fileHunks[i])
+		memStore.Revise(changes.ApplyHunkDecisions(diff, fileDecisions))
+	}
+	return changes.FormatHunkFeedback(hunks, decisions), nil
+}
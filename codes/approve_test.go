@@ -0,0 +1,118 @@
+package codes
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/reusee/tai/changes"
+)
+
+func TestPromptHunks(t *testing.T) {
+	hunks := []changes.Hunk{
+		{Path: "a.txt", Count: 1, Added: []string{"a"}},
+		{Path: "b.txt", Count: 3, Added: []string{"b1"}},
+		{Path: "b.txt", Index: 1, Count: 3, Added: []string{"b2"}},
+		{Path: "b.txt", Index: 2, Count: 3, Added: []string{"b3"}},
+	}
+	answers := []string{"?", "e", "d"}
+	var out strings.Builder
+	decisions, err := PromptHunks(
+		context.Background(),
+		&out,
+		hunks,
+		func(hunk changes.Hunk) (string, error) {
+			answer := answers[0]
+			answers = answers[1:]
+			return answer, nil
+		},
+		func(hunk changes.Hunk) ([]string, error) {
+			return []string{"edited"}, nil
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decisions) != 4 ||
+		decisions[0].Action != changes.HunkEdit || decisions[0].Lines[0] != "edited" ||
+		decisions[1].Action != changes.HunkReject ||
+		decisions[2].Action != changes.HunkReject ||
+		decisions[3].Action != changes.HunkReject {
+		t.Fatalf("got %+v", decisions)
+	}
+	if !strings.Contains(out.String(), hunkPromptHelp) || !strings.Contains(out.String(), "=== b.txt (hunk 1/3) ===") {
+		t.Fatalf("got %q", out.String())
+	}
+}
+
+func TestApproveRound(t *testing.T) {
+	dir := t.TempDir()
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	if err := root.WriteFile("a.txt", []byte("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := changes.NewMemoryStore(changes.NewRootStore(root))
+	if err := store.WriteFile("a.txt", []byte("1\nTWO\n3\n4\n5\n6\n7\n8\n9\nTEN\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	feedback, err := approveRound(context.Background(), store, func(ctx context.Context, hunks []changes.Hunk) ([]changes.HunkDecision, error) {
+		if len(hunks) != 2 {
+			t.Fatalf("got %+v", hunks)
+		}
+		return []changes.HunkDecision{
+			{Action: changes.HunkAccept},
+			{Action: changes.HunkReject},
+		}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(feedback, "- 10\n+ TEN") {
+		t.Fatalf("got feedback %q", feedback)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := root.ReadFile("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "1\nTWO\n3\n4\n5\n6\n7\n8\n9\n10\n" {
+		t.Fatalf("got %q", content)
+	}
+}
+
+func TestLineReader(t *testing.T) {
+	r, w := io.Pipe()
+	defer r.Close()
+	readLine := lineReader(r)
+
+	// a canceled read returns without input
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := readLine(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v", err)
+	}
+
+	// the pending read keeps the line for the next call
+	go func() {
+		io.WriteString(w, "y\nn\n")
+	}()
+	for _, want := range []string{"y\n", "n\n"} {
+		line, err := readLine(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != want {
+			t.Fatalf("got %q, want %q", line, want)
+		}
+	}
+}
@@ -0,0 +1,502 @@
+package codes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"cuelang.org/go/cue"
+	"github.com/reusee/dscope"
+	"github.com/reusee/tai/changes"
+	"github.com/reusee/tai/configs"
+	"github.com/reusee/tai/flags"
+	"github.com/reusee/tai/generators"
+	"github.com/reusee/tai/gotools"
+	"github.com/reusee/tai/logs"
+	"github.com/reusee/tai/loops"
+)
+
+const TheoryOfCandidates = `
+Models differ in which tasks they get right, and one run of one model is a
+single sample. With -candidates (a comma-separated model list, or the
+candidates config list) GenerateWithResultWithStats dispatches to
+RunCandidates, which runs one complete generation session per model
+concurrently and keeps only the best result.
+
+Each candidate session runs in a fresh scope (like the review loop, see
+TheoryOfReviewLoop) with ModelName set to the candidate and SessionStore
+set to a candidate MemoryStore over the working tree. The session's round
+store flushes into the candidate store instead of the disk, so candidates
+see their own earlier rounds, never each other's, and the working tree is
+untouched while they run. The go-test component of a candidate session
+runs against the candidate store through a go test overlay (see
+gotools.TheoryOfGoTestBlocks), so the model's own test rounds see its
+changes. Other reads — request-context files, go-src symbols, the initial
+context — see the working tree, which is the shared base of all
+candidates. Streaming output is written line by line with a [model]
+prefix so concurrent sessions stay readable; the per-session statistics
+tables are suppressed and replaced by the comparison.
+
+When all sessions end, every candidate that changed files is tested with
+go test ./... over its overlay, then scored by the reviewer (the first
+review model, or the -model model) from the task and the diff, with
+GenerateJSON. The winner is the candidate that did not fail and ranks
+first by its tests — passed, then untested (no changes, or nothing run),
+then failed — and then by the highest score; ties keep the order of the
+list. Only
+the winner's store is flushed to disk, through the write conflict
+detection of the working tree (see changes.TheoryOfWriteConflictDetection),
+and its diffs are returned, so a following review loop reviews the
+winner. With -approve the candidate sessions are not approved, the
+winner's changes are, before the flush (see TheoryOfHunkApproval); the
+declined changes are not written, and the returned diffs are the
+approved ones. The comparison (status, tests, score, changed files and lines,
+tokens, cost) and each candidate's changed files are printed to the
+RoundStatsWriter, or the output when none is configured, followed by the
+winner's round statistics. If every candidate fails, nothing is flushed
+and the errors are returned.
+
+The candidates share one session budget (see loops.TheoryOfSessionBudget):
+the Budget of the caller is forked into every candidate scope, so N
+candidates together spend at most the limits, not N times them. The
+reviewer's scoring calls are charged to the same budget. No candidate is
+launched and no candidate is scored once it is exhausted; an unscored
+candidate keeps score 0 and competes on its tests.
+`
+
+// Candidates lists the models of best-of-N generation. When set,
+// GenerateWithResultWithStats runs RunCandidates. See TheoryOfCandidates.
+type Candidates []string
+
+func (Module) Candidates() Candidates {
+	return nil
+}
+
+var _ flags.Flag = Candidates(nil)
+
+func (c Candidates) Keys() map[string]string {
+	return map[string]string{
+		"-candidates": "Generate with each of the comma-separated models concurrently and keep the best result",
+	}
+}
+
+func (c Candidates) Handle(key string, args []string) (newDef any, remainArgs []string, err error) {
+	if len(args) == 0 {
+		return nil, nil, fmt.Errorf("expecting model list argument, got empty")
+	}
+	ret := slices.Clone(c)
+	for model := range strings.SplitSeq(args[0], ",") {
+		if model = strings.TrimSpace(model); model != "" {
+			ret = append(ret, model)
+		}
+	}
+	return &ret, args[1:], nil
+}
+
+var _ configs.Config = Candidates(nil)
+
+func (c Candidates) ConfigPaths() []string {
+	return []string{"candidates"}
+}
+
+func (c Candidates) HandleConfig(path string, values []*cue.Value) (any, error) {
+	ret := slices.Clone(c)
+	for _, v := range values {
+		var list []string
+		if err := v.Decode(&list); err != nil {
+			return nil, err
+		}
+		ret = append(ret, list...)
+	}
+	return &ret, nil
+}
+
+// SessionStore is the store the changes of a generation session are
+// flushed to after each successful round. A nil Store is the working tree;
+// candidate sessions set their candidate store. See TheoryOfCandidates.
+type SessionStore struct {
+	Store *changes.MemoryStore
+}
+
+func (Module) SessionStore() SessionStore {
+	return SessionStore{}
+}
+
+// RunCandidates runs a generation session per candidate model and flushes
+// the best one. See TheoryOfCandidates.
+type RunCandidates func(ctx context.Context, output io.Writer) (loops.Result, []RoundStat, error)
+
+type candidate struct {
+	model   string
+	store   *changes.MemoryStore
+	result  loops.Result
+	stats   []RoundStat
+	err     error
+	diffs   []changes.FileDiff
+	tested  bool
+	passed  bool
+	score   int
+	comment string
+}
+
+// candidateScore is the reviewer's verdict on a candidate.
+type candidateScore struct {
+	Score   int    `json:"score"`
+	Comment string `json:"comment"`
+}
+
+const candidateScoreSystemPrompt = `你是代码审核者。下面给出一个编码任务，以及某个模型为完成该任务对代码所做的全部改动（diff）。请判断这些改动完成任务的程度：正确性、完整性、是否引入错误、是否符合代码库原有的风格。给出 0 到 100 的整数分数（score），100 表示完全正确地完成了任务；并用一两句话说明理由（comment）。`
+
+func (Module) RunCandidates(
+	reset dscope.Reset,
+	candidates Candidates,
+	reviewModels ReviewModels,
+	modelName flags.ModelName,
+	getGenerator generators.GetGenerator,
+	flagChats flags.Chats,
+	writeTimes *changes.FileWriteTimes,
+	undoJournal *changes.UndoJournal,
+	roundStatsWriter RoundStatsWriter,
+	logger logs.Logger,
+	budget *loops.Budget,
+	apply flags.Apply,
+	approve flags.Approve,
+	approveHunks ApproveHunks,
+) RunCandidates {
+	return func(ctx context.Context, output io.Writer) (loops.Result, []RoundStat, error) {
+		root, err := os.OpenRoot(".")
+		if err != nil {
+			return loops.Result{}, nil, err
+		}
+		defer root.Close()
+
+		var cands []*candidate
+		for _, model := range candidates {
+			store := changes.NewMemoryStore(changes.NewRootStoreWithWriteTimes(root, writeTimes))
+			store.SetJournal(undoJournal)
+			cands = append(cands, &candidate{
+				model: model,
+				store: store,
+			})
+		}
+		if len(cands) == 0 {
+			return loops.Result{}, nil, fmt.Errorf("no candidate models")
+		}
+
+		// sessions
+		var outputLock sync.Mutex
+		var wg sync.WaitGroup
+		for _, c := range cands {
+			if budget.Exhausted() {
+				c.err = fmt.Errorf("not launched: session budget exhausted (%s)", budget)
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				w := &prefixedLineWriter{
+					w:      output,
+					lock:   &outputLock,
+					prefix: "[" + c.model + "] ",
+				}
+				defer w.Close()
+				scope := reset().Fork(
+					func() flags.ModelName {
+						return flags.ModelName(c.model)
+					},
+					func() Candidates {
+						return nil
+					},
+					func() SessionStore {
+						return SessionStore{Store: c.store}
+					},
+					func() RoundStatsWriter {
+						return RoundStatsWriter(io.Discard)
+					},
+					// see TheoryOfCheckpoint
+					func() *Checkpoints {
+						return nil
+					},
+					// see TheoryOfHunkApproval
+					func() flags.Approve {
+						return false
+					},
+					// the candidate sessions run concurrently
+					func() *RoundStore {
+						return new(RoundStore)
+					},
+					// and spend one budget. See
+					// loops.TheoryOfSessionBudget.
+					func() *loops.Budget {
+						return budget
+					},
+				)
+				scope.Call(func(generateWithResultWithStats GenerateWithResultWithStats) {
+					c.result, c.stats, c.err = generateWithResultWithStats(ctx, w)
+				})
+				if c.err == nil {
+					c.diffs = c.store.Diffs()
+				}
+			}()
+		}
+		wg.Wait()
+
+		// tests, one candidate at a time: go test already uses every core
+		for _, c := range cands {
+			if c.err != nil || len(c.diffs) == 0 {
+				continue
+			}
+			testOutput, failed := gotools.RunGoTest(ctx, "", gotools.DiffsOverlay(c.diffs))
+			c.tested = true
+			c.passed = !failed
+			if failed {
+				logger.InfoContext(ctx, "candidate tests failed",
+					"model", c.model,
+					"output", testOutput,
+				)
+			}
+		}
+
+		// reviewer scores
+		reviewer := string(modelName)
+		if len(reviewModels) > 0 {
+			reviewer = reviewModels[0]
+		}
+		var scoreGenerator generators.Generator
+		for _, c := range cands {
+			if c.err != nil || len(c.diffs) == 0 {
+				continue
+			}
+			if budget.Exhausted() {
+				logger.WarnContext(ctx, "candidates not scored: session budget exhausted", "spent", budget.String())
+				break
+			}
+			if scoreGenerator == nil {
+				scoreGenerator, err = getGenerator(reviewer)
+				if err != nil {
+					logger.WarnContext(ctx, "candidates not scored", "reviewer", reviewer, "error", err)
+					break
+				}
+				scoreGenerator = budget.Charge(scoreGenerator)
+			}
+			score, err := generators.GenerateJSON[candidateScore](ctx, scoreGenerator, generators.NewPrompts(
+				candidateScoreSystemPrompt,
+				[]*generators.Content{
+					{
+						Role: generators.RoleUser,
+						Parts: []generators.Part{
+							generators.Text("任务：\n\n" + strings.Join(flagChats, "\n") + "\n\n改动：\n" + changes.FormatFileDiffs(c.diffs)),
+						},
+					},
+				},
+			), nil)
+			if err != nil {
+				logger.WarnContext(ctx, "candidate not scored", "model", c.model, "reviewer", reviewer, "error", err)
+				continue
+			}
+			c.score = max(0, min(score.Score, 100))
+			c.comment = score.Comment
+		}
+
+		statsOutput := io.Writer(roundStatsWriter)
+		if statsOutput == nil {
+			statsOutput = output
+		}
+
+		winner := selectCandidate(cands)
+		printCandidates(statsOutput, cands, winner)
+		if winner == nil {
+			var errs []error
+			for _, c := range cands {
+				errs = append(errs, fmt.Errorf("candidate %s: %w", c.model, c.err))
+			}
+			return loops.Result{}, nil, errors.Join(errs...)
+		}
+
+		// the candidate sessions were not approved, the winner is. See
+		// TheoryOfHunkApproval.
+		if bool(apply) && bool(approve) {
+			feedback, err := approveRound(ctx, winner.store, approveHunks)
+			if err != nil {
+				return winner.result, winner.stats, fmt.Errorf("approve candidate %s: %w", winner.model, err)
+			}
+			if feedback != "" {
+				logger.InfoContext(ctx, "candidate changes declined", "model", winner.model)
+			}
+			winner.diffs = winner.store.PendingDiffs()
+		}
+		if err := winner.store.Flush(); err != nil {
+			return winner.result, winner.stats, fmt.Errorf("flush candidate %s: %w", winner.model, err)
+		}
+		PrintRoundStats(statsOutput, winner.stats, "Generation Statistics: "+winner.model)
+
+		result := winner.result
+		result.Diffs = winner.diffs
+		return result, winner.stats, nil
+	}
+}
+
+// selectCandidate returns the best candidate, nil when all failed: passed
+// tests first, then untested, then failed tests, then the score, then the
+// list order. A candidate without changes is untested.
+func selectCandidate(cands []*candidate) *candidate {
+	var best *candidate
+	for _, c := range cands {
+		if c.err != nil {
+			continue
+		}
+		switch {
+		case best == nil:
+			best = c
+		case c.testRank() != best.testRank():
+			if c.testRank() > best.testRank() {
+				best = c
+			}
+		case c.score > best.score:
+			best = c
+		}
+	}
+	return best
+}
+
+// testRank orders the test outcomes of candidates: passed, untested,
+// failed.
+func (c *candidate) testRank() int {
+	switch {
+	case c.passed:
+		return 2
+	case !c.tested:
+		return 1
+	}
+	return 0
+}
+
+// printCandidates writes the comparison of the candidates and their
+// changed files.
+func printCandidates(w io.Writer, cands []*candidate, winner *candidate) {
+	fmt.Fprintf(w, "\n=== Candidates ===\n")
+	fmt.Fprintf(w, "%-2s %-24s %-8s %-6s %6s %6s %8s %8s %12s %12s\n",
+		"", "Model", "Status", "Tests", "Score", "Files", "Added", "Removed", "Tokens", "Cost")
+	for _, c := range cands {
+		mark := ""
+		if c == winner {
+			mark = "*"
+		}
+		status := "ok"
+		if c.err != nil {
+			status = "failed"
+		}
+		tests := "-"
+		if c.tested {
+			tests = "fail"
+			if c.passed {
+				tests = "pass"
+			}
+		}
+		var added, removed int
+		for _, diff := range c.diffs {
+			a, r := diffLineCounts(diff)
+			added += a
+			removed += r
+		}
+		var tokens int
+		var cost float64
+		hasCost := false
+		for _, s := range c.stats {
+			tokens += s.PromptTokens + s.CompletionTokens + s.ThoughtTokens
+			if s.Cost != nil {
+				cost += *s.Cost
+				hasCost = true
+			}
+		}
+		costCell := "-"
+		if hasCost {
+			costCell = fmt.Sprintf("%.4f", cost)
+		}
+		fmt.Fprintf(w, "%-2s %-24s %-8s %-6s %6d %6d %8d %8d %12d %12s\n",
+			mark, c.model, status, tests, c.score, len(c.diffs), added, removed, tokens, costCell)
+	}
+
+	for _, c := range cands {
+		fmt.Fprintf(w, "\n--- %s ---\n", c.model)
+		if c.err != nil {
+			fmt.Fprintf(w, "error: %v\n", c.err)
+			continue
+		}
+		if c.comment != "" {
+			fmt.Fprintf(w, "review: %s\n", c.comment)
+		}
+		if len(c.diffs) == 0 {
+			fmt.Fprintf(w, "no changes\n")
+		}
+		for _, diff := range c.diffs {
+			a, r := diffLineCounts(diff)
+			fmt.Fprintf(w, "  %s +%d -%d\n", diff.Path, a, r)
+		}
+	}
+}
+
+// diffLineCounts returns the numbers of added and removed lines of diff,
+// counted as lines present in only one side.
+func diffLineCounts(diff changes.FileDiff) (added int, removed int) {
+	counts := make(map[string]int)
+	for line := range strings.Lines(string(diff.Original)) {
+		counts[line]++
+	}
+	for line := range strings.Lines(string(diff.Current)) {
+		if counts[line] > 0 {
+			counts[line]--
+			continue
+		}
+		added++
+	}
+	for _, n := range counts {
+		removed += n
+	}
+	return
+}
+
+// prefixedLineWriter writes complete lines to w with prefix, holding lock
+// so lines of concurrent writers do not interleave. Close flushes a
+// pending partial line.
+type prefixedLineWriter struct {
+	w       io.Writer
+	lock    *sync.Mutex
+	prefix  string
+	pending []byte
+}
+
+func (p *prefixedLineWriter) Write(data []byte) (int, error) {
+	p.pending = append(p.pending, data...)
+	i := bytes.LastIndexByte(p.pending, '\n')
+	if i < 0 {
+		return len(data), nil
+	}
+	lines := p.pending[:i+1]
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for line := range bytes.Lines(lines) {
+		if _, err := io.WriteString(p.w, p.prefix); err != nil {
+			return 0, err
+		}
+		if _, err := p.w.Write(line); err != nil {
+			return 0, err
+		}
+	}
+	p.pending = append(p.pending[:0], p.pending[i+1:]...)
+	return len(data), nil
+}
+
+func (p *prefixedLineWriter) Close() error {
+	if len(p.pending) == 0 {
+		return nil
+	}
+	_, err := p.Write([]byte("\n"))
+	return err
+}
@@ -0,0 +1,250 @@
+package codes
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/reusee/dscope"
+	"github.com/reusee/tai/changes"
+	"github.com/reusee/tai/flags"
+	"github.com/reusee/tai/generators"
+	"github.com/reusee/tai/logs"
+	"github.com/reusee/tai/loops"
+)
+
+func TestCandidatesFlag(t *testing.T) {
+	v, rest, err := Candidates{"a"}.Handle("-candidates", []string{"b, c,,d", "next"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := *v.(*Candidates); !slices.Equal(got, Candidates{"a", "b", "c", "d"}) {
+		t.Fatalf("got %v", got)
+	}
+	if !slices.Equal(rest, []string{"next"}) {
+		t.Fatalf("got rest %v", rest)
+	}
+}
+
+func TestSelectCandidate(t *testing.T) {
+	failed := &candidate{model: "failed", err: io.ErrUnexpectedEOF, score: 100}
+	failing := &candidate{model: "failing", tested: true, score: 90}
+	passing := &candidate{model: "passing", tested: true, passed: true, score: 60}
+	better := &candidate{model: "better", tested: true, passed: true, score: 80}
+	unchanged := &candidate{model: "unchanged"}
+
+	if got := selectCandidate([]*candidate{failed, failing, passing, better}); got != better {
+		t.Fatalf("got %+v", got)
+	}
+	// passing tests beat the score
+	if got := selectCandidate([]*candidate{failing, passing}); got != passing {
+		t.Fatalf("got %+v", got)
+	}
+	// passed beats untested, untested beats failed, whatever the score
+	untested := &candidate{model: "untested", score: 95}
+	if got := selectCandidate([]*candidate{untested, passing}); got != passing {
+		t.Fatalf("got %+v", got)
+	}
+	if got := selectCandidate([]*candidate{failing, unchanged}); got != unchanged {
+		t.Fatalf("got %+v", got)
+	}
+	// ties keep the list order
+	if got := selectCandidate([]*candidate{unchanged, {model: "same"}}); got != unchanged {
+		t.Fatalf("got %+v", got)
+	}
+	if got := selectCandidate([]*candidate{failed}); got != nil {
+		t.Fatalf("got %+v", got)
+	}
+}
+
+// candidateScoreGenerator scores a candidate 90 when its diff contains
+// "beta", 50 otherwise.
+type candidateScoreGenerator struct{}
+
+func (candidateScoreGenerator) Spec() generators.Spec {
+	return generators.Spec{}
+}
+
+func (candidateScoreGenerator) CountTokens(string) (int, error) {
+	return 0, nil
+}
+
+func (candidateScoreGenerator) Generate(ctx context.Context, state generators.State, options *generators.GenerateOptions) (generators.State, error) {
+	response := `{"score": 50, "comment": "partial"}`
+	for content := range state.Contents() {
+		for _, part := range content.Parts {
+			if text, ok := part.(generators.Text); ok && strings.Contains(string(text), "beta") {
+				response = `{"score": 90, "comment": "complete"}`
+			}
+		}
+	}
+	state, err := state.AppendContent(&generators.Content{
+		Role: generators.RoleModel,
+		Parts: []generators.Part{
+			generators.Text(response),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var usage generators.Usage
+	usage.Prompt.TokenCount = 5
+	return state.AppendContent(&generators.Content{
+		Role: generators.RoleLog,
+		Parts: []generators.Part{
+			usage,
+		},
+	})
+}
+
+func TestRunCandidates(t *testing.T) {
+	// Each candidate session writes result.txt into its own store; only
+	// the winner reaches the disk. The directory has no Go module, so the
+	// tests fail for every candidate and the reviewer score decides.
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if err := os.WriteFile("result.txt", []byte("base\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	budget := new(loops.Budget)
+	fakeReset := dscope.Reset(func() dscope.Scope {
+		return dscope.New(
+			func() flags.ModelName { return "" },
+			func() Candidates { return Candidates{"nested"} },
+			func() SessionStore { return SessionStore{} },
+			func() RoundStatsWriter { return nil },
+			func() *loops.Budget { return nil },
+			func(modelName flags.ModelName, candidates Candidates, store SessionStore, sessionBudget *loops.Budget) GenerateWithResultWithStats {
+				return func(ctx context.Context, output io.Writer) (loops.Result, []RoundStat, error) {
+					if len(candidates) > 0 {
+						t.Error("candidate sessions must clear Candidates")
+					}
+					if sessionBudget != budget {
+						t.Error("candidate sessions must share the budget")
+					}
+					sessionBudget.Add(10, 0)
+					if store.Store == nil {
+						t.Error("candidate sessions must have a store")
+						return loops.Result{}, nil, nil
+					}
+					if modelName == "broken" {
+						return loops.Result{}, nil, io.ErrUnexpectedEOF
+					}
+					io.WriteString(output, "working on ")
+					io.WriteString(output, "it\n")
+					if err := store.Store.WriteFile("result.txt", []byte(modelName+"\n"), 0644); err != nil {
+						return loops.Result{}, nil, err
+					}
+					return loops.Result{}, []RoundStat{{Round: 1, PromptTokens: 10}}, nil
+				}
+			},
+		)
+	})
+
+	var m Module
+	newRunCandidates := func(approve flags.Approve, approveHunks ApproveHunks) RunCandidates {
+		return m.RunCandidates(
+			fakeReset,
+			Candidates{"alpha", "beta", "broken"},
+			nil,
+			flags.ModelName("reviewer"),
+			func(name string) (generators.Generator, error) {
+				if name != "reviewer" {
+					t.Errorf("unexpected reviewer %q", name)
+				}
+				return candidateScoreGenerator{}, nil
+			},
+			flags.Chats{"say your name"},
+			changes.NewFileWriteTimes(),
+			nil,
+			nil,
+			logs.Logger{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))},
+			budget,
+			flags.Apply(true),
+			approve,
+			approveHunks,
+		)
+	}
+	runCandidates := newRunCandidates(false, nil)
+
+	var output bytes.Buffer
+	result, stats, err := runCandidates(context.Background(), &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile("result.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "beta\n" {
+		t.Fatalf("expected the winner on disk, got %q", content)
+	}
+	if len(result.Diffs) != 1 || string(result.Diffs[0].Current) != "beta\n" {
+		t.Fatalf("expected the winner diffs, got %+v", result.Diffs)
+	}
+	if len(stats) != 1 {
+		t.Fatalf("expected the winner stats, got %+v", stats)
+	}
+
+	out := output.String()
+	for _, want := range []string{
+		"[alpha] working on it\n",
+		"[beta] working on it\n",
+		"=== Candidates ===",
+		"Generation Statistics: beta",
+		"result.txt +1 -1",
+		"review: complete",
+		"error: unexpected EOF",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %q in output:\n%s", want, out)
+		}
+	}
+
+	// three sessions and two scoring calls
+	if got := budget.String(); got != "tokens 40, cost 0.0000" {
+		t.Fatalf("got %s", got)
+	}
+
+	// an exhausted budget launches nothing
+	budget.MaxTokens = 40
+	_, _, err = runCandidates(context.Background(), io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "not launched") {
+		t.Fatalf("got %v", err)
+	}
+
+	// with -approve the winner's hunks are approved before the flush
+	budget = new(loops.Budget)
+	if err := os.WriteFile("result.txt", []byte("base\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var approved []changes.Hunk
+	result, _, err = newRunCandidates(true, func(ctx context.Context, hunks []changes.Hunk) ([]changes.HunkDecision, error) {
+		approved = hunks
+		return []changes.HunkDecision{
+			{Action: changes.HunkReject},
+		}, nil
+	})(context.Background(), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(approved) != 1 || approved[0].Path != "result.txt" {
+		t.Fatalf("expected the winner's hunk, got %+v", approved)
+	}
+	content, err = os.ReadFile("result.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "base\n" {
+		t.Fatalf("rejected hunk must not be flushed, got %q", content)
+	}
+	if len(result.Diffs) != 0 {
+		t.Fatalf("expected no diffs, got %+v", result.Diffs)
+	}
+}
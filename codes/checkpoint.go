@@ -0,0 +1,454 @@
+package codes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cuelang.org/go/cue"
+	"github.com/reusee/tai/changes"
+	"github.com/reusee/tai/configs"
+	"github.com/reusee/tai/generators"
+	"github.com/reusee/tai/logs"
+	"github.com/reusee/tai/modes"
+)
+
+const TheoryOfCheckpoint = `
+A long session killed between rounds (laptop sleep, Ctrl-C, an API outage)
+would lose its whole conversation, though the changes of its completed
+rounds are on disk. Because generators.State is immutable, the state a
+round ends with is a complete snapshot, so the session is checkpointed
+after every completed round that continues with another one
+(loops.RunOptions.OnRoundEnd) and can be resumed from there.
+
+A checkpoint is a JSON file named by its id under tai/checkpoints in the
+user config directory (CheckpointDir overrides it; development mode
+without one does not checkpoint). It holds the working directory and the
+command-line arguments of the run, the contents of the next round's input
+state encoded like replay cassettes (see generators.TheoryOfReplay), the
+hash of the system prompt, the accumulated round statistics, and the
+session originals of the MemoryStore, so the resumed session's diffs and
+review still cover the interrupted run's changes. The goal command adds
+its loop state after every loop: the number of completed loops, the
+feedback and pending done verification for the next loop, the
+repeated-error tracking, and the accumulated statistics and diffs.
+
+tai resume [id] loads the checkpoint (the most recently updated one when
+no id is given), changes to its directory, parses its arguments again and
+runs the command with ResumeCheckpoint set. The first session of the
+resumed run starts from the checkpointed contents instead of a new user
+prompt and keeps saving to the same checkpoint; the system prompt is
+rebuilt and a warning is logged when its hash differs. A resumed goal
+continues with the loop after the last completed one.
+
+A session that ends without an error removes its checkpoint; one that
+fails or exhausts its budget keeps it for resumption. A goal removes its
+checkpoint when the goal is achieved or the loops run out. Review and
+candidate sessions are not checkpointed.
+
+Kept checkpoints would pile up, and each holds a whole conversation, so
+the directory is bounded: creating a checkpoint prunes the oldest ones, by
+id, beyond the CheckpointMaxCount most recent (checkpoint.max_count,
+default 20, zero for no limit); the new checkpoint counts as one of them.
+In confidential mode (see generators.TheoryOfConfidentialMode) nothing is
+checkpointed: the conversation would outlive the session on disk, so a
+confidential session cannot be resumed, and tai resume refuses to resume a
+checkpoint in confidential mode.
+`
+
+// Checkpoint is the persisted state of a session. See TheoryOfCheckpoint.
+type Checkpoint struct {
+	ID      string    `json:"id"`
+	Dir     string    `json:"dir"`
+	Args    []string  `json:"args"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+
+	// the session in progress
+	SystemPromptHash string                 `json:"system_prompt_hash,omitempty"`
+	Contents         json.RawMessage        `json:"contents,omitempty"`
+	Stats            []RoundStat            `json:"stats,omitempty"`
+	Originals        []changes.FileOriginal `json:"originals,omitempty"`
+
+	Goal *GoalCheckpoint `json:"goal,omitempty"`
+}
+
+// GoalCheckpoint is the state of the goal command after a completed loop.
+type GoalCheckpoint struct {
+	Loop              int                `json:"loop"`
+	Feedback          string             `json:"feedback,omitempty"`
+	PendingDone       bool               `json:"pending_done,omitempty"`
+	LastError         string             `json:"last_error,omitempty"`
+	ConsecutiveErrors int                `json:"consecutive_errors,omitempty"`
+	Stats             []RoundStat        `json:"stats,omitempty"`
+	Diffs             []changes.FileDiff `json:"diffs,omitempty"`
+}
+
+// CheckpointDir is the checkpoint directory. Empty means tai/checkpoints in
+// the user config directory, or no checkpoints in development mode. See
+// TheoryOfCheckpoint.
+type CheckpointDir string
+
+func (Module) CheckpointDir() CheckpointDir {
+	return ""
+}
+
+// CheckpointMaxCount is the number of most recent checkpoints kept. Zero
+// means no limit. See TheoryOfCheckpoint.
+type CheckpointMaxCount int
+
+func (Module) CheckpointMaxCount() CheckpointMaxCount {
+	return 20
+}
+
+var _ configs.Config = CheckpointMaxCount(0)
+
+func (c CheckpointMaxCount) ConfigPaths() []string {
+	return []string{"checkpoint.max_count"}
+}
+
+func (c CheckpointMaxCount) HandleConfig(path string, values []*cue.Value) (any, error) {
+	var n int
+	if err := values[0].Decode(&n); err != nil {
+		return nil, err
+	}
+	ret := CheckpointMaxCount(n)
+	return &ret, nil
+}
+
+// ResumeCheckpoint is the checkpoint the run resumes. The resume command
+// forks it; a nil Checkpoint starts a new run. See TheoryOfCheckpoint.
+type ResumeCheckpoint struct {
+	Checkpoint *Checkpoint
+}
+
+func (Module) ResumeCheckpoint() ResumeCheckpoint {
+	return ResumeCheckpoint{}
+}
+
+// Checkpoints saves the checkpoint of the run. A nil Checkpoints is
+// disabled. Commands running several sessions (goal) fork one Checkpoints
+// into the scope of every session. See TheoryOfCheckpoint.
+type Checkpoints struct {
+	dir      string
+	maxCount int
+	logger   logs.Logger
+
+	mu      sync.Mutex
+	current *Checkpoint
+	resume  *Checkpoint // session not yet resumed
+	goal    *GoalCheckpoint
+}
+
+func (Module) Checkpoints(
+	dir CheckpointDir,
+	maxCount CheckpointMaxCount,
+	mode modes.Mode,
+	logger logs.Logger,
+	resume ResumeCheckpoint,
+	confidential generators.ConfidentialMode,
+) *Checkpoints {
+	if confidential {
+		return nil
+	}
+	path, err := checkpointDir(dir, mode)
+	if err != nil {
+		logger.Warn("session not checkpointed", "error", err)
+		return nil
+	}
+	if path == "" {
+		return nil
+	}
+	c := &Checkpoints{
+		dir:      path,
+		maxCount: int(maxCount),
+		logger:   logger,
+	}
+	if cp := resume.Checkpoint; cp != nil {
+		current := *cp
+		c.current = &current
+		if len(cp.Contents) > 0 {
+			c.resume = cp
+		}
+		c.goal = cp.Goal
+	}
+	return c
+}
+
+func checkpointDir(dir CheckpointDir, mode modes.Mode) (string, error) {
+	if dir != "" || mode != modes.ModeProduction {
+		return string(dir), nil
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "tai", "checkpoints"), nil
+}
+
+// ID returns the id of the checkpoint, or the empty string before the
+// first save.
+func (c *Checkpoints) ID() string {
+	if c == nil {
+		return ""
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.current == nil {
+		return ""
+	}
+	return c.current.ID
+}
+
+// resumeSession returns the checkpoint to resume the session from, once.
+func (c *Checkpoints) resumeSession() *Checkpoint {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ret := c.resume
+	c.resume = nil
+	return ret
+}
+
+// saveSession checkpoints the session at the state the next round starts
+// from.
+func (c *Checkpoints) saveSession(systemPrompt string, state generators.State, stats []RoundStat, originals []changes.FileOriginal) error {
+	if c == nil {
+		return nil
+	}
+	contents, err := generators.EncodeContents(state.Contents())
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.init()
+	c.current.SystemPromptHash = systemPromptHash(systemPrompt)
+	c.current.Contents = contents
+	c.current.Stats = slices.Clone(stats)
+	c.current.Originals = originals
+	return c.save()
+}
+
+// endSession drops the state of a session that ended without an error:
+// the checkpoint is removed, or kept with the goal state only when the
+// session is a goal loop.
+func (c *Checkpoints) endSession() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.current == nil {
+		return nil
+	}
+	if c.goal == nil {
+		return c.remove()
+	}
+	c.clearSession()
+	return c.save()
+}
+
+// BeginGoal marks the run as a goal, so sessions ending keep the
+// checkpoint, and returns the goal state to resume, or nil.
+func (c *Checkpoints) BeginGoal() *GoalCheckpoint {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.goal != nil {
+		ret := *c.goal
+		return &ret
+	}
+	c.goal = new(GoalCheckpoint)
+	return nil
+}
+
+// SaveGoal checkpoints the goal state after a completed loop.
+func (c *Checkpoints) SaveGoal(goal GoalCheckpoint) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.goal = &goal
+	c.init()
+	c.clearSession()
+	return c.save()
+}
+
+// Remove removes the checkpoint.
+func (c *Checkpoints) Remove() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.current == nil {
+		return nil
+	}
+	return c.remove()
+}
+
+func (c *Checkpoints) init() {
+	if c.current != nil {
+		return
+	}
+	now := time.Now()
+	dir, err := os.Getwd()
+	if err != nil {
+		c.logger.Warn("checkpoint without directory", "error", err)
+	}
+	c.current = &Checkpoint{
+		ID:      now.Format("20060102-150405") + "-" + strconv.Itoa(os.Getpid()),
+		Dir:     dir,
+		Args:    slices.Clone(os.Args[1:]),
+		Created: now,
+	}
+	if err := c.prune(); err != nil {
+		c.logger.Warn("checkpoint prune", "error", err)
+	}
+}
+
+// prune removes the oldest checkpoints beyond maxCount, counting the
+// current one. See TheoryOfCheckpoint.
+func (c *Checkpoints) prune() error {
+	if c.maxCount <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(c.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !ok || id == c.current.ID {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	// ids start with the creation time
+	slices.Sort(ids)
+	for len(ids) > c.maxCount-1 {
+		if err := os.Remove(filepath.Join(c.dir, ids[0]+".json")); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		ids = ids[1:]
+	}
+	return nil
+}
+
+func (c *Checkpoints) clearSession() {
+	c.current.SystemPromptHash = ""
+	c.current.Contents = nil
+	c.current.Stats = nil
+	c.current.Originals = nil
+}
+
+func (c *Checkpoints) save() error {
+	c.current.Goal = c.goal
+	c.current.Updated = time.Now()
+	data, err := json.Marshal(c.current)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(c.dir, c.current.ID+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (c *Checkpoints) remove() error {
+	err := os.Remove(filepath.Join(c.dir, c.current.ID+".json"))
+	c.current = nil
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func systemPromptHash(systemPrompt string) string {
+	sum := sha256.Sum256([]byte(systemPrompt))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadCheckpoint loads the checkpoint with the id, or the most recently
+// updated checkpoint when id is empty. See TheoryOfCheckpoint.
+type LoadCheckpoint func(id string) (*Checkpoint, error)
+
+func (Module) LoadCheckpoint(
+	dir CheckpointDir,
+	mode modes.Mode,
+) LoadCheckpoint {
+	return func(id string) (*Checkpoint, error) {
+		path, err := checkpointDir(dir, mode)
+		if err != nil {
+			return nil, err
+		}
+		if path == "" {
+			return nil, fmt.Errorf("no checkpoint directory")
+		}
+		if id != "" {
+			// an id names a file in the directory, never a path
+			if filepath.Base(id) != id || id == "." || id == ".." {
+				return nil, fmt.Errorf("invalid checkpoint id: %q", id)
+			}
+			return readCheckpoint(filepath.Join(path, id+".json"))
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		var latest *Checkpoint
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+				continue
+			}
+			cp, err := readCheckpoint(filepath.Join(path, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			if latest == nil || cp.Updated.After(latest.Updated) {
+				latest = cp
+			}
+		}
+		if latest == nil {
+			return nil, fmt.Errorf("no checkpoint in %s", path)
+		}
+		return latest, nil
+	}
+}
+
+func readCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("checkpoint %s: %w", path, err)
+	}
+	return &cp, nil
+}
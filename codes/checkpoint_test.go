@@ -0,0 +1,290 @@
+package codes
+
+import (
+	"context"
+	"errors"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/reusee/dscope"
+	"github.com/reusee/tai/blocks"
+	"github.com/reusee/tai/changes"
+	"github.com/reusee/tai/codes/codetypes"
+	"github.com/reusee/tai/flags"
+	"github.com/reusee/tai/generators"
+	"github.com/reusee/tai/loops"
+	"github.com/reusee/tai/modes"
+	"github.com/reusee/tai/records"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	t.Chdir(t.TempDir())
+	dir := CheckpointDir(t.TempDir())
+	errInterrupted := errors.New("interrupted")
+
+	newScope := func(run loops.Run) dscope.Scope {
+		return dscope.New(
+			modes.ForTest(t),
+			new(Module),
+		).Fork(
+			func() codetypes.CodeProvider { return mockCodeProvider{} },
+			func() flags.Chats { return flags.Chats{"hello"} },
+			func() *records.Recorder { return nil },
+			func() CheckpointDir { return dir },
+			func() generators.GetDefaultGenerator {
+				return func() (generators.Generator, error) {
+					return &debugOutputMockGenerator{}, nil
+				}
+			},
+			func() loops.Run { return run },
+		)
+	}
+	texts := func(state generators.State) (ret []string) {
+		for content := range state.Contents() {
+			for _, part := range content.Parts {
+				if text, ok := part.(generators.Text); ok {
+					ret = append(ret, string(text))
+				}
+			}
+		}
+		return
+	}
+
+	// the first run completes a round, writes a file, and is interrupted
+	newScope(func(ctx context.Context, opts loops.RunOptions, result *loops.Result) iter.Seq[error] {
+		return func(yield func(error) bool) {
+			opts.OnRoundStart()
+			state, err := opts.InitialState.AppendContent(&generators.Content{
+				Role:  generators.RoleModel,
+				Parts: []generators.Part{generators.Text("round one")},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := opts.BlockHandler(blocks.Block{
+				Kind:       "change",
+				Attributes: map[string]string{"op": "WRITE", "file-path": "a.txt"},
+				Body:       "new",
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if err := opts.OnRoundSuccess(state, []string{"first"}); err != nil {
+				t.Fatal(err)
+			}
+			state, err = state.AppendContent(&generators.Content{
+				Role:  generators.RoleUser,
+				Parts: []generators.Part{generators.Text("feedback")},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := opts.OnRoundEnd(state); err != nil {
+				t.Fatal(err)
+			}
+			opts.OnRoundStart()
+			yield(errInterrupted)
+		}
+	}).Call(func(
+		generateWithResultWithStats GenerateWithResultWithStats,
+		loadCheckpoint LoadCheckpoint,
+	) {
+		if _, _, err := generateWithResultWithStats(context.Background(), io.Discard); !errors.Is(err, errInterrupted) {
+			t.Fatalf("got %v", err)
+		}
+		cp, err := loadCheckpoint("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cp.Stats) != 1 || cp.Stats[0].Summary != "first" {
+			t.Fatalf("got stats %+v", cp.Stats)
+		}
+		if len(cp.Originals) != 1 || cp.Originals[0].Path != "a.txt" || cp.Originals[0].Exists {
+			t.Fatalf("got originals %+v", cp.Originals)
+		}
+	})
+
+	// the resumed run starts from the checkpointed state and removes the
+	// checkpoint when it ends
+	var cp *Checkpoint
+	newScope(nil).Call(func(loadCheckpoint LoadCheckpoint) {
+		var err error
+		cp, err = loadCheckpoint("")
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	newScope(func(ctx context.Context, opts loops.RunOptions, result *loops.Result) iter.Seq[error] {
+		return func(yield func(error) bool) {
+			got := strings.Join(texts(opts.InitialState), "|")
+			if !strings.HasSuffix(got, "hello|round one|feedback") {
+				t.Errorf("got initial contents %q", got)
+			}
+			opts.OnRoundStart()
+			if err := opts.OnRoundSuccess(opts.InitialState, []string{"second"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}).Fork(
+		func() ResumeCheckpoint {
+			return ResumeCheckpoint{
+				Checkpoint: cp,
+			}
+		},
+	).Call(func(
+		generateWithResultWithStats GenerateWithResultWithStats,
+	) {
+		result, stats, err := generateWithResultWithStats(context.Background(), io.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stats) != 2 || stats[1].Summary != "second" {
+			t.Fatalf("got stats %+v", stats)
+		}
+		if len(result.Diffs) != 1 || result.Diffs[0].Path != "a.txt" {
+			t.Fatalf("expecting the interrupted run's diffs, got %+v", result.Diffs)
+		}
+	})
+	if _, err := os.Stat(filepath.Join(string(dir), cp.ID+".json")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expecting the checkpoint removed, got %v", err)
+	}
+}
+
+func TestCheckpointGoal(t *testing.T) {
+	dir := CheckpointDir(t.TempDir())
+	scope := dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Fork(
+		func() CheckpointDir { return dir },
+	)
+
+	var id string
+	scope.Call(func(checkpoints *Checkpoints) {
+		if checkpoints.BeginGoal() != nil {
+			t.Fatal("expecting a new goal")
+		}
+		if err := checkpoints.SaveGoal(GoalCheckpoint{
+			Loop:        2,
+			Feedback:    "verify",
+			PendingDone: true,
+			Diffs: []changes.FileDiff{
+				{Path: "a.go", CurrentExists: true},
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		// a goal loop ending keeps the goal state
+		if err := checkpoints.endSession(); err != nil {
+			t.Fatal(err)
+		}
+		id = checkpoints.ID()
+	})
+
+	scope.Call(func(loadCheckpoint LoadCheckpoint) {
+		cp, err := loadCheckpoint(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scope.Fork(func() ResumeCheckpoint {
+			return ResumeCheckpoint{
+				Checkpoint: cp,
+			}
+		}).Call(func(checkpoints *Checkpoints) {
+			goal := checkpoints.BeginGoal()
+			if goal == nil || goal.Loop != 2 || goal.Feedback != "verify" || !goal.PendingDone || len(goal.Diffs) != 1 {
+				t.Fatalf("got goal %+v", goal)
+			}
+			if checkpoints.ID() != id {
+				t.Fatalf("expecting the resumed id, got %q", checkpoints.ID())
+			}
+			if err := checkpoints.Remove(); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if _, err := loadCheckpoint(""); err == nil {
+			t.Fatal("expecting no checkpoint")
+		}
+	})
+}
+
+func TestLoadCheckpointInvalidID(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "checkpoints")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// a checkpoint outside the directory
+	if err := os.WriteFile(filepath.Join(root, "outside.json"), []byte(`{"id":"outside"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Fork(
+		func() CheckpointDir { return CheckpointDir(dir) },
+	).Call(func(loadCheckpoint LoadCheckpoint) {
+		for _, id := range []string{
+			"../outside",
+			"sub/id",
+			string(filepath.Separator) + "outside",
+			"..",
+			".",
+		} {
+			if _, err := loadCheckpoint(id); err == nil || !strings.Contains(err.Error(), "invalid checkpoint id") {
+				t.Fatalf("expecting %q rejected, got %v", id, err)
+			}
+		}
+	})
+}
+
+func TestCheckpointRetention(t *testing.T) {
+	dir := t.TempDir()
+	for _, id := range []string{
+		"20000101-000000-1",
+		"20000101-000000-2",
+		"20000101-000000-3",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, id+".json"), []byte(`{"id":"`+id+`"}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scope := dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Fork(
+		func() CheckpointDir { return CheckpointDir(dir) },
+		func() CheckpointMaxCount { return 2 },
+	)
+
+	// creating a checkpoint keeps the most recent ones, counting itself
+	scope.Call(func(checkpoints *Checkpoints) {
+		state := generators.NewPrompts("system", nil)
+		if err := checkpoints.saveSession("system", state, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		if len(names) != 2 || names[0] != "20000101-000000-3.json" || names[1] != checkpoints.ID()+".json" {
+			t.Fatalf("got %v", names)
+		}
+	})
+
+	// nothing is checkpointed in confidential mode
+	scope.Fork(
+		func() generators.ConfidentialMode { return true },
+	).Call(func(checkpoints *Checkpoints) {
+		if checkpoints != nil {
+			t.Fatal("expecting no checkpoints in confidential mode")
+		}
+	})
+}
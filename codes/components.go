@@ -43,7 +43,9 @@ pass causes the system to exit prematurely when the model intended to
 proceed. MaxRounds bounds the test-fix loop so a model cannot rerun tests
 indefinitely. The go-test component is placed after change so tests run
 against the updated source, and before summary so test output is available
-for the next round.
+for the next round. In a candidate session the updated source is in the
+candidate store, so the tests run with it as an overlay (see
+TheoryOfCandidates).
 
 The go-src component resolves go-src block symbols — Go symbol names, one
 per line — through gotools.ResolveGoSymbols, appended as user content for the
@@ -90,6 +92,18 @@ provider, which derives the family from the resolved default generator, so
 no customization is needed.
 `
 
+// Tool descriptions of the codes components in native tool mode. They
+// restate the block prompts' semantics for function calls; the block
+// syntax and the wait-for-next-round rules do not apply. See
+// components.TheoryOfComponentTools.
+const (
+	goSrcToolDescription = `Return the declaration source of Go symbols that the context shows only as a signature or documentation. Symbol forms follow go doc: a plain name for a top-level declaration, TypeName.MethodName for a method, and an optional package qualifier (prefer the full import path) restricting the match to that package. A symbol that is a loaded package returns its documentation. Focus packages appear as documentation only: fetch a declaration's source before understanding, modifying, or reviewing it. The source names its defining file; use that path in change blocks. Sources are an in-memory snapshot taken when the context was assembled and do not reflect changes made in this session. Only symbols in packages loaded in this session resolve; unmatched names are reported.`
+
+	requestContextToolDescription = `Read local files, fetch network resources (HTTP GET), or list files matching a glob pattern. Each request is one tag: <file path="..." /> reads a file (relative to the project root or absolute), <fetch addr="..." user-agent="..." referer="..." cookie="..." /> fetches a URL with optional headers, and <glob pattern="..." /> lists matching paths without reading them. Read-only: never use it for side effects.`
+
+	goTestToolDescription = `Run go test with the given arguments and return stdout and stderr, whether tests pass or fail. Arguments are passed to go test directly without a shell. Use absolute package paths; the output names the working directory. Name modified or added tests with -run for targeted runs. Tests run against the session's changes, including the change blocks emitted earlier in the current response.`
+)
+
 // CodesComponents is the component set type for the codes module. It embeds
 // components.ComponentSet as an anonymous struct field so that dscope can
 // resolve it independently from other modules' ComponentSet providers.
@@ -109,6 +123,9 @@ func (Module) CodesComponents(
 	flagShell flags.Shell,
 	applyChangeBlocks changes.ApplyChangeBlocks,
 	resolveGoSymbols gotools.ResolveGoSymbols,
+	nativeTools generators.NativeTools,
+	testThenCommit gotools.TestThenCommit,
+	roundStore *RoundStore,
 ) CodesComponents {
 	var comps components.ComponentSet
 
@@ -155,13 +172,32 @@ func (Module) CodesComponents(
 	// so tests run against updated source, and before summary so test
 	// output is available for the next round.
 	// See TheoryOfCodesComponents and gotools.TheoryOfGoTestBlocks.
+	goTestTool := &components.Tool{
+		Name:             "go_test",
+		Description:      goTestToolDescription,
+		Param:            "args",
+		ParamDescription: "go test arguments, one per entry (e.g. -run, TestName, /abs/path/pkg). Empty runs ./...",
+	}
+	if bool(testThenCommit) {
+		// A native call runs within the generation, before the round's
+		// flush is handed to the component, so the flush could not wait
+		// for it. See TheoryOfTestThenCommit.
+		goTestTool = nil
+	}
 	comps = append(comps, components.Component{
 		Kind:          "go-test",
 		PromptSection: gotools.GoTestBlockSystemPrompt,
 		RestatePrompt: gotools.GoTestBlockRestatePrompt,
 		MaxRounds:     maxGoTestRounds,
+		Tool:          goTestTool,
 		Process: func(ctx context.Context, pctx *components.ProcessContext) components.ProcessResult {
-			parts, err := gotools.ProcessGoTestBlocks(pctx.Blocks, ctx)
+			// The tests run against the session's changes, flushed
+			// or not, and the round's flush may wait for them. See
+			// TheoryOfTestThenCommit.
+			parts, failed, err := gotools.ProcessGoTestBlocks(pctx.Blocks, ctx, roundStore.Store)
+			if err == nil {
+				parts, err = roundStore.settle(parts, failed)
+			}
 			return components.ProcessResult{
 				Parts: parts,
 				Err:   err,
@@ -181,6 +217,12 @@ func (Module) CodesComponents(
 		PromptSection: gotools.GoSrcBlockSystemPrompt,
 		RestatePrompt: gotools.GoSrcBlockRestatePrompt,
 		MaxRounds:     maxGoSrcRounds,
+		Tool: &components.Tool{
+			Name:             "go_src",
+			Description:      goSrcToolDescription,
+			Param:            "symbols",
+			ParamDescription: "Go symbols in go doc form: Name, TypeName.MethodName, or import/path.Name",
+		},
 		Process: func(ctx context.Context, pctx *components.ProcessContext) components.ProcessResult {
 			symbols := gotools.ParseGoSrcSymbols(pctx.Blocks)
 			if len(symbols) == 0 {
@@ -216,6 +258,12 @@ func (Module) CodesComponents(
 		PromptSection: blocks.RequestContextSystemPrompt,
 		RestatePrompt: blocks.RequestContextRestatePrompt,
 		MaxRounds:     maxRequestContextRounds,
+		Tool: &components.Tool{
+			Name:             "request_context",
+			Description:      requestContextToolDescription,
+			Param:            "requests",
+			ParamDescription: `One request tag per entry: <file path="..." />, <fetch addr="..." />, or <glob pattern="..." />`,
+		},
 		Process: func(ctx context.Context, pctx *components.ProcessContext) components.ProcessResult {
 			state, hasRC, err := blocks.ProcessRequestContextBlocks(
 				pctx.Blocks, ctx, pctx.Root, pctx.HttpClient, pctx.State,
@@ -322,5 +370,12 @@ func (Module) CodesComponents(
 		}
 	}
 
+	// Native tools: when the default generator opts in, the go-test,
+	// go-src and request-context components are called as functions
+	// instead of blocks. See components.TheoryOfComponentTools.
+	if bool(nativeTools) {
+		comps = comps.WithNativeTools()
+	}
+
 	return CodesComponents{comps}
 }
@@ -11,12 +11,15 @@ import (
 	"time"
 
 	"github.com/reusee/dscope"
+	"github.com/reusee/tai/blocks"
 	"github.com/reusee/tai/changes"
 	"github.com/reusee/tai/codes/codetypes"
 	"github.com/reusee/tai/flags"
 	"github.com/reusee/tai/generators"
+	"github.com/reusee/tai/gotools"
 	"github.com/reusee/tai/logs"
 	"github.com/reusee/tai/loops"
+	"github.com/reusee/tai/mcps"
 	"github.com/reusee/tai/nets"
 	"github.com/reusee/tai/phases"
 	"github.com/reusee/tai/records"
@@ -87,6 +90,17 @@ instruction ("审核并修正这些改动") followed by the unified diff of all
 through the MemoryStore during the main generation session. The review model works
 from an independent context and corrects potential errors in the changes,
 improving accuracy.
+
+With -review-batch the review is queued: each review session runs with
+generators.Batch set, so every generation of the session is submitted as a
+provider batch job and billed at batch prices. The review then finishes
+minutes to hours later instead of seconds, which suits a review nobody is
+waiting on. See generators.TheoryOfBatch.
+
+The review spends the same session budget as the generation it reviews: the
+caller's Budget is forked into every review scope, and a review model is
+skipped once the budget is exhausted, so a budget that stopped the
+generation also stops the review. See loops.TheoryOfSessionBudget.
 `
 
 type Generate func(ctx context.Context, output io.Writer) error
@@ -105,14 +119,18 @@ type GenerateWithResultWithStats func(ctx context.Context, output io.Writer) (lo
 // latest filesystem state, and replaces Chats with the review instruction
 // plus the session diffs. When ReviewModels is empty, the model from the
 // -model flag is reused: the resolved generator's Spec is not reusable
-// here because built-in shortcuts (flash, gemini, ...) and the ollama
-// shorthand do not set Spec.Name, and their Spec.Model values are not
-// resolvable model names. See TheoryOfReviewLoop.
+// here because its Spec.Name is the path a redirect resolved to, not the
+// model that was asked for, and Spec.Model values are not resolvable
+// model names. The session budget of the caller is forked into
+// every review scope, and no review starts once it is exhausted. See
+// TheoryOfReviewLoop and loops.TheoryOfSessionBudget.
 func (Module) RunReview(
 	reset dscope.Reset,
 	review Review,
 	reviewModels ReviewModels,
+	reviewBatch ReviewBatch,
 	modelName flags.ModelName,
+	budget *loops.Budget,
 ) RunReview {
 	return func(ctx context.Context, output io.Writer, diffs []changes.FileDiff) error {
 		if !bool(review) || len(diffs) == 0 {
@@ -129,13 +147,34 @@ func (Module) RunReview(
 			if model == "" {
 				continue
 			}
+			if budget.Exhausted() {
+				fmt.Fprintf(output, "\nReview skipped: session budget exhausted (%s)\n", budget)
+				return nil
+			}
 			scope := reset()
+			// the review spends the caller's session budget
+			scope = scope.Fork(func() *loops.Budget {
+				return budget
+			})
 			scope = scope.Fork(func() flags.Chats {
 				return flags.Chats([]string{prompt})
 			})
 			scope = scope.Fork(func() flags.ModelName {
 				return flags.ModelName(model)
 			})
+			// a review is a single session, never best-of-N
+			scope = scope.Fork(func() Candidates {
+				return nil
+			})
+			// and is not checkpointed. See TheoryOfCheckpoint.
+			scope = scope.Fork(func() *Checkpoints {
+				return nil
+			})
+			if reviewBatch {
+				scope = scope.Fork(func() generators.Batch {
+					return true
+				})
+			}
 			var reviewErr error
 			scope.Call(func(generateWithResultWithStats GenerateWithResultWithStats) {
 				_, _, reviewErr = generateWithResultWithStats(ctx, output)
@@ -177,7 +216,10 @@ and sorted by name before measuring their token cost. The input token budget
 is the full context window (or configured max tokens) without reserving space
 for max generate tokens, because most tasks complete in a single generation
 pass and reserving output space wastes context budget that could carry more
-file context.
+file context. Every count goes through the generator's CountTokens, which
+applies the spec's tokenizer and calibration ratio (see
+generators.TheoryOfTokenCalibration); the ratio is fixed for the process,
+so the budget stays deterministic across rounds.
 `
 
 func countFuncsTokens(funcs []generators.FuncDecl, count func(string) (int, error)) (int, error) {
@@ -209,9 +251,17 @@ func buildUserPromptText(parts []generators.Part) string {
 const TheoryOfRoundStatistics = `
 Round statistics are collected per round to provide visibility into token usage
 and duration. Each round produces a single RoundStat entry with the 1-based round
-number; prompt, completion, thought, and cached token counts from the round's
-final usage; the duration (from OnRoundStart to OnRoundSuccess); and the summary
-from the round's summary blocks. Round management is decoupled from usage parts:
+number; prompt, completion, thought, and cached token counts summed over
+every Generate call of the round, the final usage of each call taken the way
+the Run loop logs it (see loops.TheoryOfUsageLogging); the duration (from
+OnRoundStart to OnRoundSuccess); and the summary from the round's summary
+blocks; and the generator fallbacks taken during the round (see
+generators.TheoryOfFallback), read from GeneratorFallback parts the same way
+usage is; and the round's cost, each call priced with the spec of the
+generator that served it (see generators.TheoryOfPricing), so the table
+agrees with the session budget. The Cost column is printed only when some
+round has a cost, and each cost is recorded through the interaction recorder.
+Round management is decoupled from usage parts:
 intermediate usage snapshots emitted during streaming (e.g., Gemini's streaming
 UsageMetadata) do not create duplicate round entries. Truncated rounds (no
 summary block) that are retried are recorded via OnRoundTruncated with the
@@ -240,6 +290,12 @@ type RoundStat struct {
 	CachedTokens     int
 	Duration         time.Duration
 	Summary          string
+	// Fallbacks lists the generator switches made during the round, as
+	// "from -> to". See generators.TheoryOfFallback.
+	Fallbacks []string
+	// Cost is the round's cost under the serving spec's prices, or nil
+	// when the spec has none. See generators.TheoryOfPricing.
+	Cost *float64
 }
 
 // RoundStatsWriter receives the round statistics table printed at the end of
@@ -272,41 +328,71 @@ func PrintRoundStats(w io.Writer, stats []RoundStat, title ...string) {
 		}
 	}
 
+	// The Cost column is shown only when some round was served by a spec
+	// with prices. See generators.TheoryOfPricing.
+	hasCost := false
+	for _, s := range stats {
+		if s.Cost != nil {
+			hasCost = true
+			break
+		}
+	}
+	costHeader := func(s string) string {
+		if !hasCost {
+			return ""
+		}
+		return fmt.Sprintf(" %12s", s)
+	}
+	costCell := func(cost *float64) string {
+		if !hasCost {
+			return ""
+		}
+		if cost == nil {
+			return fmt.Sprintf(" %12s", "-")
+		}
+		return fmt.Sprintf(" %12.4f", *cost)
+	}
+
 	fmt.Fprintf(w, "\n=== %s ===\n", header)
 	fmt.Fprintf(w, "Total rounds: %d\n\n", len(stats))
+	separator := costHeader("----")
 	if hasLoop {
-		fmt.Fprintf(w, "%-6s %-6s %12s %12s %12s %12s %12s\n", "Loop", "Round", "Prompt", "Completion", "Thoughts", "Cached", "Duration")
-		fmt.Fprintf(w, "%-6s %-6s %12s %12s %12s %12s %12s\n", "-----", "-----", "------", "----------", "--------", "-------", "--------")
+		fmt.Fprintf(w, "%-6s %-6s %12s %12s %12s %12s %12s%s\n", "Loop", "Round", "Prompt", "Completion", "Thoughts", "Cached", "Duration", costHeader("Cost"))
+		fmt.Fprintf(w, "%-6s %-6s %12s %12s %12s %12s %12s%s\n", "-----", "-----", "------", "----------", "--------", "-------", "--------", separator)
 	} else {
-		fmt.Fprintf(w, "%-6s %12s %12s %12s %12s %12s\n", "Round", "Prompt", "Completion", "Thoughts", "Cached", "Duration")
-		fmt.Fprintf(w, "%-6s %12s %12s %12s %12s %12s\n", "-----", "------", "----------", "--------", "-------", "--------")
+		fmt.Fprintf(w, "%-6s %12s %12s %12s %12s %12s%s\n", "Round", "Prompt", "Completion", "Thoughts", "Cached", "Duration", costHeader("Cost"))
+		fmt.Fprintf(w, "%-6s %12s %12s %12s %12s %12s%s\n", "-----", "------", "----------", "--------", "-------", "--------", separator)
 	}
 	var totalPrompt, totalCompletion, totalThoughts, totalCached int
 	var totalDuration time.Duration
+	var totalCost float64
 	for _, s := range stats {
 		if hasLoop {
-			fmt.Fprintf(w, "%-6d %-6d %12d %12d %12d %12d %12s\n",
+			fmt.Fprintf(w, "%-6d %-6d %12d %12d %12d %12d %12s%s\n",
 				s.Loop, s.Round, s.PromptTokens, s.CompletionTokens, s.ThoughtTokens, s.CachedTokens,
-				s.Duration.Round(time.Millisecond).String())
+				s.Duration.Round(time.Millisecond).String(), costCell(s.Cost))
 		} else {
-			fmt.Fprintf(w, "%-6d %12d %12d %12d %12d %12s\n",
+			fmt.Fprintf(w, "%-6d %12d %12d %12d %12d %12s%s\n",
 				s.Round, s.PromptTokens, s.CompletionTokens, s.ThoughtTokens, s.CachedTokens,
-				s.Duration.Round(time.Millisecond).String())
+				s.Duration.Round(time.Millisecond).String(), costCell(s.Cost))
 		}
 		totalPrompt += s.PromptTokens
 		totalCompletion += s.CompletionTokens
 		totalThoughts += s.ThoughtTokens
 		totalCached += s.CachedTokens
 		totalDuration += s.Duration
+		if s.Cost != nil {
+			totalCost += *s.Cost
+		}
 	}
 	if hasLoop {
-		fmt.Fprintf(w, "%-6s %-6s %12s %12s %12s %12s %12s\n", "-----", "-----", "------", "----------", "--------", "-------", "--------")
-		fmt.Fprintf(w, "%-6s %-6s %12d %12d %12d %12d %12s\n", "", "Total", totalPrompt, totalCompletion, totalThoughts, totalCached,
-			totalDuration.Round(time.Millisecond).String())
+		fmt.Fprintf(w, "%-6s %-6s %12s %12s %12s %12s %12s%s\n", "-----", "-----", "------", "----------", "--------", "-------", "--------", separator)
+		fmt.Fprintf(w, "%-6s %-6s %12d %12d %12d %12d %12s%s\n", "", "Total", totalPrompt, totalCompletion, totalThoughts, totalCached,
+			totalDuration.Round(time.Millisecond).String(), costCell(&totalCost))
 	} else {
-		fmt.Fprintf(w, "%-6s %12s %12s %12s %12s %12s\n", "-----", "------", "----------", "--------", "-------", "--------")
-		fmt.Fprintf(w, "%-6s %12d %12d %12d %12d %12s\n", "Total", totalPrompt, totalCompletion, totalThoughts, totalCached,
-			totalDuration.Round(time.Millisecond).String())
+		fmt.Fprintf(w, "%-6s %12s %12s %12s %12s %12s%s\n", "-----", "------", "----------", "--------", "-------", "--------", separator)
+		fmt.Fprintf(w, "%-6s %12d %12d %12d %12d %12s%s\n", "Total", totalPrompt, totalCompletion, totalThoughts, totalCached,
+			totalDuration.Round(time.Millisecond).String(), costCell(&totalCost))
 	}
 	fmt.Fprintf(w, "==============================\n")
 
@@ -331,40 +417,91 @@ func PrintRoundStats(w io.Writer, stats []RoundStat, title ...string) {
 		}
 		fmt.Fprintf(w, "==============================\n")
 	}
+
+	// Print generator switches if any occurred. See
+	// generators.TheoryOfFallback.
+	hasFallbacks := false
+	for _, s := range stats {
+		if len(s.Fallbacks) > 0 {
+			hasFallbacks = true
+			break
+		}
+	}
+	if hasFallbacks {
+		fmt.Fprintf(w, "\n=== Generator Fallbacks ===\n")
+		for _, s := range stats {
+			for _, fallback := range s.Fallbacks {
+				if hasLoop {
+					fmt.Fprintf(w, "Loop %d Round %d: %s\n", s.Loop, s.Round, fallback)
+				} else {
+					fmt.Fprintf(w, "Round %d: %s\n", s.Round, fallback)
+				}
+			}
+		}
+		fmt.Fprintf(w, "==============================\n")
+	}
 }
 
 func collectRoundStats(
 	roundStats []RoundStat,
 	state generators.State,
+	spec generators.Spec,
 	prevContentCount int,
 	elapsed time.Duration,
 	summary string,
 ) ([]RoundStat, int) {
-	var lastUsage generators.Usage
+	var fallbacks []string
 	contentIndex := 0
 	for c := range state.Contents() {
 		if contentIndex >= prevContentCount {
 			for _, part := range c.Parts {
-				if usage, ok := part.(generators.Usage); ok {
-					lastUsage = usage
+				if part, ok := part.(generators.GeneratorFallback); ok {
+					fallbacks = append(fallbacks, part.From+" -> "+part.To)
 				}
 			}
 		}
 		contentIndex++
 	}
 
+	// every Generate call of the round, priced with the spec that served
+	// it, as the session budget counts them. See
+	// loops.TheoryOfSessionBudget.
+	calls := loops.RoundCalls(state, prevContentCount, spec)
+	usage := loops.RoundUsage(calls)
+	var cost *float64
+	var total float64
+	for _, call := range calls {
+		if c, ok := call.Spec.Cost(call.Usage); ok {
+			total += c
+			cost = &total
+		}
+	}
 	roundStats = append(roundStats, RoundStat{
 		Round:            len(roundStats) + 1,
-		PromptTokens:     lastUsage.Prompt.TokenCount,
-		CompletionTokens: lastUsage.Candidates.TokenCount,
-		ThoughtTokens:    lastUsage.Thoughts.TokenCount,
-		CachedTokens:     lastUsage.Prompt.TokenCountCached,
+		PromptTokens:     usage.Prompt.TokenCount,
+		CompletionTokens: usage.Candidates.TokenCount,
+		ThoughtTokens:    usage.Thoughts.TokenCount,
+		CachedTokens:     usage.Prompt.TokenCountCached,
 		Duration:         elapsed,
 		Summary:          summary,
+		Fallbacks:        fallbacks,
+		Cost:             cost,
 	})
 	return roundStats, contentIndex
 }
 
+// recordRoundCost records the cost of the last collected round so the
+// record command can show the spend of each session. See
+// generators.TheoryOfPricing.
+func recordRoundCost(recorder *records.Recorder, roundStats []RoundStat) {
+	if len(roundStats) == 0 {
+		return
+	}
+	if cost := roundStats[len(roundStats)-1].Cost; cost != nil {
+		recorder.Cost(*cost)
+	}
+}
+
 // CreateHandoff summarizes truncated or failed generation output before
 // retry, producing a self-contained handoff carried into the next round.
 // The summarize generator, logger, and interaction recorder are bound from
@@ -566,9 +703,27 @@ func (Module) GenerateWithResultWithStats(
 	thoughtSummaryWriter states.ThoughtSummaryWriter,
 	roundStatsWriter RoundStatsWriter,
 	createHandoff CreateHandoff,
+	connectMCP mcps.Connect,
+	candidates Candidates,
+	sessionStore SessionStore,
+	runCandidates RunCandidates,
+	batch generators.Batch,
+	checkpoints *Checkpoints,
+	undoJournal *changes.UndoJournal,
+	approve flags.Approve,
+	approveHunks ApproveHunks,
+	typeCheck gotools.TypeCheck,
+	testThenCommit gotools.TestThenCommit,
+	roundStore *RoundStore,
 ) GenerateWithResultWithStats {
 	return func(ctx context.Context, output io.Writer) (loops.Result, []RoundStat, error) {
 
+		// Best-of-N: one session per candidate model, each in its own
+		// scope with Candidates cleared. See TheoryOfCandidates.
+		if len(candidates) > 0 {
+			return runCandidates(ctx, output)
+		}
+
 		// Open a root on the current directory to restrict all file I/O
 		// to the project tree. See TheoryOfRequestContext.
 		root, err := os.OpenRoot(".")
@@ -584,7 +739,19 @@ func (Module) GenerateWithResultWithStats(
 		// flush time. See TheoryOfStreamingApply,
 		// changes.TheoryOfInMemoryApply and
 		// changes.TheoryOfWriteConflictDetection.
-		memStore := changes.NewMemoryStore(changes.NewRootStoreWithWriteTimes(root, writeTimes))
+		// A candidate session flushes into its candidate store instead.
+		// See TheoryOfCandidates.
+		// Rounds flushed to disk are journaled for tai undo. See
+		// changes.TheoryOfUndoJournal.
+		var baseStore changes.FileStore = changes.NewRootStoreWithWriteTimes(root, writeTimes)
+		if sessionStore.Store != nil {
+			baseStore = sessionStore.Store
+		}
+		memStore := changes.NewMemoryStore(baseStore)
+		memStore.SetJournal(undoJournal)
+		// The go-test component tests the changes not yet flushed. See
+		// TheoryOfTestThenCommit.
+		roundStore.Store = memStore
 
 		// generator
 		generator, err := getDefaultGenerator()
@@ -611,6 +778,17 @@ func (Module) GenerateWithResultWithStats(
 			recorder.Event("decision", fmt.Sprintf("handoff generator selected: model=%s", handoffGenerator.Spec().Model))
 		}
 
+		// MCP servers are started for generators that accept tools. See
+		// mcps.TheoryOfMCPClient.
+		var mcpConn *mcps.Connection
+		if spec.DisableTools == nil || !*spec.DisableTools {
+			mcpConn, err = connectMCP(ctx)
+			if err != nil {
+				return loops.Result{}, nil, err
+			}
+			defer mcpConn.Close()
+		}
+
 		// Calculate basic limits. The full context window is available for
 		// input without reserving max generate tokens: most tasks complete
 		// in a single generation pass, so reserving output space wastes
@@ -621,8 +799,10 @@ func (Module) GenerateWithResultWithStats(
 			int(maxTokens),
 		)
 
-		// Count tokens for fixed parts
-		systemPromptTokens, err := generator.CountTokens(string(systemPrompt))
+		// Count tokens for fixed parts. Counting may call the provider,
+		// so it follows ctx. See generators.TheoryOfTokenizers.
+		countTokens := generators.CountTokensFunc(ctx, generator)
+		systemPromptTokens, err := countTokens(string(systemPrompt))
 		if err != nil {
 			return loops.Result{}, nil, err
 		}
@@ -632,11 +812,17 @@ func (Module) GenerateWithResultWithStats(
 		var allFuncDecls []generators.FuncDecl
 		if spec.DisableTools != nil && !*spec.DisableTools {
 			allFuncDecls = append(allFuncDecls, funcDecls...)
-			sort.SliceStable(allFuncDecls, func(i, j int) bool {
-				return allFuncDecls[i].Name < allFuncDecls[j].Name
-			})
 		}
-		funcTokens, err := countFuncsTokens(allFuncDecls, generator.CountTokens)
+		// Native tools are declared to the model like configured
+		// functions. See components.TheoryOfComponentTools.
+		if spec.NativeToolsEnabled() {
+			allFuncDecls = append(allFuncDecls, comps.ToolDecls()...)
+		}
+		allFuncDecls = append(allFuncDecls, mcpConn.Decls()...)
+		sort.SliceStable(allFuncDecls, func(i, j int) bool {
+			return allFuncDecls[i].Name < allFuncDecls[j].Name
+		})
+		funcTokens, err := countFuncsTokens(allFuncDecls, countTokens)
 		if err != nil {
 			return loops.Result{}, nil, err
 		}
@@ -656,7 +842,7 @@ func (Module) GenerateWithResultWithStats(
 		}
 
 		// user prompt
-		userPromptParts, err := codeProvider.Parts(maxUserPromptTokens, generator.CountTokens, patterns)
+		userPromptParts, err := codeProvider.Parts(maxUserPromptTokens, countTokens, patterns)
 		if err != nil {
 			return loops.Result{}, nil, err
 		}
@@ -666,7 +852,7 @@ func (Module) GenerateWithResultWithStats(
 
 		// Concatenate the text parts with strings.Builder for token counting.
 		userPromptText := buildUserPromptText(userPromptParts)
-		userPromptTokens, err := generator.CountTokens(userPromptText)
+		userPromptTokens, err := countTokens(userPromptText)
 		if err != nil {
 			return loops.Result{}, nil, err
 		}
@@ -683,9 +869,28 @@ func (Module) GenerateWithResultWithStats(
 			fmt.Fprintf(output, "user prompt: %s\n", userPromptParts)
 		}
 
+		// A resumed session starts from the contents its checkpoint saved
+		// after the last completed round, with the originals of the
+		// interrupted run. See TheoryOfCheckpoint.
+		resumed := checkpoints.resumeSession()
+
 		// initial state
 		var initialContents []*generators.Content
-		if len(userPromptParts) > 0 {
+		if resumed != nil {
+			initialContents, err = generators.DecodeContents(resumed.Contents)
+			if err != nil {
+				return loops.Result{}, nil, fmt.Errorf("checkpoint %s: %w", resumed.ID, err)
+			}
+			if resumed.SystemPromptHash != systemPromptHash(string(systemPrompt)) {
+				logger.WarnContext(ctx, "system prompt changed since the checkpoint",
+					"checkpoint", resumed.ID,
+				)
+			}
+			memStore.RestoreOriginals(resumed.Originals)
+			if recorder != nil && recorder.Enabled() {
+				recorder.Event("decision", fmt.Sprintf("session resumed from checkpoint %s: contents=%d rounds=%d", resumed.ID, len(initialContents), len(resumed.Stats)))
+			}
+		} else if len(userPromptParts) > 0 {
 			initialContents = []*generators.Content{
 				{
 					Role:  "user",
@@ -698,6 +903,15 @@ func (Module) GenerateWithResultWithStats(
 			string(systemPrompt),
 			initialContents,
 		)
+		// Native tools: the generate phase executes the calls and sends
+		// the results back within the round. See
+		// components.TheoryOfComponentTools and phases.TheoryOfToolCalls.
+		if spec.NativeToolsEnabled() {
+			state = generators.WithFunctions(state, comps.Functions(ctx, root, httpClient)...)
+		}
+		if fns := mcpConn.Functions(); len(fns) > 0 {
+			state = generators.WithFunctions(state, fns...)
+		}
 		showThoughts := true
 		if flagThoughts.Value != nil {
 			showThoughts = *flagThoughts.Value
@@ -719,6 +933,9 @@ func (Module) GenerateWithResultWithStats(
 		}
 
 		var roundStats []RoundStat
+		if resumed != nil {
+			roundStats = resumed.Stats
+		}
 		defer func() {
 			// The table goes to the RoundStatsWriter provider when one is
 			// configured (TUI mode forks it to its output pane), and to the
@@ -733,7 +950,10 @@ func (Module) GenerateWithResultWithStats(
 		var roundStartTime time.Time
 
 		var hasChats bool
-		if chats := strings.Join(flagChats, "\n"); chats != "" {
+		if resumed != nil {
+			// the chats are in the resumed contents
+			hasChats = true
+		} else if chats := strings.Join(flagChats, "\n"); chats != "" {
 			state, err = state.AppendContent(&generators.Content{
 				Role: "user",
 				Parts: []generators.Part{
@@ -752,16 +972,28 @@ func (Module) GenerateWithResultWithStats(
 
 		prevContentCount := generators.CountContents(state)
 
+		// A round with go-test blocks may flush only when its tests
+		// pass. See TheoryOfTestThenCommit.
+		var roundGoTests bool
 		var blockHandler loops.BlockHandler
 		if bool(apply) {
 			handler := buildChangeBlockHandler(memStore)
-			blockHandler = loops.BlockHandler(handler)
+			blockHandler = func(block blocks.Block) (bool, error) {
+				if block.Kind == "go-test" {
+					roundGoTests = true
+				}
+				return handler(block)
+			}
 		}
 
 		runCtx, cancel := context.WithCancel(ctx)
 		defer cancel()
 		var fatalErr error
 
+		// The user's declined changes are fed back in the next round. See
+		// TheoryOfHunkApproval.
+		var approvalFeedback string
+
 		var result loops.Result
 		for e := range loopRun(runCtx, loops.RunOptions{
 			Generator:    generator,
@@ -769,7 +1001,15 @@ func (Module) GenerateWithResultWithStats(
 			Components:   comps.ComponentSet,
 			BlockHandler: blockHandler,
 			PhaseBuilder: func(g generators.Generator) phases.Phase {
-				return buildGenerate(g, nil)(nil)
+				// batch sessions, as queued reviews, run each
+				// generation as a batch job. See generators.TheoryOfBatch.
+				var options *generators.GenerateOptions
+				if batch {
+					options = &generators.GenerateOptions{
+						Batch: true,
+					}
+				}
+				return buildGenerate(g, options)(nil)
 			},
 			Root:                root,
 			HTTPClient:          httpClient,
@@ -778,19 +1018,53 @@ func (Module) GenerateWithResultWithStats(
 
 			OnRoundStart: func() {
 				memStore.Reset()
+				roundGoTests = false
+				roundStore.Commit = nil
 				roundStartTime = time.Now()
 			},
 
-			OnRoundSuccess: func(roundState generators.State, summaries []string) error {
-				if err := memStore.Flush(); err != nil {
-					return err
-				}
-				if recorder != nil && recorder.Enabled() {
-					recorder.Event("decision", "round succeeded: in-memory changes flushed to disk")
+			// The round's changes are type-checked before they are
+			// approved and flushed. See gotools.TheoryOfTypeCheck.
+			VerifyRound: func() error {
+				if !typeCheck {
+					return nil
 				}
+				return typeCheckRound(runCtx, memStore, logger)
+			},
 
+			OnRoundSuccess: func(roundState generators.State, summaries []string) error {
 				elapsed := time.Since(roundStartTime)
 
+				if bool(apply) && bool(approve) {
+					feedback, err := approveRound(runCtx, memStore, approveHunks)
+					if err != nil {
+						return err
+					}
+					approvalFeedback = feedback
+					if feedback != "" && recorder != nil && recorder.Enabled() {
+						recorder.Event("decision", "round approval: declined changes not flushed")
+					}
+				}
+				flush := func() error {
+					if err := memStore.Flush(); err != nil {
+						return err
+					}
+					if recorder != nil && recorder.Enabled() {
+						recorder.Event("decision", "round succeeded: in-memory changes flushed to disk")
+					}
+					return nil
+				}
+				if bool(testThenCommit) && roundGoTests {
+					// The go-test component flushes the changes
+					// if the tests pass. See TheoryOfTestThenCommit.
+					roundStore.Commit = flush
+					if recorder != nil && recorder.Enabled() {
+						recorder.Event("decision", "round succeeded: flush deferred until the round's tests pass")
+					}
+				} else if err := flush(); err != nil {
+					return err
+				}
+
 				summaryText := ""
 				var handoffErr error
 				if len(summaries) > 0 {
@@ -805,8 +1079,9 @@ func (Module) GenerateWithResultWithStats(
 					}
 				}
 				roundStats, prevContentCount = collectRoundStats(
-					roundStats, roundState, prevContentCount, elapsed, summaryText,
+					roundStats, roundState, generator.Spec(), prevContentCount, elapsed, summaryText,
 				)
+				recordRoundCost(recorder, roundStats)
 
 				if handoffErr != nil {
 					fatalErr = handoffErr
@@ -818,11 +1093,32 @@ func (Module) GenerateWithResultWithStats(
 				return nil
 			},
 
+			RoundFeedback: func() []generators.Part {
+				if approvalFeedback == "" {
+					return nil
+				}
+				feedback := approvalFeedback
+				approvalFeedback = ""
+				return []generators.Part{
+					generators.Text(feedback),
+				}
+			},
+
+			// The state the next round starts from is a complete
+			// snapshot of the session. See TheoryOfCheckpoint.
+			OnRoundEnd: func(nextState generators.State) error {
+				if err := checkpoints.saveSession(string(systemPrompt), nextState, roundStats, memStore.Originals()); err != nil {
+					logger.WarnContext(ctx, "checkpoint not saved", "error", err)
+				}
+				return nil
+			},
+
 			OnRoundTruncated: func(truncatedState generators.State, retryBaseState generators.State, summary string) error {
 				elapsed := time.Since(roundStartTime)
 				roundStats, _ = collectRoundStats(
-					roundStats, truncatedState, prevContentCount, elapsed, summary,
+					roundStats, truncatedState, generator.Spec(), prevContentCount, elapsed, summary,
 				)
+				recordRoundCost(recorder, roundStats)
 				prevContentCount = generators.CountContents(retryBaseState)
 				return nil
 			},
@@ -841,7 +1137,8 @@ func (Module) GenerateWithResultWithStats(
 						return createHandoff(runCtx, text)
 					},
 				)
-				roundStats, _ = collectRoundStats(roundStats, errState, prevContentCount, elapsed, summary)
+				roundStats, _ = collectRoundStats(roundStats, errState, generator.Spec(), prevContentCount, elapsed, summary)
+				recordRoundCost(recorder, roundStats)
 				prevContentCount = newContentCount
 
 				if handoffErr != nil {
@@ -885,6 +1182,14 @@ func (Module) GenerateWithResultWithStats(
 		}
 		result.Diffs = memStore.Diffs()
 
+		// A failed or budget-stopped session keeps its checkpoint for
+		// resumption. See TheoryOfCheckpoint.
+		if err == nil && !result.BudgetExhausted {
+			if cerr := checkpoints.endSession(); cerr != nil {
+				logger.WarnContext(ctx, "checkpoint not removed", "error", cerr)
+			}
+		}
+
 		return result, roundStats, err
 	}
 }
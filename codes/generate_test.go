@@ -289,6 +289,46 @@ func TestPrintRoundStatsWithSummaries(t *testing.T) {
 	}
 }
 
+func TestPrintRoundStatsWithFallbacks(t *testing.T) {
+	var buf bytes.Buffer
+	stats := []RoundStat{
+		{Round: 1, PromptTokens: 1000},
+		{Round: 2, PromptTokens: 2000, Fallbacks: []string{"primary -> backup"}},
+	}
+	PrintRoundStats(&buf, stats)
+	output := buf.String()
+	if !strings.Contains(output, "=== Generator Fallbacks ===") {
+		t.Fatalf("expected fallbacks section, got: %s", output)
+	}
+	if !strings.Contains(output, "Round 2: primary -> backup") {
+		t.Fatalf("expected round 2 fallback, got: %s", output)
+	}
+}
+
+func TestPrintRoundStatsWithCost(t *testing.T) {
+	var buf bytes.Buffer
+	PrintRoundStats(&buf, []RoundStat{
+		{Round: 1, PromptTokens: 1000},
+	})
+	if strings.Contains(buf.String(), "Cost") {
+		t.Fatalf("cost column must be hidden without priced rounds, got: %s", buf.String())
+	}
+
+	buf.Reset()
+	PrintRoundStats(&buf, []RoundStat{
+		{Round: 1, PromptTokens: 1000, Cost: new(0.25)},
+		{Round: 2, PromptTokens: 2000},
+		{Round: 3, PromptTokens: 2000, Cost: new(0.5)},
+	})
+	output := buf.String()
+	if !strings.Contains(output, "Cost") {
+		t.Fatalf("expected Cost column header, got: %s", output)
+	}
+	if !strings.Contains(output, "0.2500") || !strings.Contains(output, "0.7500") {
+		t.Fatalf("expected round and total cost, got: %s", output)
+	}
+}
+
 func TestPrintRoundStatsNoSummaries(t *testing.T) {
 	var buf bytes.Buffer
 	stats := []RoundStat{
@@ -406,7 +446,9 @@ func TestRunReviewSkipsWhenNoDiffs(t *testing.T) {
 		fakeReset,
 		true,
 		nil,
+		false,
 		flags.ModelName("test-model"),
+		nil,
 	)
 
 	// nil diffs (the actual case when no change blocks were applied).
@@ -447,7 +489,9 @@ func TestRunReviewRunsWhenDiffsExist(t *testing.T) {
 		fakeReset,
 		true,
 		nil,
+		false,
 		flags.ModelName("test-model"),
+		nil,
 	)
 	if err := runReview(context.Background(), io.Discard, []changes.FileDiff{
 		{
@@ -468,10 +512,9 @@ func TestRunReviewRunsWhenDiffsExist(t *testing.T) {
 func TestRunReviewUsesModelFlagValue(t *testing.T) {
 	// When no -review-model is configured, the review loop must reuse the
 	// model name from the -model flag, not the resolved generator's Spec.
-	// Built-in shortcuts (flash, gemini, ...) and the ollama shorthand do
-	// not set Spec.Name, and their Spec.Model values are not resolvable
-	// model names, so deriving the review model from the Spec produced
-	// "invalid model" errors. See TheoryOfReviewLoop.
+	// Spec.Model values are not resolvable model names, so deriving the
+	// review model from the Spec produced "invalid model" errors. See
+	// TheoryOfReviewLoop.
 	var reviewModel string
 	fakeReset := dscope.Reset(func() dscope.Scope {
 		return dscope.New(
@@ -495,7 +538,9 @@ func TestRunReviewUsesModelFlagValue(t *testing.T) {
 		fakeReset,
 		true,
 		nil,
+		false,
 		flags.ModelName("gemini-flash"),
+		nil,
 	)
 	if err := runReview(context.Background(), io.Discard, []changes.FileDiff{
 		{
@@ -513,6 +558,94 @@ func TestRunReviewUsesModelFlagValue(t *testing.T) {
 	}
 }
 
+func TestRunReviewBatch(t *testing.T) {
+	// With -review-batch the review sessions run with generators.Batch
+	// set. See TheoryOfReviewLoop.
+	var batch generators.Batch
+	fakeReset := dscope.Reset(func() dscope.Scope {
+		return dscope.New(
+			func() generators.Batch { return false },
+			func(b generators.Batch) GenerateWithResultWithStats {
+				return func(ctx context.Context, output io.Writer) (loops.Result, []RoundStat, error) {
+					batch = b
+					return loops.Result{}, nil, nil
+				}
+			},
+		)
+	})
+
+	var m Module
+	runReview := m.RunReview(
+		fakeReset,
+		true,
+		nil,
+		true,
+		flags.ModelName("test-model"),
+		nil,
+	)
+	if err := runReview(context.Background(), io.Discard, []changes.FileDiff{
+		{
+			Path:          "test.go",
+			Current:       []byte("new content"),
+			CurrentExists: true,
+		},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !batch {
+		t.Fatal("review sessions must run as batch jobs with -review-batch")
+	}
+}
+
+func TestRunReviewBudget(t *testing.T) {
+	// The review spends the caller's session budget and is skipped once
+	// it is exhausted. See TheoryOfReviewLoop.
+	var reviewBudget *loops.Budget
+	sessions := 0
+	fakeReset := dscope.Reset(func() dscope.Scope {
+		return dscope.New(
+			func() *loops.Budget { return nil },
+			func(budget *loops.Budget) GenerateWithResultWithStats {
+				return func(ctx context.Context, output io.Writer) (loops.Result, []RoundStat, error) {
+					sessions++
+					reviewBudget = budget
+					budget.Add(10, 0)
+					return loops.Result{}, nil, nil
+				}
+			},
+		)
+	})
+	budget := &loops.Budget{
+		MaxTokens: 10,
+	}
+
+	var m Module
+	runReview := m.RunReview(
+		fakeReset,
+		true,
+		ReviewModels{"a", "b"},
+		false,
+		flags.ModelName("test-model"),
+		budget,
+	)
+	var output strings.Builder
+	if err := runReview(context.Background(), &output, []changes.FileDiff{
+		{
+			Path:          "test.go",
+			Current:       []byte("new content"),
+			CurrentExists: true,
+		},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reviewBudget != budget {
+		t.Fatal("review sessions must share the caller's budget")
+	}
+	if sessions != 1 || !strings.Contains(output.String(), "session budget exhausted") {
+		t.Fatalf("got %d sessions, output %q", sessions, output.String())
+	}
+}
+
 // debugOutputMockGenerator is a generator stub that reports a usable
 // context window so the token-budget computation in
 // GenerateWithResultWithStats succeeds, and delegates CountTokens and
@@ -1143,30 +1276,30 @@ func TestGenerateRoundStatsWrittenToRoundStatsWriter(t *testing.T) {
 
 func TestCollectRoundStats(t *testing.T) {
 	t.Run("MultipleUsagePartsSingleRound", func(t *testing.T) {
-		// Simulating Gemini streaming which emits multiple Usage parts in one round.
+		// Simulating Gemini streaming which emits multiple Usage parts for one call.
 		// collectRoundStats must produce exactly 1 RoundStat entry with the last usage values.
 		var state generators.State = generators.NewPrompts("", []*generators.Content{
 			{Role: generators.RoleUser, Parts: []generators.Part{generators.Text("hi")}},
+			{Role: generators.RoleAssistant, Parts: []generators.Part{generators.Text("chunk 1")}},
+			{Role: generators.RoleAssistant, Parts: []generators.Part{generators.Text("chunk 2")}},
 			{Role: generators.RoleLog, Parts: []generators.Part{generators.Usage{
-				Prompt:     struct{ TokenCount, TokenCountCached int }{TokenCount: 100, TokenCountCached: 10},
+				Prompt:     struct{ TokenCount, TokenCountCached, TokenCountCacheWrite int }{TokenCount: 100, TokenCountCached: 10},
 				Candidates: struct{ TokenCount int }{TokenCount: 5},
 				Thoughts:   struct{ TokenCount int }{TokenCount: 2},
 			}}},
-			{Role: generators.RoleAssistant, Parts: []generators.Part{generators.Text("chunk 1")}},
 			{Role: generators.RoleLog, Parts: []generators.Part{generators.Usage{
-				Prompt:     struct{ TokenCount, TokenCountCached int }{TokenCount: 100, TokenCountCached: 10},
+				Prompt:     struct{ TokenCount, TokenCountCached, TokenCountCacheWrite int }{TokenCount: 100, TokenCountCached: 10},
 				Candidates: struct{ TokenCount int }{TokenCount: 25},
 				Thoughts:   struct{ TokenCount int }{TokenCount: 10},
 			}}},
-			{Role: generators.RoleAssistant, Parts: []generators.Part{generators.Text("chunk 2")}},
 			{Role: generators.RoleLog, Parts: []generators.Part{generators.Usage{
-				Prompt:     struct{ TokenCount, TokenCountCached int }{TokenCount: 100, TokenCountCached: 10},
+				Prompt:     struct{ TokenCount, TokenCountCached, TokenCountCacheWrite int }{TokenCount: 100, TokenCountCached: 10},
 				Candidates: struct{ TokenCount int }{TokenCount: 50},
 				Thoughts:   struct{ TokenCount int }{TokenCount: 20},
 			}}},
 		})
 
-		stats, nextCount := collectRoundStats(nil, state, 1, 500*time.Millisecond, "round 1 summary")
+		stats, nextCount := collectRoundStats(nil, state, generators.Spec{}, 1, 500*time.Millisecond, "round 1 summary")
 		if len(stats) != 1 {
 			t.Fatalf("expected exactly 1 RoundStat, got %d", len(stats))
 		}
@@ -1192,11 +1325,11 @@ func TestCollectRoundStats(t *testing.T) {
 		var state generators.State = generators.NewPrompts("", []*generators.Content{
 			{Role: generators.RoleUser, Parts: []generators.Part{generators.Text("r1")}},
 			{Role: generators.RoleLog, Parts: []generators.Part{generators.Usage{
-				Prompt:     struct{ TokenCount, TokenCountCached int }{TokenCount: 100},
+				Prompt:     struct{ TokenCount, TokenCountCached, TokenCountCacheWrite int }{TokenCount: 100},
 				Candidates: struct{ TokenCount int }{TokenCount: 30},
 			}}},
 		})
-		stats, count1 := collectRoundStats(nil, state, 0, time.Second, "r1 summary")
+		stats, count1 := collectRoundStats(nil, state, generators.Spec{}, 0, time.Second, "r1 summary")
 
 		state, _ = state.AppendContent(&generators.Content{
 			Role:  generators.RoleUser,
@@ -1205,11 +1338,11 @@ func TestCollectRoundStats(t *testing.T) {
 		state, _ = state.AppendContent(&generators.Content{
 			Role: generators.RoleLog,
 			Parts: []generators.Part{generators.Usage{
-				Prompt:     struct{ TokenCount, TokenCountCached int }{TokenCount: 200},
+				Prompt:     struct{ TokenCount, TokenCountCached, TokenCountCacheWrite int }{TokenCount: 200},
 				Candidates: struct{ TokenCount int }{TokenCount: 60},
 			}},
 		})
-		stats, count2 := collectRoundStats(stats, state, count1, 2*time.Second, "r2 summary")
+		stats, count2 := collectRoundStats(stats, state, generators.Spec{}, count1, 2*time.Second, "r2 summary")
 
 		if len(stats) != 2 {
 			t.Fatalf("expected 2 RoundStats, got %d", len(stats))
@@ -1225,12 +1358,89 @@ func TestCollectRoundStats(t *testing.T) {
 		}
 	})
 
+	t.Run("RoundWithFallback", func(t *testing.T) {
+		var state generators.State = generators.NewPrompts("", []*generators.Content{
+			{Role: generators.RoleUser, Parts: []generators.Part{generators.Text("r1")}},
+			{Role: generators.RoleLog, Parts: []generators.Part{generators.GeneratorFallback{
+				From:  "primary",
+				To:    "backup",
+				Error: "quota",
+			}}},
+		})
+		stats, _ := collectRoundStats(nil, state, generators.Spec{}, 0, time.Second, "")
+		if len(stats[0].Fallbacks) != 1 || stats[0].Fallbacks[0] != "primary -> backup" {
+			t.Fatalf("expected the fallback to be recorded, got %v", stats[0].Fallbacks)
+		}
+	})
+
+	t.Run("RoundWithCost", func(t *testing.T) {
+		var state generators.State = generators.NewPrompts("", []*generators.Content{
+			{Role: generators.RoleUser, Parts: []generators.Part{generators.Text("r1")}},
+			{Role: generators.RoleLog, Parts: []generators.Part{generators.Usage{
+				Prompt:     struct{ TokenCount, TokenCountCached, TokenCountCacheWrite int }{TokenCount: 1_000_000},
+				Candidates: struct{ TokenCount int }{TokenCount: 1_000_000},
+			}}},
+		})
+		stats, _ := collectRoundStats(nil, state, generators.Spec{}, 0, time.Second, "")
+		if stats[0].Cost != nil {
+			t.Fatalf("unpriced spec must not report a cost, got %v", *stats[0].Cost)
+		}
+		stats, _ = collectRoundStats(nil, state, generators.Spec{
+			InputPrice:  new(1.0),
+			OutputPrice: new(4.0),
+		}, 0, time.Second, "")
+		if stats[0].Cost == nil || *stats[0].Cost != 5 {
+			t.Fatalf("expected cost 5, got %v", stats[0].Cost)
+		}
+	})
+
+	t.Run("RoundWithTwoCalls", func(t *testing.T) {
+		// a call answered with a function call, then the call answering the
+		// result, the second served by a fallback
+		fallback := generators.Spec{Name: "fallback", InputPrice: new(2.0)}
+		var state generators.State = generators.NewPrompts("", []*generators.Content{
+			{Role: generators.RoleUser, Parts: []generators.Part{generators.Text("r1")}},
+			{Role: generators.RoleAssistant, Parts: []generators.Part{generators.Text("call")}},
+			{Role: generators.RoleLog, Parts: []generators.Part{generators.Usage{
+				Prompt:     struct{ TokenCount, TokenCountCached, TokenCountCacheWrite int }{TokenCount: 1_000_000, TokenCountCached: 10},
+				Candidates: struct{ TokenCount int }{TokenCount: 20},
+			}}},
+			{Role: generators.RoleTool, Parts: []generators.Part{generators.Text("result")}},
+			{Role: generators.RoleLog, Parts: []generators.Part{generators.GeneratorFallback{
+				From:   "primary",
+				To:     "fallback",
+				ToSpec: fallback,
+			}}},
+			{Role: generators.RoleAssistant, Parts: []generators.Part{generators.Text("answer")}},
+			{Role: generators.RoleLog, Parts: []generators.Part{generators.Usage{
+				Prompt:     struct{ TokenCount, TokenCountCached, TokenCountCacheWrite int }{TokenCount: 1_000_000, TokenCountCached: 5},
+				Candidates: struct{ TokenCount int }{TokenCount: 30},
+				Thoughts:   struct{ TokenCount int }{TokenCount: 7},
+			}}},
+		})
+		stats, _ := collectRoundStats(nil, state, generators.Spec{
+			Name:       "primary",
+			InputPrice: new(1.0),
+		}, 1, time.Second, "")
+		if len(stats) != 1 {
+			t.Fatalf("expected 1 RoundStat, got %d", len(stats))
+		}
+		if stats[0].PromptTokens != 2_000_000 || stats[0].CachedTokens != 15 || stats[0].CompletionTokens != 50 || stats[0].ThoughtTokens != 7 {
+			t.Fatalf("expected the summed usage (2000000, 15, 50, 7), got (%d, %d, %d, %d)",
+				stats[0].PromptTokens, stats[0].CachedTokens, stats[0].CompletionTokens, stats[0].ThoughtTokens)
+		}
+		// each call priced with the spec that served it: 1 + 2
+		if stats[0].Cost == nil || *stats[0].Cost != 3 {
+			t.Fatalf("expected cost 3, got %v", stats[0].Cost)
+		}
+	})
+
 	t.Run("RoundWithoutUsage", func(t *testing.T) {
 		var state generators.State = generators.NewPrompts("", []*generators.Content{
 			{Role: generators.RoleUser, Parts: []generators.Part{generators.Text("no usage")}},
 			{Role: generators.RoleAssistant, Parts: []generators.Part{generators.Text("reply")}},
 		})
-		stats, _ := collectRoundStats(nil, state, 0, time.Second, "no usage summary")
+		stats, _ := collectRoundStats(nil, state, generators.Spec{}, 0, time.Second, "no usage summary")
 		if len(stats) != 1 {
 			t.Fatalf("expected 1 RoundStat, got %d", len(stats))
 		}
@@ -154,3 +154,42 @@ func TestCodesComponentsExcludesNonMatchingFamilyPrompt(t *testing.T) {
 		}
 	})
 }
+
+func TestGoTestToolWithTestThenCommit(t *testing.T) {
+	// With -test-then-commit the go-test component stays a block
+	// component, so a native call cannot bypass the deferred flush. See
+	// TheoryOfTestThenCommit.
+	hasTool := func(comps CodesComponents, name string) bool {
+		for _, decl := range comps.ToolDecls() {
+			if decl.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+	scope := dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Fork(
+		func() codetypes.CodeProvider { return mockCodeProvider{} },
+		func() generators.NativeTools { return true },
+	)
+	scope.Call(func(comps CodesComponents) {
+		if !hasTool(comps, "go_test") {
+			t.Fatal("expected the go_test tool with native tools")
+		}
+	})
+	scope.Fork(
+		func() gotools.TestThenCommit { return true },
+	).Call(func(comps CodesComponents) {
+		if hasTool(comps, "go_test") {
+			t.Fatal("expected no go_test tool under -test-then-commit")
+		}
+		if !strings.Contains(comps.PromptSections(), "Go-Test Block Kind") {
+			t.Fatal("expected the go-test block prompt under -test-then-commit")
+		}
+		if !hasTool(comps, "go_src") {
+			t.Fatal("expected the other tools to stay native")
+		}
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/reusee/tai/generators"
 	"github.com/reusee/tai/gotools"
 	"github.com/reusee/tai/loops"
+	"github.com/reusee/tai/mcps"
 	"github.com/reusee/tai/phases"
 	"github.com/reusee/tai/records"
 	"github.com/reusee/tai/states"
@@ -24,4 +25,5 @@ type Module struct {
 	States     states.Module
 	Loops      loops.Module
 	Records    records.Module
+	MCPs       mcps.Module
 }
@@ -13,6 +13,8 @@ var _ configs.Config = ReviewModels(nil)
 var _ flags.Flag = ReviewModels(nil)
 var _ configs.Config = Review(false)
 var _ flags.Flag = Review(true)
+var _ configs.Config = ReviewBatch(false)
+var _ flags.Flag = ReviewBatch(true)
 
 // Review controls whether a review loop runs after the main generation
 // loop (or after the goal command completes). When enabled, the changes
@@ -23,11 +25,16 @@ type Review bool
 // ReviewModels lists the models used for review, in order. Each model runs
 // a separate review generation session with a fresh scope. When empty, the
 // model selected by the -model flag is reused: the resolved generator's
-// Spec is not reusable because built-in shortcuts (flash, gemini, ...) and
-// the ollama shorthand do not set Spec.Name, and their Spec.Model values are
+// Spec is not reusable because its Spec.Name is the path a redirect
+// resolved to, not the model that was asked for, and Spec.Model values are
 // not resolvable model names. See TheoryOfReviewLoop.
 type ReviewModels []string
 
+// ReviewBatch queues the review sessions as provider batch jobs: a review
+// does not need low latency, and batch requests are billed at batch prices.
+// See TheoryOfReviewLoop and generators.TheoryOfBatch.
+type ReviewBatch bool
+
 func (m ReviewModels) Handle(key string, args []string) (newDef any, remainArgs []string, err error) {
 	if len(args) == 0 {
 		return nil, nil, fmt.Errorf("expecting string argument, got empty")
@@ -89,3 +96,31 @@ func (Module) ReviewModels() ReviewModels {
 func (Module) Review() Review {
 	return false
 }
+
+func (r ReviewBatch) Handle(key string, args []string) (newDef any, remainArgs []string, err error) {
+	ret := ReviewBatch(true)
+	return &ret, args, nil
+}
+
+func (r ReviewBatch) Keys() map[string]string {
+	return map[string]string{
+		"-review-batch": "Run the review sessions as provider batch jobs at batch prices",
+	}
+}
+
+func (r ReviewBatch) ConfigPaths() []string {
+	return []string{"review_batch"}
+}
+
+func (r ReviewBatch) HandleConfig(path string, values []*cue.Value) (any, error) {
+	var b bool
+	if err := values[0].Decode(&b); err != nil {
+		return nil, err
+	}
+	ret := ReviewBatch(b)
+	return &ret, nil
+}
+
+func (Module) ReviewBatch() ReviewBatch {
+	return false
+}
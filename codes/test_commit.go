@@ -0,0 +1,70 @@
+package codes
+
+import (
+	"github.com/reusee/tai/changes"
+	"github.com/reusee/tai/generators"
+)
+
+const TheoryOfTestThenCommit = `
+Go-test blocks run against the session's changes.MemoryStore through a go
+test overlay (see gotools.TheoryOfGoTestBlocks), so a round's changes can be
+tested before they are written. With -test-then-commit, the flush of a
+round that emits go-test blocks waits for its tests: OnRoundSuccess leaves
+the changes pending in the MemoryStore and hands the flush to the RoundStore
+as Commit, and the go-test component, which runs after OnRoundSuccess in the
+same round, flushes them when every test run passes and discards them when
+one fails. The test output fed back to the model ends with a note saying
+which happened, so after a failure the model knows the round's changes are
+not on disk and re-emits them with the fix. Rounds without go-test blocks
+flush as usual.
+
+The go-test component is not exposed as the go_test native tool under
+-test-then-commit (see components.TheoryOfComponentTools): a native call
+runs within the generation, before OnRoundSuccess hands the flush over, so
+a round testing only through the tool would flush untested. The component
+keeps its block prompts, and the model tests with go-test blocks.
+
+The RoundStore is shared by GenerateWithResultWithStats, which sets the
+session's MemoryStore, and the go-test component; candidate sessions run
+concurrently and get their own (see TheoryOfCandidates). A deferred flush
+that never reaches the go-test component — the session stops first — is
+discarded with the rest of the unflushed changes: untested changes are not
+committed.
+`
+
+// RoundStore is the MemoryStore of the running session, through which the
+// go-test component tests the changes not yet flushed. See
+// TheoryOfTestThenCommit.
+type RoundStore struct {
+	Store *changes.MemoryStore
+	// Commit flushes the round's changes when the round's flush waits for
+	// its tests, nil otherwise.
+	Commit func() error
+}
+
+func (Module) RoundStore() *RoundStore {
+	return new(RoundStore)
+}
+
+// settle commits the round's changes waiting for its tests when they
+// passed and discards them when they failed, returning the test output
+// with a note on the outcome. See TheoryOfTestThenCommit.
+func (r *RoundStore) settle(parts []generators.Part, failed bool) ([]generators.Part, error) {
+	if r.Commit == nil {
+		return parts, nil
+	}
+	commit := r.Commit
+	r.Commit = nil
+	if failed {
+		r.Store.Reset()
+		return append(parts, generators.Text(testsFailedNote)), nil
+	}
+	if err := commit(); err != nil {
+		return parts, err
+	}
+	return append(parts, generators.Text(testsPassedNote)), nil
+}
+
+const testsFailedNote = "测试未通过，本轮的全部改动都没有写入文件。修正问题后，请重新输出本轮所有需要生效的change块。"
+
+const testsPassedNote = "测试通过，本轮的改动已写入文件。"
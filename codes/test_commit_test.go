@@ -0,0 +1,68 @@
+package codes
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/reusee/tai/changes"
+	"github.com/reusee/tai/generators"
+)
+
+func TestRoundStoreSettle(t *testing.T) {
+	dir := t.TempDir()
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	store := changes.NewMemoryStore(changes.NewRootStore(root))
+	roundStore := &RoundStore{
+		Store: store,
+	}
+	output := []generators.Part{generators.Text("ok")}
+
+	// nothing waits for the tests
+	parts, err := roundStore.settle(output, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 1 {
+		t.Fatalf("got %+v", parts)
+	}
+
+	// failed: discarded
+	if err := store.WriteFile("a.txt", []byte("a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	roundStore.Commit = store.Flush
+	parts, err = roundStore.settle(output, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 2 || !strings.Contains(string(parts[1].(generators.Text)), "没有写入") || roundStore.Commit != nil {
+		t.Fatalf("got %+v", parts)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := root.Stat("a.txt"); !os.IsNotExist(err) {
+		t.Fatalf("got %v", err)
+	}
+
+	// passed: flushed
+	if err := store.WriteFile("b.txt", []byte("b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	roundStore.Commit = store.Flush
+	parts, err = roundStore.settle(output, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 2 || !strings.Contains(string(parts[1].(generators.Text)), "已写入") {
+		t.Fatalf("got %+v", parts)
+	}
+	if content, err := root.ReadFile("b.txt"); err != nil || string(content) != "b\n" {
+		t.Fatalf("got %q, %v", content, err)
+	}
+}
@@ -0,0 +1,37 @@
+package codes
+
+import (
+	"context"
+	"errors"
+
+	"github.com/reusee/tai/changes"
+	"github.com/reusee/tai/gotools"
+	"github.com/reusee/tai/logs"
+)
+
+// typeCheckRound type-checks the packages of the round's pending changes in
+// memStore, with the session's changes overlaid (gotools.StoreOverlay), and
+// returns a *changes.VerifyError carrying the diagnostics when they fail.
+// A check that cannot run is logged and skipped. See
+// gotools.TheoryOfTypeCheck.
+func typeCheckRound(ctx context.Context, memStore *changes.MemoryStore, logger logs.Logger) error {
+	pending := memStore.PendingDiffs()
+	if len(pending) == 0 {
+		return nil
+	}
+	overlay := gotools.StoreOverlay(memStore)
+	files := make([]string, 0, len(pending))
+	for _, diff := range pending {
+		files = append(files, diff.Path)
+	}
+	err := gotools.CheckTypes(ctx, files, overlay)
+	if _, ok := errors.AsType[*gotools.TypeCheckError](err); ok {
+		return &changes.VerifyError{
+			Err: err,
+		}
+	}
+	if err != nil {
+		logger.WarnContext(ctx, "type check skipped", "error", err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package codes
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/reusee/tai/changes"
+	"github.com/reusee/tai/logs"
+)
+
+func TestTypeCheckRound(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if err := os.WriteFile("go.mod", []byte("module m\n\ngo 1.24\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("a.go", []byte("package m\n\nfunc F() int { return 1 }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	logger := logs.Logger{slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	// a candidate session's earlier changes are checked with the round's
+	sessionStore := SessionStore{
+		Store: changes.NewMemoryStore(changes.NewRootStore(root)),
+	}
+	if err := sessionStore.Store.WriteFile("b.go", []byte("package m\n\nfunc G() string { return \"\" }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	memStore := changes.NewMemoryStore(sessionStore.Store)
+	if err := memStore.WriteFile("a.go", []byte("package m\n\nfunc F() int { return G() }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = typeCheckRound(context.Background(), memStore, logger)
+	if _, ok := errors.AsType[*changes.VerifyError](err); !ok || !strings.Contains(err.Error(), "a.go:3:23") {
+		t.Fatalf("got %v", err)
+	}
+
+	memStore.Reset()
+	if err := memStore.WriteFile("a.go", []byte("package m\n\nfunc F() int { return len(G()) }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := typeCheckRound(context.Background(), memStore, logger); err != nil {
+		t.Fatal(err)
+	}
+}
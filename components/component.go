@@ -121,6 +121,10 @@ type Component struct {
 	// to prevent infinite loops (e.g., request-context components that keep
 	// requesting more context).
 	MaxRounds int
+	// Tool exposes the component as a native function for generators that
+	// enable native tools. Nil keeps the component block-only. See
+	// TheoryOfComponentTools.
+	Tool *Tool
 }
 
 // ComponentSet is an ordered collection of Component.
@@ -0,0 +1,201 @@
+package components
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/reusee/tai/blocks"
+	"github.com/reusee/tai/generators"
+	"github.com/reusee/tai/nets"
+)
+
+const TheoryOfComponentTools = `
+Blocks are the default way a model invokes a component: it emits a heredoc
+block, ends the round, and the component's output arrives as user content
+in the next round. Models that are reliable at native tool use can call the
+same components as functions instead, getting the result within the round
+as a CallResult, without paying a round trip for every lookup.
+
+A Component with a Tool is exposable as a native function. The function
+takes one string-array parameter whose entries become the lines of a block
+body, so the tool runs the component's own Process function on a
+synthesized block of the component's Kind: blocks and tools share one
+implementation and cannot drift. ComponentSet.Functions builds the
+functions for a session, binding the context, root and HTTP client that
+ProcessContext carries. A tool call sees a scratch State, not the session
+State: text of the parts and of the contents the component produced
+becomes the "output" result, and a processing error becomes the "error"
+result, so the model can correct the call instead of failing the round.
+
+Tools are opt-in per spec with NativeTools (see generators.TheoryOfSpec),
+which DisableTools overrides. When the default generator enables them,
+ComponentSet.WithNativeTools replaces the block prompts of tool components
+with NativeToolsSystemPrompt: the tool descriptions carry the instructions,
+and the components keep their Process functions, so a block emitted from
+habit is still processed. The generate phase executes calls and sends the
+results back (see phases.TheoryOfToolCalls). The heredoc path stays the
+default for every other spec.
+`
+
+const NativeToolsSystemPrompt = `**Native Tools:**
+
+Some operations are available as function calls instead of blocks. Call these functions directly; do not emit the corresponding blocks. Function results are returned within the same response, so continue working after a call returns instead of ending the response. Every response must still end with a summary block.`
+
+// Tool describes the native function exposing a component. See
+// TheoryOfComponentTools.
+type Tool struct {
+	// Name is the function name.
+	Name string
+	// Description tells the model what the function does and how to fill
+	// its parameter.
+	Description string
+	// Param names the string-array parameter whose entries become the
+	// block body lines.
+	Param string
+	// ParamDescription describes one entry of Param.
+	ParamDescription string
+}
+
+// Decl returns the function declaration of the tool.
+func (t *Tool) Decl() generators.FuncDecl {
+	return generators.FuncDecl{
+		Name:        t.Name,
+		Description: t.Description,
+		Params: generators.Vars{
+			{
+				Name:        t.Param,
+				Type:        generators.TypeArray,
+				Description: t.ParamDescription,
+				ItemType: &generators.Var{
+					Name: "item",
+					Type: generators.TypeString,
+				},
+			},
+		},
+		Returns: generators.Vars{
+			{
+				Name: "output",
+				Type: generators.TypeString,
+			},
+			{
+				Name:     "error",
+				Type:     generators.TypeString,
+				Optional: true,
+			},
+		},
+	}
+}
+
+// ToolDecls returns the declarations of the tools in the set, in
+// registration order.
+func (c ComponentSet) ToolDecls() []generators.FuncDecl {
+	var ret []generators.FuncDecl
+	for _, comp := range c {
+		if comp.Tool != nil && comp.Process != nil {
+			ret = append(ret, comp.Tool.Decl())
+		}
+	}
+	return ret
+}
+
+// WithNativeTools returns a copy of the set where components exposed as
+// tools carry no block prompts, followed by the native tools prompt. See
+// TheoryOfComponentTools.
+func (c ComponentSet) WithNativeTools() ComponentSet {
+	ret := make(ComponentSet, 0, len(c)+1)
+	hasTools := false
+	for _, comp := range c {
+		if comp.Tool != nil && comp.Process != nil {
+			comp.PromptSection = ""
+			comp.RestatePrompt = ""
+			hasTools = true
+		}
+		ret = append(ret, comp)
+	}
+	if hasTools {
+		ret = append(ret, Component{
+			PromptSection: NativeToolsSystemPrompt,
+		})
+	}
+	return ret
+}
+
+// Functions returns the native functions of the tools in the set. Calls
+// are processed with ctx, root and httpClient. See TheoryOfComponentTools.
+func (c ComponentSet) Functions(ctx context.Context, root *os.Root, httpClient nets.HTTPClient) []*generators.Function {
+	var ret []*generators.Function
+	for _, comp := range c {
+		if comp.Tool == nil || comp.Process == nil {
+			continue
+		}
+		ret = append(ret, &generators.Function{
+			Decl: comp.Tool.Decl(),
+			Func: func(args map[string]any) (map[string]any, error) {
+				return callTool(ctx, comp, args, root, httpClient), nil
+			},
+		})
+	}
+	return ret
+}
+
+func callTool(ctx context.Context, comp Component, args map[string]any, root *os.Root, httpClient nets.HTTPClient) map[string]any {
+	var lines []string
+	switch value := args[comp.Tool.Param].(type) {
+	case []any:
+		for _, v := range value {
+			lines = append(lines, fmt.Sprint(v))
+		}
+	case []string:
+		lines = value
+	case string:
+		lines = []string{value}
+	case nil:
+	default:
+		return map[string]any{
+			"error": fmt.Sprintf("parameter %s must be an array of strings, got %T", comp.Tool.Param, value),
+		}
+	}
+
+	scratch := generators.NewPrompts("", nil)
+	result := comp.Process(ctx, &ProcessContext{
+		Blocks: []blocks.Block{
+			{
+				Kind: comp.Kind,
+				Body: strings.Join(lines, "\n"),
+			},
+		},
+		State:      scratch,
+		Root:       root,
+		HttpClient: httpClient,
+	})
+
+	var output strings.Builder
+	writeParts := func(parts []generators.Part) {
+		for _, part := range parts {
+			switch part := part.(type) {
+			case generators.Text:
+				output.WriteString(string(part))
+			case generators.FileContent:
+				fmt.Fprintf(&output, "[file content omitted: %s, %d bytes]\n", part.MimeType, len(part.Content))
+			case generators.FileURL:
+				fmt.Fprintf(&output, "[file] %s\n", part)
+			}
+		}
+	}
+	if result.State != nil {
+		for content := range result.State.Contents() {
+			writeParts(content.Parts)
+		}
+	}
+	writeParts(result.Parts)
+
+	ret := map[string]any{
+		"output": output.String(),
+	}
+	if result.Err != nil {
+		ret["error"] = result.Err.Error()
+	}
+	return ret
+}
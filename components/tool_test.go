@@ -0,0 +1,102 @@
+package components
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/reusee/tai/generators"
+	"github.com/reusee/tai/nets"
+)
+
+func TestComponentSetFunctions(t *testing.T) {
+	var bodies []string
+	comps := ComponentSet{
+		{
+			Kind:          "lookup",
+			PromptSection: "use lookup blocks",
+			RestatePrompt: "restate lookup",
+			Tool: &Tool{
+				Name:        "lookup",
+				Description: "look things up",
+				Param:       "keys",
+			},
+			Process: func(ctx context.Context, p *ProcessContext) ProcessResult {
+				var err error
+				for _, block := range p.Blocks {
+					if block.Kind != "lookup" {
+						continue
+					}
+					bodies = append(bodies, block.Body)
+					if strings.Contains(block.Body, "bad") {
+						err = errors.New("bad key")
+					}
+				}
+				state, _ := p.State.AppendContent(&generators.Content{
+					Role:  generators.RoleUser,
+					Parts: []generators.Part{generators.Text("state output\n")},
+				})
+				return ProcessResult{
+					State: state,
+					Parts: []generators.Part{generators.Text("part output")},
+					Err:   err,
+				}
+			},
+		},
+		{Kind: "plain", PromptSection: "plain blocks"},
+	}
+
+	decls := comps.ToolDecls()
+	if len(decls) != 1 || decls[0].Name != "lookup" || decls[0].Params[0].Name != "keys" {
+		t.Fatalf("got %+v", decls)
+	}
+
+	native := comps.WithNativeTools()
+	if got := native.PromptSections(); got != "plain blocks\n\n"+NativeToolsSystemPrompt+"\n\n" {
+		t.Fatalf("got %q", got)
+	}
+	if native.RestatePrompts() != "" {
+		t.Fatal("tool components must not restate block prompts")
+	}
+	if comps[0].PromptSection == "" {
+		t.Fatal("WithNativeTools must not modify the receiver")
+	}
+
+	fns := comps.Functions(context.Background(), nil, nets.HTTPClient{})
+	if len(fns) != 1 {
+		t.Fatalf("got %d functions", len(fns))
+	}
+	ret, err := fns[0].Func(map[string]any{
+		"keys": []any{"a", "b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret["output"] != "state output\npart output" {
+		t.Fatalf("got %+v", ret)
+	}
+	if _, ok := ret["error"]; ok {
+		t.Fatalf("got %+v", ret)
+	}
+	if len(bodies) != 1 || bodies[0] != "a\nb" {
+		t.Fatalf("got %q", bodies)
+	}
+
+	ret, err = fns[0].Func(map[string]any{
+		"keys": []any{"bad"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret["error"] != "bad key" {
+		t.Fatalf("processing errors must be returned to the model, got %+v", ret)
+	}
+
+	ret, _ = fns[0].Func(map[string]any{
+		"keys": 42,
+	})
+	if _, ok := ret["error"]; !ok || len(bodies) != 2 {
+		t.Fatalf("malformed arguments must not be processed, got %+v", ret)
+	}
+}
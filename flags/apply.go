@@ -69,3 +69,40 @@ func (a Apply) HandleConfig(path string, values []*cue.Value) (any, error) {
 	ret := Apply(b)
 	return &ret, nil
 }
+
+// Approve makes the user accept, reject or edit each hunk of a round's
+// changes before they are flushed to the working tree. It has no effect
+// with -no-apply. See codes.TheoryOfHunkApproval.
+type Approve bool
+
+func (Module) Approve() Approve {
+	return false
+}
+
+var _ configs.Config = Approve(false)
+
+var _ Flag = Approve(false)
+
+func (a Approve) Handle(key string, args []string) (newDef any, remainArgs []string, err error) {
+	ret := Approve(true)
+	return &ret, args, nil
+}
+
+func (a Approve) Keys() map[string]string {
+	return map[string]string{
+		"-approve": "Approve each hunk of a round's changes before writing them",
+	}
+}
+
+func (a Approve) ConfigPaths() []string {
+	return []string{"approve"}
+}
+
+func (a Approve) HandleConfig(path string, values []*cue.Value) (any, error) {
+	var b bool
+	if err := values[0].Decode(&b); err != nil {
+		return nil, err
+	}
+	ret := Approve(b)
+	return &ret, nil
+}
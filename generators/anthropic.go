@@ -0,0 +1,993 @@
+package generators
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/reusee/dscope"
+	"github.com/reusee/tai/logs"
+	"github.com/reusee/tai/nets"
+)
+
+const TheoryOfAnthropic = `
+The Anthropic generator speaks the native Messages API instead of an
+OpenAI-compatible shim, because the shims drop three things the pipeline
+relies on for Claude models: thinking blocks, the signatures that must
+accompany them when they are sent back, and explicit cache_control
+breakpoints.
+
+Thinking blocks stream as Thought parts followed by a ThoughtSignature
+part carrying the block's signature (or, for redacted_thinking blocks, the
+opaque redacted payload). When PreservedThinking is set, each Thought is
+sent back as a thinking block paired with the signature that follows it; a
+Thought without an Anthropic signature (for example one produced by
+another provider) cannot be verified by the API and is dropped. When
+PreservedThinking is not set, all reasoning is stripped, matching the
+other generators.
+
+Prompt caching is explicit: the system prompt, the block preceding each
+CacheBreakpoint part, and the last content block of the final message
+carry an ephemeral cache_control marker, so the stable prefixes and the
+conversation so far are all cacheable across rounds (see
+TheoryOfCacheBreakpoints for the marker budget). Cache reads are reported
+as Usage.Prompt.TokenCountCached and cache writes as
+Usage.Prompt.TokenCountCacheWrite; Usage.Prompt.TokenCount is the total
+input including both, matching the OpenAI-compatible accounting where
+cached tokens are a subset of prompt tokens.
+
+Transient failures (429, 5xx, and the 529 overloaded status, including an
+overloaded error event in the middle of a stream) are marked ErrRetryable
+and retried through Retrier from the original state snapshot.
+`
+
+type Anthropic struct {
+	spec   Spec
+	apiKey string
+	client nets.HTTPClient
+
+	Count           dscope.Inject[BPETokenCounter]
+	Logger          dscope.Inject[logs.Logger]
+	Effort          dscope.Inject[EffortFlag]
+	TemperatureFlag dscope.Inject[TemperatureFlag]
+	Debug           dscope.Inject[DebugAnthropic]
+	FuncDecls       dscope.Inject[FuncDecls]
+	EventRecorder   dscope.Inject[EventRecorder]
+	Retrier         dscope.Inject[Retrier]
+	RateLimiters    dscope.Inject[*RateLimiters]
+	Tokenizers      dscope.Inject[Tokenizers]
+}
+
+var _ Generator = new(Anthropic)
+
+func (a *Anthropic) Spec() Spec {
+	return a.spec
+}
+
+// recordEvent records an API-level event in the interaction transcript
+// when interaction recording is active. See generators.TheoryOfEventRecorder.
+func (a *Anthropic) recordEvent(typ string, detail string) {
+	if rec := a.EventRecorder(); rec != nil && rec.Enabled() {
+		rec.Event(typ, detail)
+	}
+}
+
+func (a *Anthropic) CountTokens(text string) (int, error) {
+	return a.CountTokensContext(context.Background(), text)
+}
+
+var _ ContextTokenCounter = new(Anthropic)
+
+func (a *Anthropic) CountTokensContext(ctx context.Context, text string) (int, error) {
+	return a.Tokenizers()(a.spec, a.Count(), func(text string) (int, error) {
+		return a.countTokensAPI(ctx, text)
+	})(text)
+}
+
+// countTokensAPI counts text as a user message with the count_tokens
+// endpoint. See TheoryOfTokenizers.
+func (a *Anthropic) countTokensAPI(ctx context.Context, text string) (int, error) {
+	if err := waitCountTokensRateLimit(ctx, a.RateLimiters(), a.Logger(), a.spec); err != nil {
+		return 0, err
+	}
+	bodyBytes, err := json.Marshal(map[string]any{
+		"model": a.spec.Model,
+		"messages": []AnthropicMessage{
+			{
+				Role: "user",
+				Content: []AnthropicContentBlock{
+					{Type: "text", Text: text},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", a.baseURL()+"/messages/count_tokens", bytes.NewReader(bodyBytes))
+	if err != nil {
+		return 0, err
+	}
+	httpReq.Header.Set("x-api-key", a.apiKey)
+	httpReq.Header.Set("anthropic-version", a.apiVersion())
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := a.client.Do(httpReq)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	var result struct {
+		InputTokens int `json:"input_tokens"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return 0, err
+	}
+	return result.InputTokens, nil
+}
+
+func (a *Anthropic) baseURL() string {
+	baseURL := a.spec.BaseURL
+	if baseURL == "" {
+		baseURL = "https://api.anthropic.com/v1"
+	}
+	return strings.TrimSuffix(baseURL, "/")
+}
+
+func (a *Anthropic) apiVersion() string {
+	if a.spec.APIVersion != "" {
+		return a.spec.APIVersion
+	}
+	return "2023-06-01"
+}
+
+// defaultAnthropicMaxTokens is sent when the spec sets no output limit;
+// the Messages API requires max_tokens on every request.
+const defaultAnthropicMaxTokens = 32 * 1024
+
+// anthropicMaxCacheBreakpoints is the number of cache_control markers the
+// API accepts in one request, across the system prompt and all messages.
+const anthropicMaxCacheBreakpoints = 4
+
+func (a *Anthropic) Generate(ctx context.Context, state State, options *GenerateOptions) (ret State, err error) {
+	ret = state
+
+	preservedThinking := a.spec.PreservedThinking != nil && *a.spec.PreservedThinking
+	messages, err := stateToAnthropicMessages(ret, preservedThinking)
+	if err != nil {
+		return nil, err
+	}
+
+	req := AnthropicRequest{
+		Model:     a.spec.Model,
+		Messages:  messages,
+		MaxTokens: defaultAnthropicMaxTokens,
+	}
+	if a.spec.MaxGenerateTokens != nil {
+		req.MaxTokens = *a.spec.MaxGenerateTokens
+	}
+	if options != nil && options.MaxGenerateTokens != nil && *options.MaxGenerateTokens < req.MaxTokens {
+		req.MaxTokens = *options.MaxGenerateTokens
+	}
+
+	if sysPrompt := ret.SystemPrompt(); sysPrompt != "" {
+		block := AnthropicContentBlock{
+			Type: "text",
+			Text: sysPrompt,
+		}
+		// The system prompt is a prefix of every message breakpoint, so its
+		// own marker is the first to give up a slot when the messages use
+		// them all. See TheoryOfCacheBreakpoints.
+		if countAnthropicCacheControl(messages) < anthropicMaxCacheBreakpoints {
+			block.CacheControl = &AnthropicCacheControl{Type: "ephemeral"}
+		}
+		req.System = []AnthropicContentBlock{block}
+	}
+
+	reasoningEffort := a.spec.ReasoningEffort
+	if flagEffort := string(a.Effort()); flagEffort != "" {
+		if flagEffort != reasoningEffort {
+			a.Logger().WarnContext(ctx, "effort override",
+				"spec_effort", reasoningEffort,
+				"actual_effort", flagEffort,
+			)
+		}
+		reasoningEffort = flagEffort
+	}
+	if a.spec.MaxThinkingTokens != nil {
+		req.Thinking = &AnthropicThinking{
+			Type:         "enabled",
+			BudgetTokens: *a.spec.MaxThinkingTokens,
+		}
+	} else if reasoningEffort != "" {
+		req.Thinking = &AnthropicThinking{
+			Type: "adaptive",
+		}
+	}
+	if reasoningEffort != "" {
+		req.OutputConfig = &AnthropicOutputConfig{
+			Effort: reasoningEffort,
+		}
+	}
+
+	// The API rejects a temperature other than the default while thinking
+	// is enabled, so the spec and flag temperatures only apply without it.
+	if req.Thinking == nil {
+		if a.spec.Temperature != nil {
+			t := *a.spec.Temperature
+			req.Temperature = &t
+		}
+		if flag := a.TemperatureFlag(); flag.Value != nil {
+			if a.spec.Temperature == nil || *flag.Value != *a.spec.Temperature {
+				a.Logger().WarnContext(ctx, "temperature override",
+					"spec_temperature", a.spec.Temperature,
+					"actual_temperature", *flag.Value,
+				)
+			}
+			req.Temperature = flag.Value
+		}
+	}
+
+	if a.spec.DisableTools == nil || !*a.spec.DisableTools {
+		// Globally sorted by name for prefix cache stability. See
+		// TheoryOfPrefixCaching.
+		var allFuncs []FuncDecl
+		for fn := range ret.Functions() {
+			allFuncs = append(allFuncs, fn.Decl)
+		}
+		allFuncs = append(allFuncs, a.FuncDecls()...)
+		sort.SliceStable(allFuncs, func(i, j int) bool {
+			return allFuncs[i].Name < allFuncs[j].Name
+		})
+		for _, fn := range allFuncs {
+			req.Tools = append(req.Tools, fn.ToAnthropic())
+		}
+	}
+
+	if options != nil && options.ResponseSchema != nil {
+		if req.OutputConfig == nil {
+			req.OutputConfig = new(AnthropicOutputConfig)
+		}
+		req.OutputConfig.Format = &AnthropicOutputFormat{
+			Type:   "json_schema",
+			Schema: options.ResponseSchema.ToOpenAI(),
+		}
+	}
+
+	nonStreaming := false
+	if options != nil && options.NonStreaming {
+		nonStreaming = true
+	}
+	req.Stream = !nonStreaming
+
+	bodyBytes, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if a.Debug() {
+		a.Logger().InfoContext(ctx, "anthropic request",
+			"body", string(bodyBytes),
+		)
+	}
+
+	url := a.baseURL() + "/messages"
+	apiVersion := a.apiVersion()
+
+	client := a.client
+	if a.spec.NoProxy != nil && *a.spec.NoProxy {
+		client = nets.HTTPClient{
+			Client: &http.Client{
+				Transport: &http.Transport{
+					DialContext: (&net.Dialer{}).DialContext,
+				},
+			},
+		}
+	}
+
+	// Each attempt is paced by the spec's rate limits. See
+	// TheoryOfRateLimit.
+	estimatedTokens := estimateRequestTokens(a.spec, ret, CountTokensFunc(ctx, a))
+	ret, err = a.Retrier().Do(ctx, func() (State, error) {
+		if err := waitRateLimit(ctx, a.RateLimiters(), a.Logger(), a.spec, estimatedTokens); err != nil {
+			return ret, err
+		}
+		a.Logger().InfoContext(ctx, "generating",
+			"name", a.spec.Name,
+			"model", a.spec.Model,
+			"effort", reasoningEffort,
+			"non_streaming", nonStreaming,
+		)
+		a.recordEvent("api_call", fmt.Sprintf("anthropic messages: model=%s effort=%s non_streaming=%v", a.spec.Model, reasoningEffort, nonStreaming))
+
+		httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(bodyBytes))
+		if err != nil {
+			return ret, err
+		}
+		httpReq.Header.Set("x-api-key", a.apiKey)
+		httpReq.Header.Set("anthropic-version", apiVersion)
+		httpReq.Header.Set("Content-Type", "application/json")
+		if !nonStreaming {
+			httpReq.Header.Set("Accept", "text/event-stream")
+		}
+
+		resp, err := client.Do(httpReq)
+		if err != nil {
+			a.recordEvent("api_error", fmt.Sprintf("anthropic request failed: %v", err))
+			return ret, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			a.recordEvent("api_error", fmt.Sprintf("anthropic http status %d: %s", resp.StatusCode, strings.TrimSpace(string(body))))
+			var errResp AnthropicErrorResponse
+			var apiErr error
+			if err := json.Unmarshal(body, &errResp); err != nil || errResp.Error == nil {
+				apiErr = &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+			} else {
+				errResp.Error.HTTPStatusCode = resp.StatusCode
+				apiErr = errResp.Error
+			}
+			if isRetryableAnthropicStatus(resp.StatusCode) {
+				return ret, errors.Join(apiErr, ErrRetryable)
+			}
+			return ret, apiErr
+		}
+
+		if nonStreaming {
+			return a.handleResponse(ctx, ret, resp.Body)
+		}
+		return a.handleStream(ctx, ret, resp.Body)
+	})
+	if err != nil {
+		return ret, err
+	}
+
+	if ret, err = ret.Flush(); err != nil {
+		return ret, err
+	}
+
+	return ret, nil
+}
+
+func isRetryableAnthropicStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		529: // overloaded
+		return true
+	}
+	return false
+}
+
+func (a *Anthropic) handleResponse(ctx context.Context, state State, body io.Reader) (ret State, err error) {
+	ret = state
+	data, err := io.ReadAll(body)
+	if err != nil {
+		a.recordEvent("api_error", fmt.Sprintf("anthropic non-streaming response read failed: %v", err))
+		return state, err
+	}
+	if a.Debug() {
+		a.Logger().InfoContext(ctx, "anthropic response",
+			"body", string(data),
+		)
+	}
+	var response AnthropicResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		a.recordEvent("api_error", fmt.Sprintf("anthropic non-streaming unmarshal failed: %v", err))
+		return state, err
+	}
+
+	content := &Content{
+		Role: RoleModel,
+	}
+	for _, block := range response.Content {
+		content.Parts = append(content.Parts, partsFromAnthropicBlock(block)...)
+	}
+	if len(content.Parts) > 0 {
+		if ret, err = ret.AppendContent(content); err != nil {
+			return state, err
+		}
+	}
+	if response.Usage != nil {
+		if ret, err = ret.AppendContent(&Content{
+			Role:  RoleLog,
+			Parts: []Part{response.Usage.toUsage()},
+		}); err != nil {
+			return state, err
+		}
+	}
+	if response.StopReason != "" {
+		if ret, err = ret.AppendContent(&Content{
+			Role:  RoleLog,
+			Parts: []Part{FinishReason(response.StopReason)},
+		}); err != nil {
+			return state, err
+		}
+	}
+	return ret, nil
+}
+
+func (a *Anthropic) handleStream(ctx context.Context, state State, body io.Reader) (ret State, err error) {
+	ret = state
+
+	// Content blocks are tracked by index: thinking signatures and tool
+	// input JSON arrive as deltas and are only complete at
+	// content_block_stop.
+	type blockState struct {
+		typ       string
+		id        string
+		name      string
+		signature string
+		data      string
+		inputJSON strings.Builder
+	}
+	blocks := make(map[int]*blockState)
+	var usage AnthropicUsage
+	hasUsage := false
+	var stopReason string
+
+	appendParts := func(parts ...Part) error {
+		if len(parts) == 0 {
+			return nil
+		}
+		var err error
+		ret, err = ret.AppendContent(&Content{
+			Role:  RoleModel,
+			Parts: parts,
+		})
+		return err
+	}
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			// "event:" lines repeat the type carried in the data payload.
+			continue
+		}
+		var event AnthropicStreamEvent
+		if err := json.Unmarshal([]byte(line[6:]), &event); err != nil {
+			a.recordEvent("api_error", fmt.Sprintf("anthropic stream unmarshal failed: %v", err))
+			return ret, fmt.Errorf("error unmarshalling stream event: %w", err)
+		}
+		if a.Debug() {
+			a.Logger().InfoContext(ctx, "anthropic event",
+				"details", event,
+			)
+		}
+
+		switch event.Type {
+
+		case "message_start":
+			if event.Message != nil && event.Message.Usage != nil {
+				usage = *event.Message.Usage
+				hasUsage = true
+			}
+
+		case "content_block_start":
+			if event.ContentBlock == nil {
+				continue
+			}
+			b := &blockState{
+				typ:       event.ContentBlock.Type,
+				id:        event.ContentBlock.ID,
+				name:      event.ContentBlock.Name,
+				signature: event.ContentBlock.Signature,
+				data:      event.ContentBlock.Data,
+			}
+			blocks[event.Index] = b
+			switch b.typ {
+			case "text":
+				if event.ContentBlock.Text != "" {
+					if err := appendParts(Text(event.ContentBlock.Text)); err != nil {
+						return ret, err
+					}
+				}
+			case "thinking":
+				if event.ContentBlock.Thinking != "" {
+					if err := appendParts(Thought(event.ContentBlock.Thinking)); err != nil {
+						return ret, err
+					}
+				}
+			}
+
+		case "content_block_delta":
+			b, ok := blocks[event.Index]
+			if !ok || event.Delta == nil {
+				continue
+			}
+			switch event.Delta.Type {
+			case "text_delta":
+				if err := appendParts(Text(event.Delta.Text)); err != nil {
+					return ret, err
+				}
+			case "thinking_delta":
+				if err := appendParts(Thought(event.Delta.Thinking)); err != nil {
+					return ret, err
+				}
+			case "signature_delta":
+				b.signature += event.Delta.Signature
+			case "input_json_delta":
+				b.inputJSON.WriteString(event.Delta.PartialJSON)
+			}
+
+		case "content_block_stop":
+			b, ok := blocks[event.Index]
+			if !ok {
+				continue
+			}
+			delete(blocks, event.Index)
+			switch b.typ {
+			case "thinking":
+				if b.signature != "" {
+					if err := appendParts(ThoughtSignature{Provider: SignatureProviderAnthropic, Signature: b.signature}); err != nil {
+						return ret, err
+					}
+				}
+			case "redacted_thinking":
+				if err := appendParts(ThoughtSignature{Provider: SignatureProviderAnthropic, Redacted: b.data}); err != nil {
+					return ret, err
+				}
+			case "tool_use":
+				var arguments map[string]any
+				if b.inputJSON.Len() > 0 {
+					if err := json.Unmarshal([]byte(b.inputJSON.String()), &arguments); err != nil {
+						a.recordEvent("api_error", fmt.Sprintf("anthropic tool input unmarshal failed: %v", err))
+						return ret, err
+					}
+				}
+				if err := appendParts(FuncCall{
+					ID:        b.id,
+					Name:      b.name,
+					Arguments: arguments,
+				}); err != nil {
+					return ret, err
+				}
+			}
+
+		case "message_delta":
+			if event.Delta != nil && event.Delta.StopReason != "" {
+				stopReason = event.Delta.StopReason
+			}
+			if event.Usage != nil {
+				usage.merge(*event.Usage)
+				hasUsage = true
+			}
+
+		case "message_stop":
+			// Usage and the stop reason are emitted after the scan loop so
+			// a stream that ends without message_stop still reports them.
+
+		case "error":
+			var apiErr error = errors.New("unknown stream error")
+			if event.Error != nil {
+				apiErr = event.Error
+			}
+			a.recordEvent("api_error", fmt.Sprintf("anthropic stream error: %v", apiErr))
+			if event.Error != nil && (event.Error.Type == "overloaded_error" || event.Error.Type == "api_error") {
+				return ret, errors.Join(apiErr, ErrRetryable)
+			}
+			return ret, apiErr
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		a.recordEvent("api_error", fmt.Sprintf("anthropic stream read failed: %v", err))
+		return ret, fmt.Errorf("error reading stream: %w", err)
+	}
+
+	if hasUsage {
+		if ret, err = ret.AppendContent(&Content{
+			Role:  RoleLog,
+			Parts: []Part{usage.toUsage()},
+		}); err != nil {
+			return ret, err
+		}
+	}
+	if stopReason != "" {
+		if ret, err = ret.AppendContent(&Content{
+			Role:  RoleLog,
+			Parts: []Part{FinishReason(stopReason)},
+		}); err != nil {
+			return ret, err
+		}
+	}
+
+	return ret, nil
+}
+
+// partsFromAnthropicBlock converts a complete response content block into
+// parts, in the same shape the streaming path produces.
+func partsFromAnthropicBlock(block AnthropicContentBlock) []Part {
+	switch block.Type {
+	case "text":
+		if block.Text != "" {
+			return []Part{Text(block.Text)}
+		}
+	case "thinking":
+		var parts []Part
+		if block.Thinking != "" {
+			parts = append(parts, Thought(block.Thinking))
+		}
+		if block.Signature != "" {
+			parts = append(parts, ThoughtSignature{Provider: SignatureProviderAnthropic, Signature: block.Signature})
+		}
+		return parts
+	case "redacted_thinking":
+		return []Part{ThoughtSignature{Provider: SignatureProviderAnthropic, Redacted: block.Data}}
+	case "tool_use":
+		arguments, _ := block.Input.(map[string]any)
+		return []Part{FuncCall{
+			ID:        block.ID,
+			Name:      block.Name,
+			Arguments: arguments,
+		}}
+	}
+	return nil
+}
+
+func stateToAnthropicMessages(state State, preservedThinking bool) (messages []AnthropicMessage, err error) {
+	addBlock := func(role string, block AnthropicContentBlock) {
+		// The Messages API requires alternating roles, so consecutive
+		// blocks of the same role share one message.
+		if len(messages) > 0 && messages[len(messages)-1].Role == role {
+			last := &messages[len(messages)-1]
+			last.Content = append(last.Content, block)
+			return
+		}
+		messages = append(messages, AnthropicMessage{
+			Role:    role,
+			Content: []AnthropicContentBlock{block},
+		})
+	}
+
+	// Explicit breakpoints keep one marker slot free for the end of the
+	// conversation; the earliest breakpoints win because they mark the
+	// prefixes that change least often. See TheoryOfCacheBreakpoints.
+	breakpoints := 0
+
+	for content := range state.Contents() {
+		// Log and system contents carry internal metadata; the system
+		// prompt is sent separately. See stateToOpenAIMessages.
+		if content.Role == RoleLog || content.Role == RoleSystem {
+			continue
+		}
+		role := "user"
+		if content.Role == RoleModel || content.Role == RoleAssistant {
+			role = "assistant"
+		}
+
+		var pendingThought strings.Builder
+		for _, part := range content.Parts {
+			switch part := part.(type) {
+			case Text:
+				if len(part) == 0 {
+					continue
+				}
+				addBlock(role, AnthropicContentBlock{
+					Type: "text",
+					Text: string(part),
+				})
+			case Thought:
+				if preservedThinking && role == "assistant" {
+					pendingThought.WriteString(string(part))
+				}
+			case ThoughtSignature:
+				if !preservedThinking || role != "assistant" ||
+					part.Provider != SignatureProviderAnthropic {
+					pendingThought.Reset()
+					continue
+				}
+				if part.Redacted != "" {
+					addBlock(role, AnthropicContentBlock{
+						Type: "redacted_thinking",
+						Data: part.Redacted,
+					})
+				} else if part.Signature != "" {
+					addBlock(role, AnthropicContentBlock{
+						Type:      "thinking",
+						Thinking:  pendingThought.String(),
+						Signature: part.Signature,
+					})
+				}
+				pendingThought.Reset()
+			case FileURL:
+				if len(part) == 0 {
+					continue
+				}
+				addBlock(role, AnthropicContentBlock{
+					Type: "image",
+					Source: &AnthropicSource{
+						Type: "url",
+						URL:  string(part),
+					},
+				})
+			case FileContent:
+				block, err := anthropicFileBlock(part)
+				if err != nil {
+					return nil, err
+				}
+				addBlock(role, block)
+			case FuncCall:
+				arguments := part.Arguments
+				if arguments == nil {
+					// The API requires an input object even for calls
+					// without arguments.
+					arguments = map[string]any{}
+				}
+				addBlock("assistant", AnthropicContentBlock{
+					Type:  "tool_use",
+					ID:    part.ID,
+					Name:  part.Name,
+					Input: arguments,
+				})
+			case CallResult:
+				resultsBytes, err := json.Marshal(part.Results)
+				if err != nil {
+					return nil, err
+				}
+				addBlock("user", AnthropicContentBlock{
+					Type:      "tool_result",
+					ToolUseID: part.ID,
+					Content:   string(resultsBytes),
+				})
+			case CacheBreakpoint:
+				if breakpoints < anthropicMaxCacheBreakpoints-1 && markAnthropicCacheBreakpoint(messages) {
+					breakpoints++
+				}
+			}
+		}
+	}
+
+	// Mark the end of the conversation as a cache breakpoint so the whole
+	// prefix is reusable by the next request.
+	markAnthropicCacheBreakpoint(messages)
+
+	return
+}
+
+// markAnthropicCacheBreakpoint puts an ephemeral cache_control marker on
+// the last block of the last message and reports whether a new marker was
+// added. Thinking blocks cannot carry cache_control, so the marker goes on
+// the last block that can.
+func markAnthropicCacheBreakpoint(messages []AnthropicMessage) bool {
+	if len(messages) == 0 {
+		return false
+	}
+	last := messages[len(messages)-1].Content
+	for i := len(last) - 1; i >= 0; i-- {
+		if last[i].Type == "thinking" || last[i].Type == "redacted_thinking" {
+			continue
+		}
+		if last[i].CacheControl != nil {
+			return false
+		}
+		last[i].CacheControl = &AnthropicCacheControl{Type: "ephemeral"}
+		return true
+	}
+	return false
+}
+
+func countAnthropicCacheControl(messages []AnthropicMessage) (n int) {
+	for _, message := range messages {
+		for _, block := range message.Content {
+			if block.CacheControl != nil {
+				n++
+			}
+		}
+	}
+	return
+}
+
+func anthropicFileBlock(part FileContent) (AnthropicContentBlock, error) {
+	switch {
+	case isTextMIMEType(part.MimeType):
+		return AnthropicContentBlock{
+			Type: "text",
+			Text: string(part.Content),
+		}, nil
+	case strings.HasPrefix(part.MimeType, "image/"):
+		return AnthropicContentBlock{
+			Type: "image",
+			Source: &AnthropicSource{
+				Type:      "base64",
+				MediaType: part.MimeType,
+				Data:      base64.StdEncoding.EncodeToString(part.Content),
+			},
+		}, nil
+	case part.MimeType == "application/pdf":
+		return AnthropicContentBlock{
+			Type: "document",
+			Source: &AnthropicSource{
+				Type:      "base64",
+				MediaType: part.MimeType,
+				Data:      base64.StdEncoding.EncodeToString(part.Content),
+			},
+		}, nil
+	}
+	return AnthropicContentBlock{}, fmt.Errorf("anthropic: unsupported file content type: %s", part.MimeType)
+}
+
+func (f FuncDecl) ToAnthropic() AnthropicTool {
+	return AnthropicTool{
+		Name:        f.Name,
+		Description: f.Description,
+		InputSchema: f.Params.ToOpenAI(),
+	}
+}
+
+type NewAnthropic func(spec Spec) *Anthropic
+
+func (Module) NewAnthropic(
+	inject dscope.InjectStruct,
+	client nets.HTTPClient,
+	apiKey AnthropicAPIKey,
+) NewAnthropic {
+	return func(spec Spec) *Anthropic {
+		ret := &Anthropic{
+			spec:   spec,
+			client: client,
+			apiKey: firstNonZero(spec.APIKey, string(apiKey)),
+		}
+		inject(&ret)
+		return ret
+	}
+}
+
+type AnthropicRequest struct {
+	Model        string                  `json:"model"`
+	MaxTokens    int                     `json:"max_tokens"`
+	System       []AnthropicContentBlock `json:"system,omitempty"`
+	Messages     []AnthropicMessage      `json:"messages"`
+	Stream       bool                    `json:"stream,omitempty"`
+	Temperature  *float32                `json:"temperature,omitempty"`
+	Thinking     *AnthropicThinking      `json:"thinking,omitempty"`
+	OutputConfig *AnthropicOutputConfig  `json:"output_config,omitempty"`
+	Tools        []AnthropicTool         `json:"tools,omitempty"`
+}
+
+type AnthropicMessage struct {
+	Role    string                  `json:"role"`
+	Content []AnthropicContentBlock `json:"content"`
+}
+
+type AnthropicContentBlock struct {
+	Type         string                 `json:"type"`
+	Text         string                 `json:"text,omitempty"`
+	Thinking     string                 `json:"thinking,omitempty"`
+	Signature    string                 `json:"signature,omitempty"`
+	Data         string                 `json:"data,omitempty"`
+	Source       *AnthropicSource       `json:"source,omitempty"`
+	ID           string                 `json:"id,omitempty"`
+	Name         string                 `json:"name,omitempty"`
+	Input        any                    `json:"input,omitempty"`
+	ToolUseID    string                 `json:"tool_use_id,omitempty"`
+	Content      string                 `json:"content,omitempty"`
+	CacheControl *AnthropicCacheControl `json:"cache_control,omitempty"`
+}
+
+type AnthropicSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type AnthropicCacheControl struct {
+	Type string `json:"type"`
+}
+
+type AnthropicThinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens,omitempty"`
+}
+
+type AnthropicOutputConfig struct {
+	Effort string                 `json:"effort,omitempty"`
+	Format *AnthropicOutputFormat `json:"format,omitempty"`
+}
+
+type AnthropicOutputFormat struct {
+	Type   string `json:"type"`
+	Schema any    `json:"schema,omitempty"`
+}
+
+type AnthropicTool struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	InputSchema any    `json:"input_schema"`
+}
+
+type AnthropicResponse struct {
+	ID         string                  `json:"id"`
+	Role       string                  `json:"role"`
+	Model      string                  `json:"model"`
+	Content    []AnthropicContentBlock `json:"content"`
+	StopReason string                  `json:"stop_reason"`
+	Usage      *AnthropicUsage         `json:"usage,omitempty"`
+}
+
+type AnthropicStreamEvent struct {
+	Type         string                 `json:"type"`
+	Index        int                    `json:"index"`
+	Message      *AnthropicResponse     `json:"message,omitempty"`
+	ContentBlock *AnthropicContentBlock `json:"content_block,omitempty"`
+	Delta        *AnthropicStreamDelta  `json:"delta,omitempty"`
+	Usage        *AnthropicUsage        `json:"usage,omitempty"`
+	Error        *AnthropicError        `json:"error,omitempty"`
+}
+
+type AnthropicStreamDelta struct {
+	Type        string `json:"type"`
+	Text        string `json:"text,omitempty"`
+	Thinking    string `json:"thinking,omitempty"`
+	Signature   string `json:"signature,omitempty"`
+	PartialJSON string `json:"partial_json,omitempty"`
+	StopReason  string `json:"stop_reason,omitempty"`
+}
+
+type AnthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+// merge overlays the non-zero counts of a message_delta usage, which are
+// cumulative, onto the usage reported at message_start.
+func (u *AnthropicUsage) merge(other AnthropicUsage) {
+	if other.InputTokens != 0 {
+		u.InputTokens = other.InputTokens
+	}
+	if other.OutputTokens != 0 {
+		u.OutputTokens = other.OutputTokens
+	}
+	if other.CacheCreationInputTokens != 0 {
+		u.CacheCreationInputTokens = other.CacheCreationInputTokens
+	}
+	if other.CacheReadInputTokens != 0 {
+		u.CacheReadInputTokens = other.CacheReadInputTokens
+	}
+}
+
+func (u AnthropicUsage) toUsage() Usage {
+	var usage Usage
+	usage.Prompt.TokenCount = u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+	usage.Prompt.TokenCountCached = u.CacheReadInputTokens
+	usage.Prompt.TokenCountCacheWrite = u.CacheCreationInputTokens
+	usage.Candidates.TokenCount = u.OutputTokens
+	return usage
+}
+
+type AnthropicErrorResponse struct {
+	Type  string          `json:"type"`
+	Error *AnthropicError `json:"error,omitempty"`
+}
+
+type AnthropicError struct {
+	Type           string `json:"type"`
+	Message        string `json:"message"`
+	HTTPStatusCode int    `json:"-"`
+}
+
+func (e *AnthropicError) Error() string {
+	return e.Type + ": " + e.Message
+}
@@ -0,0 +1,369 @@
+package generators
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/reusee/dscope"
+	"github.com/reusee/tai/modes"
+	"github.com/reusee/tai/nets"
+)
+
+func anthropicSSE(events ...string) string {
+	var sb strings.Builder
+	for _, event := range events {
+		var typ struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal([]byte(event), &typ)
+		fmt.Fprintf(&sb, "event: %s\ndata: %s\n\n", typ.Type, event)
+	}
+	return sb.String()
+}
+
+func TestAnthropicStreaming(t *testing.T) {
+	var gotBody AnthropicRequest
+	var gotHeader http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &gotBody); err != nil {
+			t.Errorf("bad request body: %v", err)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, anthropicSSE(
+			`{"type":"message_start","message":{"id":"msg_1","role":"assistant","content":[],"usage":{"input_tokens":10,"cache_creation_input_tokens":20,"cache_read_input_tokens":30,"output_tokens":1}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"let me "}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"think"}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"signature_delta","signature":"sig-1"}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"content_block_start","index":1,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"hello "}}`,
+			`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"world"}}`,
+			`{"type":"content_block_stop","index":1}`,
+			`{"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_1","name":"now","input":{}}}`,
+			`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"{\"tz\":"}}`,
+			`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"\"UTC\"}"}}`,
+			`{"type":"content_block_stop","index":2}`,
+			`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":42}}`,
+			`{"type":"message_stop"}`,
+		))
+	}))
+	defer server.Close()
+
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Fork(
+		func() nets.HTTPClient {
+			return nets.HTTPClient{Client: server.Client()}
+		},
+	).Call(func(
+		newAnthropic NewAnthropic,
+	) {
+		gen := newAnthropic(Spec{
+			BaseURL: server.URL,
+			Model:   "claude-test",
+			APIKey:  "test-key",
+		})
+
+		state := NewPrompts("be brief", []*Content{
+			{Role: RoleUser, Parts: []Part{Text("hi")}},
+		})
+		ret, err := gen.Generate(context.Background(), state, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if gotHeader.Get("x-api-key") != "test-key" {
+			t.Fatalf("wrong api key header: %q", gotHeader.Get("x-api-key"))
+		}
+		if gotHeader.Get("anthropic-version") == "" {
+			t.Fatal("anthropic-version header not set")
+		}
+		if !gotBody.Stream {
+			t.Fatal("expected streaming request")
+		}
+		if len(gotBody.System) != 1 || gotBody.System[0].Text != "be brief" ||
+			gotBody.System[0].CacheControl == nil {
+			t.Fatalf("system prompt must be a cached text block, got %+v", gotBody.System)
+		}
+		last := gotBody.Messages[len(gotBody.Messages)-1]
+		if last.Content[len(last.Content)-1].CacheControl == nil {
+			t.Fatalf("last block must carry cache_control, got %+v", last)
+		}
+
+		var thought Thought
+		var text Text
+		var sig ThoughtSignature
+		var call FuncCall
+		var usage Usage
+		var finish FinishReason
+		for c := range ret.Contents() {
+			for _, p := range c.Parts {
+				switch p := p.(type) {
+				case Thought:
+					thought += p
+				case Text:
+					if c.Role == RoleModel {
+						text += p
+					}
+				case ThoughtSignature:
+					sig = p
+				case FuncCall:
+					call = p
+				case Usage:
+					usage = p
+				case FinishReason:
+					finish = p
+				}
+			}
+		}
+		if thought != "let me think" {
+			t.Fatalf("got thought %q", thought)
+		}
+		if sig.Signature != "sig-1" {
+			t.Fatalf("got signature %+v", sig)
+		}
+		if text != "hello world" {
+			t.Fatalf("got text %q", text)
+		}
+		if call.ID != "toolu_1" || call.Name != "now" || call.Arguments["tz"] != "UTC" {
+			t.Fatalf("got call %+v", call)
+		}
+		if usage.Prompt.TokenCount != 60 ||
+			usage.Prompt.TokenCountCached != 30 ||
+			usage.Prompt.TokenCountCacheWrite != 20 ||
+			usage.Candidates.TokenCount != 42 {
+			t.Fatalf("got usage %+v", usage)
+		}
+		if finish != "tool_use" {
+			t.Fatalf("got finish reason %q", finish)
+		}
+	})
+}
+
+func TestAnthropicNonStreaming(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id":"msg_1","role":"assistant","content":[{"type":"text","text":"done"}],"stop_reason":"end_turn","usage":{"input_tokens":5,"output_tokens":2}}`)
+	}))
+	defer server.Close()
+
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Fork(
+		func() nets.HTTPClient {
+			return nets.HTTPClient{Client: server.Client()}
+		},
+	).Call(func(
+		newAnthropic NewAnthropic,
+	) {
+		gen := newAnthropic(Spec{
+			BaseURL: server.URL,
+			Model:   "claude-test",
+		})
+		state := NewPrompts("", []*Content{
+			{Role: RoleUser, Parts: []Part{Text("hi")}},
+		})
+		ret, err := gen.Generate(context.Background(), state, &GenerateOptions{
+			NonStreaming: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for c := range ret.Contents() {
+			for _, p := range c.Parts {
+				if text, ok := p.(Text); ok && text == "done" {
+					found = true
+				}
+			}
+		}
+		if !found {
+			t.Fatal("expected response text")
+		}
+	})
+}
+
+func TestAnthropicCountTokensAPI(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path != "/messages/count_tokens" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		var req AnthropicRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		if req.Model != "claude-test" || len(req.Messages) != 1 || req.Messages[0].Content[0].Text != "count me" {
+			t.Errorf("unexpected request %+v", req)
+		}
+		fmt.Fprint(w, `{"input_tokens":42}`)
+	}))
+	defer server.Close()
+
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Fork(
+		func() nets.HTTPClient {
+			return nets.HTTPClient{Client: server.Client()}
+		},
+	).Call(func(
+		newAnthropic NewAnthropic,
+	) {
+		gen := newAnthropic(Spec{
+			BaseURL:   server.URL,
+			Model:     "claude-test",
+			Tokenizer: "api",
+		})
+		for range 2 {
+			n, err := gen.CountTokens("count me")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 42 {
+				t.Fatalf("got %d", n)
+			}
+		}
+		if calls != 1 {
+			t.Fatalf("expected a cached count, got %d calls", calls)
+		}
+
+		// the count follows the caller's context
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := CountTokensFunc(ctx, gen)("count me too"); err == nil {
+			t.Fatal("expected error with a canceled context")
+		}
+		if calls != 1 {
+			t.Fatalf("canceled count must not reach the server, got %d calls", calls)
+		}
+	})
+}
+
+func TestAnthropicNonRetryableError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"type":"error","error":{"type":"invalid_request_error","message":"bad model"}}`)
+	}))
+	defer server.Close()
+
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Fork(
+		func() nets.HTTPClient {
+			return nets.HTTPClient{Client: server.Client()}
+		},
+	).Call(func(
+		newAnthropic NewAnthropic,
+	) {
+		gen := newAnthropic(Spec{
+			BaseURL: server.URL,
+			Model:   "claude-test",
+		})
+		state := NewPrompts("", []*Content{
+			{Role: RoleUser, Parts: []Part{Text("hi")}},
+		})
+		_, err := gen.Generate(context.Background(), state, nil)
+		if err == nil || !strings.Contains(err.Error(), "bad model") {
+			t.Fatalf("expected api error, got %v", err)
+		}
+	})
+}
+
+func TestStateToAnthropicMessages(t *testing.T) {
+	state := NewPrompts("", []*Content{
+		{Role: RoleUser, Parts: []Part{Text("q")}},
+		{Role: RoleModel, Parts: []Part{
+			Thought("reasoning"),
+			ThoughtSignature{Provider: SignatureProviderAnthropic, Signature: "sig"},
+			ThoughtSignature{Provider: SignatureProviderAnthropic, Redacted: "opaque"},
+			Thought("foreign"),
+			ThoughtSignature{Provider: SignatureProviderResponses, Signature: "enc"},
+			Text("answer"),
+			FuncCall{ID: "c1", Name: "f"},
+		}},
+		{Role: RoleTool, Parts: []Part{
+			CallResult{ID: "c1", Name: "f", Results: map[string]any{"ok": true}},
+		}},
+		{Role: RoleLog, Parts: []Part{Usage{}}},
+	})
+
+	t.Run("preserved thinking", func(t *testing.T) {
+		messages, err := stateToAnthropicMessages(state, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(messages) != 3 {
+			t.Fatalf("expected 3 messages, got %+v", messages)
+		}
+		assistant := messages[1]
+		if assistant.Role != "assistant" || len(assistant.Content) != 4 {
+			t.Fatalf("got %+v", assistant)
+		}
+		if b := assistant.Content[0]; b.Type != "thinking" || b.Thinking != "reasoning" || b.Signature != "sig" {
+			t.Fatalf("got %+v", b)
+		}
+		if b := assistant.Content[1]; b.Type != "redacted_thinking" || b.Data != "opaque" {
+			t.Fatalf("got %+v", b)
+		}
+		if b := assistant.Content[3]; b.Type != "tool_use" || b.Input == nil {
+			t.Fatalf("tool_use must carry an input object, got %+v", b)
+		}
+		if b := messages[2].Content[0]; messages[2].Role != "user" || b.Type != "tool_result" || b.ToolUseID != "c1" {
+			t.Fatalf("got %+v", messages[2])
+		}
+	})
+
+	t.Run("stripped thinking", func(t *testing.T) {
+		messages, err := stateToAnthropicMessages(state, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, b := range messages[1].Content {
+			if b.Type == "thinking" || b.Type == "redacted_thinking" {
+				t.Fatalf("thinking must be stripped, got %+v", messages[1])
+			}
+		}
+	})
+}
+
+func TestAnthropicCacheBreakpoints(t *testing.T) {
+	var parts []Part
+	for i := range 5 {
+		parts = append(parts, Text(fmt.Sprintf("tier %d", i)), CacheBreakpoint{})
+	}
+	parts = append(parts, Text("question"))
+	state := NewPrompts("", []*Content{
+		{Role: RoleUser, Parts: parts},
+	})
+
+	messages, err := stateToAnthropicMessages(state, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := messages[0].Content
+	// The earliest breakpoints are kept and one slot is left for the end
+	// of the conversation.
+	for i, block := range blocks {
+		marked := block.CacheControl != nil
+		want := i < anthropicMaxCacheBreakpoints-1 || i == len(blocks)-1
+		if marked != want {
+			t.Fatalf("block %d (%q): cache_control=%v, want %v", i, block.Text, marked, want)
+		}
+	}
+	if n := countAnthropicCacheControl(messages); n != anthropicMaxCacheBreakpoints {
+		t.Fatalf("got %d markers", n)
+	}
+}
@@ -20,6 +20,8 @@ type (
 	NvidiaAPIKey     string
 	AzureAPIKey      string
 	BedrockAPIKey    string
+	AnthropicAPIKey  string
+	OpenAIAPIKey     string
 )
 
 var _ configs.Config = GoogleAPIKey("")
@@ -273,3 +275,41 @@ func (o OpenCodeGoAPIKey) HandleConfig(path string, values []*cue.Value) (any, e
 func (Module) OpenCodeGoAPIKey() OpenCodeGoAPIKey {
 	return OpenCodeGoAPIKey(os.Getenv("OPENCODE_GO_API_KEY"))
 }
+
+var _ configs.Config = AnthropicAPIKey("")
+
+func (a AnthropicAPIKey) ConfigPaths() []string {
+	return []string{"anthropic_api_key"}
+}
+
+func (a AnthropicAPIKey) HandleConfig(path string, values []*cue.Value) (any, error) {
+	s, err := values[0].String()
+	if err != nil {
+		return nil, err
+	}
+	ret := AnthropicAPIKey(s)
+	return &ret, nil
+}
+
+func (Module) AnthropicAPIKey() AnthropicAPIKey {
+	return AnthropicAPIKey(os.Getenv("ANTHROPIC_API_KEY"))
+}
+
+var _ configs.Config = OpenAIAPIKey("")
+
+func (o OpenAIAPIKey) ConfigPaths() []string {
+	return []string{"openai_api_key"}
+}
+
+func (o OpenAIAPIKey) HandleConfig(path string, values []*cue.Value) (any, error) {
+	s, err := values[0].String()
+	if err != nil {
+		return nil, err
+	}
+	ret := OpenAIAPIKey(s)
+	return &ret, nil
+}
+
+func (Module) OpenAIAPIKey() OpenAIAPIKey {
+	return OpenAIAPIKey(os.Getenv("OPENAI_API_KEY"))
+}
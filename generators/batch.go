@@ -0,0 +1,228 @@
+package generators
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"cuelang.org/go/cue"
+	"github.com/reusee/tai/configs"
+	"github.com/reusee/tai/flags"
+	"github.com/reusee/tai/logs"
+)
+
+const TheoryOfBatch = `
+Some work does not need an answer within seconds: session analysis, a
+review queued after the main session. OpenAI and Gemini run such requests
+as batch jobs at half the price. GenerateOptions.Batch asks a generator to
+take that path; the call still returns a normal State, only later.
+
+The OpenAI-compatible generator uploads the requests as a JSONL file
+(POST /files with purpose "batch"), creates a job over it (POST /batches
+for /v1/chat/completions, completion window 24h), polls GET /batches/{id},
+and reads the output and error files (GET /files/{id}/content), matching
+lines by custom_id. The Gemini generator creates a job with inlined
+requests (Batches.Create) and polls Batches.Get. Either way the response is
+converted exactly as a non-streaming response, so callers and wrappers
+(calibration, fallback, cache, recording) see no difference. Azure
+deployments and the other generators have no batch path and ignore the
+option.
+
+A job per request would waste the point of batching when callers issue many
+requests at once (analysis over many sessions), so requests are coalesced:
+the first request to a queue — keyed by generator kind, base URL, model and
+API key, since a job takes a single model — waits batchCollectDelay for
+others, up to maxBatchRequests per job, and the job is submitted for all of
+them. Each caller waits for its own result or its own context; the job runs
+under a context detached from the callers' cancellation and bounded by
+maxBatchWait, since a job cannot be recalled into an answer anyway.
+
+Polling starts immediately and backs off from batchPollInterval, doubling
+up to maxBatchPollInterval: jobs usually take minutes to hours, and a
+polling loop must not spend the rate limit saved by batching. Batch
+requests do not wait on the rate limiter (see TheoryOfRateLimit), they are
+not counted against the synchronous limits, and a Gemini request does not
+create cached contents, whose lifetime may not cover the job.
+
+Usage reported by a batch response carries Batch, so pricing applies the
+batch discount (see TheoryOfPricing).
+
+The -batch flag (batch config) sets the option for the commands that
+accept latency: the analysis of the record command and, with the codes
+-review-batch flag, the review sessions.
+`
+
+const (
+	// batchCollectDelay is how long the first request of a job waits for
+	// others.
+	batchCollectDelay = 200 * time.Millisecond
+	// maxBatchRequests bounds the requests of a job.
+	maxBatchRequests = 1000
+	// maxBatchWait bounds a job, a little over the 24h completion window.
+	maxBatchWait = 25 * time.Hour
+)
+
+// polling intervals; variables so tests can shorten them
+var (
+	batchPollInterval    = 5 * time.Second
+	maxBatchPollInterval = 2 * time.Minute
+)
+
+// batchResult is the outcome of one request of a job.
+type batchResult[Resp any] struct {
+	resp Resp
+	err  error
+}
+
+type batchCall[Req, Resp any] struct {
+	req    Req
+	result batchResult[Resp]
+	done   chan struct{}
+}
+
+// batchQueue coalesces concurrent requests into batch jobs. See
+// TheoryOfBatch.
+type batchQueue[Req, Resp any] struct {
+	mu      sync.Mutex
+	pending []*batchCall[Req, Resp]
+}
+
+// processBatchQueues holds the queues of the process, keyed like
+// batchQueueFor, so generators built in different scopes share jobs.
+var processBatchQueues sync.Map
+
+func batchQueueFor[Req, Resp any](key string) *batchQueue[Req, Resp] {
+	v, _ := processBatchQueues.LoadOrStore(key, new(batchQueue[Req, Resp]))
+	return v.(*batchQueue[Req, Resp])
+}
+
+// do adds req to the next job and waits for its result. run submits a job
+// and returns the results in request order.
+func (q *batchQueue[Req, Resp]) do(
+	ctx context.Context,
+	req Req,
+	run func(ctx context.Context, reqs []Req) ([]batchResult[Resp], error),
+) (ret Resp, err error) {
+	call := &batchCall[Req, Resp]{
+		req:  req,
+		done: make(chan struct{}),
+	}
+
+	q.mu.Lock()
+	q.pending = append(q.pending, call)
+	first := len(q.pending) == 1
+	var full []*batchCall[Req, Resp]
+	if len(q.pending) >= maxBatchRequests {
+		full = q.pending
+		q.pending = nil
+	}
+	q.mu.Unlock()
+
+	jobCtx := context.WithoutCancel(ctx)
+	switch {
+	case full != nil:
+		go q.submit(jobCtx, full, run)
+	case first:
+		go func() {
+			time.Sleep(batchCollectDelay)
+			q.mu.Lock()
+			calls := q.pending
+			q.pending = nil
+			q.mu.Unlock()
+			if len(calls) > 0 {
+				q.submit(jobCtx, calls, run)
+			}
+		}()
+	}
+
+	select {
+	case <-call.done:
+		return call.result.resp, call.result.err
+	case <-ctx.Done():
+		return ret, ctx.Err()
+	}
+}
+
+func (q *batchQueue[Req, Resp]) submit(
+	ctx context.Context,
+	calls []*batchCall[Req, Resp],
+	run func(ctx context.Context, reqs []Req) ([]batchResult[Resp], error),
+) {
+	ctx, cancel := context.WithTimeout(ctx, maxBatchWait)
+	defer cancel()
+	reqs := make([]Req, 0, len(calls))
+	for _, call := range calls {
+		reqs = append(reqs, call.req)
+	}
+	results, err := run(ctx, reqs)
+	if err == nil && len(results) != len(calls) {
+		err = fmt.Errorf("batch returned %d results for %d requests", len(results), len(calls))
+	}
+	for i, call := range calls {
+		if err != nil {
+			call.result.err = err
+		} else {
+			call.result = results[i]
+		}
+		close(call.done)
+	}
+}
+
+// pollBatch calls poll until it reports the job done, immediately and
+// then with growing intervals. See TheoryOfBatch.
+func pollBatch(ctx context.Context, logger logs.Logger, job string, poll func() (done bool, err error)) error {
+	interval := batchPollInterval
+	for {
+		done, err := poll()
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		logger.InfoContext(ctx, "batch job pending", "job", job, "next poll", interval)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+		interval = min(interval*2, maxBatchPollInterval)
+	}
+}
+
+// Batch makes commands that accept latency run their requests as batch
+// jobs. See TheoryOfBatch.
+type Batch bool
+
+func (Module) Batch() Batch {
+	return false
+}
+
+var _ flags.Flag = Batch(false)
+
+func (b Batch) Keys() map[string]string {
+	return map[string]string{
+		"-batch": "Run requests that can wait (record analysis, queued review) as provider batch jobs at batch prices",
+	}
+}
+
+func (b Batch) Handle(key string, args []string) (newDef any, remainArgs []string, err error) {
+	ret := Batch(true)
+	return &ret, args, nil
+}
+
+var _ configs.Config = Batch(false)
+
+func (b Batch) ConfigPaths() []string {
+	return []string{"batch"}
+}
+
+func (b Batch) HandleConfig(path string, values []*cue.Value) (any, error) {
+	var v bool
+	if err := values[0].Decode(&v); err != nil {
+		return nil, err
+	}
+	ret := Batch(v)
+	return &ret, nil
+}
@@ -0,0 +1,376 @@
+package generators
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"cuelang.org/go/cue"
+	"github.com/reusee/tai/configs"
+	"github.com/reusee/tai/flags"
+	"github.com/reusee/tai/logs"
+	"github.com/reusee/tai/modes"
+)
+
+const TheoryOfTokenCalibration = `
+Even with the right tokenizer (see TheoryOfTokenizers) a local count
+misses what the provider adds: message framing, function declarations,
+images, and vocabularies nobody ships locally. The provider reports the
+truth after every call as Usage.Prompt.TokenCount, so the error can be
+measured instead of guessed.
+
+GetGenerator wraps every generator it constructs, innermost, so calls
+served by the response cache or a replay are not measured. When a call
+reports a Usage, the request is counted with the generator's own
+CountTokens (the system prompt and the text parts, like the rate limiter's
+estimate, and the function declarations, joined into one text so an "api"
+tokenizer makes one count-tokens request) and, when the estimate is at
+least minCalibrationTokens, reported/estimated is recorded for the spec.
+The request is counted only then, not on every call, since counting
+re-tokenizes the whole context. A request with parts the count cannot
+measure — files, images, function calls and results, thoughts — is not
+recorded: the reported tokens include them and would skew the ratio. The
+ratio is an exponential moving average (calibrationWeight per sample),
+clamped to [minCalibrationRatio, maxCalibrationRatio] so one odd call
+cannot wreck the budget. Specs are keyed by name (model and base URL
+without one) and tokenizer, since changing the tokenizer changes the
+error.
+
+The wrapper's CountTokens multiplies the counts by the ratio, rounded up.
+Everything that budgets through the generator's counter is corrected
+without knowing about calibration: the context budget derived from focus
+package tokens (gotools calculateMaxContextTokens), the visibility
+allocation of SimplifyFiles, and the user prompt limit. A ratio below one
+is kept as well, giving back budget a pessimistic counter wasted.
+
+Budgets must be stable across rounds to keep the prefix cache warm (see
+codes.TheoryOfTokenBudgetStability), so the ratio applied is the one
+loaded when the process first opened the store, quantized to
+calibrationStep; samples recorded during the process only affect later
+processes. The store is a JSON file (token_calibration_file, default
+tai/token_calibration.json in the user cache directory; in development
+mode, as in tests, nothing is persisted unless a file is set). Each record
+rereads the file before writing it back, so concurrent processes lose at
+most a sample. Calibration errors never fail generation; they are logged.
+`
+
+const (
+	// minCalibrationTokens is the smallest estimate recorded; short
+	// requests are dominated by framing overhead.
+	minCalibrationTokens = 1024
+	// calibrationWeight is the weight of a new sample in the average.
+	calibrationWeight = 0.2
+	// calibrationStep is the quantization of the applied ratio.
+	calibrationStep = 0.05
+
+	minCalibrationRatio = 0.5
+	maxCalibrationRatio = 4
+)
+
+// TokenCalibration stores per-spec ratios between reported and estimated
+// prompt tokens. A nil TokenCalibration is disabled. See
+// TheoryOfTokenCalibration.
+type TokenCalibration struct {
+	path   string
+	logger logs.Logger
+
+	mu      sync.Mutex
+	applied map[string]float64
+	entries map[string]calibrationEntry
+}
+
+type calibrationEntry struct {
+	Ratio   float64 `json:"ratio"`
+	Samples int     `json:"samples"`
+}
+
+// processTokenCalibrations holds the stores opened in the process, keyed by
+// file, so scopes recreated by dscope.Reset apply the same ratios.
+var processTokenCalibrations sync.Map
+
+func (Module) TokenCalibration(
+	file TokenCalibrationFile,
+	mode modes.Mode,
+	logger logs.Logger,
+) *TokenCalibration {
+	path := string(file)
+	if path == "" && mode == modes.ModeProduction {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			logger.Warn("token calibration not persisted", "error", err)
+		} else {
+			path = filepath.Join(cacheDir, "tai", "token_calibration.json")
+		}
+	}
+	if path == "" {
+		// not persisted; a store per scope
+		return newTokenCalibration("", logger)
+	}
+	if v, ok := processTokenCalibrations.Load(path); ok {
+		return v.(*TokenCalibration)
+	}
+	v, _ := processTokenCalibrations.LoadOrStore(path, newTokenCalibration(path, logger))
+	return v.(*TokenCalibration)
+}
+
+func newTokenCalibration(path string, logger logs.Logger) *TokenCalibration {
+	c := &TokenCalibration{
+		path:    path,
+		logger:  logger,
+		applied: make(map[string]float64),
+		entries: make(map[string]calibrationEntry),
+	}
+	if path != "" {
+		entries, err := c.load()
+		if err != nil {
+			logger.Warn("token calibration load", "error", err)
+		}
+		c.entries = entries
+	}
+	for key, entry := range c.entries {
+		c.applied[key] = quantizeCalibration(entry.Ratio)
+	}
+	return c
+}
+
+func quantizeCalibration(ratio float64) float64 {
+	ratio = max(minCalibrationRatio, min(ratio, maxCalibrationRatio))
+	return math.Round(ratio/calibrationStep) * calibrationStep
+}
+
+func calibrationKey(spec Spec) string {
+	return spec.rateLimitKey() + "\x00" + spec.Tokenizer
+}
+
+// Ratio returns the ratio applied to the counts of spec, 1 when nothing
+// was recorded before the store was opened.
+func (c *TokenCalibration) Ratio(spec Spec) float64 {
+	if c == nil {
+		return 1
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ratio, ok := c.applied[calibrationKey(spec)]; ok {
+		return ratio
+	}
+	return 1
+}
+
+// Record adds a sample of reported prompt tokens for an estimate.
+func (c *TokenCalibration) Record(spec Spec, estimated int, reported int) error {
+	if c == nil || estimated < minCalibrationTokens || reported <= 0 {
+		return nil
+	}
+	sample := max(minCalibrationRatio, min(float64(reported)/float64(estimated), maxCalibrationRatio))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.path != "" {
+		// merge samples written by other processes
+		entries, err := c.load()
+		if err != nil {
+			return err
+		}
+		c.entries = entries
+	}
+	key := calibrationKey(spec)
+	entry, ok := c.entries[key]
+	if ok {
+		entry.Ratio += calibrationWeight * (sample - entry.Ratio)
+	} else {
+		entry.Ratio = sample
+	}
+	entry.Samples++
+	c.entries[key] = entry
+	if c.path == "" {
+		return nil
+	}
+	return c.save()
+}
+
+func (c *TokenCalibration) load() (map[string]calibrationEntry, error) {
+	entries := make(map[string]calibrationEntry)
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return entries, err
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return make(map[string]calibrationEntry), fmt.Errorf("token calibration %s: %w", c.path, err)
+	}
+	return entries, nil
+}
+
+func (c *TokenCalibration) save() error {
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// Wrap returns gen with calibrated token counts, recording the reported
+// usage of its calls. See TheoryOfTokenCalibration.
+func (c *TokenCalibration) Wrap(gen Generator) Generator {
+	if c == nil {
+		return gen
+	}
+	return &calibratedGenerator{
+		upstream:    gen,
+		calibration: c,
+		ratio:       c.Ratio(gen.Spec()),
+	}
+}
+
+type calibratedGenerator struct {
+	upstream    Generator
+	calibration *TokenCalibration
+	ratio       float64
+}
+
+var _ Generator = new(calibratedGenerator)
+
+func (g *calibratedGenerator) Spec() Spec {
+	return g.upstream.Spec()
+}
+
+func (g *calibratedGenerator) CountTokens(text string) (int, error) {
+	return g.CountTokensContext(context.Background(), text)
+}
+
+var _ ContextTokenCounter = new(calibratedGenerator)
+
+func (g *calibratedGenerator) CountTokensContext(ctx context.Context, text string) (int, error) {
+	n, err := CountTokensFunc(ctx, g.upstream)(text)
+	if err != nil || g.ratio == 1 {
+		return n, err
+	}
+	return int(math.Ceil(float64(n) * g.ratio)), nil
+}
+
+func (g *calibratedGenerator) Generate(ctx context.Context, state State, options *GenerateOptions) (State, error) {
+	captured := new([]*Content)
+	ret, err := g.upstream.Generate(ctx, &capturingState{
+		upstream: state,
+		captured: captured,
+	}, options)
+	if w, ok := ret.(*capturingState); ok {
+		ret = w.upstream
+	}
+	if err != nil {
+		return ret, err
+	}
+
+	var usage *Usage
+	for _, content := range *captured {
+		for _, part := range content.Parts {
+			if u, ok := part.(Usage); ok {
+				usage = &u
+			}
+		}
+	}
+	if usage == nil {
+		return ret, nil
+	}
+	estimated, ok := estimateCalibrationTokens(state, CountTokensFunc(ctx, g.upstream))
+	if !ok {
+		return ret, nil
+	}
+	if err := g.calibration.Record(g.upstream.Spec(), estimated, usage.Prompt.TokenCount); err != nil {
+		g.calibration.logger.WarnContext(ctx, "token calibration store", "error", err)
+	}
+	return ret, nil
+}
+
+// estimateCalibrationTokens counts the prompt tokens of a request: the
+// system prompt, the text parts and the function declarations, in one
+// count. It returns false when the request has parts the count cannot
+// measure. See TheoryOfTokenCalibration.
+func estimateCalibrationTokens(state State, countTokens func(string) (int, error)) (int, bool) {
+	for content := range state.Contents() {
+		if content.Role == RoleLog {
+			continue
+		}
+		for _, part := range content.Parts {
+			switch part.(type) {
+			case Text, CacheBreakpoint:
+			default:
+				return 0, false
+			}
+		}
+	}
+	text := promptText(state)
+	var decls []FuncDecl
+	for fn := range state.Functions() {
+		if fn != nil {
+			decls = append(decls, fn.Decl)
+		}
+	}
+	if len(decls) > 0 {
+		data, err := json.Marshal(decls)
+		if err != nil {
+			return 0, false
+		}
+		text += "\n" + string(data)
+	}
+	n, err := countTokens(text)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// TokenCalibrationFile is the token calibration store. Empty means
+// tai/token_calibration.json in the user cache directory, or no file in
+// development mode. See TheoryOfTokenCalibration.
+type TokenCalibrationFile string
+
+func (Module) TokenCalibrationFile() TokenCalibrationFile {
+	return ""
+}
+
+var _ flags.Flag = TokenCalibrationFile("")
+
+func (t TokenCalibrationFile) Handle(key string, args []string) (newDef any, remainArgs []string, err error) {
+	if len(args) == 0 {
+		return nil, nil, fmt.Errorf("expecting file argument, got empty")
+	}
+	ret := TokenCalibrationFile(args[0])
+	return &ret, args[1:], nil
+}
+
+func (t TokenCalibrationFile) Keys() map[string]string {
+	return map[string]string{
+		"-token-calibration-file": "File storing the ratios between reported and estimated prompt tokens",
+	}
+}
+
+var _ configs.Config = TokenCalibrationFile("")
+
+func (t TokenCalibrationFile) ConfigPaths() []string {
+	return []string{"token_calibration_file"}
+}
+
+func (t TokenCalibrationFile) HandleConfig(path string, values []*cue.Value) (any, error) {
+	var s string
+	if err := values[0].Decode(&s); err != nil {
+		return nil, err
+	}
+	ret := TokenCalibrationFile(s)
+	return &ret, nil
+}
@@ -0,0 +1,206 @@
+package generators
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/reusee/dscope"
+	"github.com/reusee/tai/modes"
+)
+
+type calibrationTestGenerator struct {
+	reported int
+}
+
+func (g calibrationTestGenerator) Spec() Spec {
+	return Spec{Name: "calibrated", Tokenizer: "cl100k_base"}
+}
+
+func (g calibrationTestGenerator) CountTokens(text string) (int, error) {
+	return len(text), nil
+}
+
+func (g calibrationTestGenerator) Generate(ctx context.Context, state State, options *GenerateOptions) (State, error) {
+	var usage Usage
+	usage.Prompt.TokenCount = g.reported
+	return state.AppendContent(&Content{
+		Role:  RoleLog,
+		Parts: []Part{usage},
+	})
+}
+
+func TestTokenCalibration(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "calibration.json")
+	newScope := func() dscope.Scope {
+		return dscope.New(
+			modes.ForTest(t),
+			new(Module),
+		).Fork(
+			func() TokenCalibrationFile {
+				return TokenCalibrationFile(file)
+			},
+		)
+	}
+	// 2000 estimated tokens, with the separator
+	state := NewPrompts(strings.Repeat("s", 999), []*Content{
+		{Role: RoleUser, Parts: []Part{Text(strings.Repeat("u", 1000))}},
+	})
+
+	newScope().Call(func(calibration *TokenCalibration) {
+		gen := calibration.Wrap(calibrationTestGenerator{reported: 3000})
+		if n, _ := gen.CountTokens("abcd"); n != 4 {
+			t.Fatalf("expected uncalibrated count, got %d", n)
+		}
+		if _, err := gen.Generate(t.Context(), state, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := gen.Generate(t.Context(), state, nil); err != nil {
+			t.Fatal(err)
+		}
+		// the ratio applied is fixed for the process
+		gen = calibration.Wrap(calibrationTestGenerator{reported: 3000})
+		if n, _ := gen.CountTokens("abcd"); n != 4 {
+			t.Fatalf("expected the ratio to be fixed, got %d", n)
+		}
+		// short requests are not recorded
+		if err := calibration.Record(gen.Spec(), 10, 1000); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// a new process applies the recorded ratio
+	processTokenCalibrations.Delete(file)
+	newScope().Call(func(calibration *TokenCalibration) {
+		if ratio := calibration.Ratio(calibrationTestGenerator{}.Spec()); ratio != 1.5 {
+			t.Fatalf("got ratio %v", ratio)
+		}
+		// the tokenizer is part of the key
+		if ratio := calibration.Ratio(Spec{Name: "calibrated"}); ratio != 1 {
+			t.Fatalf("got ratio %v", ratio)
+		}
+		gen := calibration.Wrap(calibrationTestGenerator{})
+		if n, _ := gen.CountTokens("abcd"); n != 6 {
+			t.Fatalf("expected calibrated count, got %d", n)
+		}
+		if err := calibration.Record(gen.Spec(), 2000, 100000); err != nil {
+			t.Fatal(err)
+		}
+		if entry := calibration.entries[calibrationKey(gen.Spec())]; entry.Samples != 3 || entry.Ratio != 1.5+calibrationWeight*(maxCalibrationRatio-1.5) {
+			t.Fatalf("got %+v", entry)
+		}
+	})
+}
+
+func TestEstimateCalibrationTokens(t *testing.T) {
+	count := func(text string) (int, error) {
+		return len(text), nil
+	}
+	state := WithFunctions(NewPrompts("sys", []*Content{
+		{Role: RoleUser, Parts: []Part{Text("user")}},
+	}), &Function{
+		Decl: FuncDecl{Name: "f"},
+	})
+	// the declarations are counted, with the rest in one count
+	calls := 0
+	n, ok := estimateCalibrationTokens(state, func(text string) (int, error) {
+		calls++
+		return count(text)
+	})
+	if !ok || n <= len("sys")+len("user") {
+		t.Fatalf("got %d, %v", n, ok)
+	}
+	if calls != 1 {
+		t.Fatalf("expected one count, got %d", calls)
+	}
+
+	// a file is not measured
+	withFile, err := state.AppendContent(&Content{
+		Role:  RoleUser,
+		Parts: []Part{FileContent{Content: []byte("x"), MimeType: "image/png"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := estimateCalibrationTokens(withFile, count); ok {
+		t.Fatal("expecting a state with a file not estimated")
+	}
+
+	// a call without usage is not counted
+	counted := false
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Call(func(calibration *TokenCalibration) {
+		gen := calibration.Wrap(countingTestGenerator{
+			counted: &counted,
+		})
+		if _, err := gen.Generate(t.Context(), state, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if counted {
+		t.Fatal("expecting no count without a usage")
+	}
+}
+
+// countingTestGenerator reports no usage and notes CountTokens calls.
+type countingTestGenerator struct {
+	counted *bool
+}
+
+func (g countingTestGenerator) Spec() Spec {
+	return Spec{Name: "counting"}
+}
+
+func (g countingTestGenerator) CountTokens(text string) (int, error) {
+	*g.counted = true
+	return len(text), nil
+}
+
+func (g countingTestGenerator) Generate(ctx context.Context, state State, options *GenerateOptions) (State, error) {
+	return state, nil
+}
+
+func TestTokenizers(t *testing.T) {
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Call(func(tokenizers Tokenizers) {
+		fallback := func(text string) (int, error) {
+			return -1, nil
+		}
+		if n, _ := tokenizers(Spec{}, fallback, nil)("hello world"); n != -1 {
+			t.Fatalf("expected the fallback, got %d", n)
+		}
+		if n, err := tokenizers(Spec{Tokenizer: "cl100k_base"}, fallback, nil)("hello world"); err != nil || n != 2 {
+			t.Fatalf("got %d, %v", n, err)
+		}
+		if n, _ := tokenizers(Spec{Tokenizer: "deepseek"}, fallback, nil)("hello 世界"); n != 3 {
+			t.Fatalf("got %d", n)
+		}
+		if _, err := tokenizers(Spec{Tokenizer: "foo"}, fallback, nil)("x"); err == nil {
+			t.Fatal("expected error")
+		}
+		// api counts are cached
+		calls := 0
+		api := func(text string) (int, error) {
+			calls++
+			return len(text), nil
+		}
+		spec := Spec{Name: "api", Tokenizer: "api"}
+		for range 2 {
+			if n, _ := tokenizers(spec, fallback, api)("abc"); n != 3 {
+				t.Fatalf("got %d", n)
+			}
+		}
+		if calls != 1 {
+			t.Fatalf("expected one api call, got %d", calls)
+		}
+		// without an endpoint the default counter is used
+		if n, _ := tokenizers(spec, fallback, nil)("abc"); n != -1 {
+			t.Fatalf("got %d", n)
+		}
+	})
+}
@@ -0,0 +1,395 @@
+package generators
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/reusee/tai/logs"
+)
+
+const TheoryOfCapabilities = `
+Models differ in what a request may contain: some accept no images, PDFs
+or audio, some have no function calling, no JSON schema output, no
+reasoning parameters, or no system role. Sending such content fails the
+request, or worse, is silently ignored. Spec.Capabilities declares what
+the model accepts, field by field: vision, pdf, audio, tools, json_schema,
+reasoning and system_prompt.
+
+A capability is resolved in order: the value set in the spec (merged
+field-wise from parent to child like Provider), then the built-in default
+of the model family, then supported. Family defaults are keyed by the
+longest matching prefix of the Family, or of the Model with any
+"models/" or vendor path prefix removed, so "deepseek-chat" and
+"openrouter/deepseek/deepseek-chat" share the deepseek defaults. Unknown
+families support everything, which is the behavior before capabilities
+existed, so only a model known or declared not to support something is
+adapted.
+
+Adaptation happens where requests are built, so callers keep producing
+the same State:
+
+- A model without tools resolves with DisableTools set (unless the spec
+  sets it explicitly), so GetGenerator callers that consult DisableTools
+  or NativeToolsEnabled fall back to heredoc blocks and no functions are
+  declared.
+- A model without json_schema reports SupportsResponseSchema false, so
+  GenerateJSON embeds the schema in the prompt; generators also ignore a
+  ResponseSchema passed directly.
+- stateToOpenAIMessages and partToGemini replace file parts the model
+  cannot read (images and unknown URLs need vision, application/pdf needs
+  pdf, audio/* needs audio) with a text placeholder naming the omitted
+  attachment, so the model knows something was there. Text MIME types are
+  always sent.
+- A model without a system role receives the system prompt as text at the
+  start of the first user turn.
+- A model without reasoning receives no reasoning effort or thinking
+  configuration.
+
+Each adaptation is logged once per spec and capability for the process,
+through the process-wide CapabilityWarnings, so a long session does not
+repeat the warning every round.
+`
+
+// Capability names a kind of request content a model may not accept. See
+// TheoryOfCapabilities.
+type Capability string
+
+const (
+	CapabilityVision       Capability = "vision"
+	CapabilityPDF          Capability = "pdf"
+	CapabilityAudio        Capability = "audio"
+	CapabilityTools        Capability = "tools"
+	CapabilityJSONSchema   Capability = "json_schema"
+	CapabilityReasoning    Capability = "reasoning"
+	CapabilitySystemPrompt Capability = "system_prompt"
+)
+
+// Capabilities declares what a model accepts. Unset fields fall back to
+// the family default. See TheoryOfCapabilities.
+type Capabilities struct {
+	Vision       *bool `json:"vision,omitempty"`
+	PDF          *bool `json:"pdf,omitempty"`
+	Audio        *bool `json:"audio,omitempty"`
+	Tools        *bool `json:"tools,omitempty"`
+	JSONSchema   *bool `json:"json_schema,omitempty"`
+	Reasoning    *bool `json:"reasoning,omitempty"`
+	SystemPrompt *bool `json:"system_prompt,omitempty"`
+}
+
+func (c *Capabilities) get(capability Capability) *bool {
+	if c == nil {
+		return nil
+	}
+	switch capability {
+	case CapabilityVision:
+		return c.Vision
+	case CapabilityPDF:
+		return c.PDF
+	case CapabilityAudio:
+		return c.Audio
+	case CapabilityTools:
+		return c.Tools
+	case CapabilityJSONSchema:
+		return c.JSONSchema
+	case CapabilityReasoning:
+		return c.Reasoning
+	case CapabilitySystemPrompt:
+		return c.SystemPrompt
+	}
+	return nil
+}
+
+// merge overlays other onto c, returning the merged result. Field-by-field
+// semantics: other's set fields win, c's unset fields survive.
+func (c *Capabilities) merge(other *Capabilities) *Capabilities {
+	if other == nil {
+		return c
+	}
+	if c == nil {
+		ret := *other
+		return &ret
+	}
+	ret := *c
+	if other.Vision != nil {
+		ret.Vision = other.Vision
+	}
+	if other.PDF != nil {
+		ret.PDF = other.PDF
+	}
+	if other.Audio != nil {
+		ret.Audio = other.Audio
+	}
+	if other.Tools != nil {
+		ret.Tools = other.Tools
+	}
+	if other.JSONSchema != nil {
+		ret.JSONSchema = other.JSONSchema
+	}
+	if other.Reasoning != nil {
+		ret.Reasoning = other.Reasoning
+	}
+	if other.SystemPrompt != nil {
+		ret.SystemPrompt = other.SystemPrompt
+	}
+	return &ret
+}
+
+// familyCapabilities holds the built-in defaults, keyed by family prefix.
+// Only capabilities known to be missing or present are set.
+var familyCapabilities = map[string]Capabilities{
+	"gemini": {
+		Vision:       new(true),
+		PDF:          new(true),
+		Audio:        new(true),
+		Tools:        new(true),
+		JSONSchema:   new(true),
+		Reasoning:    new(true),
+		SystemPrompt: new(true),
+	},
+	"gemma": {
+		Audio:        new(false),
+		PDF:          new(false),
+		Tools:        new(false),
+		JSONSchema:   new(false),
+		Reasoning:    new(false),
+		SystemPrompt: new(false),
+	},
+	"claude": {
+		Vision:       new(true),
+		PDF:          new(true),
+		Audio:        new(false),
+		Tools:        new(true),
+		JSONSchema:   new(true),
+		Reasoning:    new(true),
+		SystemPrompt: new(true),
+	},
+	"gpt-4o": {
+		Vision:    new(true),
+		PDF:       new(false),
+		Audio:     new(false),
+		Tools:     new(true),
+		Reasoning: new(false),
+	},
+	"gpt-4.1": {
+		Vision:    new(true),
+		PDF:       new(false),
+		Audio:     new(false),
+		Tools:     new(true),
+		Reasoning: new(false),
+	},
+	"gpt-5": {
+		Vision:    new(true),
+		PDF:       new(false),
+		Audio:     new(false),
+		Tools:     new(true),
+		Reasoning: new(true),
+	},
+	"o1": {
+		Vision:    new(true),
+		PDF:       new(false),
+		Audio:     new(false),
+		Reasoning: new(true),
+	},
+	"o1-mini": {
+		Vision:       new(false),
+		PDF:          new(false),
+		Audio:        new(false),
+		Tools:        new(false),
+		JSONSchema:   new(false),
+		Reasoning:    new(true),
+		SystemPrompt: new(false),
+	},
+	"o3": {
+		Vision:    new(true),
+		PDF:       new(false),
+		Audio:     new(false),
+		Tools:     new(true),
+		Reasoning: new(true),
+	},
+	"o4": {
+		Vision:    new(true),
+		PDF:       new(false),
+		Audio:     new(false),
+		Tools:     new(true),
+		Reasoning: new(true),
+	},
+	"deepseek": {
+		Vision:     new(false),
+		PDF:        new(false),
+		Audio:      new(false),
+		JSONSchema: new(false),
+	},
+	"deepseek-chat": {
+		Vision:     new(false),
+		PDF:        new(false),
+		Audio:      new(false),
+		JSONSchema: new(false),
+		Reasoning:  new(false),
+	},
+	"qwq": {
+		Vision: new(false),
+		PDF:    new(false),
+		Audio:  new(false),
+	},
+}
+
+// family returns the lower-cased name the family defaults are matched
+// against: the Family, or the Model without "models/" and vendor prefixes.
+func (s Spec) family() string {
+	name := s.Family
+	if name == "" {
+		name = s.Model
+		if i := strings.LastIndex(name, "/"); i >= 0 {
+			name = name[i+1:]
+		}
+	}
+	return strings.ToLower(name)
+}
+
+// familyDefault returns the built-in default for capability, or nil when
+// the family is unknown or does not set it. The longest matching prefix
+// wins.
+func (s Spec) familyDefault(capability Capability) *bool {
+	family := s.family()
+	if family == "" {
+		return nil
+	}
+	var ret *bool
+	matched := -1
+	for prefix, caps := range familyCapabilities {
+		if len(prefix) <= matched || !strings.HasPrefix(family, prefix) {
+			continue
+		}
+		matched = len(prefix)
+		ret = caps.get(capability)
+	}
+	return ret
+}
+
+// Supports reports whether the model of the spec accepts capability: the
+// spec's own value, then the family default, then true. See
+// TheoryOfCapabilities.
+func (s Spec) Supports(capability Capability) bool {
+	if v := s.Capabilities.get(capability); v != nil {
+		return *v
+	}
+	if v := s.familyDefault(capability); v != nil {
+		return *v
+	}
+	return true
+}
+
+// withCapabilities applies the capabilities that are expressed by other
+// spec fields: a model without tools resolves with DisableTools set,
+// unless the spec sets DisableTools explicitly.
+func (s Spec) withCapabilities() Spec {
+	if s.DisableTools == nil && !s.Supports(CapabilityTools) {
+		s.DisableTools = new(true)
+	}
+	return s
+}
+
+// CapabilityWarnings logs each adaptation once per spec and capability.
+// See TheoryOfCapabilities.
+type CapabilityWarnings struct {
+	warned sync.Map
+}
+
+var processCapabilityWarnings = new(CapabilityWarnings)
+
+// CapabilityWarnings provides the process-wide warning registry. Like
+// RateLimiters, it is package-level so generators built in reset scopes
+// share it.
+func (Module) CapabilityWarnings() *CapabilityWarnings {
+	return processCapabilityWarnings
+}
+
+// Warn logs that capability is missing and how the request was adapted,
+// unless it was already logged for the spec.
+func (w *CapabilityWarnings) Warn(ctx context.Context, logger logs.Logger, spec Spec, capability Capability, adaptation string) {
+	key := spec.rateLimitKey() + "\x00" + string(capability)
+	if _, loaded := w.warned.LoadOrStore(key, true); loaded {
+		return
+	}
+	logger.WarnContext(ctx, "model capability not supported, adapting request",
+		"name", spec.Name,
+		"model", spec.Model,
+		"capability", capability,
+		"adaptation", adaptation,
+	)
+}
+
+// capabilityAdapter adapts request content to the capabilities of a spec.
+// A nil adapter sends everything unchanged.
+type capabilityAdapter struct {
+	ctx      context.Context
+	spec     Spec
+	logger   logs.Logger
+	warnings *CapabilityWarnings
+}
+
+func (a *capabilityAdapter) supports(capability Capability) bool {
+	return a == nil || a.spec.Supports(capability)
+}
+
+func (a *capabilityAdapter) warn(capability Capability, adaptation string) {
+	if a == nil || a.warnings == nil || a.logger.Logger == nil {
+		return
+	}
+	a.warnings.Warn(a.ctx, a.logger, a.spec, capability, adaptation)
+}
+
+// systemPromptInUserTurn reports whether the system prompt must be sent as
+// user text, warning when it is.
+func (a *capabilityAdapter) systemPromptInUserTurn(systemPrompt string) bool {
+	if systemPrompt == "" || a.supports(CapabilitySystemPrompt) {
+		return false
+	}
+	a.warn(CapabilitySystemPrompt, "system prompt moved into the first user turn")
+	return true
+}
+
+// part returns part as the model can accept it: file parts needing a
+// missing capability become a text placeholder.
+func (a *capabilityAdapter) part(part Part) Part {
+	var mimeType, name string
+	switch p := part.(type) {
+	case FileContent:
+		if isTextMIMEType(p.MimeType) {
+			return part
+		}
+		mimeType = p.MimeType
+		name = p.MimeType
+	case FileURL:
+		if len(p) == 0 {
+			return part
+		}
+		mimeType = mime.TypeByExtension(path.Ext(string(p)))
+		name = string(p)
+	default:
+		return part
+	}
+	capability := fileCapability(mimeType)
+	if a.supports(capability) {
+		return part
+	}
+	a.warn(capability, "attachments replaced by text placeholders")
+	return Text(fmt.Sprintf("[attachment omitted: %s (the model does not accept %s input)]\n", name, capability))
+}
+
+// fileCapability returns the capability needed to read a file of
+// mimeType. Unknown types are treated as images.
+func fileCapability(mimeType string) Capability {
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	switch {
+	case mimeType == "application/pdf":
+		return CapabilityPDF
+	case strings.HasPrefix(mimeType, "audio/"):
+		return CapabilityAudio
+	}
+	return CapabilityVision
+}
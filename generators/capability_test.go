@@ -0,0 +1,183 @@
+package generators
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/reusee/tai/logs"
+)
+
+func TestSpecSupports(t *testing.T) {
+	// unknown families support everything
+	if !(Spec{Model: "my-local-model"}).Supports(CapabilityVision) {
+		t.Fatal("expected unknown model to support vision")
+	}
+	// family defaults, matched on the model without vendor prefixes
+	spec := Spec{Model: "deepseek/deepseek-chat"}
+	if spec.Supports(CapabilityVision) || spec.Supports(CapabilityReasoning) {
+		t.Fatal("expected deepseek-chat defaults")
+	}
+	if !spec.Supports(CapabilityTools) {
+		t.Fatal("expected deepseek-chat to support tools")
+	}
+	// longest prefix wins
+	if !(Spec{Model: "deepseek-reasoner"}).Supports(CapabilityReasoning) {
+		t.Fatal("expected deepseek-reasoner to support reasoning")
+	}
+	if (Spec{Family: "o1-mini"}).Supports(CapabilitySystemPrompt) {
+		t.Fatal("expected o1-mini without system prompt")
+	}
+	// explicit values override family defaults
+	spec.Capabilities = &Capabilities{
+		Vision: new(true),
+	}
+	if !spec.Supports(CapabilityVision) {
+		t.Fatal("expected explicit vision")
+	}
+	// and json_schema gates response schemas
+	if (Spec{Type: "openai", Model: "deepseek-chat"}).SupportsResponseSchema() {
+		t.Fatal("expected no response schema for deepseek")
+	}
+}
+
+func TestResolveSpecCapabilities(t *testing.T) {
+	specs := []Spec{
+		{
+			Name:  "local",
+			Type:  "openai",
+			Model: "gemma-3",
+			Capabilities: &Capabilities{
+				Vision: new(false),
+				Audio:  new(true),
+			},
+			Variants: []Spec{
+				{
+					Name: "vision",
+					Capabilities: &Capabilities{
+						Vision: new(true),
+					},
+				},
+				{
+					Name:         "tools",
+					DisableTools: new(false),
+				},
+			},
+		},
+	}
+
+	spec, err := resolveSpec("local/vision", specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !spec.Supports(CapabilityVision) || !spec.Supports(CapabilityAudio) {
+		t.Fatalf("expected merged capabilities, got %+v", spec.Capabilities)
+	}
+	// gemma has no tools, so the resolved spec disables them
+	if spec.DisableTools == nil || !*spec.DisableTools {
+		t.Fatal("expected tools to be disabled")
+	}
+
+	spec, err = resolveSpec("local/tools", specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Supports(CapabilityVision) {
+		t.Fatal("expected parent capabilities")
+	}
+	// an explicit DisableTools wins
+	if *spec.DisableTools {
+		t.Fatal("expected explicit disable_tools to be kept")
+	}
+}
+
+func newTestCapabilityAdapter(spec Spec) (*capabilityAdapter, *bytes.Buffer) {
+	buf := new(bytes.Buffer)
+	return &capabilityAdapter{
+		ctx:  context.Background(),
+		spec: spec,
+		logger: logs.Logger{
+			Logger: slog.New(slog.NewTextHandler(buf, nil)),
+		},
+		warnings: new(CapabilityWarnings),
+	}, buf
+}
+
+func TestStateToOpenAIMessagesAdaptsCapabilities(t *testing.T) {
+	adapter, logged := newTestCapabilityAdapter(Spec{
+		Name:  "plain",
+		Model: "plain-model",
+		Capabilities: &Capabilities{
+			Vision:       new(false),
+			SystemPrompt: new(false),
+		},
+	})
+	state := NewPrompts("be brief", []*Content{
+		{
+			Role: RoleUser,
+			Parts: []Part{
+				Text("look at "),
+				FileContent{MimeType: "image/png", Content: []byte("png")},
+				FileURL("https://example.com/b.jpg"),
+				FileContent{MimeType: "text/plain", Content: []byte(" and this")},
+			},
+		},
+	})
+
+	messages, err := stateToOpenAIMessages(state, false, false, adapter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].Role != string(RoleUser) {
+		t.Fatalf("expected a single user message, got %+v", messages)
+	}
+	text, ok := messages[0].Content.(string)
+	if !ok {
+		t.Fatalf("expected text content, got %+v", messages[0].Content)
+	}
+	if !strings.HasPrefix(text, "be brief\n\nlook at ") {
+		t.Fatalf("expected system prompt in the user turn: %q", text)
+	}
+	for _, want := range []string{
+		"[attachment omitted: image/png",
+		"[attachment omitted: https://example.com/b.jpg",
+		" and this",
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("missing %q: %q", want, text)
+		}
+	}
+
+	// each capability is warned about once
+	if _, err := stateToOpenAIMessages(state, false, false, adapter); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(logged.String(), "capability=vision"); n != 1 {
+		t.Fatalf("expected one vision warning, got %d:\n%s", n, logged)
+	}
+	if n := strings.Count(logged.String(), "capability=system_prompt"); n != 1 {
+		t.Fatalf("expected one system prompt warning, got %d:\n%s", n, logged)
+	}
+}
+
+func TestPartToGeminiAdaptsCapabilities(t *testing.T) {
+	adapter, _ := newTestCapabilityAdapter(Spec{
+		Model: "models/gemma-3-27b-it",
+	})
+	part := partToGemini(FileContent{MimeType: "application/pdf", Content: []byte("%PDF")}, adapter)
+	if part == nil || part.InlineData != nil || !strings.Contains(part.Text, "application/pdf") {
+		t.Fatalf("expected a text placeholder, got %+v", part)
+	}
+	// images are kept, gemma reads them
+	part = partToGemini(FileContent{MimeType: "image/png", Content: []byte("png")}, adapter)
+	if part == nil || part.InlineData == nil {
+		t.Fatalf("expected inline data, got %+v", part)
+	}
+	// a nil adapter sends everything
+	part = partToGemini(FileContent{MimeType: "application/pdf", Content: []byte("%PDF")}, nil)
+	if part == nil || part.InlineData == nil {
+		t.Fatalf("expected inline data, got %+v", part)
+	}
+}
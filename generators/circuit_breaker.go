@@ -0,0 +1,229 @@
+package generators
+
+import (
+	"context"
+	"iter"
+	"strings"
+	"sync"
+	"time"
+)
+
+const TheoryOfCircuitBreaker = `
+RandomRedirect spreads load across endpoints, but a uniform pick keeps
+sending a share of the requests to an endpoint that is rate limiting or
+down, and each of those requests pays Retrier's backoff before failing.
+The circuit breaker tracks the health of each resolved spec path for the
+whole process and removes unhealthy endpoints from RandomRedirect selection
+for a while.
+
+GetGenerator wraps every generator it resolves with CircuitBreaker.Monitor,
+which reports each Generate outcome under the resolved spec path, or the
+requested name for the built-in shortcuts. A failure is a persistent provider error, classified
+like fallback errors (exhausted retries, 429, 5xx, quota, unknown model;
+see TheoryOfFallback), or a success whose first content arrived later than
+circuitSlowFirstContent — time to first content is measured instead of
+total duration because generation length varies with the output, while a
+long silence before the first token means a congested endpoint. Any other
+outcome, including invalid requests, leaves the circuit alone, and a timely
+success closes it.
+
+After circuitFailureThreshold consecutive failures the circuit opens for a
+cooldown. When the cooldown ends the path becomes selectable again
+(half-open): one more failure reopens it immediately with a doubled
+cooldown, capped at circuitMaxCooldown, and a success closes it.
+
+RandomRedirect selection skips targets whose path, or any path below it,
+has an open circuit, because a relative target usually resolves to a
+descendant path through further redirects. If every target is open the
+pick falls back to all targets, so health never makes resolution fail.
+Only the pick is affected: an explicitly requested spec is always
+resolved, and the Fallback chain handles its failures. Long sessions
+resolve the generator again on every goal iteration, so they move away
+from an unhealthy endpoint at the next iteration.
+`
+
+const (
+	circuitFailureThreshold = 2
+	circuitBaseCooldown     = time.Minute
+	circuitMaxCooldown      = 10 * time.Minute
+	circuitSlowFirstContent = 2 * time.Minute
+)
+
+// CircuitBreaker tracks the health of resolved spec paths. See
+// TheoryOfCircuitBreaker.
+type CircuitBreaker struct {
+	mu       sync.Mutex
+	circuits map[string]*circuit
+	now      func() time.Time
+}
+
+type circuit struct {
+	failures  int
+	cooldown  time.Duration // non-zero once opened, until a success
+	openUntil time.Time
+}
+
+// CircuitBreaker provides the process-wide breaker shared by every
+// generator resolved in the scope.
+func (Module) CircuitBreaker() *CircuitBreaker {
+	return newCircuitBreaker()
+}
+
+func newCircuitBreaker() *CircuitBreaker {
+	return &CircuitBreaker{
+		circuits: make(map[string]*circuit),
+		now:      time.Now,
+	}
+}
+
+// Available reports whether neither path nor any path below it has an
+// open circuit.
+func (c *CircuitBreaker) Available(path string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for p, circ := range c.circuits {
+		if p != path && !strings.HasPrefix(p, path+"/") {
+			continue
+		}
+		if now.Before(circ.openUntil) {
+			return false
+		}
+	}
+	return true
+}
+
+// Success closes the circuit of path.
+func (c *CircuitBreaker) Success(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.circuits, path)
+}
+
+// Failure records a failure of path, opening its circuit after
+// circuitFailureThreshold consecutive failures, or immediately when the
+// circuit was opened before and has not closed since.
+func (c *CircuitBreaker) Failure(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	circ, ok := c.circuits[path]
+	if !ok {
+		circ = new(circuit)
+		c.circuits[path] = circ
+	}
+	circ.failures++
+	if circ.cooldown == 0 && circ.failures < circuitFailureThreshold {
+		return
+	}
+	if circ.cooldown == 0 {
+		circ.cooldown = circuitBaseCooldown
+	} else {
+		circ.cooldown = min(circ.cooldown*2, circuitMaxCooldown)
+	}
+	circ.failures = 0
+	circ.openUntil = c.now().Add(circ.cooldown)
+}
+
+// Monitor wraps gen so its Generate outcomes are recorded under its spec
+// name.
+func (c *CircuitBreaker) Monitor(gen Generator) Generator {
+	return monitoredGenerator{
+		Generator: gen,
+		breaker:   c,
+	}
+}
+
+type monitoredGenerator struct {
+	Generator
+	breaker *CircuitBreaker
+}
+
+func (m monitoredGenerator) Generate(ctx context.Context, state State, options *GenerateOptions) (State, error) {
+	observed := &firstContentState{
+		upstream: state,
+		first: &firstContent{
+			start: m.breaker.now(),
+			now:   m.breaker.now,
+		},
+	}
+	ret, err := m.Generator.Generate(ctx, observed, options)
+	if w, ok := ret.(*firstContentState); ok {
+		ret = w.upstream
+	}
+
+	path := m.Spec().Name
+	switch {
+	case err != nil:
+		if isFallbackError(ctx, err) {
+			m.breaker.Failure(path)
+		}
+	case observed.first.latency() > circuitSlowFirstContent:
+		m.breaker.Failure(path)
+	default:
+		m.breaker.Success(path)
+	}
+	return ret, err
+}
+
+// firstContentState wraps the State passed to a generator to note when
+// the first content is appended. The wrapper is removed from the returned
+// State.
+type firstContentState struct {
+	upstream State
+	first    *firstContent // shared by all states derived from the wrapper
+}
+
+var _ State = new(firstContentState)
+
+type firstContent struct {
+	once  sync.Once
+	start time.Time
+	at    time.Time
+	now   func() time.Time
+}
+
+func (f *firstContent) latency() time.Duration {
+	if f.at.IsZero() {
+		return 0
+	}
+	return f.at.Sub(f.start)
+}
+
+func (f *firstContentState) Contents() iter.Seq[*Content] {
+	return f.upstream.Contents()
+}
+
+func (f *firstContentState) AppendContent(content *Content) (State, error) {
+	ret := *f
+	var err error
+	ret.upstream, err = f.upstream.AppendContent(content)
+	if err != nil {
+		return &ret, err
+	}
+	f.first.once.Do(func() {
+		f.first.at = f.first.now()
+	})
+	return &ret, nil
+}
+
+func (f *firstContentState) SystemPrompt() string {
+	return f.upstream.SystemPrompt()
+}
+
+func (f *firstContentState) Functions() iter.Seq[*Function] {
+	return f.upstream.Functions()
+}
+
+func (f *firstContentState) Flush() (State, error) {
+	ret := *f
+	var err error
+	ret.upstream, err = f.upstream.Flush()
+	if err != nil {
+		return &ret, err
+	}
+	return &ret, nil
+}
+
+func (f *firstContentState) Unwrap() State {
+	return f.upstream
+}
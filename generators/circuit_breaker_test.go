@@ -0,0 +1,167 @@
+package generators
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type breakerTestGenerator struct {
+	name  string
+	err   error
+	delay time.Duration
+	clock *time.Time
+}
+
+func (g breakerTestGenerator) Spec() Spec {
+	return Spec{Name: g.name}
+}
+
+func (g breakerTestGenerator) CountTokens(text string) (int, error) {
+	return len(text), nil
+}
+
+func (g breakerTestGenerator) Generate(ctx context.Context, state State, options *GenerateOptions) (State, error) {
+	if g.err != nil {
+		return state, g.err
+	}
+	*g.clock = g.clock.Add(g.delay)
+	return state.AppendContent(&Content{
+		Role:  RoleModel,
+		Parts: []Part{Text("ok")},
+	})
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	clock := time.Unix(0, 0)
+	breaker := newCircuitBreaker()
+	breaker.now = func() time.Time {
+		return clock
+	}
+
+	breaker.Failure("a/b")
+	if !breaker.Available("a") {
+		t.Fatal("one failure must not open the circuit")
+	}
+	breaker.Failure("a/b")
+	if breaker.Available("a/b") || breaker.Available("a") {
+		t.Fatal("circuit must open after consecutive failures, including for parent paths")
+	}
+	if !breaker.Available("a/bc") {
+		t.Fatal("sibling path with a common name prefix must stay available")
+	}
+
+	// half-open after the cooldown, reopened by a single failure with a
+	// doubled cooldown
+	clock = clock.Add(circuitBaseCooldown)
+	if !breaker.Available("a/b") {
+		t.Fatal("circuit must be half-open after the cooldown")
+	}
+	breaker.Failure("a/b")
+	clock = clock.Add(circuitBaseCooldown)
+	if breaker.Available("a/b") {
+		t.Fatal("reopened circuit must use a doubled cooldown")
+	}
+	clock = clock.Add(circuitBaseCooldown)
+	breaker.Success("a/b")
+	breaker.Failure("a/b")
+	if !breaker.Available("a/b") {
+		t.Fatal("success must close the circuit")
+	}
+}
+
+func TestCircuitBreakerMonitor(t *testing.T) {
+	clock := time.Unix(0, 0)
+	breaker := newCircuitBreaker()
+	breaker.now = func() time.Time {
+		return clock
+	}
+	state := NewPrompts("", nil)
+
+	failing := breaker.Monitor(breakerTestGenerator{
+		name: "failing",
+		err:  &StatusError{StatusCode: 503},
+	})
+	invalid := breaker.Monitor(breakerTestGenerator{
+		name: "invalid",
+		err:  &StatusError{StatusCode: 400},
+	})
+	slow := breaker.Monitor(breakerTestGenerator{
+		name:  "slow",
+		delay: circuitSlowFirstContent + time.Second,
+		clock: &clock,
+	})
+	fast := breaker.Monitor(breakerTestGenerator{
+		name:  "fast",
+		clock: &clock,
+	})
+	for range circuitFailureThreshold {
+		failing.Generate(context.Background(), state, nil)
+		invalid.Generate(context.Background(), state, nil)
+		ret, err := fast.Generate(context.Background(), state, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := ret.(Prompts); !ok {
+			t.Fatalf("monitor wrapper must be removed from the returned state, got %T", ret)
+		}
+	}
+	if breaker.Available("failing") {
+		t.Fatal("server errors must open the circuit")
+	}
+	if !breaker.Available("invalid") {
+		t.Fatal("invalid requests must not open the circuit")
+	}
+	if !breaker.Available("fast") {
+		t.Fatal("timely successes must keep the circuit closed")
+	}
+
+	for range circuitFailureThreshold {
+		if _, err := slow.Generate(context.Background(), state, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if breaker.Available("slow") {
+		t.Fatal("slow first content must open the circuit")
+	}
+}
+
+func TestPickRandomRedirect(t *testing.T) {
+	t.Run("weights", func(t *testing.T) {
+		counts := make(map[string]int)
+		for range 2000 {
+			counts[pickRandomRedirect("base", []string{"a", "/b", "c"}, map[string]float64{
+				"a": 3,
+				"c": 0,
+			}, nil)]++
+		}
+		if counts["base/c"] != 0 {
+			t.Fatalf("zero weight target must never be picked, got %v", counts)
+		}
+		if counts["base/a"] < 2*counts["b"] {
+			t.Fatalf("heavier target must be picked more often, got %v", counts)
+		}
+	})
+
+	t.Run("open circuits are skipped", func(t *testing.T) {
+		breaker := newCircuitBreaker()
+		for range circuitFailureThreshold {
+			breaker.Failure("base/a/model")
+		}
+		for range 100 {
+			if got := pickRandomRedirect("base", []string{"a", "b"}, nil, breaker); got != "base/b" {
+				t.Fatalf("got %s", got)
+			}
+		}
+	})
+
+	t.Run("all open", func(t *testing.T) {
+		breaker := newCircuitBreaker()
+		for range circuitFailureThreshold {
+			breaker.Failure("base/a")
+		}
+		if got := pickRandomRedirect("base", []string{"a"}, nil, breaker); got != "base/a" {
+			t.Fatalf("got %s", got)
+		}
+	})
+}
@@ -43,6 +43,23 @@ func (d DebugOpenAI) HandleConfig(path string, values []*cue.Value) (any, error)
 	return &ret, nil
 }
 
+// DebugAnthropic configs.Config implementation.
+
+var _ configs.Config = DebugAnthropic(false)
+
+func (d DebugAnthropic) ConfigPaths() []string {
+	return []string{"debug_anthropic"}
+}
+
+func (d DebugAnthropic) HandleConfig(path string, values []*cue.Value) (any, error) {
+	var b bool
+	if err := values[0].Decode(&b); err != nil {
+		return nil, err
+	}
+	ret := DebugAnthropic(b)
+	return &ret, nil
+}
+
 // TapOpenAI configs.Config implementation.
 
 var _ configs.Config = TapOpenAI(false)
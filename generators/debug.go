@@ -44,6 +44,25 @@ func (d DebugOpenAI) Keys() map[string]string {
 	}
 }
 
+type DebugAnthropic bool
+
+func (Module) DebugAnthropic() DebugAnthropic {
+	return false
+}
+
+var _ flags.Flag = DebugAnthropic(false)
+
+func (d DebugAnthropic) Handle(key string, args []string) (newDef any, remainArgs []string, err error) {
+	ret := DebugAnthropic(true)
+	return &ret, args, nil
+}
+
+func (d DebugAnthropic) Keys() map[string]string {
+	return map[string]string{
+		"-debug-anthropic": "Enable debug logging for the Anthropic generator",
+	}
+}
+
 type TapOpenAI bool
 
 func (Module) TapOpenAI() TapOpenAI {
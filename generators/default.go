@@ -33,6 +33,21 @@ func (Module) ModelFamily(
 	return ModelFamily(generator.Spec().Family)
 }
 
+// NativeTools reports whether the resolved default generator enables
+// native tools, selecting the tool-mode component prompts. See
+// components.TheoryOfComponentTools.
+type NativeTools bool
+
+func (Module) NativeTools(
+	getDefaultGenerator GetDefaultGenerator,
+) NativeTools {
+	generator, err := getDefaultGenerator()
+	if err != nil {
+		return false
+	}
+	return NativeTools(generator.Spec().NativeToolsEnabled())
+}
+
 func (Module) GetDefaultFastModel(
 	name flags.FastModelName,
 	get GetGenerator,
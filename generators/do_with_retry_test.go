@@ -41,6 +41,9 @@ func TestRetrierExhaustionStripsErrRetryable(t *testing.T) {
 	if !strings.Contains(err.Error(), "retry exhausted") {
 		t.Fatalf("expected 'retry exhausted' in error message, got: %v", err)
 	}
+	if !errors.Is(err, ErrRetryExhausted) {
+		t.Fatal("exhaustion must wrap ErrRetryExhausted so generator fallback can detect it")
+	}
 	if result != 0 {
 		t.Fatalf("expected zero result, got %d", result)
 	}
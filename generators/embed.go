@@ -0,0 +1,452 @@
+package generators
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"cuelang.org/go/cue"
+	"github.com/reusee/tai/configs"
+	"github.com/reusee/tai/flags"
+	"github.com/reusee/tai/logs"
+	"google.golang.org/genai"
+)
+
+const TheoryOfEmbedding = `
+An Embedder maps texts to vectors whose cosine similarity approximates the
+relatedness of the texts. It is used to rank context by relevance to the
+chat input (see gotools.TheoryOfSemanticRelevance), which import distance
+and change counts cannot see.
+
+The embedding model is selected by -embedding-model or embedding_model,
+naming a spec of the generator tree like -model does; its type selects the
+implementation and its model the embedding model. Gemini specs embed with
+the Gemini embedContent API; OpenAI-compatible specs (openai, ollama,
+open-router, aliyun, zhipu, huoshan, vercel, nvidia) post to the
+/embeddings endpoint of their base URL with their API key. The name "hash"
+needs no spec: it selects HashEmbedder, a deterministic local embedder
+based on feature hashing of words, for tests and offline use. Without an
+embedding model GetEmbedder returns nil and nothing is embedded.
+Confidential mode applies as for generators: the texts leave the machine.
+
+Embeddings are requested in batches of at most maxEmbedBatch texts. Every
+embedder GetEmbedder returns is wrapped by CachedEmbedder, an on-disk cache
+keyed by the SHA-256 of the embedding model and the text, so unchanged
+texts are embedded once, and repeated runs over identical inputs see
+identical vectors even when a provider's output jitters. Entries do not
+expire: a vector is a pure function of its key. Cache errors are misses
+and failed stores are logged.
+`
+
+// Embedder maps texts to embedding vectors. See TheoryOfEmbedding.
+type Embedder interface {
+	// EmbeddingModel identifies the vectors the embedder produces; vectors
+	// of different models are not comparable.
+	EmbeddingModel() string
+	// Embed returns one vector for each text, in order.
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// maxEmbedBatch bounds the texts of one embedding request.
+const maxEmbedBatch = 100
+
+// embedBatches calls fn for consecutive batches of texts and concatenates
+// the vectors.
+func embedBatches(texts []string, fn func([]string) ([][]float32, error)) ([][]float32, error) {
+	ret := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += maxEmbedBatch {
+		batch := texts[start:min(start+maxEmbedBatch, len(texts))]
+		vectors, err := fn(batch)
+		if err != nil {
+			return nil, err
+		}
+		if len(vectors) != len(batch) {
+			return nil, fmt.Errorf("embedding: expected %d vectors, got %d", len(batch), len(vectors))
+		}
+		ret = append(ret, vectors...)
+	}
+	return ret, nil
+}
+
+var _ Embedder = Gemini{}
+
+func (g Gemini) EmbeddingModel() string {
+	return "gemini/" + g.spec.Model
+}
+
+func (g Gemini) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	client, err := g.GetClient()(ctx, g.spec.APIKey)
+	if err != nil {
+		return nil, err
+	}
+	return embedBatches(texts, func(batch []string) ([][]float32, error) {
+		contents := make([]*genai.Content, 0, len(batch))
+		for _, text := range batch {
+			contents = append(contents, genai.NewContentFromText(text, genai.RoleUser))
+		}
+		g.recordEvent("api_call", fmt.Sprintf("gemini embed content: model=%s texts=%d", g.spec.Model, len(batch)))
+		resp, err := client.Models.EmbedContent(ctx, g.spec.Model, contents, nil)
+		if err != nil {
+			g.recordEvent("api_error", fmt.Sprintf("gemini embed content failed: %v", err))
+			return nil, err
+		}
+		ret := make([][]float32, 0, len(resp.Embeddings))
+		for _, embedding := range resp.Embeddings {
+			if embedding == nil {
+				return nil, fmt.Errorf("gemini embedding: missing vector")
+			}
+			ret = append(ret, embedding.Values)
+		}
+		return ret, nil
+	})
+}
+
+var _ Embedder = new(OpenAI)
+
+func (o *OpenAI) EmbeddingModel() string {
+	return strings.TrimSuffix(o.spec.BaseURL, "/") + "/" + o.spec.Model
+}
+
+func (o *OpenAI) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	return embedBatches(texts, func(batch []string) ([][]float32, error) {
+		body, err := json.Marshal(map[string]any{
+			"model": o.spec.Model,
+			"input": batch,
+		})
+		if err != nil {
+			return nil, err
+		}
+		url := strings.TrimSuffix(o.spec.BaseURL, "/") + "/embeddings"
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		if o.apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+o.apiKey)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		o.recordEvent("api_call", fmt.Sprintf("openai-compatible embeddings: model=%s texts=%d", o.spec.Model, len(batch)))
+		resp, err := o.client.Do(req)
+		if err != nil {
+			o.recordEvent("api_error", fmt.Sprintf("openai embeddings request failed: %v", err))
+			return nil, err
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			o.recordEvent("api_error", fmt.Sprintf("openai embeddings http status %d", resp.StatusCode))
+			return nil, &StatusError{StatusCode: resp.StatusCode, Body: string(data)}
+		}
+		var result struct {
+			Data []struct {
+				Index     int       `json:"index"`
+				Embedding []float32 `json:"embedding"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(data, &result); err != nil {
+			return nil, err
+		}
+		ret := make([][]float32, len(batch))
+		for _, item := range result.Data {
+			if item.Index < 0 || item.Index >= len(ret) {
+				return nil, fmt.Errorf("openai embeddings: index %d out of range", item.Index)
+			}
+			ret[item.Index] = item.Embedding
+		}
+		for i, vector := range ret {
+			if vector == nil {
+				return nil, fmt.Errorf("openai embeddings: missing vector %d", i)
+			}
+		}
+		return ret, nil
+	})
+}
+
+// HashEmbedder is a deterministic local embedder: every lowercased word of
+// a text adds a signed unit to the dimension its hash selects, and the sum
+// is normalized. Texts sharing words are similar. See TheoryOfEmbedding.
+type HashEmbedder struct {
+	Dimensions int
+}
+
+var _ Embedder = HashEmbedder{}
+
+func (h HashEmbedder) EmbeddingModel() string {
+	return fmt.Sprintf("hash/%d", h.Dimensions)
+}
+
+func (h HashEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	ret := make([][]float32, 0, len(texts))
+	for _, text := range texts {
+		vector := make([]float32, h.Dimensions)
+		words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, word := range words {
+			hash := fnv.New64a()
+			hash.Write([]byte(word))
+			sum := hash.Sum64()
+			sign := float32(1)
+			if sum&(1<<63) != 0 {
+				sign = -1
+			}
+			vector[sum%uint64(h.Dimensions)] += sign
+		}
+		var norm float64
+		for _, v := range vector {
+			norm += float64(v) * float64(v)
+		}
+		if norm > 0 {
+			norm = math.Sqrt(norm)
+			for i := range vector {
+				vector[i] = float32(float64(vector[i]) / norm)
+			}
+		}
+		ret = append(ret, vector)
+	}
+	return ret, nil
+}
+
+// CosineSimilarity returns the cosine of the angle between a and b, or zero
+// when either is zero or their lengths differ.
+func CosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
+
+// CachedEmbedder serves vectors of an Embedder from an on-disk cache. See
+// TheoryOfEmbedding.
+type CachedEmbedder struct {
+	upstream Embedder
+	dir      string
+	logger   logs.Logger
+}
+
+var _ Embedder = new(CachedEmbedder)
+
+// NewCachedEmbedder returns upstream with vectors cached under dir.
+func NewCachedEmbedder(upstream Embedder, dir string, logger logs.Logger) *CachedEmbedder {
+	return &CachedEmbedder{
+		upstream: upstream,
+		dir:      dir,
+		logger:   logger,
+	}
+}
+
+func (c *CachedEmbedder) EmbeddingModel() string {
+	return c.upstream.EmbeddingModel()
+}
+
+func (c *CachedEmbedder) path(text string) string {
+	sum := sha256.Sum256([]byte(c.upstream.EmbeddingModel() + "\x00" + text))
+	key := hex.EncodeToString(sum[:])
+	return filepath.Join(c.dir, key[:2], key+".json")
+}
+
+func (c *CachedEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	ret := make([][]float32, len(texts))
+	var missing []int
+	var missingTexts []string
+	for i, text := range texts {
+		data, err := os.ReadFile(c.path(text))
+		if err == nil {
+			var vector []float32
+			if err := json.Unmarshal(data, &vector); err == nil && len(vector) > 0 {
+				ret[i] = vector
+				continue
+			}
+		}
+		missing = append(missing, i)
+		missingTexts = append(missingTexts, text)
+	}
+	if len(missing) == 0 {
+		return ret, nil
+	}
+
+	vectors, err := c.upstream.Embed(ctx, missingTexts)
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) != len(missing) {
+		return nil, fmt.Errorf("embedding: expected %d vectors, got %d", len(missing), len(vectors))
+	}
+	for j, i := range missing {
+		ret[i] = vectors[j]
+		if err := c.put(texts[i], vectors[j]); err != nil {
+			c.logger.WarnContext(ctx, "embedding cache store", "error", err)
+		}
+	}
+	return ret, nil
+}
+
+func (c *CachedEmbedder) put(text string, vector []float32) error {
+	data, err := json.Marshal(vector)
+	if err != nil {
+		return err
+	}
+	path := c.path(text)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// hashEmbeddingDimensions is the vector size of the "hash" embedding model.
+const hashEmbeddingDimensions = 256
+
+// GetEmbedder returns the embedder of the configured embedding model, or
+// nil when none is configured. See TheoryOfEmbedding.
+type GetEmbedder func() (Embedder, error)
+
+func (Module) GetEmbedder(
+	name EmbeddingModel,
+	getSpecs GetGeneratorSpecs,
+	cacheDir EmbeddingCacheDir,
+	confidential ConfidentialMode,
+	logger logs.Logger,
+	newGemini NewGemini,
+	newOpenAI NewOpenAI,
+	newOpenRouter NewOpenRouter,
+	newAliyun NewAliyun,
+	newZhipu NewZhipu,
+	newHuoshan NewHuoshan,
+	newVercel NewVercel,
+	newNvidia NewNvidia,
+) GetEmbedder {
+	return func() (Embedder, error) {
+		if name == "" {
+			return nil, nil
+		}
+
+		var embedder Embedder
+		if name == "hash" {
+			embedder = HashEmbedder{
+				Dimensions: hashEmbeddingDimensions,
+			}
+		} else {
+			specs, err := getSpecs()
+			if err != nil {
+				return nil, err
+			}
+			spec, err := resolveSpec(string(name), specs)
+			if err != nil {
+				return nil, err
+			}
+			if err := confidential.check(spec, string(name)); err != nil {
+				return nil, err
+			}
+			switch strings.ToLower(spec.Type) {
+			case "gemini":
+				embedder = newGemini(spec)
+			case "openai", "open-ai", "open_ai":
+				embedder = newOpenAI(spec, spec.APIKey)
+			case "ollama":
+				if spec.BaseURL == "" {
+					spec.BaseURL = "http://127.0.0.1:11434/v1"
+				}
+				embedder = newOpenAI(spec, "")
+			case "open-router", "open_router", "openrouter":
+				embedder = newOpenRouter(spec)
+			case "aliyun":
+				embedder = newAliyun(spec)
+			case "zhipu":
+				embedder = newZhipu(spec)
+			case "huoshan":
+				embedder = newHuoshan(spec)
+			case "vercel":
+				embedder = newVercel(spec)
+			case "nvidia":
+				embedder = newNvidia(spec)
+			default:
+				return nil, fmt.Errorf("generator type %q does not support embeddings", spec.Type)
+			}
+		}
+
+		dir := string(cacheDir)
+		if dir == "" {
+			userCacheDir, err := os.UserCacheDir()
+			if err != nil {
+				logger.Warn("embedding cache disabled", "error", err)
+				return embedder, nil
+			}
+			dir = filepath.Join(userCacheDir, "tai", "embeddings")
+		}
+		return NewCachedEmbedder(embedder, dir, logger), nil
+	}
+}
+
+// EmbeddingModel names the spec of the embedding model. Empty disables
+// embeddings. See TheoryOfEmbedding.
+type EmbeddingModel string
+
+func (Module) EmbeddingModel() EmbeddingModel {
+	return ""
+}
+
+var _ flags.Flag = EmbeddingModel("")
+
+func (e EmbeddingModel) Handle(key string, args []string) (newDef any, remainArgs []string, err error) {
+	if len(args) == 0 {
+		return nil, nil, fmt.Errorf("expecting string argument, got empty")
+	}
+	ret := EmbeddingModel(args[0])
+	return &ret, args[1:], nil
+}
+
+func (e EmbeddingModel) Keys() map[string]string {
+	return map[string]string{
+		"-embedding-model": "Set the embedding model used to rank context packages by relevance",
+	}
+}
+
+var _ configs.Config = EmbeddingModel("")
+
+func (e EmbeddingModel) ConfigPaths() []string {
+	return []string{"embedding_model"}
+}
+
+func (e EmbeddingModel) HandleConfig(path string, values []*cue.Value) (any, error) {
+	var s string
+	if err := values[0].Decode(&s); err != nil {
+		return nil, err
+	}
+	ret := EmbeddingModel(s)
+	return &ret, nil
+}
+
+// EmbeddingCacheDir is the embedding cache directory. Empty means
+// tai/embeddings in the user cache directory. See TheoryOfEmbedding.
+type EmbeddingCacheDir string
+
+func (Module) EmbeddingCacheDir() EmbeddingCacheDir {
+	return ""
+}
@@ -0,0 +1,177 @@
+package generators
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/reusee/dscope"
+	"github.com/reusee/tai/logs"
+	"github.com/reusee/tai/modes"
+	"github.com/reusee/tai/nets"
+)
+
+func TestHashEmbedder(t *testing.T) {
+	embedder := HashEmbedder{Dimensions: 64}
+	vectors, err := embedder.Embed(t.Context(), []string{
+		"parse change blocks",
+		"Parse change-blocks.",
+		"render terminal colors",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vectors) != 3 || len(vectors[0]) != 64 {
+		t.Fatalf("got %d vectors", len(vectors))
+	}
+	same := CosineSimilarity(vectors[0], vectors[1])
+	other := CosineSimilarity(vectors[0], vectors[2])
+	if same < 0.99 {
+		t.Fatalf("expected identical words to match, got %v", same)
+	}
+	if other >= same {
+		t.Fatalf("expected unrelated text to be less similar: %v >= %v", other, same)
+	}
+}
+
+type countingEmbedder struct {
+	HashEmbedder
+	texts *[]string
+}
+
+func (c countingEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	*c.texts = append(*c.texts, texts...)
+	return c.HashEmbedder.Embed(ctx, texts)
+}
+
+func TestCachedEmbedder(t *testing.T) {
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Call(func(logger logs.Logger) {
+		var upstreamTexts []string
+		upstream := countingEmbedder{
+			HashEmbedder: HashEmbedder{Dimensions: 16},
+			texts:        &upstreamTexts,
+		}
+		dir := t.TempDir()
+
+		cached := NewCachedEmbedder(upstream, dir, logger)
+		first, err := cached.Embed(t.Context(), []string{"a", "b"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// a new cache over the same directory serves stored vectors
+		cached = NewCachedEmbedder(upstream, dir, logger)
+		second, err := cached.Embed(t.Context(), []string{"b", "c", "a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(upstreamTexts) != 3 || upstreamTexts[2] != "c" {
+			t.Fatalf("expected only the missing text to be embedded, got %v", upstreamTexts)
+		}
+		if CosineSimilarity(first[0], second[2]) < 0.999 || CosineSimilarity(first[1], second[0]) < 0.999 {
+			t.Fatal("cached vectors differ")
+		}
+	})
+}
+
+func TestOpenAIEmbed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/embeddings" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer key" {
+			t.Errorf("unexpected authorization %q", got)
+		}
+		var req struct {
+			Model string   `json:"model"`
+			Input []string `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		if req.Model != "embed-model" {
+			t.Errorf("unexpected model %q", req.Model)
+		}
+		type item struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		}
+		var data []item
+		// out of order, to check that vectors follow the index
+		for i := len(req.Input) - 1; i >= 0; i-- {
+			data = append(data, item{
+				Index:     i,
+				Embedding: []float32{float32(i), 1},
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"data": data,
+		})
+	}))
+	defer server.Close()
+
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Fork(
+		func() nets.HTTPClient {
+			return nets.HTTPClient{Client: server.Client()}
+		},
+	).Call(func(newOpenAI NewOpenAI) {
+		embedder := newOpenAI(Spec{
+			BaseURL: server.URL + "/v1/",
+			Model:   "embed-model",
+		}, "key")
+		vectors, err := embedder.Embed(t.Context(), []string{"x", "y", "z"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, vector := range vectors {
+			if vector[0] != float32(i) {
+				t.Fatalf("vector %d: got %v", i, vector)
+			}
+		}
+	})
+}
+
+func TestGetEmbedder(t *testing.T) {
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Call(func(getEmbedder GetEmbedder) {
+		embedder, err := getEmbedder()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if embedder != nil {
+			t.Fatal("expected no embedder without an embedding model")
+		}
+	})
+
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Fork(
+		func() EmbeddingModel {
+			return "hash"
+		},
+		func() EmbeddingCacheDir {
+			return EmbeddingCacheDir(t.TempDir())
+		},
+	).Call(func(getEmbedder GetEmbedder) {
+		embedder, err := getEmbedder()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := embedder.(*CachedEmbedder); !ok {
+			t.Fatalf("expected a cached embedder, got %T", embedder)
+		}
+		if embedder.EmbeddingModel() != "hash/256" {
+			t.Fatalf("got %s", embedder.EmbeddingModel())
+		}
+	})
+}
@@ -1,5 +1,24 @@
 package generators
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var ErrRetryable = errors.New("retryable error")
+
+// ErrRetryExhausted is wrapped by the error Retrier.Do returns when every
+// attempt failed with a retryable error. It is not retryable itself, but it
+// triggers generator fallback. See TheoryOfFallback.
+var ErrRetryExhausted = errors.New("retry exhausted")
+
+// StatusError is an HTTP error response whose body is not a recognized
+// provider error object.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("bad status: %d, body: %s", e.StatusCode, e.Body)
+}
@@ -0,0 +1,243 @@
+package generators
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/reusee/dscope"
+	"github.com/reusee/tai/logs"
+	"google.golang.org/genai"
+)
+
+const TheoryOfFallback = `
+Retrier absorbs transient failures of one endpoint, but an endpoint can fail
+persistently: retries are exhausted during an outage, the account runs out
+of quota, or the model is withdrawn. Without a fallback the error ends the
+whole session. Spec.Fallback lists other specs to switch to (see
+TheoryOfSpec), and GetGenerator wraps a generator whose resolved spec has
+fallbacks in a Fallback generator.
+
+Fallback delegates to the active generator. When generation fails with a
+fallback error, the next spec of the chain is resolved like GetGenerator
+does, but without the response cache and record mode, which wrap the whole
+chain once (see TheoryOfResponseCache), and the same request is sent to
+it, starting from the State passed to Generate, so output of the failed
+attempt is discarded like in the round retry. Fallback errors are
+persistent provider failures: exhausted retries (ErrRetryExhausted), and
+HTTP-level failures that another provider may not share — authentication
+and quota (401, 402, 403, 429), unknown model (404), and server errors
+(5xx). Invalid requests and context cancellation are returned as is, since
+another spec would fail the same way.
+
+The switch is sticky: later calls use the generator that last succeeded, so
+each round does not pay for the failing endpoint's retries again. A
+fallback spec's own fallbacks extend the chain, and specs already tried are
+skipped, so chains that refer to each other terminate. Specs are resolved
+lazily, so a misconfigured fallback only matters when it is reached. When
+the chain is exhausted, the last error is returned.
+
+Each switch is logged, recorded through EventRecorder as a "fallback"
+event, and appended to the State as a GeneratorFallback part in RoleLog
+content, so round statistics and the interaction records show which
+generator served the round, and the session budget prices the calls after
+the switch with the spec switched to.
+`
+
+// Fallback wraps a generator with its fallback chain. See TheoryOfFallback.
+type Fallback struct {
+	getGenerator GetGenerator
+
+	mu    sync.Mutex
+	gens  []Generator
+	names []string // specs not yet tried
+	tried map[string]bool
+
+	Logger        dscope.Inject[logs.Logger]
+	EventRecorder dscope.Inject[EventRecorder]
+}
+
+var _ Generator = new(Fallback)
+
+type NewFallback func(primary Generator, fallbacks []string, getGenerator GetGenerator) *Fallback
+
+func (Module) NewFallback(
+	inject dscope.InjectStruct,
+) NewFallback {
+	return func(primary Generator, fallbacks []string, getGenerator GetGenerator) *Fallback {
+		ret := &Fallback{
+			getGenerator: getGenerator,
+			gens:         []Generator{primary},
+			names:        fallbacks,
+			tried: map[string]bool{
+				primary.Spec().Name: true,
+			},
+		}
+		inject(&ret)
+		return ret
+	}
+}
+
+// active returns the generator in use and its position in the chain.
+func (f *Fallback) active() (Generator, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.gens[len(f.gens)-1], len(f.gens) - 1
+}
+
+func (f *Fallback) Spec() Spec {
+	gen, _ := f.active()
+	return gen.Spec()
+}
+
+func (f *Fallback) CountTokens(text string) (int, error) {
+	gen, _ := f.active()
+	return gen.CountTokens(text)
+}
+
+var _ ContextTokenCounter = new(Fallback)
+
+func (f *Fallback) CountTokensContext(ctx context.Context, text string) (int, error) {
+	gen, _ := f.active()
+	return CountTokensFunc(ctx, gen)(text)
+}
+
+func (f *Fallback) Generate(ctx context.Context, state State, options *GenerateOptions) (State, error) {
+	for {
+		gen, index := f.active()
+		ret, err := gen.Generate(ctx, state, options)
+		if err == nil || !isFallbackError(ctx, err) {
+			return ret, err
+		}
+		next := f.advance(ctx, index, err)
+		if next == nil {
+			return ret, err
+		}
+		fallback := GeneratorFallback{
+			From:   gen.Spec().Name,
+			To:     next.Spec().Name,
+			Error:  err.Error(),
+			ToSpec: next.Spec(),
+		}
+		if rec := f.EventRecorder(); rec != nil && rec.Enabled() {
+			rec.Event("fallback", fmt.Sprintf("generator %s failed, switched to %s: %s", fallback.From, fallback.To, fallback.Error))
+		}
+		state, err = state.AppendContent(&Content{
+			Role:  RoleLog,
+			Parts: []Part{fallback},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// advance makes the next spec of the chain active after the generator at
+// index failed, and returns it, or nil when the chain is exhausted. When
+// another call already advanced past index, the current generator is
+// returned without resolving a new one.
+func (f *Fallback) advance(ctx context.Context, index int, cause error) Generator {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if index < len(f.gens)-1 {
+		return f.gens[len(f.gens)-1]
+	}
+	for len(f.names) > 0 {
+		name := f.names[0]
+		f.names = f.names[1:]
+		if f.tried[name] {
+			continue
+		}
+		f.tried[name] = true
+		gen, err := f.getGenerator(name)
+		if err != nil {
+			f.Logger().WarnContext(ctx, "resolve fallback generator", "name", name, "error", err)
+			continue
+		}
+		// A fallback with its own chain contributes the chain instead of
+		// nesting wrappers, so tried specs are skipped across all levels.
+		if nested, ok := gen.(*Fallback); ok {
+			nested.mu.Lock()
+			gen = nested.gens[0]
+			f.names = append(f.names, nested.names...)
+			nested.mu.Unlock()
+		}
+		// A redirect may lead to a spec tried under another path.
+		spec := gen.Spec()
+		if f.tried[spec.Name] && spec.Name != name {
+			continue
+		}
+		f.tried[spec.Name] = true
+		f.Logger().WarnContext(ctx, "generator fallback",
+			"from", f.gens[len(f.gens)-1].Spec().Name,
+			"to", spec.Name,
+			"model", spec.Model,
+			"error", cause,
+		)
+		f.gens = append(f.gens, gen)
+		return gen
+	}
+	return nil
+}
+
+// isFallbackError reports whether err is a persistent provider failure that
+// another spec may not share. See TheoryOfFallback.
+func isFallbackError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, ErrRetryExhausted) {
+		return true
+	}
+
+	var statusCode int
+	var genaiErr *genai.APIError
+	var apiErr *APIError
+	var anthropicErr *AnthropicError
+	var responsesErr *ResponsesError
+	var statusErr *StatusError
+	switch {
+	case errors.As(err, &genaiErr):
+		statusCode = genaiErr.Code
+	case errors.As(err, &apiErr):
+		statusCode = apiErr.HTTPStatusCode
+		if code, ok := apiErr.Code.(string); ok && isFallbackErrorCode(code) {
+			return true
+		}
+	case errors.As(err, &anthropicErr):
+		statusCode = anthropicErr.HTTPStatusCode
+		switch anthropicErr.Type {
+		case "overloaded_error", "api_error", "rate_limit_error", "not_found_error":
+			return true
+		}
+	case errors.As(err, &responsesErr):
+		if isFallbackErrorCode(responsesErr.Code) {
+			return true
+		}
+	case errors.As(err, &statusErr):
+		statusCode = statusErr.StatusCode
+	}
+
+	switch statusCode {
+	case http.StatusUnauthorized,
+		http.StatusPaymentRequired,
+		http.StatusForbidden,
+		http.StatusNotFound,
+		http.StatusTooManyRequests:
+		return true
+	}
+	return statusCode >= 500
+}
+
+func isFallbackErrorCode(code string) bool {
+	switch code {
+	case "insufficient_quota", "model_not_found", "rate_limit_exceeded",
+		"server_error", "server_is_overloaded":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,246 @@
+package generators
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/reusee/dscope"
+	"github.com/reusee/tai/configs"
+	"github.com/reusee/tai/modes"
+	"github.com/reusee/tai/nets"
+)
+
+func TestFallback(t *testing.T) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.URL.Path)
+		switch r.URL.Path {
+		case "/missing/messages", "/other-missing/messages":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"type":"error","error":{"type":"not_found_error","message":"model not found"}}`)
+		case "/invalid/messages":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"type":"error","error":{"type":"invalid_request_error","message":"bad request"}}`)
+		default:
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, anthropicSSE(
+				`{"type":"message_start","message":{"id":"msg_1","role":"assistant","content":[],"usage":{"input_tokens":1,"output_tokens":1}}}`,
+				`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+				`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"ok"}}`,
+				`{"type":"content_block_stop","index":0}`,
+				`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":1}}`,
+				`{"type":"message_stop"}`,
+			))
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.cue")
+	configContent := fmt.Sprintf(`generators: [
+  {
+    name: "claude"
+    type: "anthropic"
+    model: "claude-test"
+    variants: [
+      {name: "missing", base_url: %[1]q, fallback: ["/claude/ok"]},
+      {name: "ok", base_url: %[2]q},
+      {name: "invalid", base_url: %[3]q, fallback: ["/claude/ok"]},
+      {name: "loop-a", base_url: %[1]q, fallback: ["/claude/loop-b"]},
+      {name: "loop-b", base_url: %[4]q, fallback: ["/claude/loop-a"]},
+      {name: "nested", base_url: %[1]q, fallback: ["/claude/middle"]},
+      {name: "middle", base_url: %[4]q, fallback: ["/claude/ok"]},
+    ]
+  },
+]
+`, server.URL+"/missing", server.URL+"/ok", server.URL+"/invalid", server.URL+"/other-missing")
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Fork(
+		func() configs.Loader {
+			return configs.NewLoader([]string{configPath}, configs.LoaderConfig{})
+		},
+		func() nets.HTTPClient {
+			return nets.HTTPClient{Client: server.Client()}
+		},
+	).Call(func(get GetGenerator) {
+		state := NewPrompts("", []*Content{
+			{Role: RoleUser, Parts: []Part{Text("hi")}},
+		})
+
+		t.Run("switch on persistent failure", func(t *testing.T) {
+			calls = nil
+			gen, err := get("claude/missing")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ret, err := gen.Generate(context.Background(), state, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fallback GeneratorFallback
+			var text Text
+			for c := range ret.Contents() {
+				for _, p := range c.Parts {
+					switch p := p.(type) {
+					case GeneratorFallback:
+						fallback = p
+					case Text:
+						if c.Role == RoleModel {
+							text += p
+						}
+					}
+				}
+			}
+			if fallback.From != "claude/missing" || fallback.To != "claude/ok" {
+				t.Fatalf("got fallback %+v", fallback)
+			}
+			if text != "ok" {
+				t.Fatalf("got text %q", text)
+			}
+			if gen.Spec().Name != "claude/ok" {
+				t.Fatalf("fallback must be sticky, active spec is %q", gen.Spec().Name)
+			}
+
+			// The failing generator is not retried once switched.
+			calls = nil
+			if _, err := gen.Generate(context.Background(), state, nil); err != nil {
+				t.Fatal(err)
+			}
+			if len(calls) != 1 || calls[0] != "/ok/messages" {
+				t.Fatalf("got calls %v", calls)
+			}
+		})
+
+		t.Run("invalid request does not switch", func(t *testing.T) {
+			calls = nil
+			gen, err := get("claude/invalid")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = gen.Generate(context.Background(), state, nil)
+			var apiErr *AnthropicError
+			if !errors.As(err, &apiErr) || apiErr.Type != "invalid_request_error" {
+				t.Fatalf("expected the invalid request error, got %v", err)
+			}
+			if len(calls) != 1 {
+				t.Fatalf("got calls %v", calls)
+			}
+		})
+
+		t.Run("cyclic chain terminates", func(t *testing.T) {
+			calls = nil
+			gen, err := get("claude/loop-a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = gen.Generate(context.Background(), state, nil)
+			if err == nil {
+				t.Fatal("expected error when every spec fails")
+			}
+			if len(calls) != 2 {
+				t.Fatalf("each spec must be tried once, got calls %v", calls)
+			}
+		})
+	})
+
+	// With the response cache, the chain is cached once as a whole, and a
+	// nested chain is flattened.
+	cacheDir := t.TempDir()
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Fork(
+		func() configs.Loader {
+			return configs.NewLoader([]string{configPath}, configs.LoaderConfig{})
+		},
+		func() nets.HTTPClient {
+			return nets.HTTPClient{Client: server.Client()}
+		},
+		func() ResponseCacheEnabled {
+			return true
+		},
+		func() ResponseCacheDir {
+			return ResponseCacheDir(cacheDir)
+		},
+	).Call(func(get GetGenerator) {
+		calls = nil
+		gen, err := get("claude/nested")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cached, ok := gen.(*cachedGenerator)
+		if !ok {
+			t.Fatalf("got %T", gen)
+		}
+		fallback, ok := cached.upstream.(*Fallback)
+		if !ok {
+			t.Fatalf("got %T", cached.upstream)
+		}
+		state := NewPrompts("", []*Content{
+			{Role: RoleUser, Parts: []Part{Text("hi")}},
+		})
+		if _, err := gen.Generate(context.Background(), state, nil); err != nil {
+			t.Fatal(err)
+		}
+		if len(calls) != 3 || calls[2] != "/ok/messages" {
+			t.Fatalf("got calls %v", calls)
+		}
+		for _, g := range fallback.gens {
+			if _, ok := g.(*Fallback); ok {
+				t.Fatal("nested chain must be flattened")
+			}
+			if _, ok := g.(*cachedGenerator); ok {
+				t.Fatal("fallback targets must not be cached again")
+			}
+		}
+		if len(fallback.gens) != 3 {
+			t.Fatalf("got %d generators", len(fallback.gens))
+		}
+		var entries int
+		filepath.WalkDir(cacheDir, func(path string, d os.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				entries++
+			}
+			return nil
+		})
+		if entries != 1 {
+			t.Fatalf("got %d cache entries", entries)
+		}
+	})
+}
+
+func TestIsFallbackError(t *testing.T) {
+	ctx := context.Background()
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	for _, c := range []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want bool
+	}{
+		{"retry exhausted", ctx, fmt.Errorf("%w after 10 attempts: x", ErrRetryExhausted), true},
+		{"quota", ctx, OpenAIError{Err: &APIError{Code: "insufficient_quota", HTTPStatusCode: 429}}, true},
+		{"server error", ctx, &StatusError{StatusCode: 502}, true},
+		{"model not found", ctx, &AnthropicError{Type: "not_found_error", HTTPStatusCode: 404}, true},
+		{"bad request", ctx, &APIError{HTTPStatusCode: 400}, false},
+		{"plain error", ctx, errors.New("parse failure"), false},
+		{"canceled", canceled, fmt.Errorf("%w after 10 attempts: x", ErrRetryExhausted), false},
+	} {
+		if got := isFallbackError(c.ctx, c.err); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"strings"
 )
 
 type Function struct {
@@ -123,7 +124,22 @@ func toVar(t reflect.Type, name string) Var {
 			if !field.IsExported() {
 				continue
 			}
-			fieldVar := toVar(field.Type, field.Name)
+			// Property names follow the json tag, the names values are
+			// encoded and decoded with; omitted-when-empty fields are
+			// optional.
+			name := field.Name
+			tag, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if tag == "-" {
+				continue
+			} else if tag != "" {
+				name = tag
+			}
+			fieldVar := toVar(field.Type, name)
+			for opt := range strings.SplitSeq(opts, ",") {
+				if opt == "omitempty" || opt == "omitzero" {
+					fieldVar.Optional = true
+				}
+			}
 			props = append(props, fieldVar)
 		}
 		v.Properties = props
@@ -42,6 +42,10 @@ type Gemini struct {
 	FuncDecls       dscope.Inject[FuncDecls]
 	EventRecorder   dscope.Inject[EventRecorder]
 	Retrier         dscope.Inject[Retrier]
+	RateLimiters    dscope.Inject[*RateLimiters]
+	CachedContents  dscope.Inject[GeminiCachedContents]
+	Capabilities    dscope.Inject[*CapabilityWarnings]
+	Tokenizers      dscope.Inject[Tokenizers]
 }
 
 var _ Generator = Gemini{}
@@ -62,7 +66,53 @@ func (g Gemini) recordEvent(typ string, detail string) {
 }
 
 func (g Gemini) CountTokens(text string) (int, error) {
-	return g.Counter()(g.spec.Model)(text)
+	return g.CountTokensContext(context.Background(), text)
+}
+
+var _ ContextTokenCounter = Gemini{}
+
+func (g Gemini) CountTokensContext(ctx context.Context, text string) (int, error) {
+	return g.Tokenizers()(g.spec, g.Counter()(g.spec.Model), func(text string) (int, error) {
+		return g.countTokensAPI(ctx, text)
+	})(text)
+}
+
+// countTokensAPI counts text with the countTokens endpoint. See
+// TheoryOfTokenizers.
+func (g Gemini) countTokensAPI(ctx context.Context, text string) (int, error) {
+	if err := waitCountTokensRateLimit(ctx, g.RateLimiters(), g.Logger(), g.spec); err != nil {
+		return 0, err
+	}
+	client, err := g.GetClient()(ctx, g.spec.APIKey)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Models.CountTokens(ctx, g.spec.Model, []*genai.Content{
+		genai.NewContentFromText(text, genai.RoleUser),
+	}, nil)
+	if err != nil {
+		return 0, err
+	}
+	return int(resp.TotalTokens), nil
+}
+
+var _ ModelLister = Gemini{}
+
+// ListModels returns the models of the Gemini API. See
+// TheoryOfModelInventory.
+func (g Gemini) ListModels(ctx context.Context) ([]string, error) {
+	client, err := g.GetClient()(ctx, g.spec.APIKey)
+	if err != nil {
+		return nil, err
+	}
+	var ret []string
+	for model, err := range client.Models.All(ctx) {
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, model.Name)
+	}
+	return ret, nil
 }
 
 func (g Gemini) Generate(ctx context.Context, state State, options *GenerateOptions) (ret State, err error) {
@@ -106,6 +156,13 @@ func (g Gemini) Generate(ctx context.Context, state State, options *GenerateOpti
 		}
 	}
 
+	adapter := &capabilityAdapter{
+		ctx:      ctx,
+		spec:     g.spec,
+		logger:   g.Logger(),
+		warnings: g.Capabilities(),
+	}
+
 	thinkingConfig := &genai.ThinkingConfig{
 		IncludeThoughts: true,
 	}
@@ -140,6 +197,11 @@ func (g Gemini) Generate(ctx context.Context, state State, options *GenerateOpti
 		}
 	}
 
+	if !adapter.supports(CapabilityReasoning) {
+		adapter.warn(CapabilityReasoning, "thinking configuration not sent")
+		thinkingConfig = nil
+	}
+
 	var tools []*genai.Tool
 	var toolConfig *genai.ToolConfig
 	if g.spec.DisableTools == nil || !*g.spec.DisableTools {
@@ -197,6 +259,21 @@ func (g Gemini) Generate(ctx context.Context, state State, options *GenerateOpti
 	}
 
 	var contents []*genai.Content
+	// A system prompt the model cannot take as an instruction opens the
+	// first user turn. See TheoryOfCapabilities.
+	var systemTurn *genai.Content
+	if sysPrompt := ret.SystemPrompt(); adapter.systemPromptInUserTurn(sysPrompt) {
+		systemTurn = &genai.Content{
+			Role: string(RoleUser),
+			Parts: []*genai.Part{
+				{Text: sysPrompt},
+			},
+		}
+		contents = append(contents, systemTurn)
+	}
+	// The last cache breakpoint follows breakParts parts of
+	// contents[breakIndex]. See TheoryOfGeminiCachedContent.
+	breakIndex, breakParts := -1, 0
 	for content := range ret.Contents() {
 		if content.Role == RoleLog || content.Role == RoleSystem {
 			continue
@@ -210,7 +287,16 @@ func (g Gemini) Generate(ctx context.Context, state State, options *GenerateOpti
 		pbContent := &genai.Content{
 			Role: role,
 		}
+		if systemTurn != nil && len(contents) == 1 && contents[0] == systemTurn && role == string(RoleUser) {
+			// continue the turn holding the system prompt
+			pbContent = systemTurn
+			contents = contents[:0]
+		}
 		for _, part := range content.Parts {
+			if _, ok := part.(CacheBreakpoint); ok {
+				breakIndex, breakParts = len(contents), len(pbContent.Parts)
+				continue
+			}
 			// Thoughts are only sent to the server when PreservedThinking is
 			// enabled. By default, reasoning content is stripped from outgoing
 			// requests to avoid sending it back to the model.
@@ -223,7 +309,7 @@ func (g Gemini) Generate(ctx context.Context, state State, options *GenerateOpti
 				}
 				continue
 			}
-			if pbPart := partToGemini(part); pbPart != nil {
+			if pbPart := partToGemini(part, adapter); pbPart != nil {
 				pbContent.Parts = append(pbContent.Parts, pbPart)
 			}
 		}
@@ -260,7 +346,7 @@ func (g Gemini) Generate(ctx context.Context, state State, options *GenerateOpti
 		ToolConfig:      toolConfig,
 		ServiceTier:     serviceTier,
 	}
-	if sysPrompt := ret.SystemPrompt(); sysPrompt != "" {
+	if sysPrompt := ret.SystemPrompt(); sysPrompt != "" && systemTurn == nil {
 		config.SystemInstruction = &genai.Content{
 			Parts: []*genai.Part{
 				{Text: sysPrompt},
@@ -268,25 +354,66 @@ func (g Gemini) Generate(ctx context.Context, state State, options *GenerateOpti
 		}
 	}
 
-	if options != nil && options.ResponseSchema != nil {
+	if options != nil && options.ResponseSchema != nil && !adapter.supports(CapabilityJSONSchema) {
+		adapter.warn(CapabilityJSONSchema, "response schema not sent")
+	} else if options != nil && options.ResponseSchema != nil {
 		config.ResponseMIMEType = "application/json"
 		config.ResponseSchema = options.ResponseSchema.ToGemini()
 	}
 
+	// A batch request runs later, possibly after a cached content
+	// expired. See TheoryOfBatch.
+	batch := options != nil && options.Batch
+
+	// Move the prefix up to the last cache breakpoint into a cachedContents
+	// resource. The system instruction and tools are part of the resource
+	// and must not be repeated in the request. See
+	// TheoryOfGeminiCachedContent.
+	if breakIndex >= 0 && !batch {
+		prefix, rest := splitGeminiCachePrefix(contents, breakIndex, breakParts)
+		if len(prefix) > 0 && len(rest) > 0 {
+			name, err := g.CachedContents()(ctx, client, g.spec.Model, &genai.CreateCachedContentConfig{
+				Contents:          prefix,
+				SystemInstruction: config.SystemInstruction,
+				Tools:             config.Tools,
+				ToolConfig:        config.ToolConfig,
+			}, CountTokensFunc(ctx, g))
+			if err != nil {
+				return ret, err
+			}
+			if name != "" {
+				config.CachedContent = name
+				config.SystemInstruction = nil
+				config.Tools = nil
+				config.ToolConfig = nil
+				contents = rest
+			}
+		}
+	}
+
 	nonStreaming := false
 	if options != nil && options.NonStreaming {
 		nonStreaming = true
 	}
 
+	// Each attempt is paced by the spec's rate limits; batch jobs are
+	// not. See TheoryOfRateLimit and TheoryOfBatch.
+	estimatedTokens := estimateRequestTokens(g.spec, ret, CountTokensFunc(ctx, g))
 	ret, err = g.Retrier().Do(ctx, func() (State, error) {
+		if !batch {
+			if err := waitRateLimit(ctx, g.RateLimiters(), g.Logger(), g.spec, estimatedTokens); err != nil {
+				return ret, err
+			}
+		}
 
 		g.Logger().InfoContext(ctx, "generating",
 			"name", g.spec.Name,
 			"model", g.spec.Model,
 			"effort", g.spec.ReasoningEffort,
 			"non_streaming", nonStreaming,
+			"batch", batch,
 		)
-		g.recordEvent("api_call", fmt.Sprintf("gemini generate content: model=%s effort=%s non_streaming=%v", g.spec.Model, g.spec.ReasoningEffort, nonStreaming))
+		g.recordEvent("api_call", fmt.Sprintf("gemini generate content: model=%s effort=%s non_streaming=%v batch=%v", g.spec.Model, g.spec.ReasoningEffort, nonStreaming, batch))
 
 		newState := ret
 		hasContent := false
@@ -303,8 +430,9 @@ func (g Gemini) Generate(ctx context.Context, state State, options *GenerateOpti
 			if metadata := resp.UsageMetadata; metadata != nil {
 				lastUsage = &Usage{
 					Prompt: struct {
-						TokenCount       int
-						TokenCountCached int
+						TokenCount           int
+						TokenCountCached     int
+						TokenCountCacheWrite int
 					}{
 						TokenCount:       int(metadata.PromptTokenCount),
 						TokenCountCached: int(metadata.CachedContentTokenCount),
@@ -319,6 +447,7 @@ func (g Gemini) Generate(ctx context.Context, state State, options *GenerateOpti
 					}{
 						TokenCount: int(metadata.ThoughtsTokenCount),
 					},
+					Batch: batch,
 				}
 			}
 
@@ -371,7 +500,17 @@ func (g Gemini) Generate(ctx context.Context, state State, options *GenerateOpti
 			return nil
 		}
 
-		if nonStreaming {
+		if batch {
+			resp, err := g.batchGenerate(ctx, client, contents, config)
+			if err != nil {
+				g.recordEvent("api_error", fmt.Sprintf("gemini batch failed: %v", err))
+				return ret, wrap(err)
+			}
+			if err := handleResponse(resp); err != nil {
+				return ret, err
+			}
+
+		} else if nonStreaming {
 			resp, err := client.Models.GenerateContent(ctx, g.spec.Model, contents, config)
 			if err != nil {
 				g.recordEvent("api_error", fmt.Sprintf("gemini non-streaming API call failed: %v", err))
@@ -471,7 +610,8 @@ func (Module) Retrier(
 // Do runs fn with retry and exponential backoff. The result type is
 // inferred from fn, so the method is called without explicit
 // instantiation. After exhausting all retries, ErrRetryable is stripped
-// from the returned error to break outer retry loops.
+// from the returned error to break outer retry loops, and the error wraps
+// ErrRetryExhausted instead.
 // See TheoryOfRetry.
 func (r Retrier) Do[T any](
 	ctx context.Context,
@@ -519,7 +659,7 @@ func (r Retrier) Do[T any](
 		if r.eventRecorder != nil && r.eventRecorder.Enabled() {
 			r.eventRecorder.Event("api_error", fmt.Sprintf("API retry exhausted after %d attempts: %v", maxRetries, err))
 		}
-		err = fmt.Errorf("retry exhausted after %d attempts: %v", maxRetries, err)
+		err = fmt.Errorf("%w after %d attempts: %v", ErrRetryExhausted, maxRetries, err)
 	}
 	return
 }
@@ -0,0 +1,82 @@
+package generators
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/genai"
+)
+
+// batchGenerate runs the request in a batch job with other concurrent
+// requests to the same model. See TheoryOfBatch.
+func (g Gemini) batchGenerate(ctx context.Context, client *genai.Client, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	key := "gemini\x00" + g.spec.Model + "\x00" + firstNonZero(g.spec.APIKey, string(g.APIKey()))
+	return batchQueueFor[*genai.InlinedRequest, *genai.GenerateContentResponse](key).do(ctx,
+		&genai.InlinedRequest{
+			Contents: contents,
+			Config:   config,
+		},
+		func(ctx context.Context, reqs []*genai.InlinedRequest) ([]batchResult[*genai.GenerateContentResponse], error) {
+			return g.runBatch(ctx, client, reqs)
+		},
+	)
+}
+
+// runBatch creates a job with inlined requests, waits for it and returns
+// the results in request order.
+func (g Gemini) runBatch(ctx context.Context, client *genai.Client, reqs []*genai.InlinedRequest) ([]batchResult[*genai.GenerateContentResponse], error) {
+	job, err := client.Batches.Create(ctx, g.spec.Model, &genai.BatchJobSource{
+		InlinedRequests: reqs,
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create batch: %w", err)
+	}
+	g.Logger().InfoContext(ctx, "batch job created",
+		"job", job.Name,
+		"model", g.spec.Model,
+		"requests", len(reqs),
+	)
+	g.recordEvent("api_call", fmt.Sprintf("gemini batch created: job=%s model=%s requests=%d", job.Name, g.spec.Model, len(reqs)))
+
+	done := func() bool {
+		switch job.State {
+		case genai.JobStateSucceeded, genai.JobStateFailed, genai.JobStateCancelled, genai.JobStateExpired:
+			return true
+		}
+		return false
+	}
+	if err := pollBatch(ctx, g.Logger(), job.Name, func() (bool, error) {
+		if done() {
+			return true, nil
+		}
+		job, err = client.Batches.Get(ctx, job.Name, nil)
+		if err != nil {
+			return false, err
+		}
+		return done(), nil
+	}); err != nil {
+		return nil, err
+	}
+	if job.State != genai.JobStateSucceeded {
+		if job.Error != nil {
+			return nil, fmt.Errorf("batch %s %s: %s", job.Name, job.State, job.Error.Message)
+		}
+		return nil, fmt.Errorf("batch %s %s", job.Name, job.State)
+	}
+	if job.Dest == nil || len(job.Dest.InlinedResponses) != len(reqs) {
+		return nil, fmt.Errorf("batch %s returned no results for %d requests", job.Name, len(reqs))
+	}
+
+	results := make([]batchResult[*genai.GenerateContentResponse], len(reqs))
+	for i, resp := range job.Dest.InlinedResponses {
+		switch {
+		case resp.Error != nil:
+			results[i].err = fmt.Errorf("batch request: %s", resp.Error.Message)
+		case resp.Response == nil:
+			results[i].err = fmt.Errorf("batch request without response")
+		default:
+			results[i].resp = resp.Response
+		}
+	}
+	return results, nil
+}
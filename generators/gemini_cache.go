@@ -0,0 +1,246 @@
+package generators
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/reusee/tai/logs"
+	"google.golang.org/genai"
+)
+
+const TheoryOfGeminiCachedContent = `
+Gemini's explicit caching stores a prompt prefix (system instruction, tools,
+and leading contents) as a cachedContents resource; a request referencing
+the resource by name pays the cached rate for those tokens and sends only
+the remaining contents. The generator caches the prefix up to the last
+CacheBreakpoint in the state (see TheoryOfCacheBreakpoints): with the
+three-tier file ordering that is the end of the project files, so every
+round of a session and every session over unchanged files reuses one
+resource.
+
+Resources are keyed by a hash of the client, the model and the serialized
+prefix, and the hash is also the resource's display name. A resource belongs
+to the API key or project that created it, so the client's backend,
+project, location, base URL and API key are part of the key. The process keeps a registry
+from hash to resource name so each round costs no extra API call; on a
+registry miss the existing resources are listed once to reuse one created
+by an earlier process before creating a new one. Resources are created with
+a short TTL because storage is billed per hour, and the TTL is extended when
+a reused resource is close to expiring. Each key has its own lock, held
+across its API calls, so concurrent generations of one prefix create one
+resource while generations of other prefixes proceed.
+
+Explicit caching is best effort. A prefix below the provider's minimum
+cacheable size is not submitted, and a failed creation (unsupported model,
+quota) is logged and remembered for the TTL, so the request proceeds
+uncached through implicit prefix caching instead of failing the round.
+`
+
+const (
+	// geminiCacheTTL is the lifetime of a created or extended resource.
+	geminiCacheTTL = 15 * time.Minute
+	// geminiCacheMinRemaining is the remaining lifetime below which a
+	// registered resource is extended before use, so a request never
+	// references a resource that expires while the request is in flight.
+	geminiCacheMinRemaining = 2 * time.Minute
+	// geminiCacheMinTokens is the smallest prefix submitted for explicit
+	// caching; the API rejects smaller ones.
+	geminiCacheMinTokens  = 4096
+	geminiCacheNamePrefix = "tai-"
+)
+
+// GeminiCachedContents returns the name of a cachedContents resource holding
+// the prefix described by config, creating or reusing one as needed. It
+// returns an empty name when the prefix is not cached. countTokens is only
+// called when a new resource would be created. See
+// TheoryOfGeminiCachedContent.
+type GeminiCachedContents func(
+	ctx context.Context,
+	client *genai.Client,
+	model string,
+	config *genai.CreateCachedContentConfig,
+	countTokens func(string) (int, error),
+) (name string, err error)
+
+func (Module) GeminiCachedContents(
+	logger logs.Logger,
+) GeminiCachedContents {
+	// entry is the resource of a key, or a failure remembered until
+	// expire when name is empty.
+	type entry struct {
+		mu     sync.Mutex
+		name   string
+		expire time.Time
+	}
+	var mu sync.Mutex
+	entries := make(map[string]*entry) // prefix hash -> resource
+
+	return func(
+		ctx context.Context,
+		client *genai.Client,
+		model string,
+		config *genai.CreateCachedContentConfig,
+		countTokens func(string) (int, error),
+	) (string, error) {
+		key, err := geminiCacheKey(client, model, config)
+		if err != nil {
+			return "", err
+		}
+		displayName := geminiCacheNamePrefix + key
+
+		mu.Lock()
+		e, ok := entries[key]
+		if !ok {
+			e = new(entry)
+			entries[key] = e
+		}
+		mu.Unlock()
+
+		// Holding the key's lock across the API calls serializes creation,
+		// so concurrent generations of the same prefix create one resource.
+		e.mu.Lock()
+		defer e.mu.Unlock()
+
+		if e.name == "" && time.Now().Before(e.expire) {
+			// failed recently, do not retry until the entry expires
+			return "", nil
+		}
+		if e.name != "" {
+			if time.Until(e.expire) > geminiCacheMinRemaining {
+				return e.name, nil
+			}
+			updated, err := client.Caches.Update(ctx, e.name, &genai.UpdateCachedContentConfig{
+				TTL: geminiCacheTTL,
+			})
+			if err == nil {
+				e.expire = updated.ExpireTime
+				return e.name, nil
+			}
+			logger.WarnContext(ctx, "extend gemini cached content", "name", e.name, "error", err)
+			e.name = ""
+			e.expire = time.Time{}
+		}
+
+		// Reuse a resource created by an earlier process.
+		for cached, err := range client.Caches.All(ctx) {
+			if err != nil {
+				logger.WarnContext(ctx, "list gemini cached contents", "error", err)
+				break
+			}
+			if cached.DisplayName != displayName ||
+				time.Until(cached.ExpireTime) <= geminiCacheMinRemaining {
+				continue
+			}
+			e.name, e.expire = cached.Name, cached.ExpireTime
+			return cached.Name, nil
+		}
+
+		tokens, err := countTokens(geminiCacheText(config))
+		if err != nil {
+			return "", err
+		}
+		if tokens < geminiCacheMinTokens {
+			e.expire = time.Now().Add(geminiCacheTTL)
+			return "", nil
+		}
+
+		createConfig := *config
+		createConfig.DisplayName = displayName
+		createConfig.TTL = geminiCacheTTL
+		cached, err := client.Caches.Create(ctx, model, &createConfig)
+		if err != nil {
+			logger.WarnContext(ctx, "create gemini cached content", "model", model, "error", err)
+			e.expire = time.Now().Add(geminiCacheTTL)
+			return "", nil
+		}
+		logger.InfoContext(ctx, "gemini cached content created",
+			"name", cached.Name,
+			"tokens", tokens,
+			"expire", cached.ExpireTime,
+		)
+		e.name, e.expire = cached.Name, cached.ExpireTime
+		return cached.Name, nil
+	}
+}
+
+// geminiCacheKey hashes the client, the model and the cacheable prefix.
+// The config's TTL and display name are not part of the key.
+func geminiCacheKey(client *genai.Client, model string, config *genai.CreateCachedContentConfig) (string, error) {
+	clientConfig := client.ClientConfig()
+	data, err := json.Marshal(struct {
+		Backend           genai.Backend
+		Project           string
+		Location          string
+		BaseURL           string
+		APIKey            string
+		Model             string
+		Contents          []*genai.Content
+		SystemInstruction *genai.Content
+		Tools             []*genai.Tool
+		ToolConfig        *genai.ToolConfig
+	}{
+		Backend:           clientConfig.Backend,
+		Project:           clientConfig.Project,
+		Location:          clientConfig.Location,
+		BaseURL:           clientConfig.HTTPOptions.BaseURL,
+		APIKey:            clientConfig.APIKey,
+		Model:             model,
+		Contents:          config.Contents,
+		SystemInstruction: config.SystemInstruction,
+		Tools:             config.Tools,
+		ToolConfig:        config.ToolConfig,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// geminiCacheText concatenates the text of the prefix for the minimum size
+// check.
+func geminiCacheText(config *genai.CreateCachedContentConfig) string {
+	var b strings.Builder
+	contents := config.Contents
+	if config.SystemInstruction != nil {
+		contents = append([]*genai.Content{config.SystemInstruction}, contents...)
+	}
+	for _, content := range contents {
+		for _, part := range content.Parts {
+			b.WriteString(part.Text)
+		}
+	}
+	return b.String()
+}
+
+// splitGeminiCachePrefix splits contents at a breakpoint position: the
+// breakpoint follows the first parts parts of contents[index], or precedes
+// contents[index] when parts is zero. The parts slices are copied so the
+// halves do not share backing arrays.
+func splitGeminiCachePrefix(contents []*genai.Content, index int, parts int) (prefix []*genai.Content, rest []*genai.Content) {
+	if parts == 0 || index >= len(contents) {
+		index = min(index, len(contents))
+		return contents[:index:index], contents[index:]
+	}
+	split := contents[index]
+	prefix = append(prefix, contents[:index]...)
+	if parts >= len(split.Parts) {
+		prefix = append(prefix, split)
+		return prefix, contents[index+1:]
+	}
+	prefix = append(prefix, &genai.Content{
+		Role:  split.Role,
+		Parts: append([]*genai.Part(nil), split.Parts[:parts]...),
+	})
+	rest = append(rest, &genai.Content{
+		Role:  split.Role,
+		Parts: append([]*genai.Part(nil), split.Parts[parts:]...),
+	})
+	rest = append(rest, contents[index+1:]...)
+	return prefix, rest
+}
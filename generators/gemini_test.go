@@ -1,6 +1,7 @@
 package generators
 
 import (
+	"context"
 	"os"
 	"testing"
 
@@ -62,3 +63,68 @@ func TestGeminiListModels(t *testing.T) {
 
 	})
 }
+
+func TestSplitGeminiCachePrefix(t *testing.T) {
+	contents := []*genai.Content{
+		{Role: "user", Parts: []*genai.Part{{Text: "a"}, {Text: "b"}}},
+		{Role: "model", Parts: []*genai.Part{{Text: "c"}}},
+	}
+
+	prefix, rest := splitGeminiCachePrefix(contents, 0, 1)
+	if len(prefix) != 1 || len(prefix[0].Parts) != 1 || prefix[0].Parts[0].Text != "a" {
+		t.Fatalf("got prefix %+v", prefix)
+	}
+	if len(rest) != 2 || rest[0].Role != "user" || rest[0].Parts[0].Text != "b" || rest[1].Parts[0].Text != "c" {
+		t.Fatalf("got rest %+v", rest)
+	}
+	if len(contents[0].Parts) != 2 {
+		t.Fatal("input contents must not be modified")
+	}
+
+	prefix, rest = splitGeminiCachePrefix(contents, 0, 2)
+	if len(prefix) != 1 || len(rest) != 1 || rest[0].Role != "model" {
+		t.Fatalf("got %+v %+v", prefix, rest)
+	}
+
+	prefix, rest = splitGeminiCachePrefix(contents, 1, 0)
+	if len(prefix) != 1 || len(rest) != 1 || prefix[0].Role != "user" {
+		t.Fatalf("got %+v %+v", prefix, rest)
+	}
+}
+
+func TestGeminiCacheKey(t *testing.T) {
+	// A resource is keyed by the client that created it. See
+	// TheoryOfGeminiCachedContent.
+	newClient := func(apiKey string) *genai.Client {
+		client, err := genai.NewClient(context.Background(), &genai.ClientConfig{
+			APIKey:  apiKey,
+			Backend: genai.BackendGeminiAPI,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+	config := &genai.CreateCachedContentConfig{
+		Contents: []*genai.Content{
+			genai.NewContentFromText("prefix", genai.RoleUser),
+		},
+	}
+	key := func(client *genai.Client, model string) string {
+		ret, err := geminiCacheKey(client, model, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ret
+	}
+	a := newClient("a")
+	if key(a, "m") != key(newClient("a"), "m") {
+		t.Fatal("same client and prefix must share the key")
+	}
+	if key(a, "m") == key(newClient("b"), "m") {
+		t.Fatal("clients with different API keys must not share the key")
+	}
+	if key(a, "m") == key(a, "n") {
+		t.Fatal("models must not share the key")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"math/rand/v2"
+	"slices"
 	"strings"
 )
 
@@ -17,12 +18,35 @@ type GenerateOptions struct {
 	MaxGenerateTokens *int
 	ResponseSchema    *Var
 	NonStreaming      bool
+	// Batch runs the request as part of a provider batch job, returning
+	// when the job completes. Generators without a batch API ignore it.
+	// See TheoryOfBatch.
+	Batch bool
 }
 
 type GetGenerator func(name string) (Generator, error)
 
 func resolveSpec(name string, roots []Spec) (Spec, error) {
+	return resolveSpecWithBreaker(name, roots, nil)
+}
+
+// resolveSpecWithBreaker resolves name like resolveSpec, skipping
+// RandomRedirect targets whose circuit is open. A nil breaker treats every
+// target as available. See TheoryOfCircuitBreaker.
+func resolveSpecWithBreaker(name string, roots []Spec, breaker *CircuitBreaker) (Spec, error) {
+	return resolveSpecWith(name, roots, func(path string, entries []string, weights map[string]float64) string {
+		return pickRandomRedirect(path, entries, weights, breaker)
+	})
+}
+
+// resolveSpecWith resolves name, calling pick to choose among the
+// RandomRedirect entries of a spec. The model inventory passes a pick that
+// walks every target. See TheoryOfModelInventory.
+func resolveSpecWith(name string, roots []Spec, pick func(path string, entries []string, weights map[string]float64) string) (Spec, error) {
 	visited := make(map[string]bool)
+	// Fallbacks are collected from the final spec of every path visited,
+	// so a spec that redirects keeps its own fallbacks.
+	var fallbacks []string
 
 	for {
 		// build alias map
@@ -79,6 +103,8 @@ func resolveSpec(name string, roots []Spec) (Spec, error) {
 		currentMap := rootMap
 		var lastRedirect string
 		var lastRandomRedirects []string
+		var lastRedirectWeights map[string]float64
+		var lastFallbacks []string
 
 		for _, part := range parts {
 			spec, ok := currentMap[part]
@@ -104,6 +130,15 @@ func resolveSpec(name string, roots []Spec) (Spec, error) {
 			if spec.ContextTokens != 0 {
 				merged.ContextTokens = spec.ContextTokens
 			}
+			if spec.Tokenizer != "" {
+				merged.Tokenizer = spec.Tokenizer
+			}
+			if spec.RequestsPerMinute != 0 {
+				merged.RequestsPerMinute = spec.RequestsPerMinute
+			}
+			if spec.TokensPerMinute != 0 {
+				merged.TokensPerMinute = spec.TokensPerMinute
+			}
 			if spec.MaxGenerateTokens != nil {
 				merged.MaxGenerateTokens = spec.MaxGenerateTokens
 			}
@@ -119,6 +154,9 @@ func resolveSpec(name string, roots []Spec) (Spec, error) {
 			if spec.DisableTools != nil {
 				merged.DisableTools = spec.DisableTools
 			}
+			if spec.NativeTools != nil {
+				merged.NativeTools = spec.NativeTools
+			}
 			if spec.ExtraArguments != nil {
 				merged.ExtraArguments = spec.ExtraArguments
 			}
@@ -146,14 +184,40 @@ func resolveSpec(name string, roots []Spec) (Spec, error) {
 			if spec.ZeroDataRetention != nil {
 				merged.ZeroDataRetention = spec.ZeroDataRetention
 			}
+			if spec.CacheControl != nil {
+				merged.CacheControl = spec.CacheControl
+			}
 			if spec.Provider != nil {
 				merged.Provider = merged.Provider.merge(spec.Provider)
 			}
+			if spec.Capabilities != nil {
+				merged.Capabilities = merged.Capabilities.merge(spec.Capabilities)
+			}
+			if spec.InputPrice != nil {
+				merged.InputPrice = spec.InputPrice
+			}
+			if spec.CachedInputPrice != nil {
+				merged.CachedInputPrice = spec.CachedInputPrice
+			}
+			if spec.OutputPrice != nil {
+				merged.OutputPrice = spec.OutputPrice
+			}
+			if spec.ThinkingPrice != nil {
+				merged.ThinkingPrice = spec.ThinkingPrice
+			}
+			if spec.Cassette != "" {
+				merged.Cassette = spec.Cassette
+			}
+			if spec.ReplaySession != 0 {
+				merged.ReplaySession = spec.ReplaySession
+			}
 			// Redirect and RandomRedirect are not merged from parent to
 			// child; only the final spec in the path determines whether a
 			// redirect applies.
 			lastRedirect = spec.Redirect
 			lastRandomRedirects = spec.RandomRedirect
+			lastRedirectWeights = spec.RandomRedirectWeights
+			lastFallbacks = spec.Fallback
 			// descend into variants
 			nextMap := make(map[string]Spec, len(spec.Variants))
 			for _, v := range spec.Variants {
@@ -171,6 +235,20 @@ func resolveSpec(name string, roots []Spec) (Spec, error) {
 
 		merged.Name = name
 
+		// Fallback entries follow the same resolution rules as Redirect and
+		// are stored as full paths, since the final spec may be reached
+		// through redirects from a different path.
+		for _, fallback := range lastFallbacks {
+			if strings.HasPrefix(fallback, "/") {
+				fallback = fallback[1:]
+			} else {
+				fallback = name + "/" + fallback
+			}
+			if !slices.Contains(fallbacks, fallback) {
+				fallbacks = append(fallbacks, fallback)
+			}
+		}
+
 		// Handle redirect: if the last spec in the path has a Redirect
 		// field, re-resolve with the redirected path. A relative redirect
 		// (e.g., "child") is appended to the current path as additional
@@ -186,26 +264,97 @@ func resolveSpec(name string, roots []Spec) (Spec, error) {
 		}
 
 		// Handle random redirect: if the last spec in the path has a
-		// RandomRedirect field (and Redirect is not set), randomly pick
-		// one entry and apply it as a redirect. This enables load
-		// balancing across multiple endpoints. Entries follow the same
-		// resolution rules as Redirect: relative paths append to the
-		// current path, absolute paths starting with "/" replace it.
+		// RandomRedirect field (and Redirect is not set), pick one entry
+		// by weight among the targets whose circuit is not open, and apply
+		// it as a redirect. This enables load balancing across multiple
+		// endpoints. Entries follow the same resolution rules as Redirect:
+		// relative paths append to the current path, absolute paths
+		// starting with "/" replace it.
 		if len(lastRandomRedirects) > 0 {
-			picked := lastRandomRedirects[rand.IntN(len(lastRandomRedirects))]
-			if strings.HasPrefix(picked, "/") {
-				name = picked[1:]
-			} else {
-				name = name + "/" + picked
-			}
+			name = pick(name, lastRandomRedirects, lastRedirectWeights)
 			continue
 		}
 
-		return merged, nil
+		merged.Fallback = fallbacks
+		return merged.withCapabilities(), nil
 	}
 }
 
-func (Module) GetGenerator(
+// pickRandomRedirect returns the path of a RandomRedirect target of the
+// spec at path. Targets are picked with probability proportional to their
+// weight (1 when not listed in weights); targets with a non-positive weight
+// are never picked. Targets whose circuit is open are skipped unless every
+// target is open, so selection never fails because of health. See
+// TheoryOfCircuitBreaker.
+func pickRandomRedirect(path string, entries []string, weights map[string]float64, breaker *CircuitBreaker) string {
+	var available []redirectTarget
+	all := redirectTargets(path, entries, weights)
+	for _, t := range all {
+		if breaker == nil || breaker.Available(t.path) {
+			available = append(available, t)
+		}
+	}
+	if len(available) == 0 {
+		available = all
+	}
+	if len(available) == 0 {
+		// every weight is non-positive; fall back to a uniform pick
+		return redirectPath(path, entries[rand.IntN(len(entries))])
+	}
+	var total float64
+	for _, t := range available {
+		total += t.weight
+	}
+	r := rand.Float64() * total
+	for _, t := range available {
+		if r < t.weight {
+			return t.path
+		}
+		r -= t.weight
+	}
+	return available[len(available)-1].path
+}
+
+// redirectTarget is a RandomRedirect entry resolved to a full path.
+type redirectTarget struct {
+	path   string
+	weight float64
+}
+
+// redirectTargets returns the pickable RandomRedirect targets of the spec
+// at path: entries with a positive weight, 1 when not listed in weights.
+func redirectTargets(path string, entries []string, weights map[string]float64) []redirectTarget {
+	var ret []redirectTarget
+	for _, entry := range entries {
+		weight := 1.0
+		if w, ok := weights[entry]; ok {
+			weight = w
+		}
+		if weight <= 0 {
+			continue
+		}
+		ret = append(ret, redirectTarget{
+			path:   redirectPath(path, entry),
+			weight: weight,
+		})
+	}
+	return ret
+}
+
+// redirectPath resolves a redirect entry of the spec at path: relative
+// entries append to the path, absolute entries replace it.
+func redirectPath(path string, entry string) string {
+	if strings.HasPrefix(entry, "/") {
+		return entry[1:]
+	}
+	return path + "/" + entry
+}
+
+// NewGeneratorFromSpec builds the provider generator of a resolved spec,
+// without the wrappers GetGenerator adds.
+type NewGeneratorFromSpec func(spec Spec) (Generator, error)
+
+func (Module) NewGeneratorFromSpec(
 	newGemini NewGemini,
 	newHuoshan NewHuoshan,
 	newBaidu NewBaidu,
@@ -214,108 +363,174 @@ func (Module) GetGenerator(
 	newTencent NewTencent,
 	newOpenAI NewOpenAI,
 	newAliyun NewAliyun,
-	getSpecs GetGeneratorSpecs,
 	newZhipu NewZhipu,
 	newVercel NewVercel,
 	newNvidia NewNvidia,
 	newAzure NewAzure,
 	newBedrock NewBedrock,
 	newOpenCodeGo NewOpenCodeGo,
+	newAnthropic NewAnthropic,
+	newOpenAIResponses NewOpenAIResponses,
+	newReplay NewReplay,
+) NewGeneratorFromSpec {
+	return func(spec Spec) (Generator, error) {
+		switch strings.ToLower(spec.Type) {
+		case "open-router", "open_router", "openrouter":
+			return newOpenRouter(spec), nil
+		case "deepseek":
+			return newDeepseek(spec), nil
+		case "baidu":
+			return newBaidu(spec), nil
+		case "tencent":
+			return newTencent(spec), nil
+		case "openai", "open-ai", "open_ai":
+			return newOpenAI(spec, spec.APIKey), nil
+		case "huoshan":
+			return newHuoshan(spec), nil
+		case "gemini":
+			return newGemini(spec), nil
+		case "aliyun":
+			return newAliyun(spec), nil
+		case "zhipu":
+			return newZhipu(spec), nil
+		case "ollama":
+			if spec.BaseURL == "" {
+				spec.BaseURL = "http://127.0.0.1:11434/v1"
+			}
+			return newOpenAI(spec, ""), nil
+		case "vercel":
+			return newVercel(spec), nil
+		case "nvidia":
+			return newNvidia(spec), nil
+		case "azure":
+			return newAzure(spec), nil
+		case "bedrock":
+			return newBedrock(spec), nil
+		case "opencode-go", "opencode_go", "opencodego":
+			return newOpenCodeGo(spec), nil
+		case "anthropic", "claude":
+			return newAnthropic(spec), nil
+		case "responses", "openai-responses", "openai_responses":
+			return newOpenAIResponses(spec), nil
+		case "replay":
+			return newReplay(spec), nil
+		default:
+			return nil, fmt.Errorf("unknown generator type: %q", spec.Type)
+		}
+	}
+}
+
+func (Module) GetGenerator(
+	newFromSpec NewGeneratorFromSpec,
+	getSpecs GetGeneratorSpecs,
+	newFallback NewFallback,
+	responseCache *ResponseCache,
+	breaker *CircuitBreaker,
 	confidential ConfidentialMode,
+	calibration *TokenCalibration,
 ) GetGenerator {
-	return func(name string) (Generator, error) {
-
-		// user-defined first
+	// resolve returns the spec of name: a user-defined spec first, then
+	// the ollama shorthand and the built-in shortcuts.
+	resolve := func(name string) (Spec, error) {
 		specs, err := getSpecs()
 		if err != nil {
-			return nil, err
+			return Spec{}, err
 		}
-		if resolvedSpec, err := resolveSpec(name, specs); err == nil {
-			if err := confidential.check(resolvedSpec, name); err != nil {
-				return nil, err
-			}
-			switch strings.ToLower(resolvedSpec.Type) {
-			case "open-router", "open_router", "openrouter":
-				return newOpenRouter(resolvedSpec), nil
-			case "deepseek":
-				return newDeepseek(resolvedSpec), nil
-			case "baidu":
-				return newBaidu(resolvedSpec), nil
-			case "tencent":
-				return newTencent(resolvedSpec), nil
-			case "openai", "open-ai", "open_ai":
-				return newOpenAI(resolvedSpec, resolvedSpec.APIKey), nil
-			case "huoshan":
-				return newHuoshan(resolvedSpec), nil
-			case "gemini":
-				return newGemini(resolvedSpec), nil
-			case "aliyun":
-				return newAliyun(resolvedSpec), nil
-			case "zhipu":
-				return newZhipu(resolvedSpec), nil
-			case "ollama":
-				if resolvedSpec.BaseURL == "" {
-					resolvedSpec.BaseURL = "http://127.0.0.1:11434/v1"
-				}
-				return newOpenAI(resolvedSpec, ""), nil
-			case "vercel":
-				return newVercel(resolvedSpec), nil
-			case "nvidia":
-				return newNvidia(resolvedSpec), nil
-			case "azure":
-				return newAzure(resolvedSpec), nil
-			case "bedrock":
-				return newBedrock(resolvedSpec), nil
-			case "opencode-go", "opencode_go", "opencodego":
-				return newOpenCodeGo(resolvedSpec), nil
-			default:
-				return nil, fmt.Errorf("unknown generator type: %q", resolvedSpec.Type)
-			}
+		if resolvedSpec, err := resolveSpecWithBreaker(name, specs, breaker); err == nil {
+			return resolvedSpec, nil
 		}
 
 		// ollama
 		provider, modelName, ok := strings.Cut(name, ":")
 		if ok && provider == "ollama" {
-			spec := Spec{
+			return Spec{
+				Name:          name,
+				Type:          "ollama",
 				BaseURL:       "http://127.0.0.1:11434/v1",
 				Model:         modelName,
 				DisableSearch: new(true),
-			}
-			if err := confidential.check(spec, name); err != nil {
-				return nil, err
-			}
-			return newOpenAI(spec, ""), nil
+			}, nil
 		}
 
 		// built-ins
 		switch name {
 
 		case "flash", "gemini-flash":
-			spec := Spec{
+			return Spec{
+				Name:              name,
+				Type:              "gemini",
 				Model:             "models/gemini-flash-latest",
 				ContextTokens:     192 * K,
 				MaxGenerateTokens: new(32 * K),
 				Temperature:       new(float32(0.1)),
-			}
-			if err := confidential.check(spec, name); err != nil {
-				return nil, err
-			}
-			return newGemini(spec), nil
+			}, nil
 
 		case "gemini", "pro", "gemini-pro":
-			spec := Spec{
+			return Spec{
+				Name:              name,
+				Type:              "gemini",
 				Model:             "models/gemini-pro-latest",
 				ContextTokens:     192 * K,
 				MaxGenerateTokens: new(32 * K),
 				Temperature:       new(float32(0.1)),
-			}
-			if err := confidential.check(spec, name); err != nil {
-				return nil, err
-			}
-			return newGemini(spec), nil
+			}, nil
 
 		}
 
-		return nil, fmt.Errorf("invalid model: %s", name)
+		return Spec{}, fmt.Errorf("invalid model: %s", name)
+	}
+
+	// chain builds the generator of spec with its fallback chain, without
+	// the wrappers applied once around the chain. Fallback resolves its
+	// targets with getChain, so a nested chain is a *Fallback to flatten
+	// and a fallback target is not cached or recorded twice. See
+	// TheoryOfFallback.
+	var getChain GetGenerator
+	chain := func(spec Spec, name string) (Generator, error) {
+		if err := confidential.check(spec, name); err != nil {
+			return nil, err
+		}
+		gen, err := newFromSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		// See TheoryOfTokenCalibration.
+		if !strings.EqualFold(spec.Type, "replay") {
+			gen = calibration.Wrap(gen)
+		}
+		gen = breaker.Monitor(gen)
+		// See TheoryOfFallback.
+		if len(spec.Fallback) > 0 {
+			gen = newFallback(gen, spec.Fallback, getChain)
+		}
+		return gen, nil
+	}
+	getChain = func(name string) (Generator, error) {
+		spec, err := resolve(name)
+		if err != nil {
+			return nil, err
+		}
+		return chain(spec, name)
+	}
+
+	getGenerator := func(name string) (Generator, error) {
+		spec, err := resolve(name)
+		if err != nil {
+			return nil, err
+		}
+		gen, err := chain(spec, name)
+		if err != nil {
+			return nil, err
+		}
+		// See TheoryOfResponseCache.
+		if !strings.EqualFold(spec.Type, "replay") {
+			gen = responseCache.Wrap(gen)
+		}
+		// record mode, see TheoryOfReplay
+		if spec.Cassette != "" && !strings.EqualFold(spec.Type, "replay") {
+			gen = NewRecording(gen, spec.Cassette)
+		}
+		return gen, nil
 	}
+	return getGenerator
 }
@@ -3,6 +3,7 @@ package generators
 import (
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -1089,6 +1090,73 @@ func TestResolveSpecRandomRedirect(t *testing.T) {
 	})
 }
 
+func TestResolveSpecFallback(t *testing.T) {
+	localRoots := []Spec{
+		{
+			Name:     "main",
+			Type:     "gemini",
+			Redirect: "/provider/primary",
+			Fallback: []string{"/provider/backup"},
+		},
+		{
+			Name: "provider",
+			Type: "openai",
+			Variants: []Spec{
+				{
+					Name:     "primary",
+					Model:    "primary-model",
+					Fallback: []string{"cheap", "/provider/backup"},
+					Variants: []Spec{
+						{
+							Name:  "cheap",
+							Model: "cheap-model",
+						},
+					},
+				},
+				{
+					Name:  "backup",
+					Model: "backup-model",
+				},
+			},
+		},
+	}
+
+	t.Run("relative and absolute entries", func(t *testing.T) {
+		s, err := resolveSpec("provider/primary", localRoots)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"provider/primary/cheap", "provider/backup"}
+		if !slices.Equal(s.Fallback, want) {
+			t.Fatalf("got %v, want %v", s.Fallback, want)
+		}
+	})
+
+	t.Run("redirecting spec keeps its fallbacks", func(t *testing.T) {
+		s, err := resolveSpec("main", localRoots)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Name != "provider/primary" {
+			t.Fatalf("got name %q", s.Name)
+		}
+		want := []string{"provider/backup", "provider/primary/cheap"}
+		if !slices.Equal(s.Fallback, want) {
+			t.Fatalf("got %v, want %v", s.Fallback, want)
+		}
+	})
+
+	t.Run("not inherited by children", func(t *testing.T) {
+		s, err := resolveSpec("provider/primary/cheap", localRoots)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(s.Fallback) != 0 {
+			t.Fatalf("got %v", s.Fallback)
+		}
+	})
+}
+
 func TestResolveSpecMaxThinkingTokens(t *testing.T) {
 	t.Run("inherited from parent", func(t *testing.T) {
 		localRoots := []Spec{
@@ -1342,3 +1410,35 @@ func TestConfidentialModeRejectsNonZeroDataRetention(t *testing.T) {
 		}
 	})
 }
+
+func TestGetGeneratorBuiltinWrappers(t *testing.T) {
+	// The built-in shortcuts and the ollama shorthand get the wrappers of
+	// user-defined specs. See TheoryOfResponseCache.
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Fork(
+		func() configs.Loader {
+			return configs.NewLoader([]string{}, configs.LoaderConfig{})
+		},
+		func() ResponseCacheEnabled {
+			return true
+		},
+		func() ResponseCacheDir {
+			return ResponseCacheDir(t.TempDir())
+		},
+	).Call(func(get GetGenerator) {
+		for _, name := range []string{"flash", "pro", "ollama:llama3"} {
+			gen, err := get(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := gen.(*cachedGenerator); !ok {
+				t.Fatalf("%s: got %T", name, gen)
+			}
+			if spec := gen.Spec(); spec.Name != name {
+				t.Fatalf("%s: got spec %+v", name, spec)
+			}
+		}
+	})
+}
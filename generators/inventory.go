@@ -0,0 +1,420 @@
+package generators
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/reusee/tai/flags"
+)
+
+const TheoryOfModelInventory = `
+Nested Variants, Aliases, Redirect and RandomRedirect make a model name hard
+to follow by reading the configuration: -model fast may pass through an
+alias, a random pick and an absolute redirect before reaching a spec, and
+the fields of that spec are merged from every ancestor. The model inventory
+(the tai models command) prints the spec tree from GetGeneratorSpecs with,
+for every path, its aliases, its redirect or weighted random redirect
+entries, and every spec it can resolve to with the merged fields and the
+confidential mode eligibility (ZeroDataRetention, see
+TheoryOfConfidentialMode).
+
+Targets are found by the resolver itself (resolveSpecWith) with a pick that
+walks every RandomRedirect entry instead of choosing one, so the inventory
+follows exactly the rules of GetGenerator (see TheoryOfSpec); each target
+carries its share, the product of the weight fractions along the picks, and
+resolution errors (a missing spec, a redirect cycle) are printed in place.
+The walk is bounded by maxSpecTargets per path. Circuit breaker state is
+ignored: the inventory describes the configuration, not the moment.
+
+Configured models drift: providers retire models, and a spec keeps pointing
+at the old name until a request fails. With -check the inventory builds the
+provider generator of every target (NewGeneratorFromSpec, without the
+GetGenerator wrappers) and, when it implements ModelLister, asks the
+provider for its models: GET /models for OpenAI-compatible endpoints, GET
+/api/tags for Ollama, the model listing of the Gemini API. A listing is
+fetched once per provider type, base URL and key. Targets whose model is not
+listed are flagged; generators without a listing (Azure deployments,
+Anthropic, Bedrock, replay) are not checked. Names are compared without the
+Gemini "models/" prefix and the Ollama ":latest" tag.
+
+The built-in shortcuts (flash, gemini, pro) and the ollama:<model>
+shorthand are not configured specs; GetGenerator builds a spec named after
+the shortcut for them, and they are listed after the tree for completeness.
+`
+
+// maxSpecTargets bounds the targets walked for one spec path.
+const maxSpecTargets = 64
+
+// SpecInventoryEntry is a spec of the tree with the specs its path
+// resolves to. See TheoryOfModelInventory.
+type SpecInventoryEntry struct {
+	Path  string
+	Depth int
+	// Spec is the spec as configured, without merging.
+	Spec    Spec
+	Targets []SpecTarget
+}
+
+// SpecTarget is a spec a path can resolve to.
+type SpecTarget struct {
+	// Spec is the merged spec; Spec.Name is the resolved path.
+	Spec Spec
+	// Share is the probability of resolving to this target, below 1 when
+	// random redirects are involved.
+	Share float64
+	Err   error
+	Check ModelCheck
+}
+
+// ModelCheck is the outcome of looking up a target's model in its
+// provider's model listing.
+type ModelCheck struct {
+	Checked bool
+	Listed  bool
+	Err     error
+}
+
+// ModelLister is implemented by generators whose provider lists its
+// models. See TheoryOfModelInventory.
+type ModelLister interface {
+	ListModels(ctx context.Context) ([]string, error)
+}
+
+var errModelListingUnsupported = errors.New("model listing not supported")
+
+// specInventory returns the entries of the spec tree in depth-first order.
+func specInventory(roots []Spec) (ret []SpecInventoryEntry) {
+	var walk func(spec Spec, prefix string, depth int)
+	walk = func(spec Spec, prefix string, depth int) {
+		path := spec.Name
+		if prefix != "" {
+			path = prefix + "/" + spec.Name
+		}
+		ret = append(ret, SpecInventoryEntry{
+			Path:    path,
+			Depth:   depth,
+			Spec:    spec,
+			Targets: specTargets(path, roots),
+		})
+		for _, child := range spec.Variants {
+			walk(child, path, depth+1)
+		}
+	}
+	for _, root := range roots {
+		walk(root, "", 0)
+	}
+	return
+}
+
+// specTargets returns every spec name can resolve to, walking all
+// RandomRedirect entries. Each resolution records the number of entries at
+// every pick; picks past the forced prefix take the first entry, and the
+// other entries of those picks are walked by further resolutions.
+func specTargets(name string, roots []Spec) (ret []SpecTarget) {
+	var walk func(prefix []int)
+	walk = func(prefix []int) {
+		if len(ret) >= maxSpecTargets {
+			return
+		}
+		var branches []int
+		share := 1.0
+		spec, err := resolveSpecWith(name, roots, func(path string, entries []string, weights map[string]float64) string {
+			targets := redirectTargets(path, entries, weights)
+			if len(targets) == 0 {
+				// every weight is non-positive; the pick is uniform
+				for _, entry := range entries {
+					targets = append(targets, redirectTarget{
+						path:   redirectPath(path, entry),
+						weight: 1,
+					})
+				}
+			}
+			i := 0
+			if len(branches) < len(prefix) {
+				i = prefix[len(branches)]
+			}
+			branches = append(branches, len(targets))
+			var total float64
+			for _, t := range targets {
+				total += t.weight
+			}
+			share *= targets[i].weight / total
+			return targets[i].path
+		})
+		ret = append(ret, SpecTarget{
+			Spec:  spec,
+			Share: share,
+			Err:   err,
+		})
+		for j := len(prefix); j < len(branches); j++ {
+			for i := 1; i < branches[j]; i++ {
+				next := make([]int, j+1)
+				copy(next, prefix)
+				next[j] = i
+				walk(next)
+			}
+		}
+	}
+	walk(nil)
+	return
+}
+
+// checkModels looks up the model of every target in its provider's
+// listing, fetching each listing once.
+func checkModels(ctx context.Context, newFromSpec NewGeneratorFromSpec, entries []SpecInventoryEntry) {
+	type listing struct {
+		models []string
+		err    error
+	}
+	listings := make(map[string]listing)
+	for i := range entries {
+		for j := range entries[i].Targets {
+			target := &entries[i].Targets[j]
+			if target.Err != nil || target.Spec.Model == "" {
+				continue
+			}
+			generator, err := newFromSpec(target.Spec)
+			if err != nil {
+				target.Check = ModelCheck{Checked: true, Err: err}
+				continue
+			}
+			lister, ok := generator.(ModelLister)
+			if !ok {
+				continue
+			}
+			spec := generator.Spec()
+			key := strings.ToLower(spec.Type) + "\x00" + spec.BaseURL + "\x00" + spec.APIKey
+			l, ok := listings[key]
+			if !ok {
+				l.models, l.err = lister.ListModels(ctx)
+				listings[key] = l
+			}
+			if errors.Is(l.err, errModelListingUnsupported) {
+				continue
+			}
+			target.Check = ModelCheck{
+				Checked: true,
+				Listed:  l.err == nil && modelListed(spec.Model, l.models),
+				Err:     l.err,
+			}
+		}
+	}
+}
+
+// modelListed reports whether model is in listed, ignoring the Gemini
+// "models/" prefix and the Ollama ":latest" tag.
+func modelListed(model string, listed []string) bool {
+	normalize := func(name string) string {
+		return strings.TrimSuffix(strings.TrimPrefix(name, "models/"), ":latest")
+	}
+	model = normalize(model)
+	for _, name := range listed {
+		if normalize(name) == model {
+			return true
+		}
+	}
+	return false
+}
+
+// writeSpecInventory writes the tree, one spec per line indented by depth,
+// each followed by its targets.
+func writeSpecInventory(w io.Writer, entries []SpecInventoryEntry) error {
+	b := new(strings.Builder)
+	for _, entry := range entries {
+		indent := strings.Repeat("  ", entry.Depth)
+		b.WriteString(indent + entry.Spec.Name)
+		if len(entry.Spec.Aliases) > 0 {
+			fmt.Fprintf(b, " (aliases: %s)", strings.Join(entry.Spec.Aliases, ", "))
+		}
+		switch {
+		case entry.Spec.Redirect != "":
+			fmt.Fprintf(b, " -> %s", entry.Spec.Redirect)
+		case len(entry.Spec.RandomRedirect) > 0:
+			var picks []string
+			for _, e := range entry.Spec.RandomRedirect {
+				if w, ok := entry.Spec.RandomRedirectWeights[e]; ok {
+					e += " x" + strconv.FormatFloat(w, 'g', -1, 64)
+				}
+				picks = append(picks, e)
+			}
+			fmt.Fprintf(b, " -> random: %s", strings.Join(picks, ", "))
+		}
+		b.WriteString("\n")
+		writeSpecTargets(b, indent+"  ", entry.Path, entry.Targets)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeSpecTargets writes one line per target; the resolved path is
+// omitted when it is the path itself.
+func writeSpecTargets(b *strings.Builder, indent string, path string, targets []SpecTarget) {
+	for _, target := range targets {
+		b.WriteString(indent + "= ")
+		if target.Err != nil {
+			fmt.Fprintf(b, "error: %v\n", target.Err)
+			continue
+		}
+		if target.Spec.Name != path {
+			b.WriteString(target.Spec.Name + ": ")
+		}
+		b.WriteString(formatSpecFields(target.Spec))
+		if target.Share < 1 {
+			fmt.Fprintf(b, " [%.0f%%]", target.Share*100)
+		}
+		switch check := target.Check; {
+		case !check.Checked:
+		case check.Err != nil:
+			fmt.Fprintf(b, " [check failed: %v]", check.Err)
+		case !check.Listed:
+			b.WriteString(" [MISSING: not listed by the provider]")
+		default:
+			b.WriteString(" [listed]")
+		}
+		b.WriteString("\n")
+	}
+}
+
+// formatSpecFields renders the set fields of a merged spec as key=value
+// pairs. The API key is never shown.
+func formatSpecFields(spec Spec) string {
+	var fields []string
+	add := func(key string, value any) {
+		fields = append(fields, fmt.Sprintf("%s=%v", key, value))
+	}
+	str := func(key string, value string) {
+		if value != "" {
+			add(key, value)
+		}
+	}
+	num := func(key string, value *float64) {
+		if value != nil {
+			add(key, *value)
+		}
+	}
+	flag := func(key string, value *bool) {
+		if value != nil {
+			add(key, *value)
+		}
+	}
+	str("type", spec.Type)
+	str("model", spec.Model)
+	str("base_url", spec.BaseURL)
+	str("family", spec.Family)
+	if spec.ContextTokens != 0 {
+		add("context_tokens", spec.ContextTokens)
+	}
+	if spec.MaxGenerateTokens != nil {
+		add("max_generate_tokens", *spec.MaxGenerateTokens)
+	}
+	if spec.MaxThinkingTokens != nil {
+		add("max_thinking_tokens", *spec.MaxThinkingTokens)
+	}
+	if spec.Temperature != nil {
+		add("temperature", *spec.Temperature)
+	}
+	str("reasoning_effort", spec.ReasoningEffort)
+	str("service_tier", spec.ServiceTier)
+	str("tokenizer", spec.Tokenizer)
+	if spec.RequestsPerMinute != 0 {
+		add("requests_per_minute", spec.RequestsPerMinute)
+	}
+	if spec.TokensPerMinute != 0 {
+		add("tokens_per_minute", spec.TokensPerMinute)
+	}
+	num("input_price", spec.InputPrice)
+	num("cached_input_price", spec.CachedInputPrice)
+	num("output_price", spec.OutputPrice)
+	num("thinking_price", spec.ThinkingPrice)
+	flag("disable_tools", spec.DisableTools)
+	flag("native_tools", spec.NativeTools)
+	flag("disable_search", spec.DisableSearch)
+	flag("preserved_thinking", spec.PreservedThinking)
+	flag("cache_control", spec.CacheControl)
+	if len(spec.Fallback) > 0 {
+		add("fallback", strings.Join(spec.Fallback, ","))
+	}
+	confidential := "no"
+	if spec.ZeroDataRetention != nil && *spec.ZeroDataRetention {
+		confidential = "yes"
+	}
+	add("confidential", confidential)
+	return strings.Join(fields, " ")
+}
+
+// builtinModels describes the model names GetGenerator resolves without a
+// spec.
+const builtinModels = `built-in:
+  flash, gemini-flash = type=gemini model=models/gemini-flash-latest confidential=no
+  gemini, pro, gemini-pro = type=gemini model=models/gemini-pro-latest confidential=no
+  ollama:<model> = type=ollama model=<model> base_url=http://127.0.0.1:11434/v1 confidential=no
+`
+
+// ModelInventory writes the model inventory to output: what the -model
+// flag resolves to, the spec tree, and the built-in names. With check,
+// targets are looked up in the providers' model listings. See
+// TheoryOfModelInventory.
+type ModelInventory func(ctx context.Context, check bool, output io.Writer) error
+
+func (Module) ModelInventory(
+	getSpecs GetGeneratorSpecs,
+	newFromSpec NewGeneratorFromSpec,
+	modelName flags.ModelName,
+) ModelInventory {
+	return func(ctx context.Context, check bool, output io.Writer) error {
+		specs, err := getSpecs()
+		if err != nil {
+			return err
+		}
+		entries := specInventory(specs)
+		// the -model flag first, as an entry outside the tree; names
+		// that are not specs are among the built-ins
+		if modelName != "" {
+			targets := specTargets(string(modelName), specs)
+			if len(targets) > 1 || targets[0].Err == nil {
+				entries = append([]SpecInventoryEntry{
+					{
+						Path: string(modelName),
+						Spec: Spec{
+							Name: "-model " + string(modelName),
+						},
+						Targets: targets,
+					},
+				}, entries...)
+			}
+		}
+		if check {
+			checkModels(ctx, newFromSpec, entries)
+		}
+		if err := writeSpecInventory(output, entries); err != nil {
+			return err
+		}
+		_, err = io.WriteString(output, builtinModels)
+		return err
+	}
+}
+
+// CheckModels makes the models command look up every target in its
+// provider's model listing. See TheoryOfModelInventory.
+type CheckModels bool
+
+func (Module) CheckModels() CheckModels {
+	return false
+}
+
+var _ flags.Flag = CheckModels(false)
+
+func (c CheckModels) Keys() map[string]string {
+	return map[string]string{
+		"-check": "Query provider model listings to flag specs whose model no longer exists (with the models command)",
+	}
+}
+
+func (c CheckModels) Handle(key string, args []string) (newDef any, remainArgs []string, err error) {
+	ret := CheckModels(true)
+	return &ret, args, nil
+}
@@ -0,0 +1,151 @@
+package generators
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/reusee/dscope"
+	"github.com/reusee/tai/flags"
+	"github.com/reusee/tai/modes"
+	"github.com/reusee/tai/nets"
+)
+
+func TestSpecTargets(t *testing.T) {
+	roots := []Spec{
+		{
+			Name:           "fast",
+			Type:           "openai",
+			Aliases:        []string{"f"},
+			RandomRedirect: []string{"a", "/other"},
+			RandomRedirectWeights: map[string]float64{
+				"a": 3,
+			},
+			Variants: []Spec{
+				{Name: "a", Model: "model-a"},
+			},
+		},
+		{
+			Name:           "other",
+			Type:           "gemini",
+			RandomRedirect: []string{"x", "y", "missing"},
+			RandomRedirectWeights: map[string]float64{
+				"missing": 0,
+			},
+			Variants: []Spec{
+				{Name: "x", Model: "model-x"},
+				{Name: "y", Redirect: "/loop"},
+			},
+		},
+		{
+			Name:     "loop",
+			Redirect: "/loop",
+		},
+	}
+
+	targets := specTargets("f", roots)
+	type result struct {
+		name  string
+		share float64
+		err   bool
+	}
+	var got []result
+	for _, target := range targets {
+		got = append(got, result{target.Spec.Name, target.Share, target.Err != nil})
+	}
+	want := []result{
+		{"fast/a", 0.75, false},
+		{"other/x", 0.125, false},
+		{"", 0.125, true},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got %v", got)
+	}
+	if targets[0].Spec.Type != "openai" || targets[0].Spec.Model != "model-a" {
+		t.Fatalf("expecting merged fields, got %+v", targets[0].Spec)
+	}
+}
+
+func TestModelInventory(t *testing.T) {
+	modelRequests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/models":
+			modelRequests++
+			fmt.Fprint(w, `{"data": [{"id": "known"}]}`)
+		case "/api/tags":
+			fmt.Fprint(w, `{"models": [{"name": "llama3:latest"}]}`)
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Fork(
+		func() nets.HTTPClient {
+			return nets.HTTPClient{Client: server.Client()}
+		},
+		func() GetGeneratorSpecs {
+			return func() ([]Spec, error) {
+				return []Spec{
+					{
+						Name:              "remote",
+						Type:              "openai",
+						BaseURL:           server.URL,
+						APIKey:            "secret-key",
+						ZeroDataRetention: new(true),
+						Variants: []Spec{
+							{Name: "known", Model: "known", Aliases: []string{"k"}},
+							{Name: "gone", Model: "gone"},
+						},
+					},
+					{
+						Name:    "local",
+						Type:    "ollama",
+						BaseURL: server.URL + "/v1",
+						Model:   "llama3",
+					},
+				}, nil
+			}
+		},
+		func() flags.ModelName {
+			return "k"
+		},
+	).Call(func(
+		modelInventory ModelInventory,
+	) {
+		var buf bytes.Buffer
+		if err := modelInventory(context.Background(), true, &buf); err != nil {
+			t.Fatal(err)
+		}
+		out := buf.String()
+		for _, want := range []string{
+			"-model k\n  = remote/known: type=openai model=known",
+			"  known (aliases: k)\n    = type=openai model=known base_url=" + server.URL + " confidential=yes [listed]\n",
+			"model=gone base_url=" + server.URL + " confidential=yes [MISSING",
+			"local\n  = type=ollama model=llama3",
+			"flash, gemini-flash",
+		} {
+			if !strings.Contains(out, want) {
+				t.Fatalf("missing %q in output:\n%s", want, out)
+			}
+		}
+		if strings.Contains(out, "secret-key") {
+			t.Fatalf("API key in output:\n%s", out)
+		}
+		if strings.Count(out, "[listed]") != 3 {
+			t.Fatalf("expecting the -model target, known and llama3 listed:\n%s", out)
+		}
+		if modelRequests != 1 {
+			t.Fatalf("expecting one listing per provider, got %d", modelRequests)
+		}
+	})
+}
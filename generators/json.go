@@ -0,0 +1,259 @@
+package generators
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+const TheoryOfStructuredOutput = `
+Callers that want data rather than prose — a list of memory items, an
+analysis report — should not each assemble a schema and scrape the text
+response. GenerateJSON[T] derives the schema from T with toVar, the
+derivation MakeFunc uses for function parameters (property names follow
+json tags), asks the generator for a JSON value, and decodes it into T.
+
+A non-object T (a slice, a string) is wrapped as the "value" property of
+an object schema, because providers require an object at the root of a
+response schema; the wrapper is removed before decoding.
+
+Providers that support response schemas get the schema as
+GenerateOptions.ResponseSchema, which each generator sends in its native
+form: Gemini's responseSchema, the json_schema response format of
+OpenAI-compatible chat completions and of the Responses API (in the strict
+form, see Var.ToOpenAIStrict), and Anthropic's output format. Spec
+SupportsResponseSchema selects these by spec type, excluding models
+without the json_schema capability (see TheoryOfCapabilities). Every
+other provider gets the schema in a user message instructing it to answer with only the
+JSON value.
+
+Either way, the answer is checked before decoding: the JSON is extracted
+from the response text (tolerating code fences and surrounding prose) and
+validated against the schema (types and required properties). A response
+that fails is answered with a user message naming the error, and the
+model is asked again, up to maxJSONAttempts times, so a provider that
+honors schemas loosely still converges. Generator errors are returned
+as is; retrying them is the generators' business (see TheoryOfRetry).
+`
+
+// maxJSONAttempts bounds the generations of GenerateJSON.
+const maxJSONAttempts = 3
+
+// ErrInvalidJSON is returned by GenerateJSON when no attempt produced a
+// valid value.
+var ErrInvalidJSON = errors.New("invalid JSON response")
+
+// SupportsResponseSchema reports whether the spec's provider and model
+// accept a response schema. See TheoryOfStructuredOutput and
+// TheoryOfCapabilities.
+func (s Spec) SupportsResponseSchema() bool {
+	if !s.Supports(CapabilityJSONSchema) {
+		return false
+	}
+	switch strings.ToLower(s.Type) {
+	case "gemini",
+		"openai", "open-ai", "open_ai",
+		"azure",
+		"open-router", "open_router", "openrouter",
+		"responses", "openai-responses", "openai_responses",
+		"anthropic", "claude":
+		return true
+	}
+	return false
+}
+
+// GenerateJSON generates a JSON value matching the schema of T with
+// generator, continuing state, and decodes it. options may be nil. See
+// TheoryOfStructuredOutput.
+func GenerateJSON[T any](ctx context.Context, generator Generator, state State, options *GenerateOptions) (ret T, err error) {
+	schema := toVar(reflect.TypeFor[T](), "response")
+	wrapped := schema.Type != TypeObject
+	if wrapped {
+		schema.Name = "value"
+		schema.Optional = false
+		schema = Var{
+			Name:       "response",
+			Type:       TypeObject,
+			Properties: Vars{schema},
+		}
+	}
+
+	var opts GenerateOptions
+	if options != nil {
+		opts = *options
+	}
+	if generator.Spec().SupportsResponseSchema() {
+		opts.ResponseSchema = &schema
+	} else {
+		schemaJSON, err := json.MarshalIndent(schema.ToOpenAI(), "", "  ")
+		if err != nil {
+			return ret, err
+		}
+		state, err = state.AppendContent(&Content{
+			Role: RoleUser,
+			Parts: []Part{
+				Text("Respond with only a JSON value conforming to the following JSON schema. Do not add any other text.\n\n" + string(schemaJSON)),
+			},
+		})
+		if err != nil {
+			return ret, err
+		}
+	}
+
+	var lastErr error
+	for range maxJSONAttempts {
+		numContents := CountContents(state)
+		state, err = generator.Generate(ctx, state, &opts)
+		if err != nil {
+			return ret, err
+		}
+
+		var text strings.Builder
+		i := 0
+		for content := range state.Contents() {
+			i++
+			if i <= numContents || (content.Role != RoleModel && content.Role != RoleAssistant) {
+				continue
+			}
+			for _, part := range content.Parts {
+				if t, ok := part.(Text); ok {
+					text.WriteString(string(t))
+				}
+			}
+		}
+
+		lastErr = decodeJSON(text.String(), schema, wrapped, &ret)
+		if lastErr == nil {
+			return ret, nil
+		}
+		state, err = state.AppendContent(&Content{
+			Role: RoleUser,
+			Parts: []Part{
+				Text(fmt.Sprintf("The response is not valid: %v. Respond again with only the corrected JSON value.", lastErr)),
+			},
+		})
+		if err != nil {
+			return ret, err
+		}
+	}
+	return ret, fmt.Errorf("%w after %d attempts: %v", ErrInvalidJSON, maxJSONAttempts, lastErr)
+}
+
+// decodeJSON extracts the JSON value from text, validates it against
+// schema and decodes it into target. When wrapped, the value of the
+// "value" property is decoded.
+func decodeJSON(text string, schema Var, wrapped bool, target any) error {
+	raw := extractJSON(text)
+	if raw == "" {
+		return fmt.Errorf("no JSON value found")
+	}
+	var value any
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+	if err := validateJSON(schema, value, "$"); err != nil {
+		return err
+	}
+	data := []byte(raw)
+	if wrapped {
+		var err error
+		data, err = json.Marshal(value.(map[string]any)["value"])
+		if err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(data, target)
+}
+
+// extractJSON returns the JSON value in text, removing code fences and
+// any prose around the outermost object or array.
+func extractJSON(text string) string {
+	text = strings.TrimSpace(text)
+	if rest, ok := strings.CutPrefix(text, "```"); ok {
+		// drop the info string and the closing fence
+		if _, body, ok := strings.Cut(rest, "\n"); ok {
+			rest = body
+		}
+		if idx := strings.LastIndex(rest, "```"); idx >= 0 {
+			rest = rest[:idx]
+		}
+		text = strings.TrimSpace(rest)
+	}
+	if json.Valid([]byte(text)) {
+		return text
+	}
+	start := strings.IndexAny(text, "{[")
+	if start < 0 {
+		return ""
+	}
+	closer := "}"
+	if text[start] == '[' {
+		closer = "]"
+	}
+	end := strings.LastIndex(text, closer)
+	if end < start {
+		return ""
+	}
+	return text[start : end+1]
+}
+
+// validateJSON checks a decoded value against v.
+func validateJSON(v Var, value any, path string) error {
+	if value == nil {
+		if v.Optional {
+			return nil
+		}
+		return fmt.Errorf("%s: missing required value", path)
+	}
+	switch v.Type {
+	case TypeString:
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s: expected a string", path)
+		}
+	case TypeNumber:
+		if _, ok := value.(json.Number); !ok {
+			return fmt.Errorf("%s: expected a number", path)
+		}
+	case TypeInteger:
+		n, ok := value.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s: expected an integer", path)
+		}
+		if f, err := n.Float64(); err != nil || f != math.Trunc(f) {
+			return fmt.Errorf("%s: expected an integer", path)
+		}
+	case TypeBoolean:
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected a boolean", path)
+		}
+	case TypeArray:
+		items, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an array", path)
+		}
+		if v.ItemType != nil {
+			for i, item := range items {
+				if err := validateJSON(*v.ItemType, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case TypeObject:
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an object", path)
+		}
+		for _, prop := range v.Properties {
+			if err := validateJSON(prop, obj[prop.Name], path+"."+prop.Name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
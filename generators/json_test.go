@@ -0,0 +1,156 @@
+package generators
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type jsonTestGenerator struct {
+	spec      Spec
+	responses []string
+	options   *[]*GenerateOptions
+	prompts   *[]string
+}
+
+func (g jsonTestGenerator) Spec() Spec {
+	return g.spec
+}
+
+func (g jsonTestGenerator) CountTokens(text string) (int, error) {
+	return len(text), nil
+}
+
+func (g jsonTestGenerator) Generate(ctx context.Context, state State, options *GenerateOptions) (State, error) {
+	n := len(*g.options)
+	*g.options = append(*g.options, options)
+	var prompt strings.Builder
+	for content := range state.Contents() {
+		for _, part := range content.Parts {
+			if text, ok := part.(Text); ok {
+				prompt.WriteString(string(text))
+			}
+		}
+	}
+	*g.prompts = append(*g.prompts, prompt.String())
+	return state.AppendContent(&Content{
+		Role:  RoleModel,
+		Parts: []Part{Text(g.responses[n])},
+	})
+}
+
+type jsonTestReport struct {
+	Title string   `json:"title"`
+	Tags  []string `json:"tags"`
+	Score int      `json:"score,omitempty"`
+}
+
+func TestGenerateJSONResponseSchema(t *testing.T) {
+	var options []*GenerateOptions
+	var prompts []string
+	gen := jsonTestGenerator{
+		spec: Spec{Type: "gemini"},
+		responses: []string{
+			`{"title": "foo", "tags": ["a", "b"]}`,
+		},
+		options: &options,
+		prompts: &prompts,
+	}
+	report, err := GenerateJSON[jsonTestReport](t.Context(), gen, NewPrompts("", nil), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Title != "foo" || len(report.Tags) != 2 {
+		t.Fatalf("got %+v", report)
+	}
+	if len(options) != 1 {
+		t.Fatalf("expected one generation, got %d", len(options))
+	}
+	schema := options[0].ResponseSchema
+	if schema == nil {
+		t.Fatal("expected response schema")
+	}
+	if len(schema.Properties) != 3 {
+		t.Fatalf("got %+v", schema.Properties)
+	}
+	for _, prop := range schema.Properties {
+		switch prop.Name {
+		case "title", "tags":
+			if prop.Optional {
+				t.Fatalf("%s should be required", prop.Name)
+			}
+		case "score":
+			if !prop.Optional {
+				t.Fatal("score should be optional")
+			}
+		default:
+			t.Fatalf("unexpected property %s", prop.Name)
+		}
+	}
+	if strings.Contains(prompts[0], "JSON schema") {
+		t.Fatal("schema should not be in the prompt")
+	}
+}
+
+func TestGenerateJSONPromptFallbackRetry(t *testing.T) {
+	var options []*GenerateOptions
+	var prompts []string
+	gen := jsonTestGenerator{
+		spec: Spec{Type: "ollama"},
+		responses: []string{
+			`{"value": "not a list"}`,
+			"Here it is:\n```json\n{\"value\": [\"x\", \"y\"]}\n```",
+		},
+		options: &options,
+		prompts: &prompts,
+	}
+	tags, err := GenerateJSON[[]string](t.Context(), gen, NewPrompts("", nil), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 2 || tags[0] != "x" || tags[1] != "y" {
+		t.Fatalf("got %v", tags)
+	}
+	if len(options) != 2 {
+		t.Fatalf("expected two generations, got %d", len(options))
+	}
+	if options[0].ResponseSchema != nil {
+		t.Fatal("unexpected response schema")
+	}
+	if !strings.Contains(prompts[0], "JSON schema") {
+		t.Fatal("expected schema in the prompt")
+	}
+	if !strings.Contains(prompts[1], "$.value: expected an array") {
+		t.Fatalf("expected validation error in the retry prompt: %s", prompts[1])
+	}
+}
+
+func TestGenerateJSONInvalid(t *testing.T) {
+	var options []*GenerateOptions
+	var prompts []string
+	gen := jsonTestGenerator{
+		spec: Spec{Type: "ollama"},
+		responses: []string{
+			"no json",
+			`{"tags": []}`,
+			`{"title": 1, "tags": []}`,
+		},
+		options: &options,
+		prompts: &prompts,
+	}
+	_, err := GenerateJSON[jsonTestReport](t.Context(), gen, NewPrompts("", nil), nil)
+	if !errors.Is(err, ErrInvalidJSON) {
+		t.Fatalf("got %v", err)
+	}
+	if len(options) != maxJSONAttempts {
+		t.Fatalf("expected %d generations, got %d", maxJSONAttempts, len(options))
+	}
+}
+
+func TestSupportsResponseSchemaTypeCase(t *testing.T) {
+	// NewGeneratorFromSpec lower-cases the type, so must this
+	if !(Spec{Type: "Gemini"}).SupportsResponseSchema() {
+		t.Fatal("type should be case-insensitive")
+	}
+}
@@ -35,6 +35,9 @@ type OpenAI struct {
 	TapFlag              dscope.Inject[TapOpenAI]
 	FuncDecls            dscope.Inject[FuncDecls]
 	EventRecorder        dscope.Inject[EventRecorder]
+	RateLimiters         dscope.Inject[*RateLimiters]
+	CapabilityWarnings   dscope.Inject[*CapabilityWarnings]
+	Tokenizers           dscope.Inject[Tokenizers]
 }
 
 var _ Generator = new(OpenAI)
@@ -54,18 +57,65 @@ func (o *OpenAI) recordEvent(typ string, detail string) {
 	}
 }
 
+var _ ModelLister = new(OpenAI)
+
+// ListModels returns the models served at the base URL: GET /models, or
+// GET /api/tags for Ollama. Azure deployments have no listing. See
+// TheoryOfModelInventory.
+func (o *OpenAI) ListModels(ctx context.Context) ([]string, error) {
+	if o.spec.IsAzure != nil && *o.spec.IsAzure {
+		return nil, errModelListingUnsupported
+	}
+	var ret []string
+	if strings.EqualFold(o.spec.Type, "ollama") {
+		var tags struct {
+			Models []struct {
+				Name string `json:"name"`
+			} `json:"models"`
+		}
+		base := strings.TrimSuffix(strings.TrimSuffix(o.spec.BaseURL, "/"), "/v1")
+		if err := o.apiRequest(ctx, o.client, http.MethodGet, base+"/api/tags", "", nil, &tags); err != nil {
+			return nil, err
+		}
+		for _, model := range tags.Models {
+			ret = append(ret, model.Name)
+		}
+		return ret, nil
+	}
+	var models struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := o.apiRequest(ctx, o.client, http.MethodGet, o.apiURL("/models"), "", nil, &models); err != nil {
+		return nil, err
+	}
+	for _, model := range models.Data {
+		ret = append(ret, model.ID)
+	}
+	return ret, nil
+}
+
 func (o *OpenAI) CountTokens(text string) (int, error) {
+	var fallback TokenCounter = o.Count()
 	if o.TokenCounterOverride != nil {
-		return o.TokenCounterOverride(text)
+		fallback = o.TokenCounterOverride
 	}
-	return o.Count()(text)
+	return o.Tokenizers()(o.spec, fallback, nil)(text)
 }
 
 func (o *OpenAI) Generate(ctx context.Context, state State, options *GenerateOptions) (ret State, err error) {
 	ret = state
 
 	preservedThinking := o.spec.PreservedThinking != nil && *o.spec.PreservedThinking
-	messages, err := stateToOpenAIMessages(ret, preservedThinking)
+	cacheControl := o.spec.CacheControl != nil && *o.spec.CacheControl
+	adapter := &capabilityAdapter{
+		ctx:      ctx,
+		spec:     o.spec,
+		logger:   o.Logger(),
+		warnings: o.CapabilityWarnings(),
+	}
+	messages, err := stateToOpenAIMessages(ret, preservedThinking, cacheControl, adapter)
 	if err != nil {
 		return nil, err
 	}
@@ -130,12 +180,18 @@ func (o *OpenAI) Generate(ctx context.Context, state State, options *GenerateOpt
 	if options != nil && options.NonStreaming {
 		nonStreaming = true
 	}
+	// Azure deployments have no batch path. See TheoryOfBatch.
+	batch := options != nil && options.Batch && (o.spec.IsAzure == nil || !*o.spec.IsAzure)
+	if batch {
+		nonStreaming = true
+	}
 
 	o.Logger().InfoContext(ctx, "generating",
 		"name", o.spec.Name,
 		"model", o.spec.Model,
 		"effort", o.spec.ReasoningEffort,
 		"non_streaming", nonStreaming,
+		"batch", batch,
 	)
 
 	// Use pointer type so that nil (not specified) is omitted from the
@@ -172,6 +228,10 @@ func (o *OpenAI) Generate(ctx context.Context, state State, options *GenerateOpt
 		}
 		reasoningEffort = flagEffort
 	}
+	if !adapter.supports(CapabilityReasoning) && (reasoningEffort != "" || o.spec.MaxThinkingTokens != nil) {
+		adapter.warn(CapabilityReasoning, "reasoning parameters not sent")
+		reasoningEffort = ""
+	}
 	if reasoningEffort != "" {
 		req.ReasoningEffort = reasoningEffort
 	}
@@ -184,7 +244,7 @@ func (o *OpenAI) Generate(ctx context.Context, state State, options *GenerateOpt
 		req.Tools = tools
 	}
 
-	if o.spec.IsOpenRouter != nil && *o.spec.IsOpenRouter && (req.ReasoningEffort != "" || o.spec.MaxThinkingTokens != nil) {
+	if o.spec.IsOpenRouter != nil && *o.spec.IsOpenRouter && (req.ReasoningEffort != "" || (o.spec.MaxThinkingTokens != nil && adapter.supports(CapabilityReasoning))) {
 		req.Reasoning = &Reasoning{}
 		if req.ReasoningEffort != "" {
 			req.Reasoning.Effort = req.ReasoningEffort
@@ -195,15 +255,17 @@ func (o *OpenAI) Generate(ctx context.Context, state State, options *GenerateOpt
 		}
 	}
 
-	o.recordEvent("api_call", fmt.Sprintf("openai-compatible chat completion: model=%s effort=%s non_streaming=%v", o.spec.Model, reasoningEffort, nonStreaming))
+	o.recordEvent("api_call", fmt.Sprintf("openai-compatible chat completion: model=%s effort=%s non_streaming=%v batch=%v", o.spec.Model, reasoningEffort, nonStreaming, batch))
 
-	if options != nil && options.ResponseSchema != nil {
+	if options != nil && options.ResponseSchema != nil && !adapter.supports(CapabilityJSONSchema) {
+		adapter.warn(CapabilityJSONSchema, "response schema not sent")
+	} else if options != nil && options.ResponseSchema != nil {
 		req.ResponseFormat = &ResponseFormat{
 			Type: "json_schema",
 			JSONSchema: &JSONSchema{
 				Name:   "response",
 				Strict: true,
-				Schema: options.ResponseSchema.ToOpenAI(),
+				Schema: options.ResponseSchema.ToOpenAIStrict(),
 			},
 		}
 	}
@@ -234,61 +296,6 @@ func (o *OpenAI) Generate(ctx context.Context, state State, options *GenerateOpt
 		}
 	}
 
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(bodyBytes))
-	if err != nil {
-		return nil, err
-	}
-	if o.spec.IsAzure != nil && *o.spec.IsAzure {
-		httpReq.Header.Set("api-key", o.apiKey)
-	} else {
-		httpReq.Header.Set("Authorization", "Bearer "+o.apiKey)
-	}
-	httpReq.Header.Set("Content-Type", "application/json")
-	if !nonStreaming {
-		httpReq.Header.Set("Accept", "text/event-stream")
-	}
-
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		o.recordEvent("api_error", fmt.Sprintf("openai request failed: %v", err))
-		return ret, OpenAIError{
-			Err:     err,
-			Request: req,
-		}
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		statusMsg := fmt.Sprintf("openai http status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
-		var errResp ErrorResponse
-		// Check both unmarshal failure and nil Error field: some providers
-		// return valid JSON without an "error" field (e.g. {"message": "..."}),
-		// which would leave errResp.Error nil and cause a panic on the next
-		// line when setting HTTPStatusCode.
-		if err := json.Unmarshal(body, &errResp); err != nil || errResp.Error == nil {
-			err := fmt.Errorf("bad status: %d, body: %s", resp.StatusCode, string(body))
-			o.recordEvent("api_error", statusMsg)
-			if resp.StatusCode == http.StatusTooManyRequests {
-				return ret, errors.Join(err, ErrRetryable)
-			}
-			return ret, OpenAIError{
-				Err:     err,
-				Request: req,
-			}
-		}
-
-		errResp.Error.HTTPStatusCode = resp.StatusCode
-		o.recordEvent("api_error", statusMsg)
-		if resp.StatusCode == http.StatusTooManyRequests {
-			return ret, errors.Join(errResp.Error, ErrRetryable)
-		}
-		return ret, OpenAIError{
-			Err:     errResp.Error,
-			Request: req,
-		}
-	}
-
 	var lastUsage *OpenAIUsage
 	handleUsage := func(u *OpenAIUsage) {
 		if u != nil {
@@ -303,8 +310,10 @@ func (o *OpenAI) Generate(ctx context.Context, state State, options *GenerateOpt
 		usage.Prompt.TokenCount = lastUsage.PromptTokens
 		if lastUsage.PromptTokensDetails != nil {
 			usage.Prompt.TokenCountCached = lastUsage.PromptTokensDetails.CachedTokens
+			usage.Prompt.TokenCountCacheWrite = lastUsage.PromptTokensDetails.CacheWriteTokens
 		}
 		usage.Candidates.TokenCount = lastUsage.CompletionTokens
+		usage.Batch = batch
 		if lastUsage.CompletionTokensDetails != nil {
 			usage.Candidates.TokenCount -= lastUsage.CompletionTokensDetails.ReasoningTokens
 			usage.Thoughts.TokenCount = lastUsage.CompletionTokensDetails.ReasoningTokens
@@ -320,27 +329,14 @@ func (o *OpenAI) Generate(ctx context.Context, state State, options *GenerateOpt
 		return nil
 	}
 
-	if nonStreaming {
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			o.recordEvent("api_error", fmt.Sprintf("openai non-streaming response read failed: %v", err))
-			return ret, err
-		}
-		if o.Debug() {
-			o.Logger().InfoContext(ctx, "OpenAI response",
-				"body", string(body),
-			)
-		}
-		var response ChatCompletionResponse
-		if err := json.Unmarshal(body, &response); err != nil {
-			o.recordEvent("api_error", fmt.Sprintf("openai non-streaming unmarshal failed: %v", err))
-			return ret, err
-		}
-
+	// appendResponse appends a non-streaming response, also the form of
+	// a batch job result.
+	appendResponse := func(response ChatCompletionResponse) error {
+		var err error
 		if response.Usage != nil {
 			handleUsage(response.Usage)
 			if err := emitUsage(); err != nil {
-				return ret, err
+				return err
 			}
 		}
 
@@ -369,7 +365,7 @@ func (o *OpenAI) Generate(ctx context.Context, state State, options *GenerateOpt
 				if call.Function.Arguments != "" {
 					if err := json.Unmarshal([]byte(call.Function.Arguments), &arguments); err != nil {
 						o.recordEvent("api_error", fmt.Sprintf("openai tool call arguments unmarshal failed: %v", err))
-						return ret, err
+						return err
 					}
 				}
 				content.Parts = append(content.Parts, FuncCall{
@@ -379,7 +375,7 @@ func (o *OpenAI) Generate(ctx context.Context, state State, options *GenerateOpt
 				})
 			}
 			if ret, err = ret.AppendContent(content); err != nil {
-				return ret, err
+				return err
 			}
 
 			if choice.FinishReason != "" {
@@ -389,14 +385,113 @@ func (o *OpenAI) Generate(ctx context.Context, state State, options *GenerateOpt
 						FinishReason(choice.FinishReason),
 					},
 				}); err != nil {
-					return ret, err
+					return err
 				}
 				if choice.FinishReason == "error" {
 					o.recordEvent("api_error", fmt.Sprintf("openai finish reason: %s", choice.FinishReason))
-					return ret, errors.New(string(choice.FinishReason))
+					return errors.New(string(choice.FinishReason))
 				}
 			}
 		}
+		return nil
+	}
+
+	if batch {
+		response, err := o.batchGenerate(ctx, client, req)
+		if err != nil {
+			o.recordEvent("api_error", fmt.Sprintf("openai batch failed: %v", err))
+			return ret, OpenAIError{
+				Err:     err,
+				Request: req,
+			}
+		}
+		if err := appendResponse(response); err != nil {
+			return ret, err
+		}
+		return ret.Flush()
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, err
+	}
+	if o.spec.IsAzure != nil && *o.spec.IsAzure {
+		httpReq.Header.Set("api-key", o.apiKey)
+	} else {
+		httpReq.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if !nonStreaming {
+		httpReq.Header.Set("Accept", "text/event-stream")
+	}
+
+	// Pace the request by the spec's rate limits. Retries re-enter
+	// Generate, so each attempt is paced. See TheoryOfRateLimit.
+	if err := waitRateLimit(ctx, o.RateLimiters(), o.Logger(), o.spec, estimateRequestTokens(o.spec, state, o.CountTokens)); err != nil {
+		return ret, err
+	}
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		o.recordEvent("api_error", fmt.Sprintf("openai request failed: %v", err))
+		return ret, OpenAIError{
+			Err:     err,
+			Request: req,
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		statusMsg := fmt.Sprintf("openai http status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+		var errResp ErrorResponse
+		// Check both unmarshal failure and nil Error field: some providers
+		// return valid JSON without an "error" field (e.g. {"message": "..."}),
+		// which would leave errResp.Error nil and cause a panic on the next
+		// line when setting HTTPStatusCode.
+		if err := json.Unmarshal(body, &errResp); err != nil || errResp.Error == nil {
+			var err error = &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+			o.recordEvent("api_error", statusMsg)
+			if resp.StatusCode == http.StatusTooManyRequests {
+				return ret, errors.Join(err, ErrRetryable)
+			}
+			return ret, OpenAIError{
+				Err:     err,
+				Request: req,
+			}
+		}
+
+		errResp.Error.HTTPStatusCode = resp.StatusCode
+		o.recordEvent("api_error", statusMsg)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			return ret, errors.Join(errResp.Error, ErrRetryable)
+		}
+		return ret, OpenAIError{
+			Err:     errResp.Error,
+			Request: req,
+		}
+	}
+
+	if nonStreaming {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			o.recordEvent("api_error", fmt.Sprintf("openai non-streaming response read failed: %v", err))
+			return ret, err
+		}
+		if o.Debug() {
+			o.Logger().InfoContext(ctx, "OpenAI response",
+				"body", string(body),
+			)
+		}
+		var response ChatCompletionResponse
+		if err := json.Unmarshal(body, &response); err != nil {
+			o.recordEvent("api_error", fmt.Sprintf("openai non-streaming unmarshal failed: %v", err))
+			return ret, err
+		}
+
+		if err := appendResponse(response); err != nil {
+			return ret, err
+		}
 
 	} else {
 		parser := new(OpenAIParser)
@@ -517,8 +612,14 @@ func (o *OpenAI) Generate(ctx context.Context, state State, options *GenerateOpt
 	return ret, nil
 }
 
-func stateToOpenAIMessages(state State, preservedThinking bool) (messages []ChatCompletionMessage, err error) {
-	if state.SystemPrompt() != "" {
+// stateToOpenAIMessages converts the state to chat completion messages.
+// When cacheControl is set, each CacheBreakpoint marks the part preceding
+// it with an ephemeral cache_control; otherwise breakpoints are dropped.
+// See TheoryOfCacheBreakpoints. adapter adapts the messages to the model's
+// capabilities and may be nil. See TheoryOfCapabilities.
+func stateToOpenAIMessages(state State, preservedThinking bool, cacheControl bool, adapter *capabilityAdapter) (messages []ChatCompletionMessage, err error) {
+	systemInUserTurn := adapter.systemPromptInUserTurn(state.SystemPrompt())
+	if state.SystemPrompt() != "" && !systemInUserTurn {
 		messages = append(messages, ChatCompletionMessage{
 			Role:    string(RoleSystem),
 			Content: state.SystemPrompt(),
@@ -573,6 +674,37 @@ func stateToOpenAIMessages(state State, preservedThinking bool) (messages []Chat
 		}
 	}
 
+	// markCacheBreakpoint puts the cache_control marker on the last part of
+	// the last message. String content is converted to a single text part
+	// so the marker has a part to attach to; later text then starts a new
+	// part instead of being concatenated past the breakpoint.
+	markCacheBreakpoint := func() {
+		if len(messages) == 0 {
+			return
+		}
+		last := &messages[len(messages)-1]
+		switch c := last.Content.(type) {
+		case string:
+			last.Content = []ChatMessagePart{
+				{
+					Type:         "text",
+					Text:         c,
+					CacheControl: &AnthropicCacheControl{Type: "ephemeral"},
+				},
+			}
+		case []ChatMessagePart:
+			if len(c) > 0 {
+				c[len(c)-1].CacheControl = &AnthropicCacheControl{Type: "ephemeral"}
+			}
+		}
+	}
+
+	// The system prompt opens the user turn it is moved into; text of the
+	// first user content is appended to it by addText.
+	if systemInUserTurn {
+		addText(string(RoleUser), state.SystemPrompt()+"\n\n")
+	}
+
 	for content := range state.Contents() {
 		// Skip log and system content to prevent internal metadata (Usage,
 		// FinishReason, Error) from being sent to the API. This also preserves
@@ -586,7 +718,7 @@ func stateToOpenAIMessages(state State, preservedThinking bool) (messages []Chat
 			role = string(RoleAssistant)
 		}
 		for _, part := range content.Parts {
-			switch part := part.(type) {
+			switch part := adapter.part(part).(type) {
 			case Text:
 				if len(part) == 0 {
 					continue
@@ -667,6 +799,10 @@ func stateToOpenAIMessages(state State, preservedThinking bool) (messages []Chat
 					ToolCallID: part.ID,
 					Content:    string(resultsBytes),
 				})
+			case CacheBreakpoint:
+				if cacheControl {
+					markCacheBreakpoint()
+				}
 			}
 		}
 	}
@@ -770,9 +906,10 @@ func (m *ChatCompletionMessage) UnmarshalJSON(data []byte) error {
 }
 
 type ChatMessagePart struct {
-	Type     string               `json:"type"`
-	Text     string               `json:"text,omitempty"`
-	ImageURL *ChatMessageImageURL `json:"image_url,omitempty"`
+	Type         string                 `json:"type"`
+	Text         string                 `json:"text,omitempty"`
+	ImageURL     *ChatMessageImageURL   `json:"image_url,omitempty"`
+	CacheControl *AnthropicCacheControl `json:"cache_control,omitempty"`
 }
 
 type ChatMessageImageURL struct {
@@ -851,7 +988,8 @@ type APIError struct {
 }
 
 type PromptTokensDetails struct {
-	CachedTokens int `json:"cached_tokens,omitempty"`
+	CachedTokens     int `json:"cached_tokens,omitempty"`
+	CacheWriteTokens int `json:"cache_write_tokens,omitempty"`
 }
 
 type CompletionTokensDetails struct {
@@ -0,0 +1,241 @@
+package generators
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/reusee/tai/nets"
+)
+
+// openAIBatchEndpoint is the endpoint of the batched requests.
+const openAIBatchEndpoint = "/v1/chat/completions"
+
+type openAIBatchInput struct {
+	CustomID string                `json:"custom_id"`
+	Method   string                `json:"method"`
+	URL      string                `json:"url"`
+	Body     ChatCompletionRequest `json:"body"`
+}
+
+type openAIBatchOutput struct {
+	CustomID string `json:"custom_id"`
+	Response *struct {
+		StatusCode int             `json:"status_code"`
+		Body       json.RawMessage `json:"body"`
+	} `json:"response"`
+	Error *APIError `json:"error"`
+}
+
+type openAIBatchJob struct {
+	ID           string `json:"id"`
+	Status       string `json:"status"`
+	OutputFileID string `json:"output_file_id"`
+	ErrorFileID  string `json:"error_file_id"`
+	Errors       *struct {
+		Data []APIError `json:"data"`
+	} `json:"errors"`
+}
+
+type openAIFile struct {
+	ID string `json:"id"`
+}
+
+// batchGenerate runs req in a batch job with other concurrent requests to
+// the same model. See TheoryOfBatch.
+func (o *OpenAI) batchGenerate(ctx context.Context, client nets.HTTPClient, req ChatCompletionRequest) (ChatCompletionResponse, error) {
+	key := "openai\x00" + o.spec.BaseURL + "\x00" + o.spec.Model + "\x00" + o.apiKey
+	return batchQueueFor[ChatCompletionRequest, ChatCompletionResponse](key).do(ctx, req,
+		func(ctx context.Context, reqs []ChatCompletionRequest) ([]batchResult[ChatCompletionResponse], error) {
+			return o.runBatch(ctx, client, reqs)
+		},
+	)
+}
+
+// runBatch uploads reqs, creates the job, waits for it and returns the
+// results in request order.
+func (o *OpenAI) runBatch(ctx context.Context, client nets.HTTPClient, reqs []ChatCompletionRequest) ([]batchResult[ChatCompletionResponse], error) {
+	// input file
+	input := new(bytes.Buffer)
+	encoder := json.NewEncoder(input)
+	for i, req := range reqs {
+		if err := encoder.Encode(openAIBatchInput{
+			CustomID: strconv.Itoa(i),
+			Method:   http.MethodPost,
+			URL:      openAIBatchEndpoint,
+			Body:     req,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	form := new(bytes.Buffer)
+	writer := multipart.NewWriter(form)
+	if err := writer.WriteField("purpose", "batch"); err != nil {
+		return nil, err
+	}
+	part, err := writer.CreateFormFile("file", "batch.jsonl")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write(input.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	var file openAIFile
+	if err := o.apiRequest(ctx, client, http.MethodPost, o.apiURL("/files"), writer.FormDataContentType(), form, &file); err != nil {
+		return nil, fmt.Errorf("upload batch input: %w", err)
+	}
+
+	// job
+	body, err := json.Marshal(map[string]any{
+		"input_file_id":     file.ID,
+		"endpoint":          openAIBatchEndpoint,
+		"completion_window": "24h",
+	})
+	if err != nil {
+		return nil, err
+	}
+	var job openAIBatchJob
+	if err := o.apiRequest(ctx, client, http.MethodPost, o.apiURL("/batches"), "application/json", bytes.NewReader(body), &job); err != nil {
+		return nil, fmt.Errorf("create batch: %w", err)
+	}
+	o.Logger().InfoContext(ctx, "batch job created",
+		"job", job.ID,
+		"model", o.spec.Model,
+		"requests", len(reqs),
+	)
+	o.recordEvent("api_call", fmt.Sprintf("openai batch created: job=%s model=%s requests=%d", job.ID, o.spec.Model, len(reqs)))
+
+	if err := pollBatch(ctx, o.Logger(), job.ID, func() (bool, error) {
+		if err := o.apiRequest(ctx, client, http.MethodGet, o.apiURL("/batches/"+job.ID), "", nil, &job); err != nil {
+			return false, err
+		}
+		switch job.Status {
+		case "completed", "failed", "expired", "cancelled":
+			return true, nil
+		}
+		return false, nil
+	}); err != nil {
+		return nil, err
+	}
+	if job.Status == "failed" && job.OutputFileID == "" {
+		if job.Errors != nil && len(job.Errors.Data) > 0 {
+			return nil, fmt.Errorf("batch %s failed: %w", job.ID, &job.Errors.Data[0])
+		}
+		return nil, fmt.Errorf("batch %s failed", job.ID)
+	}
+
+	// results; requests missing from both files did not complete, as in
+	// an expired job
+	results := make([]batchResult[ChatCompletionResponse], len(reqs))
+	for i := range results {
+		results[i].err = fmt.Errorf("batch %s %s without a result", job.ID, job.Status)
+	}
+	for _, fileID := range []string{job.OutputFileID, job.ErrorFileID} {
+		if fileID == "" {
+			continue
+		}
+		content := new(bytes.Buffer)
+		if err := o.apiRequest(ctx, client, http.MethodGet, o.apiURL("/files/"+fileID+"/content"), "", nil, content); err != nil {
+			return nil, fmt.Errorf("download batch results: %w", err)
+		}
+		scanner := bufio.NewScanner(content)
+		scanner.Buffer(nil, 64<<20)
+		for scanner.Scan() {
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+			var output openAIBatchOutput
+			if err := json.Unmarshal(scanner.Bytes(), &output); err != nil {
+				return nil, fmt.Errorf("batch result: %w", err)
+			}
+			i, err := strconv.Atoi(output.CustomID)
+			if err != nil || i < 0 || i >= len(results) {
+				return nil, fmt.Errorf("batch result with unknown custom_id %q", output.CustomID)
+			}
+			results[i] = openAIBatchResult(output)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+func openAIBatchResult(output openAIBatchOutput) (ret batchResult[ChatCompletionResponse]) {
+	switch {
+	case output.Error != nil:
+		ret.err = output.Error
+	case output.Response == nil:
+		ret.err = fmt.Errorf("batch result without response")
+	case output.Response.StatusCode != http.StatusOK:
+		var errResp ErrorResponse
+		if err := json.Unmarshal(output.Response.Body, &errResp); err == nil && errResp.Error != nil {
+			errResp.Error.HTTPStatusCode = output.Response.StatusCode
+			ret.err = errResp.Error
+		} else {
+			ret.err = &StatusError{
+				StatusCode: output.Response.StatusCode,
+				Body:       string(output.Response.Body),
+			}
+		}
+	default:
+		ret.err = json.Unmarshal(output.Response.Body, &ret.resp)
+	}
+	return
+}
+
+// apiURL returns the URL of an endpoint under the base URL.
+func (o *OpenAI) apiURL(path string) string {
+	return strings.TrimSuffix(o.spec.BaseURL, "/") + path
+}
+
+// apiRequest sends a request to an endpoint other than chat completions
+// (batches, files, models), decoding a JSON response into out, or copying
+// it when out is a *bytes.Buffer.
+func (o *OpenAI) apiRequest(ctx context.Context, client nets.HTTPClient, method string, url string, contentType string, body io.Reader, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	if o.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var errResp ErrorResponse
+		if err := json.Unmarshal(data, &errResp); err == nil && errResp.Error != nil {
+			errResp.Error.HTTPStatusCode = resp.StatusCode
+			return errResp.Error
+		}
+		return &StatusError{
+			StatusCode: resp.StatusCode,
+			Body:       string(data),
+		}
+	}
+	if buf, ok := out.(*bytes.Buffer); ok {
+		_, err := buf.Write(data)
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
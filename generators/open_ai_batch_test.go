@@ -0,0 +1,194 @@
+package generators
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/reusee/dscope"
+	"github.com/reusee/tai/modes"
+	"github.com/reusee/tai/nets"
+)
+
+// openAIBatchStub implements the file and batch endpoints: the job stays
+// in progress for the first poll and then completes with one output line
+// per input line, echoing the last user message.
+type openAIBatchStub struct {
+	t        *testing.T
+	mu       sync.Mutex
+	input    []byte
+	jobs     int
+	polls    int
+	requests []string
+}
+
+func (s *openAIBatchStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer test-key" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error": {"message": "bad key"}}`)
+		return
+	}
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/files":
+		if r.FormValue("purpose") != "batch" {
+			s.t.Errorf("got purpose %q", r.FormValue("purpose"))
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			s.t.Error(err)
+			return
+		}
+		defer file.Close()
+		buf := new(bytes.Buffer)
+		buf.ReadFrom(file)
+		s.input = buf.Bytes()
+		fmt.Fprint(w, `{"id": "file-in"}`)
+
+	case r.Method == http.MethodPost && r.URL.Path == "/batches":
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["input_file_id"] != "file-in" || body["endpoint"] != "/v1/chat/completions" {
+			s.t.Errorf("got batch %v", body)
+		}
+		s.jobs++
+		fmt.Fprint(w, `{"id": "batch-1", "status": "validating"}`)
+
+	case r.Method == http.MethodGet && r.URL.Path == "/batches/batch-1":
+		s.polls++
+		if s.polls == 1 {
+			fmt.Fprint(w, `{"id": "batch-1", "status": "in_progress"}`)
+			return
+		}
+		fmt.Fprint(w, `{"id": "batch-1", "status": "completed", "output_file_id": "file-out"}`)
+
+	case r.Method == http.MethodGet && r.URL.Path == "/files/file-out/content":
+		scanner := bufio.NewScanner(bytes.NewReader(s.input))
+		for scanner.Scan() {
+			var input openAIBatchInput
+			if err := json.Unmarshal(scanner.Bytes(), &input); err != nil {
+				s.t.Error(err)
+				return
+			}
+			if input.Body.Stream {
+				s.t.Error("batch requests must not stream")
+			}
+			messages := input.Body.Messages
+			body, _ := json.Marshal(map[string]any{
+				"choices": []any{
+					map[string]any{
+						"index": 0,
+						"message": map[string]any{
+							"role":    "assistant",
+							"content": "echo " + fmt.Sprint(messages[len(messages)-1].Content),
+						},
+						"finish_reason": "stop",
+					},
+				},
+				"usage": map[string]any{
+					"prompt_tokens":     10,
+					"completion_tokens": 5,
+					"total_tokens":      15,
+				},
+			})
+			line, _ := json.Marshal(map[string]any{
+				"custom_id": input.CustomID,
+				"response": map[string]any{
+					"status_code": 200,
+					"body":        json.RawMessage(body),
+				},
+			})
+			w.Write(append(line, '\n'))
+		}
+
+	default:
+		s.t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestOpenAIBatch(t *testing.T) {
+	defer func(interval time.Duration) {
+		batchPollInterval = interval
+	}(batchPollInterval)
+	batchPollInterval = time.Millisecond
+
+	stub := &openAIBatchStub{t: t}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Fork(
+		func() nets.HTTPClient {
+			return nets.HTTPClient{Client: server.Client()}
+		},
+	).Call(func(
+		newOpenAI NewOpenAI,
+	) {
+		openai := newOpenAI(Spec{
+			BaseURL: server.URL,
+			Model:   "batch-test-model",
+		}, "test-key")
+
+		// concurrent requests share one job
+		prompts := []string{"alpha", "beta"}
+		states := make([]State, len(prompts))
+		errs := make([]error, len(prompts))
+		var wg sync.WaitGroup
+		for i, prompt := range prompts {
+			wg.Go(func() {
+				states[i], errs[i] = openai.Generate(context.Background(), NewPrompts("", []*Content{
+					{Role: RoleUser, Parts: []Part{Text(prompt)}},
+				}), &GenerateOptions{
+					Batch: true,
+				})
+			})
+		}
+		wg.Wait()
+
+		for i, prompt := range prompts {
+			if errs[i] != nil {
+				t.Fatal(errs[i])
+			}
+			var text Text
+			var usage Usage
+			for c := range states[i].Contents() {
+				for _, p := range c.Parts {
+					switch p := p.(type) {
+					case Text:
+						if c.Role == RoleModel || c.Role == RoleAssistant {
+							text += p
+						}
+					case Usage:
+						usage = p
+					}
+				}
+			}
+			if !strings.Contains(string(text), "echo") || !strings.Contains(string(text), prompt) {
+				t.Fatalf("got text %q for %q", text, prompt)
+			}
+			if !usage.Batch || usage.Prompt.TokenCount != 10 {
+				t.Fatalf("got usage %+v", usage)
+			}
+		}
+
+		if stub.jobs != 1 {
+			t.Fatalf("expecting one job for concurrent requests, got %d (%v)", stub.jobs, stub.requests)
+		}
+		if stub.polls < 2 {
+			t.Fatalf("expecting polling until completion, got %d polls", stub.polls)
+		}
+	})
+}
@@ -10,3 +10,7 @@ var _ error = OpenAIError{}
 func (o OpenAIError) Error() string {
 	return o.Err.Error()
 }
+
+func (o OpenAIError) Unwrap() error {
+	return o.Err
+}
@@ -0,0 +1,859 @@
+package generators
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/reusee/dscope"
+	"github.com/reusee/tai/logs"
+	"github.com/reusee/tai/nets"
+)
+
+const TheoryOfOpenAIResponses = `
+The Responses generator speaks OpenAI's /v1/responses API. Chat Completions
+has no place for reasoning in its messages, so reasoning models lose their
+reasoning between rounds and must redo it from the visible conversation.
+The Responses API returns reasoning as output items that can be sent back
+as input, which lets the model continue from its own prior reasoning.
+
+Requests are stateless: store is false and the full conversation is sent
+as input items on every request, like the other generators. This keeps the
+State the single source of truth — round retries restart from an earlier
+State snapshot, which a server-side previous_response_id chain could not
+follow — and keeps the provider from retaining conversation data. Reasoning
+therefore round-trips as encrypted content: with PreservedThinking set, the
+request includes "reasoning.encrypted_content", each reasoning item is
+stored in the State as its summary Thought parts followed by a
+ThoughtSignature carrying the item ID and encrypted content, and the pair is
+sent back as a reasoning input item. Without PreservedThinking, reasoning
+is stripped like in the other generators; signatures issued by other
+providers are never sent.
+
+Reasoning summaries stream as Thought parts, so they are shown and
+summarized like other models' thoughts. Function tools reuse the Chat
+Completions schema conversion (FuncDecl.ToOpenAI), flattened into the
+Responses tool shape. Usage maps input, cached input, output, and reasoning
+tokens onto Usage with the same accounting as Chat Completions, and an
+incomplete response caused by the output limit is reported as the "length"
+finish reason so truncation retry applies. Transient failures (429, 5xx,
+and server-side stream errors) are retried through Retrier.
+`
+
+type OpenAIResponses struct {
+	spec   Spec
+	apiKey string
+	client nets.HTTPClient
+
+	Count           dscope.Inject[BPETokenCounter]
+	Logger          dscope.Inject[logs.Logger]
+	Effort          dscope.Inject[EffortFlag]
+	TemperatureFlag dscope.Inject[TemperatureFlag]
+	Debug           dscope.Inject[DebugOpenAI]
+	FuncDecls       dscope.Inject[FuncDecls]
+	EventRecorder   dscope.Inject[EventRecorder]
+	Retrier         dscope.Inject[Retrier]
+	RateLimiters    dscope.Inject[*RateLimiters]
+	Tokenizers      dscope.Inject[Tokenizers]
+}
+
+var _ Generator = new(OpenAIResponses)
+
+func (o *OpenAIResponses) Spec() Spec {
+	return o.spec
+}
+
+// recordEvent records an API-level event in the interaction transcript
+// when interaction recording is active. See generators.TheoryOfEventRecorder.
+func (o *OpenAIResponses) recordEvent(typ string, detail string) {
+	if rec := o.EventRecorder(); rec != nil && rec.Enabled() {
+		rec.Event(typ, detail)
+	}
+}
+
+func (o *OpenAIResponses) CountTokens(text string) (int, error) {
+	return o.Tokenizers()(o.spec, o.Count(), nil)(text)
+}
+
+func (o *OpenAIResponses) Generate(ctx context.Context, state State, options *GenerateOptions) (ret State, err error) {
+	ret = state
+
+	preservedThinking := o.spec.PreservedThinking != nil && *o.spec.PreservedThinking
+	input, err := stateToResponsesInput(ret, preservedThinking)
+	if err != nil {
+		return nil, err
+	}
+
+	req := ResponsesRequest{
+		Model:        o.spec.Model,
+		Input:        input,
+		Instructions: ret.SystemPrompt(),
+		Store:        new(false),
+		ServiceTier:  o.spec.ServiceTier,
+	}
+	if o.spec.MaxGenerateTokens != nil {
+		n := *o.spec.MaxGenerateTokens
+		req.MaxOutputTokens = &n
+	}
+	if options != nil && options.MaxGenerateTokens != nil {
+		n := *options.MaxGenerateTokens
+		if req.MaxOutputTokens == nil || n < *req.MaxOutputTokens {
+			req.MaxOutputTokens = &n
+		}
+	}
+
+	if o.spec.Temperature != nil {
+		t := *o.spec.Temperature
+		req.Temperature = &t
+	}
+	if flag := o.TemperatureFlag(); flag.Value != nil {
+		if o.spec.Temperature == nil || *flag.Value != *o.spec.Temperature {
+			o.Logger().WarnContext(ctx, "temperature override",
+				"spec_temperature", o.spec.Temperature,
+				"actual_temperature", *flag.Value,
+			)
+		}
+		req.Temperature = flag.Value
+	}
+
+	reasoningEffort := o.spec.ReasoningEffort
+	if flagEffort := string(o.Effort()); flagEffort != "" {
+		if flagEffort != reasoningEffort {
+			o.Logger().WarnContext(ctx, "effort override",
+				"spec_effort", reasoningEffort,
+				"actual_effort", flagEffort,
+			)
+		}
+		reasoningEffort = flagEffort
+	}
+	// The reasoning parameter is rejected by non-reasoning models, so it
+	// is only sent when the spec asks for reasoning behavior.
+	if reasoningEffort != "" || preservedThinking {
+		req.Reasoning = &ResponsesReasoning{
+			Effort:  reasoningEffort,
+			Summary: "auto",
+		}
+	}
+	if preservedThinking {
+		req.Include = append(req.Include, "reasoning.encrypted_content")
+	}
+
+	if o.spec.DisableTools == nil || !*o.spec.DisableTools {
+		// Globally sorted by name for prefix cache stability. See
+		// TheoryOfPrefixCaching.
+		var allFuncs []FuncDecl
+		for fn := range ret.Functions() {
+			allFuncs = append(allFuncs, fn.Decl)
+		}
+		allFuncs = append(allFuncs, o.FuncDecls()...)
+		sort.SliceStable(allFuncs, func(i, j int) bool {
+			return allFuncs[i].Name < allFuncs[j].Name
+		})
+		for _, fn := range allFuncs {
+			req.Tools = append(req.Tools, fn.ToResponses())
+		}
+	}
+
+	if options != nil && options.ResponseSchema != nil {
+		req.Text = &ResponsesText{
+			Format: &ResponsesFormat{
+				Type:   "json_schema",
+				Name:   "response",
+				Strict: true,
+				Schema: options.ResponseSchema.ToOpenAIStrict(),
+			},
+		}
+	}
+
+	nonStreaming := false
+	if options != nil && options.NonStreaming {
+		nonStreaming = true
+	}
+	req.Stream = !nonStreaming
+
+	bodyBytes, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if o.Debug() {
+		o.Logger().InfoContext(ctx, "responses request",
+			"body", string(bodyBytes),
+		)
+	}
+
+	baseURL := o.spec.BaseURL
+	if baseURL == "" {
+		baseURL = "https://api.openai.com/v1"
+	}
+	url := strings.TrimSuffix(baseURL, "/") + "/responses"
+
+	client := o.client
+	if o.spec.NoProxy != nil && *o.spec.NoProxy {
+		client = nets.HTTPClient{
+			Client: &http.Client{
+				Transport: &http.Transport{
+					DialContext: (&net.Dialer{}).DialContext,
+				},
+			},
+		}
+	}
+
+	// Each attempt is paced by the spec's rate limits. See
+	// TheoryOfRateLimit.
+	estimatedTokens := estimateRequestTokens(o.spec, ret, o.CountTokens)
+	ret, err = o.Retrier().Do(ctx, func() (State, error) {
+		if err := waitRateLimit(ctx, o.RateLimiters(), o.Logger(), o.spec, estimatedTokens); err != nil {
+			return ret, err
+		}
+		o.Logger().InfoContext(ctx, "generating",
+			"name", o.spec.Name,
+			"model", o.spec.Model,
+			"effort", reasoningEffort,
+			"non_streaming", nonStreaming,
+		)
+		o.recordEvent("api_call", fmt.Sprintf("openai responses: model=%s effort=%s non_streaming=%v", o.spec.Model, reasoningEffort, nonStreaming))
+
+		httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(bodyBytes))
+		if err != nil {
+			return ret, err
+		}
+		httpReq.Header.Set("Authorization", "Bearer "+o.apiKey)
+		httpReq.Header.Set("Content-Type", "application/json")
+		if !nonStreaming {
+			httpReq.Header.Set("Accept", "text/event-stream")
+		}
+
+		resp, err := client.Do(httpReq)
+		if err != nil {
+			o.recordEvent("api_error", fmt.Sprintf("responses request failed: %v", err))
+			return ret, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			o.recordEvent("api_error", fmt.Sprintf("responses http status %d: %s", resp.StatusCode, strings.TrimSpace(string(body))))
+			var errResp ErrorResponse
+			var apiErr error
+			if err := json.Unmarshal(body, &errResp); err != nil || errResp.Error == nil {
+				apiErr = &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+			} else {
+				errResp.Error.HTTPStatusCode = resp.StatusCode
+				apiErr = errResp.Error
+			}
+			if isRetryableResponsesStatus(resp.StatusCode) {
+				return ret, errors.Join(apiErr, ErrRetryable)
+			}
+			return ret, apiErr
+		}
+
+		if nonStreaming {
+			return o.handleResponse(ctx, ret, resp.Body)
+		}
+		return o.handleStream(ctx, ret, resp.Body)
+	})
+	if err != nil {
+		return ret, err
+	}
+
+	if ret, err = ret.Flush(); err != nil {
+		return ret, err
+	}
+
+	return ret, nil
+}
+
+func isRetryableResponsesStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable:
+		return true
+	}
+	return false
+}
+
+func (o *OpenAIResponses) handleResponse(ctx context.Context, state State, body io.Reader) (ret State, err error) {
+	ret = state
+	data, err := io.ReadAll(body)
+	if err != nil {
+		o.recordEvent("api_error", fmt.Sprintf("responses non-streaming response read failed: %v", err))
+		return state, err
+	}
+	if o.Debug() {
+		o.Logger().InfoContext(ctx, "responses response",
+			"body", string(data),
+		)
+	}
+	var response ResponsesResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		o.recordEvent("api_error", fmt.Sprintf("responses non-streaming unmarshal failed: %v", err))
+		return state, err
+	}
+	if response.Error != nil {
+		o.recordEvent("api_error", fmt.Sprintf("responses failed: %v", response.Error))
+		return state, response.Error
+	}
+
+	content := &Content{
+		Role: RoleModel,
+	}
+	for _, item := range response.Output {
+		parts, err := partsFromResponsesItem(item, true)
+		if err != nil {
+			return state, err
+		}
+		content.Parts = append(content.Parts, parts...)
+	}
+	if len(content.Parts) > 0 {
+		if ret, err = ret.AppendContent(content); err != nil {
+			return state, err
+		}
+	}
+	return appendResponsesEnd(ret, &response)
+}
+
+func (o *OpenAIResponses) handleStream(ctx context.Context, state State, body io.Reader) (ret State, err error) {
+	ret = state
+
+	appendParts := func(parts ...Part) error {
+		if len(parts) == 0 {
+			return nil
+		}
+		var err error
+		ret, err = ret.AppendContent(&Content{
+			Role:  RoleModel,
+			Parts: parts,
+		})
+		return err
+	}
+
+	var final *ResponsesResponse
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(nil, 4<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			// "event:" lines repeat the type carried in the data payload.
+			continue
+		}
+		var event ResponsesStreamEvent
+		if err := json.Unmarshal([]byte(line[6:]), &event); err != nil {
+			o.recordEvent("api_error", fmt.Sprintf("responses stream unmarshal failed: %v", err))
+			return ret, fmt.Errorf("error unmarshalling stream event: %w", err)
+		}
+		if o.Debug() {
+			o.Logger().InfoContext(ctx, "responses event",
+				"details", event,
+			)
+		}
+
+		switch event.Type {
+
+		case "response.output_text.delta":
+			if err := appendParts(Text(event.Delta)); err != nil {
+				return ret, err
+			}
+
+		case "response.reasoning_summary_part.added":
+			// Summary parts are separate paragraphs of one summary.
+			if event.SummaryIndex > 0 {
+				if err := appendParts(Thought("\n\n")); err != nil {
+					return ret, err
+				}
+			}
+
+		case "response.reasoning_summary_text.delta":
+			if err := appendParts(Thought(event.Delta)); err != nil {
+				return ret, err
+			}
+
+		case "response.output_item.done":
+			// Text and summaries were streamed as deltas; the completed
+			// item carries what only exists in full: the encrypted
+			// reasoning and the function call arguments.
+			if event.Item == nil {
+				continue
+			}
+			parts, err := partsFromResponsesItem(*event.Item, false)
+			if err != nil {
+				o.recordEvent("api_error", fmt.Sprintf("responses output item: %v", err))
+				return ret, err
+			}
+			if err := appendParts(parts...); err != nil {
+				return ret, err
+			}
+
+		case "response.completed", "response.incomplete":
+			final = event.Response
+
+		case "response.failed":
+			var apiErr error = errors.New("response failed")
+			if event.Response != nil && event.Response.Error != nil {
+				apiErr = event.Response.Error
+			}
+			o.recordEvent("api_error", fmt.Sprintf("responses stream failed: %v", apiErr))
+			if event.Response != nil && event.Response.Error != nil && event.Response.Error.retryable() {
+				return ret, errors.Join(apiErr, ErrRetryable)
+			}
+			return ret, apiErr
+
+		case "error":
+			apiErr := &ResponsesError{
+				Code:    event.Code,
+				Message: event.Message,
+			}
+			o.recordEvent("api_error", fmt.Sprintf("responses stream error: %v", apiErr))
+			if apiErr.retryable() {
+				return ret, errors.Join(apiErr, ErrRetryable)
+			}
+			return ret, apiErr
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		o.recordEvent("api_error", fmt.Sprintf("responses stream read failed: %v", err))
+		return ret, fmt.Errorf("error reading stream: %w", err)
+	}
+
+	if final == nil {
+		return ret, nil
+	}
+	return appendResponsesEnd(ret, final)
+}
+
+// appendResponsesEnd appends the usage and finish reason of a completed or
+// incomplete response as log content.
+func appendResponsesEnd(state State, response *ResponsesResponse) (ret State, err error) {
+	ret = state
+	if response.Usage != nil {
+		if ret, err = ret.AppendContent(&Content{
+			Role:  RoleLog,
+			Parts: []Part{response.Usage.toUsage()},
+		}); err != nil {
+			return state, err
+		}
+	}
+	var reason string
+	switch response.Status {
+	case "completed":
+		reason = "stop"
+	case "incomplete":
+		reason = "incomplete"
+		if response.IncompleteDetails != nil && response.IncompleteDetails.Reason != "" {
+			reason = response.IncompleteDetails.Reason
+		}
+		if reason == "max_output_tokens" {
+			// Reported as "length" so truncation retry applies. See
+			// TheoryOfSummaryCompletionRetry in codes/generate.go.
+			reason = "length"
+		}
+	}
+	if reason != "" {
+		if ret, err = ret.AppendContent(&Content{
+			Role:  RoleLog,
+			Parts: []Part{FinishReason(reason)},
+		}); err != nil {
+			return state, err
+		}
+	}
+	return ret, nil
+}
+
+// partsFromResponsesItem converts a completed output item into parts.
+// withDeltas selects whether the content that streaming delivers as
+// deltas (message text and reasoning summaries) is included; the
+// streaming path has already appended it.
+func partsFromResponsesItem(item ResponsesItem, withDeltas bool) (parts []Part, err error) {
+	switch item.Type {
+	case "message":
+		if !withDeltas {
+			return nil, nil
+		}
+		for _, content := range item.Content {
+			if content.Type == "output_text" && content.Text != "" {
+				parts = append(parts, Text(content.Text))
+			}
+		}
+	case "reasoning":
+		if withDeltas {
+			for i, summary := range item.Summary {
+				if i > 0 {
+					parts = append(parts, Thought("\n\n"))
+				}
+				if summary.Text != "" {
+					parts = append(parts, Thought(summary.Text))
+				}
+			}
+		}
+		if item.EncryptedContent != "" {
+			parts = append(parts, ThoughtSignature{
+				Provider:  SignatureProviderResponses,
+				ID:        item.ID,
+				Signature: item.EncryptedContent,
+			})
+		}
+	case "function_call":
+		var arguments map[string]any
+		if item.Arguments != "" {
+			if err := json.Unmarshal([]byte(item.Arguments), &arguments); err != nil {
+				return nil, fmt.Errorf("function call %s arguments: %w", item.Name, err)
+			}
+		}
+		parts = append(parts, FuncCall{
+			ID:        item.CallID,
+			Name:      item.Name,
+			Arguments: arguments,
+		})
+	}
+	return parts, nil
+}
+
+// stateToResponsesInput converts the state to Responses input items.
+// Consecutive same-role message parts share one message item; reasoning
+// is sent back as reasoning items only when preservedThinking is set and
+// the reasoning was issued by the Responses API. See
+// TheoryOfOpenAIResponses.
+func stateToResponsesInput(state State, preservedThinking bool) (items []any, err error) {
+	// lastMessage is the message item text can be appended to; it is
+	// reset by any non-message item so ordering is preserved.
+	var lastMessage *ResponsesMessageItem
+	addContent := func(role string, content ResponsesContent) {
+		if lastMessage != nil && lastMessage.Role == role {
+			lastMessage.Content = append(lastMessage.Content, content)
+			return
+		}
+		lastMessage = &ResponsesMessageItem{
+			Type:    "message",
+			Role:    role,
+			Content: []ResponsesContent{content},
+		}
+		items = append(items, lastMessage)
+	}
+	addItem := func(item any) {
+		lastMessage = nil
+		items = append(items, item)
+	}
+
+	for content := range state.Contents() {
+		// Log and system contents carry internal metadata; the system
+		// prompt is sent as instructions. See stateToOpenAIMessages.
+		if content.Role == RoleLog || content.Role == RoleSystem {
+			continue
+		}
+		role := "user"
+		textType := "input_text"
+		if content.Role == RoleModel || content.Role == RoleAssistant {
+			role = "assistant"
+			textType = "output_text"
+		}
+
+		var pendingSummary []ResponsesSummary
+		for _, part := range content.Parts {
+			switch part := part.(type) {
+			case Text:
+				if len(part) == 0 {
+					continue
+				}
+				addContent(role, ResponsesContent{
+					Type: textType,
+					Text: string(part),
+				})
+			case Thought:
+				if !preservedThinking || role != "assistant" || len(part) == 0 {
+					continue
+				}
+				// State merges adjacent Thought parts, so the summary
+				// parts of one reasoning item come back as one text.
+				if len(pendingSummary) == 0 {
+					pendingSummary = append(pendingSummary, ResponsesSummary{
+						Type: "summary_text",
+					})
+				}
+				pendingSummary[0].Text += string(part)
+			case ThoughtSignature:
+				if !preservedThinking || role != "assistant" ||
+					part.Provider != SignatureProviderResponses {
+					pendingSummary = nil
+					continue
+				}
+				summary := pendingSummary
+				if summary == nil {
+					// The API requires the field even when empty.
+					summary = []ResponsesSummary{}
+				}
+				addItem(&ResponsesReasoningItem{
+					Type:             "reasoning",
+					ID:               part.ID,
+					Summary:          summary,
+					EncryptedContent: part.Signature,
+				})
+				pendingSummary = nil
+			case FileURL:
+				if len(part) == 0 || role != "user" {
+					continue
+				}
+				addContent(role, ResponsesContent{
+					Type:     "input_image",
+					ImageURL: string(part),
+				})
+			case FileContent:
+				if role != "user" {
+					continue
+				}
+				addContent(role, responsesFileContent(part))
+			case FuncCall:
+				argsBytes, err := json.Marshal(part.Arguments)
+				if err != nil {
+					return nil, err
+				}
+				addItem(&ResponsesFunctionCallItem{
+					Type:      "function_call",
+					CallID:    part.ID,
+					Name:      part.Name,
+					Arguments: string(argsBytes),
+				})
+			case CallResult:
+				resultsBytes, err := json.Marshal(part.Results)
+				if err != nil {
+					return nil, err
+				}
+				addItem(&ResponsesFunctionCallOutputItem{
+					Type:   "function_call_output",
+					CallID: part.ID,
+					Output: string(resultsBytes),
+				})
+			}
+		}
+	}
+
+	return
+}
+
+func responsesFileContent(part FileContent) ResponsesContent {
+	if isTextMIMEType(part.MimeType) {
+		return ResponsesContent{
+			Type: "input_text",
+			Text: string(part.Content),
+		}
+	}
+	dataURL := fmt.Sprintf("data:%s;base64,%s",
+		part.MimeType,
+		base64.StdEncoding.EncodeToString(part.Content),
+	)
+	if strings.HasPrefix(part.MimeType, "image/") {
+		return ResponsesContent{
+			Type:     "input_image",
+			ImageURL: dataURL,
+		}
+	}
+	return ResponsesContent{
+		Type:     "input_file",
+		Filename: "attachment",
+		FileData: dataURL,
+	}
+}
+
+// ToResponses converts the declaration to the flat Responses function
+// tool, reusing the Chat Completions schema conversion.
+func (f FuncDecl) ToResponses() ResponsesTool {
+	tool := f.ToOpenAI()
+	return ResponsesTool{
+		Type:        "function",
+		Name:        tool.Function.Name,
+		Description: tool.Function.Description,
+		Strict:      tool.Function.Strict,
+		Parameters:  tool.Function.Parameters,
+	}
+}
+
+type NewOpenAIResponses func(spec Spec) *OpenAIResponses
+
+func (Module) NewOpenAIResponses(
+	inject dscope.InjectStruct,
+	client nets.HTTPClient,
+	apiKey OpenAIAPIKey,
+) NewOpenAIResponses {
+	return func(spec Spec) *OpenAIResponses {
+		ret := &OpenAIResponses{
+			spec:   spec,
+			client: client,
+			apiKey: firstNonZero(spec.APIKey, string(apiKey)),
+		}
+		inject(&ret)
+		return ret
+	}
+}
+
+type ResponsesRequest struct {
+	Model           string              `json:"model"`
+	Input           []any               `json:"input"`
+	Instructions    string              `json:"instructions,omitempty"`
+	Stream          bool                `json:"stream,omitempty"`
+	Store           *bool               `json:"store,omitempty"`
+	Include         []string            `json:"include,omitempty"`
+	Reasoning       *ResponsesReasoning `json:"reasoning,omitempty"`
+	MaxOutputTokens *int                `json:"max_output_tokens,omitempty"`
+	Temperature     *float32            `json:"temperature,omitempty"`
+	Tools           []ResponsesTool     `json:"tools,omitempty"`
+	Text            *ResponsesText      `json:"text,omitempty"`
+	ServiceTier     string              `json:"service_tier,omitempty"`
+}
+
+type ResponsesReasoning struct {
+	Effort  string `json:"effort,omitempty"`
+	Summary string `json:"summary,omitempty"`
+}
+
+type ResponsesTool struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Strict      bool   `json:"strict,omitempty"`
+	Parameters  any    `json:"parameters"`
+}
+
+type ResponsesText struct {
+	Format *ResponsesFormat `json:"format,omitempty"`
+}
+
+type ResponsesFormat struct {
+	Type   string `json:"type"`
+	Name   string `json:"name,omitempty"`
+	Strict bool   `json:"strict,omitempty"`
+	Schema any    `json:"schema,omitempty"`
+}
+
+type ResponsesMessageItem struct {
+	Type    string             `json:"type"`
+	Role    string             `json:"role"`
+	Content []ResponsesContent `json:"content"`
+}
+
+type ResponsesContent struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+	Filename string `json:"filename,omitempty"`
+	FileData string `json:"file_data,omitempty"`
+}
+
+type ResponsesReasoningItem struct {
+	Type             string             `json:"type"`
+	ID               string             `json:"id,omitempty"`
+	Summary          []ResponsesSummary `json:"summary"`
+	EncryptedContent string             `json:"encrypted_content,omitempty"`
+}
+
+type ResponsesSummary struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type ResponsesFunctionCallItem struct {
+	Type      string `json:"type"`
+	CallID    string `json:"call_id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+type ResponsesFunctionCallOutputItem struct {
+	Type   string `json:"type"`
+	CallID string `json:"call_id"`
+	Output string `json:"output"`
+}
+
+// ResponsesItem is an output item of any type; only the fields of its
+// type are set.
+type ResponsesItem struct {
+	Type             string             `json:"type"`
+	ID               string             `json:"id,omitempty"`
+	Role             string             `json:"role,omitempty"`
+	Status           string             `json:"status,omitempty"`
+	Content          []ResponsesContent `json:"content,omitempty"`
+	Summary          []ResponsesSummary `json:"summary,omitempty"`
+	EncryptedContent string             `json:"encrypted_content,omitempty"`
+	CallID           string             `json:"call_id,omitempty"`
+	Name             string             `json:"name,omitempty"`
+	Arguments        string             `json:"arguments,omitempty"`
+}
+
+type ResponsesResponse struct {
+	ID                string          `json:"id"`
+	Status            string          `json:"status"`
+	Output            []ResponsesItem `json:"output"`
+	Usage             *ResponsesUsage `json:"usage,omitempty"`
+	IncompleteDetails *struct {
+		Reason string `json:"reason"`
+	} `json:"incomplete_details,omitempty"`
+	Error *ResponsesError `json:"error,omitempty"`
+}
+
+type ResponsesStreamEvent struct {
+	Type         string             `json:"type"`
+	Delta        string             `json:"delta,omitempty"`
+	ItemID       string             `json:"item_id,omitempty"`
+	OutputIndex  int                `json:"output_index,omitempty"`
+	SummaryIndex int                `json:"summary_index,omitempty"`
+	Item         *ResponsesItem     `json:"item,omitempty"`
+	Response     *ResponsesResponse `json:"response,omitempty"`
+	Code         string             `json:"code,omitempty"`
+	Message      string             `json:"message,omitempty"`
+}
+
+type ResponsesUsage struct {
+	InputTokens        int `json:"input_tokens"`
+	InputTokensDetails *struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"input_tokens_details,omitempty"`
+	OutputTokens        int `json:"output_tokens"`
+	OutputTokensDetails *struct {
+		ReasoningTokens int `json:"reasoning_tokens"`
+	} `json:"output_tokens_details,omitempty"`
+	TotalTokens int `json:"total_tokens"`
+}
+
+// toUsage uses the Chat Completions accounting: cached tokens are a subset
+// of the input tokens, and reasoning tokens are split out of the output.
+func (u ResponsesUsage) toUsage() Usage {
+	var usage Usage
+	usage.Prompt.TokenCount = u.InputTokens
+	if u.InputTokensDetails != nil {
+		usage.Prompt.TokenCountCached = u.InputTokensDetails.CachedTokens
+	}
+	usage.Candidates.TokenCount = u.OutputTokens
+	if u.OutputTokensDetails != nil {
+		usage.Candidates.TokenCount -= u.OutputTokensDetails.ReasoningTokens
+		usage.Thoughts.TokenCount = u.OutputTokensDetails.ReasoningTokens
+	}
+	return usage
+}
+
+type ResponsesError struct {
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func (e *ResponsesError) Error() string {
+	if e.Code == "" {
+		return e.Message
+	}
+	return e.Code + ": " + e.Message
+}
+
+func (e *ResponsesError) retryable() bool {
+	switch e.Code {
+	case "server_error", "rate_limit_exceeded", "server_is_overloaded":
+		return true
+	}
+	return false
+}
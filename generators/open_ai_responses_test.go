@@ -0,0 +1,294 @@
+package generators
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/reusee/dscope"
+	"github.com/reusee/tai/modes"
+	"github.com/reusee/tai/nets"
+)
+
+func responsesSSE(events ...string) string {
+	var sb strings.Builder
+	for _, event := range events {
+		var typ struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal([]byte(event), &typ)
+		fmt.Fprintf(&sb, "event: %s\ndata: %s\n\n", typ.Type, event)
+	}
+	return sb.String()
+}
+
+func TestOpenAIResponsesStreaming(t *testing.T) {
+	var gotBody map[string]any
+	var gotPath, gotAuth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &gotBody); err != nil {
+			t.Errorf("bad request body: %v", err)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, responsesSSE(
+			`{"type":"response.created","response":{"id":"resp_1","status":"in_progress"}}`,
+			`{"type":"response.output_item.added","output_index":0,"item":{"type":"reasoning","id":"rs_1","summary":[]}}`,
+			`{"type":"response.reasoning_summary_part.added","item_id":"rs_1","summary_index":0}`,
+			`{"type":"response.reasoning_summary_text.delta","item_id":"rs_1","summary_index":0,"delta":"first"}`,
+			`{"type":"response.reasoning_summary_part.added","item_id":"rs_1","summary_index":1}`,
+			`{"type":"response.reasoning_summary_text.delta","item_id":"rs_1","summary_index":1,"delta":"second"}`,
+			`{"type":"response.output_item.done","output_index":0,"item":{"type":"reasoning","id":"rs_1","summary":[{"type":"summary_text","text":"first"},{"type":"summary_text","text":"second"}],"encrypted_content":"enc-1"}}`,
+			`{"type":"response.output_text.delta","item_id":"msg_1","delta":"hello "}`,
+			`{"type":"response.output_text.delta","item_id":"msg_1","delta":"world"}`,
+			`{"type":"response.output_item.done","output_index":1,"item":{"type":"message","id":"msg_1","role":"assistant","content":[{"type":"output_text","text":"hello world"}]}}`,
+			`{"type":"response.output_item.done","output_index":2,"item":{"type":"function_call","id":"fc_1","call_id":"call_1","name":"now","arguments":"{\"tz\":\"UTC\"}"}}`,
+			`{"type":"response.completed","response":{"id":"resp_1","status":"completed","usage":{"input_tokens":100,"input_tokens_details":{"cached_tokens":60},"output_tokens":50,"output_tokens_details":{"reasoning_tokens":20},"total_tokens":150}}}`,
+		))
+	}))
+	defer server.Close()
+
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Fork(
+		func() nets.HTTPClient {
+			return nets.HTTPClient{Client: server.Client()}
+		},
+	).Call(func(
+		newResponses NewOpenAIResponses,
+	) {
+		gen := newResponses(Spec{
+			BaseURL:           server.URL,
+			Model:             "o-test",
+			APIKey:            "test-key",
+			PreservedThinking: new(true),
+		})
+
+		state := WithFunctions(NewPrompts("be brief", []*Content{
+			{Role: RoleUser, Parts: []Part{Text("hi")}},
+		}), &Function{
+			Decl: FuncDecl{
+				Name:        "now",
+				Description: "current time",
+				Params: Vars{
+					{Name: "tz", Type: TypeString, Description: "time zone"},
+				},
+			},
+		})
+		ret, err := gen.Generate(context.Background(), state, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if gotPath != "/responses" {
+			t.Fatalf("got path %q", gotPath)
+		}
+		if gotAuth != "Bearer test-key" {
+			t.Fatalf("got auth %q", gotAuth)
+		}
+		if gotBody["stream"] != true || gotBody["store"] != false {
+			t.Fatalf("expected stateless streaming request, got %v", gotBody)
+		}
+		if gotBody["instructions"] != "be brief" {
+			t.Fatalf("got instructions %v", gotBody["instructions"])
+		}
+		if include, _ := gotBody["include"].([]any); len(include) != 1 || include[0] != "reasoning.encrypted_content" {
+			t.Fatalf("got include %v", gotBody["include"])
+		}
+		tools, _ := gotBody["tools"].([]any)
+		if len(tools) != 1 {
+			t.Fatalf("got tools %v", gotBody["tools"])
+		}
+		if tool := tools[0].(map[string]any); tool["type"] != "function" || tool["name"] != "now" || tool["parameters"] == nil {
+			t.Fatalf("got tool %v", tool)
+		}
+
+		var thought Thought
+		var text Text
+		var sig ThoughtSignature
+		var call FuncCall
+		var usage Usage
+		var finish FinishReason
+		for c := range ret.Contents() {
+			for _, p := range c.Parts {
+				switch p := p.(type) {
+				case Thought:
+					thought += p
+				case Text:
+					if c.Role == RoleModel {
+						text += p
+					}
+				case ThoughtSignature:
+					sig = p
+				case FuncCall:
+					call = p
+				case Usage:
+					usage = p
+				case FinishReason:
+					finish = p
+				}
+			}
+		}
+		if thought != "first\n\nsecond" {
+			t.Fatalf("got thought %q", thought)
+		}
+		if sig.Provider != SignatureProviderResponses || sig.ID != "rs_1" || sig.Signature != "enc-1" {
+			t.Fatalf("got signature %+v", sig)
+		}
+		if text != "hello world" {
+			t.Fatalf("got text %q", text)
+		}
+		if call.ID != "call_1" || call.Name != "now" || call.Arguments["tz"] != "UTC" {
+			t.Fatalf("got call %+v", call)
+		}
+		if usage.Prompt.TokenCount != 100 ||
+			usage.Prompt.TokenCountCached != 60 ||
+			usage.Candidates.TokenCount != 30 ||
+			usage.Thoughts.TokenCount != 20 {
+			t.Fatalf("got usage %+v", usage)
+		}
+		if finish != "stop" {
+			t.Fatalf("got finish reason %q", finish)
+		}
+	})
+}
+
+func TestOpenAIResponsesNonStreaming(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id":"resp_1","status":"incomplete","incomplete_details":{"reason":"max_output_tokens"},"output":[{"type":"message","role":"assistant","content":[{"type":"output_text","text":"done"}]}],"usage":{"input_tokens":5,"output_tokens":2}}`)
+	}))
+	defer server.Close()
+
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Fork(
+		func() nets.HTTPClient {
+			return nets.HTTPClient{Client: server.Client()}
+		},
+	).Call(func(
+		newResponses NewOpenAIResponses,
+	) {
+		gen := newResponses(Spec{
+			BaseURL: server.URL,
+			Model:   "o-test",
+		})
+		state := NewPrompts("", []*Content{
+			{Role: RoleUser, Parts: []Part{Text("hi")}},
+		})
+		ret, err := gen.Generate(context.Background(), state, &GenerateOptions{
+			NonStreaming: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var text Text
+		var finish FinishReason
+		for c := range ret.Contents() {
+			for _, p := range c.Parts {
+				switch p := p.(type) {
+				case Text:
+					if c.Role == RoleModel {
+						text += p
+					}
+				case FinishReason:
+					finish = p
+				}
+			}
+		}
+		if text != "done" {
+			t.Fatalf("got text %q", text)
+		}
+		if finish != "length" {
+			t.Fatalf("output limit must be reported as length, got %q", finish)
+		}
+	})
+}
+
+func TestStateToResponsesInput(t *testing.T) {
+	state := NewPrompts("", []*Content{
+		{Role: RoleUser, Parts: []Part{
+			Text("q"),
+			FileContent{Content: []byte("package main"), MimeType: "text/plain; charset=utf-8"},
+			CacheBreakpoint{},
+		}},
+		{Role: RoleModel, Parts: []Part{
+			Thought("summary"),
+			ThoughtSignature{Provider: SignatureProviderResponses, ID: "rs_1", Signature: "enc"},
+			Thought("foreign"),
+			ThoughtSignature{Provider: SignatureProviderAnthropic, Signature: "sig"},
+			Text("answer"),
+			FuncCall{ID: "c1", Name: "f", Arguments: map[string]any{"a": 1}},
+		}},
+		{Role: RoleTool, Parts: []Part{
+			CallResult{ID: "c1", Name: "f", Results: map[string]any{"ok": true}},
+		}},
+		{Role: RoleLog, Parts: []Part{Usage{}}},
+	})
+
+	types := func(items []any) (ret []string) {
+		for _, item := range items {
+			switch item := item.(type) {
+			case *ResponsesMessageItem:
+				ret = append(ret, item.Type+":"+item.Role)
+			case *ResponsesReasoningItem:
+				ret = append(ret, item.Type)
+			case *ResponsesFunctionCallItem:
+				ret = append(ret, item.Type)
+			case *ResponsesFunctionCallOutputItem:
+				ret = append(ret, item.Type)
+			}
+		}
+		return
+	}
+
+	t.Run("preserved thinking", func(t *testing.T) {
+		items, err := stateToResponsesInput(state, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := strings.Join(types(items), ",")
+		want := "message:user,reasoning,message:assistant,function_call,function_call_output"
+		if got != want {
+			t.Fatalf("got %s, want %s", got, want)
+		}
+		user := items[0].(*ResponsesMessageItem)
+		if len(user.Content) != 2 || user.Content[1].Type != "input_text" {
+			t.Fatalf("got %+v", user)
+		}
+		reasoning := items[1].(*ResponsesReasoningItem)
+		if reasoning.ID != "rs_1" || reasoning.EncryptedContent != "enc" ||
+			len(reasoning.Summary) != 1 || reasoning.Summary[0].Text != "summary" {
+			t.Fatalf("got %+v", reasoning)
+		}
+		if assistant := items[2].(*ResponsesMessageItem); assistant.Content[0].Type != "output_text" {
+			t.Fatalf("got %+v", assistant)
+		}
+		if call := items[3].(*ResponsesFunctionCallItem); call.CallID != "c1" || call.Arguments != `{"a":1}` {
+			t.Fatalf("got %+v", call)
+		}
+		if output := items[4].(*ResponsesFunctionCallOutputItem); output.CallID != "c1" || output.Output != `{"ok":true}` {
+			t.Fatalf("got %+v", output)
+		}
+	})
+
+	t.Run("stripped thinking", func(t *testing.T) {
+		items, err := stateToResponsesInput(state, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := strings.Join(types(items), ",")
+		want := "message:user,message:assistant,function_call,function_call_output"
+		if got != want {
+			t.Fatalf("got %s, want %s", got, want)
+		}
+	})
+}
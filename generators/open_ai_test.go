@@ -80,7 +80,7 @@ func TestStateToOpenAIMessages(t *testing.T) {
 			},
 		})
 
-		messages, err := stateToOpenAIMessages(state, false)
+		messages, err := stateToOpenAIMessages(state, false, false, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -110,7 +110,7 @@ func TestStateToOpenAIMessages(t *testing.T) {
 			},
 		})
 
-		messages, err := stateToOpenAIMessages(state, false)
+		messages, err := stateToOpenAIMessages(state, false, false, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -140,7 +140,7 @@ func TestStateToOpenAIMessages(t *testing.T) {
 				},
 			},
 		})
-		messages, err := stateToOpenAIMessages(state, true)
+		messages, err := stateToOpenAIMessages(state, true, false, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -165,7 +165,7 @@ func TestStateToOpenAIMessages(t *testing.T) {
 				},
 			},
 		})
-		messages, err := stateToOpenAIMessages(state, false)
+		messages, err := stateToOpenAIMessages(state, false, false, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -196,7 +196,7 @@ func TestStateToOpenAIMessages(t *testing.T) {
 				},
 			},
 		})
-		messages, err := stateToOpenAIMessages(state, false)
+		messages, err := stateToOpenAIMessages(state, false, false, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -211,6 +211,42 @@ func TestStateToOpenAIMessages(t *testing.T) {
 		}
 	})
 
+	t.Run("cache breakpoints", func(t *testing.T) {
+		state := NewPrompts("", []*Content{
+			{
+				Role: RoleUser,
+				Parts: []Part{
+					Text("stable"),
+					CacheBreakpoint{},
+					Text("volatile"),
+				},
+			},
+		})
+
+		messages, err := stateToOpenAIMessages(state, false, false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentStr, ok := messages[0].Content.(string); !ok || contentStr != "stablevolatile" {
+			t.Fatalf("breakpoints must be dropped without cache control, got %+v", messages)
+		}
+
+		messages, err = stateToOpenAIMessages(state, false, true, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts, ok := messages[0].Content.([]ChatMessagePart)
+		if !ok || len(parts) != 2 {
+			t.Fatalf("got %+v", messages)
+		}
+		if parts[0].Text != "stable" || parts[0].CacheControl == nil {
+			t.Fatalf("stable prefix must carry cache_control, got %+v", parts[0])
+		}
+		if parts[1].Text != "volatile" || parts[1].CacheControl != nil {
+			t.Fatalf("got %+v", parts[1])
+		}
+	})
+
 }
 
 func TestAzureConfiguration(t *testing.T) {
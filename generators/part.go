@@ -9,18 +9,58 @@ The Part interface is a sealed type union for content parts. It uses only a
 marker method (isPart) to prevent external implementations. Gemini-specific
 conversion is handled by the partToGemini function, which uses a type switch —
 mirroring the OpenAI path (stateToOpenAIMessages) that uses type switches.
-Metadata types (Thought, FinishReason, Usage, Error) have no Gemini
-representation: Thought is skipped by a continue before the conversion call,
-and the other three are carried in RoleLog content that is filtered out before
-the conversion loop.
+Metadata types (Thought, ThoughtSignature, CacheBreakpoint, FinishReason,
+Usage, Error, GeneratorFallback) have no Gemini part representation: Thought
+is skipped by a continue before the conversion call, ThoughtSignature and
+CacheBreakpoint convert to nil, and the others are carried in RoleLog
+content that is filtered out before the conversion loop. Both paths first
+pass each part through the capability adapter, which replaces file parts
+the model cannot read with text (see TheoryOfCapabilities).
+`
+
+const TheoryOfCacheBreakpoints = `
+Context providers know which parts of the prompt are stable and which are
+volatile: the three-tier file ordering (see gotools.TheoryOfFileOrdering)
+places non-root-module files first, root-module context files next, and
+root-package focus files last, followed by per-request extras. Providers
+with implicit prefix caching reuse whatever prefix happens to match, but
+providers with explicit caching only cache at the positions the request
+marks. CacheBreakpoint carries the tier boundaries from the context
+provider to the generators so the explicit markers land where the stable
+prefixes end, instead of only at the end of the conversation.
+
+A CacheBreakpoint is a marker part with no content. It also stops Content
+merging of adjacent Text parts (Content.Merge only concatenates directly
+adjacent Text parts), so each tier stays a separate text block that can
+carry its own marker. Each generator maps breakpoints to its own mechanism:
+
+- Anthropic marks the content block preceding each breakpoint with an
+  ephemeral cache_control, keeping the earliest breakpoints when the
+  per-request marker limit is reached, because earlier prefixes change
+  least often.
+- OpenAI-compatible generators forward the same cache_control marker on
+  the text part preceding the breakpoint when Spec.CacheControl is set,
+  for providers (e.g. OpenRouter routing to Claude or Gemini) that accept
+  it. Providers that do not accept the field would reject the request, so
+  forwarding is opt-in.
+- Gemini creates a cachedContents resource for the prefix up to the last
+  breakpoint (system instruction, tools, and contents) and references it
+  in the request, reusing an existing resource whose content hash matches.
+  See TheoryOfGeminiCachedContent.
+
+Generators and renderers without explicit caching ignore the part. Cache
+hits are reported as Usage.Prompt.TokenCountCached by every generator, so
+they appear in the per-round statistics without provider-specific code.
 `
 
 type Part interface {
 	isPart()
 }
 
-func partToGemini(part Part) *genai.Part {
-	switch p := part.(type) {
+// partToGemini converts part to its Gemini form. adapter adapts it to the
+// model's capabilities and may be nil. See TheoryOfCapabilities.
+func partToGemini(part Part, adapter *capabilityAdapter) *genai.Part {
+	switch p := adapter.part(part).(type) {
 	case Text:
 		if len(p) == 0 {
 			return nil
@@ -74,6 +114,36 @@ type Thought string
 
 func (Thought) isPart() {}
 
+// ThoughtSignature is the provider-issued token that closes the preceding
+// Thought parts of one reasoning block. Providers that verify reasoning
+// sent back to them require the signature alongside the thought text:
+// Anthropic signs thinking blocks (Signature) or withholds them entirely
+// (Redacted), and the OpenAI Responses API returns reasoning items as
+// encrypted content (Signature) identified by ID. Provider names the
+// issuing API, because a signature is only meaningful to the provider
+// that issued it; generators drop signatures from other providers.
+type ThoughtSignature struct {
+	Provider  string
+	ID        string
+	Signature string
+	Redacted  string
+}
+
+const (
+	SignatureProviderAnthropic = "anthropic"
+	SignatureProviderResponses = "openai-responses"
+)
+
+func (ThoughtSignature) isPart() {}
+
+// CacheBreakpoint marks the end of a stable prompt prefix. Context
+// providers emit it at tier boundaries; generators with explicit prompt
+// caching place their cache markers there and all others ignore it. See
+// TheoryOfCacheBreakpoints.
+type CacheBreakpoint struct{}
+
+func (CacheBreakpoint) isPart() {}
+
 type FileURL string
 
 func (FileURL) isPart() {}
@@ -112,8 +182,9 @@ func (FinishReason) isPart() {}
 
 type Usage struct {
 	Prompt struct {
-		TokenCount       int
-		TokenCountCached int
+		TokenCount           int
+		TokenCountCached     int
+		TokenCountCacheWrite int
 	}
 	Candidates struct {
 		TokenCount int
@@ -121,6 +192,9 @@ type Usage struct {
 	Thoughts struct {
 		TokenCount int
 	}
+	// Batch reports that the request ran in a batch job. See
+	// TheoryOfBatch.
+	Batch bool
 }
 
 func (Usage) isPart() {}
@@ -131,6 +205,21 @@ type Error struct {
 
 func (Error) isPart() {}
 
+// GeneratorFallback records that the generator From failed and the
+// generation was retried with To, the next spec of its fallback chain.
+// It is carried in RoleLog content so round statistics and transcripts
+// show the switch. See TheoryOfFallback.
+type GeneratorFallback struct {
+	From  string
+	To    string
+	Error string
+	// ToSpec is the spec of To, which prices the usage of the calls it
+	// serves.
+	ToSpec Spec
+}
+
+func (GeneratorFallback) isPart() {}
+
 func PartFromGemini(part *genai.Part) Part {
 	if part.Text != "" || part.Thought {
 		if part.Thought {
@@ -0,0 +1,65 @@
+package generators
+
+const TheoryOfPricing = `
+Token counts alone do not tell what a session spent: prices differ by model,
+by provider, and by token kind. A spec can carry its prices per million
+tokens: InputPrice for prompt tokens, CachedInputPrice for prompt tokens
+served from the provider's cache, OutputPrice for completion tokens, and
+ThinkingPrice for reasoning tokens. The fields are pointers merged from
+parent to child like the other optional fields, so a family-level spec can
+set prices that its variants inherit or override. Prices carry no currency;
+costs are in whatever currency the configured prices use.
+
+Spec.Cost prices one Usage part. Usage reports disjoint counts across
+providers: Prompt.TokenCount is the whole input, of which
+Prompt.TokenCountCached were cache hits, and reasoning tokens are reported
+in Thoughts rather than Candidates when the provider separates them.
+Uncached input is charged at InputPrice and cached input at
+CachedInputPrice, falling back to InputPrice; cache writes are charged as
+plain input. Thoughts fall back to OutputPrice, since providers bill
+reasoning as output. A spec without any price reports no cost, which is
+different from a free model with zero prices. Usage from a batch job (see
+TheoryOfBatch) costs batchPriceRatio of the listed prices, the discount
+OpenAI and Gemini both apply.
+
+The cost of a round is the sum of its Generate calls, each priced from the
+call's final Usage part with the spec of the generator that served it, so
+a fallback switch is priced at the fallback's rates. Costs appear in the
+round statistics table (see codes.TheoryOfRoundStatistics) and are
+recorded as "cost" events so the record command can show the spend of each
+session.
+`
+
+// Cost returns the cost of usage under the spec's prices, per
+// TheoryOfPricing. ok is false when the spec has no price set.
+func (s Spec) Cost(usage Usage) (cost float64, ok bool) {
+	if s.InputPrice == nil &&
+		s.CachedInputPrice == nil &&
+		s.OutputPrice == nil &&
+		s.ThinkingPrice == nil {
+		return 0, false
+	}
+	price := func(p *float64, fallback *float64) float64 {
+		if p != nil {
+			return *p
+		}
+		if fallback != nil {
+			return *fallback
+		}
+		return 0
+	}
+	cached := usage.Prompt.TokenCountCached
+	uncached := max(usage.Prompt.TokenCount-cached, 0)
+	cost = float64(uncached)*price(s.InputPrice, nil) +
+		float64(cached)*price(s.CachedInputPrice, s.InputPrice) +
+		float64(usage.Candidates.TokenCount)*price(s.OutputPrice, nil) +
+		float64(usage.Thoughts.TokenCount)*price(s.ThinkingPrice, s.OutputPrice)
+	if usage.Batch {
+		cost *= batchPriceRatio
+	}
+	return cost / 1e6, true
+}
+
+// batchPriceRatio is the price of batch usage relative to the listed
+// prices.
+const batchPriceRatio = 0.5
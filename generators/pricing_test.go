@@ -0,0 +1,94 @@
+package generators
+
+import "testing"
+
+func TestSpecCost(t *testing.T) {
+	var usage Usage
+	usage.Prompt.TokenCount = 3_000_000
+	usage.Prompt.TokenCountCached = 1_000_000
+	usage.Candidates.TokenCount = 1_000_000
+	usage.Thoughts.TokenCount = 2_000_000
+
+	if _, ok := (Spec{}).Cost(usage); ok {
+		t.Fatal("spec without prices must not report a cost")
+	}
+
+	for _, c := range []struct {
+		name string
+		spec Spec
+		want float64
+	}{
+		{
+			name: "all prices",
+			spec: Spec{
+				InputPrice:       new(2.0),
+				CachedInputPrice: new(0.5),
+				OutputPrice:      new(8.0),
+				ThinkingPrice:    new(4.0),
+			},
+			want: 2*2 + 0.5 + 8 + 2*4,
+		},
+		{
+			name: "defaults",
+			spec: Spec{
+				InputPrice:  new(2.0),
+				OutputPrice: new(8.0),
+			},
+			want: 3*2 + 8 + 2*8,
+		},
+		{
+			name: "free",
+			spec: Spec{
+				InputPrice: new(0.0),
+			},
+			want: 0,
+		},
+	} {
+		got, ok := c.spec.Cost(usage)
+		if !ok {
+			t.Fatalf("%s: expected a cost", c.name)
+		}
+		if got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	usage.Batch = true
+	spec := Spec{
+		InputPrice:  new(2.0),
+		OutputPrice: new(8.0),
+	}
+	if got, _ := spec.Cost(usage); got != (3*2+8+2*8)*batchPriceRatio {
+		t.Errorf("batch: got %v", got)
+	}
+}
+
+func TestResolveSpecPricing(t *testing.T) {
+	localRoots := []Spec{
+		{
+			Name:        "base",
+			Type:        "gemini",
+			InputPrice:  new(1.0),
+			OutputPrice: new(4.0),
+			Variants: []Spec{
+				{
+					Name:        "child",
+					OutputPrice: new(2.0),
+				},
+			},
+		},
+	}
+	s, err := resolveSpec("base/child", localRoots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.InputPrice == nil || *s.InputPrice != 1 {
+		t.Errorf("expected inherited input price 1, got %v", s.InputPrice)
+	}
+	if s.OutputPrice == nil || *s.OutputPrice != 2 {
+		t.Errorf("expected overridden output price 2, got %v", s.OutputPrice)
+	}
+	if s.CachedInputPrice != nil || s.ThinkingPrice != nil {
+		t.Errorf("unset prices must stay nil, got %v %v", s.CachedInputPrice, s.ThinkingPrice)
+	}
+}
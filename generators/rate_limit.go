@@ -0,0 +1,227 @@
+package generators
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/reusee/tai/logs"
+)
+
+const TheoryOfRateLimit = `
+Providers with strict requests-per-minute and tokens-per-minute quotas
+answer bursts with 429s, which Retrier absorbs by sleeping exponentially:
+the quota is wasted on rejected requests and the backoff overshoots the
+time the quota actually needs to recover. Spec.RequestsPerMinute and
+Spec.TokensPerMinute describe the quota so requests are paced on the
+client side instead.
+
+RateLimiters holds one pair of token buckets per resolved spec: a request
+bucket holding RequestsPerMinute and a token bucket holding
+TokensPerMinute, each refilled continuously over a minute and starting
+full, so short bursts within the quota are not delayed. Before each API
+call, including each retry attempt, a generator reserves one request and
+the estimated prompt tokens and waits until both buckets cover the
+reservation. Reservations are taken immediately, possibly driving a bucket
+negative, so concurrent callers queue in order instead of waking together.
+The token estimate counts the system prompt and the text of the contents,
+joined into one text, with the generator's CountTokens, so a counter asking
+the provider makes one request; it is computed once per Generate call and
+only when TokensPerMinute is set. A request larger than the whole minute's
+quota is clamped to it, since waiting could never satisfy it.
+
+Providers limit count-tokens requests (see TheoryOfTokenizers) apart from
+generation, so they do not draw from the generation buckets: each reserves
+one request from a count-tokens bucket of its own, holding
+RequestsPerMinute like the request bucket. A session counting its context
+therefore never delays its generations, and a burst of counts is still
+paced.
+
+The buckets are process-wide, keyed by the resolved spec name (the model
+and base URL for specs without a name). Every generator resolved in the
+process shares them — the main generator, handoff generators,
+summarizers, and review models — including generators built in scopes
+recreated by dscope.Reset for goal loops and review sessions, which is why
+the registry is a package-level value rather than a per-scope provider
+result. Limits are read from the spec on every reservation, so the last
+resolved configuration applies. Specs without either field are not
+limited.
+`
+
+// RateLimiters paces requests per resolved spec. See TheoryOfRateLimit.
+type RateLimiters struct {
+	mu      sync.Mutex
+	buckets map[string]*specBuckets
+	now     func() time.Time
+}
+
+type specBuckets struct {
+	requests tokenBucket
+	tokens   tokenBucket
+	// countRequests paces count-tokens requests.
+	countRequests tokenBucket
+}
+
+// tokenBucket holds up to perMinute units, refilled continuously over a
+// minute.
+type tokenBucket struct {
+	perMinute int
+	available float64
+	updated   time.Time
+}
+
+var processRateLimiters = newRateLimiters()
+
+// RateLimiters provides the process-wide limiters. See TheoryOfRateLimit.
+func (Module) RateLimiters() *RateLimiters {
+	return processRateLimiters
+}
+
+func newRateLimiters() *RateLimiters {
+	return &RateLimiters{
+		buckets: make(map[string]*specBuckets),
+		now:     time.Now,
+	}
+}
+
+func (s Spec) rateLimited() bool {
+	return s.RequestsPerMinute > 0 || s.TokensPerMinute > 0
+}
+
+func (s Spec) rateLimitKey() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return s.Model + "@" + s.BaseURL
+}
+
+// reserve takes one request and tokens from the buckets of spec and
+// returns how long the caller must wait before sending the request.
+func (r *RateLimiters) reserve(spec Spec, tokens int) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	buckets := r.specBuckets(spec)
+	now := r.now()
+	return max(
+		buckets.requests.reserve(now, spec.RequestsPerMinute, 1),
+		buckets.tokens.reserve(now, spec.TokensPerMinute, tokens),
+	)
+}
+
+// reserveCountTokens takes one request from the count-tokens bucket of
+// spec and returns how long the caller must wait before sending it.
+func (r *RateLimiters) reserveCountTokens(spec Spec) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.specBuckets(spec).countRequests.reserve(r.now(), spec.RequestsPerMinute, 1)
+}
+
+func (r *RateLimiters) specBuckets(spec Spec) *specBuckets {
+	key := spec.rateLimitKey()
+	buckets, ok := r.buckets[key]
+	if !ok {
+		buckets = new(specBuckets)
+		r.buckets[key] = buckets
+	}
+	return buckets
+}
+
+func (b *tokenBucket) reserve(now time.Time, perMinute int, n int) time.Duration {
+	if perMinute <= 0 {
+		*b = tokenBucket{}
+		return 0
+	}
+	capacity := float64(perMinute)
+	if b.perMinute == 0 {
+		b.available = capacity
+	} else {
+		b.available += now.Sub(b.updated).Minutes() * capacity
+	}
+	b.available = min(b.available, capacity)
+	b.perMinute = perMinute
+	b.updated = now
+	b.available -= min(float64(n), capacity)
+	if b.available >= 0 {
+		return 0
+	}
+	return time.Duration(-b.available / capacity * float64(time.Minute))
+}
+
+// estimateRequestTokens estimates the prompt tokens of state for the
+// tokens-per-minute bucket, or returns zero when spec has no token limit.
+func estimateRequestTokens(spec Spec, state State, countTokens func(string) (int, error)) int {
+	if spec.TokensPerMinute <= 0 {
+		return 0
+	}
+	return countPromptTokens(state, countTokens)
+}
+
+// countPromptTokens counts the system prompt and the text parts of state
+// in one count. A counting error counts zero, so the result is a lower
+// bound.
+func countPromptTokens(state State, countTokens func(string) (int, error)) int {
+	text := promptText(state)
+	if text == "" {
+		return 0
+	}
+	n, err := countTokens(text)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// promptText joins the system prompt and the text parts of state.
+func promptText(state State) string {
+	var texts []string
+	if prompt := state.SystemPrompt(); prompt != "" {
+		texts = append(texts, prompt)
+	}
+	for content := range state.Contents() {
+		for _, part := range content.Parts {
+			if text, ok := part.(Text); ok && text != "" {
+				texts = append(texts, string(text))
+			}
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// waitRateLimit waits until the buckets of spec admit a request of tokens
+// estimated prompt tokens. See TheoryOfRateLimit.
+func waitRateLimit(ctx context.Context, limiters *RateLimiters, logger logs.Logger, spec Spec, tokens int) error {
+	if !spec.rateLimited() {
+		return nil
+	}
+	return waitDelay(ctx, logger, spec, "rate limit wait", tokens, limiters.reserve(spec, tokens))
+}
+
+// waitCountTokensRateLimit waits until the count-tokens bucket of spec
+// admits a request. See TheoryOfRateLimit.
+func waitCountTokensRateLimit(ctx context.Context, limiters *RateLimiters, logger logs.Logger, spec Spec) error {
+	if spec.RequestsPerMinute <= 0 {
+		return nil
+	}
+	return waitDelay(ctx, logger, spec, "count tokens rate limit wait", 0, limiters.reserveCountTokens(spec))
+}
+
+func waitDelay(ctx context.Context, logger logs.Logger, spec Spec, msg string, tokens int, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+	logger.InfoContext(ctx, msg,
+		"name", spec.Name,
+		"model", spec.Model,
+		"tokens", tokens,
+		"delay", delay,
+	)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,155 @@
+package generators
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/reusee/dscope"
+	"github.com/reusee/tai/modes"
+	"github.com/reusee/tai/nets"
+)
+
+func TestRateLimitersReserve(t *testing.T) {
+	clock := time.Unix(0, 0)
+	limiters := newRateLimiters()
+	limiters.now = func() time.Time {
+		return clock
+	}
+
+	spec := Spec{
+		Name:              "limited",
+		RequestsPerMinute: 2,
+		TokensPerMinute:   1000,
+	}
+	// the buckets start full
+	if d := limiters.reserve(spec, 400); d != 0 {
+		t.Fatalf("first request must not wait, got %v", d)
+	}
+	if d := limiters.reserve(spec, 400); d != 0 {
+		t.Fatalf("burst within the quota must not wait, got %v", d)
+	}
+	// the third request exceeds the request quota by one
+	if d := limiters.reserve(spec, 100); d != 30*time.Second {
+		t.Fatalf("expected to wait for one request to refill, got %v", d)
+	}
+
+	// tokens refill continuously
+	clock = clock.Add(2 * time.Minute)
+	if d := limiters.reserve(spec, 900); d != 0 {
+		t.Fatalf("refilled bucket must not wait, got %v", d)
+	}
+	if d := limiters.reserve(spec, 400); d != 18*time.Second {
+		t.Fatalf("expected to wait for 300 tokens to refill, got %v", d)
+	}
+
+	// a request larger than the quota is clamped to it
+	other := Spec{
+		Name:            "other",
+		TokensPerMinute: 100,
+	}
+	if d := limiters.reserve(other, 1000); d != 0 {
+		t.Fatalf("oversized request must be clamped to the quota, got %v", d)
+	}
+
+	// buckets are keyed by spec
+	if d := limiters.reserve(Spec{Name: "unlimited"}, 1000); d != 0 {
+		t.Fatalf("unlimited spec must not wait, got %v", d)
+	}
+}
+
+func TestEstimateRequestTokens(t *testing.T) {
+	state := NewPrompts("system", []*Content{
+		{Role: RoleUser, Parts: []Part{Text("hello"), Thought("ignored")}},
+	})
+	count := func(text string) (int, error) {
+		return len(text), nil
+	}
+	if n := estimateRequestTokens(Spec{}, state, count); n != 0 {
+		t.Fatalf("no estimate without a token limit, got %d", n)
+	}
+	// the request is counted as one text
+	calls := 0
+	countOnce := func(text string) (int, error) {
+		calls++
+		return count(text)
+	}
+	if n := estimateRequestTokens(Spec{TokensPerMinute: 1}, state, countOnce); n != len("system\nhello") {
+		t.Fatalf("got %d", n)
+	}
+	if calls != 1 {
+		t.Fatalf("expected one count, got %d", calls)
+	}
+}
+
+func TestRateLimitersReserveCountTokens(t *testing.T) {
+	clock := time.Unix(0, 0)
+	limiters := newRateLimiters()
+	limiters.now = func() time.Time {
+		return clock
+	}
+	spec := Spec{
+		Name:              "limited",
+		RequestsPerMinute: 1,
+	}
+	// counts do not draw from the generation buckets
+	if d := limiters.reserveCountTokens(spec); d != 0 {
+		t.Fatalf("first count must not wait, got %v", d)
+	}
+	if d := limiters.reserve(spec, 0); d != 0 {
+		t.Fatalf("generation must not wait for counts, got %v", d)
+	}
+	// counts are paced by their own bucket
+	if d := limiters.reserveCountTokens(spec); d != time.Minute {
+		t.Fatalf("expected to wait for one count to refill, got %v", d)
+	}
+}
+
+func TestOpenAIRateLimit(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"role\":\"assistant\",\"content\":\"ok\"}}]}\n\ndata: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Fork(
+		func() nets.HTTPClient {
+			return nets.HTTPClient{Client: server.Client()}
+		},
+	).Call(func(
+		newOpenAI NewOpenAI,
+	) {
+		state := NewPrompts("", []*Content{
+			{Role: RoleUser, Parts: []Part{Text("hi")}},
+		})
+		spec := Spec{
+			Name:              t.Name(),
+			BaseURL:           server.URL,
+			Model:             "test-model",
+			DisableTools:      new(true),
+			RequestsPerMinute: 1,
+		}
+		// generators of the same spec share the buckets
+		if _, err := newOpenAI(spec, "key").Generate(context.Background(), state, nil); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := newOpenAI(spec, "key").Generate(ctx, state, nil)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected the second request to wait past the deadline, got %v", err)
+		}
+		if calls != 1 {
+			t.Fatalf("the paced request must not be sent, got %d calls", calls)
+		}
+	})
+}
@@ -0,0 +1,587 @@
+package generators
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/reusee/dscope"
+)
+
+const TheoryOfReplay = `
+Tests and prompt experiments need the pipeline to see the same model output
+every time, without paying for or waiting on a provider. A replay generator
+(spec type "replay") serves responses recorded earlier instead of calling an
+API, and a record mode writes those recordings from any real generator.
+
+A recording is a list of interactions: the key of a request and the contents
+the generator appended to the State in response. The key is a SHA-256 hash
+of the system prompt and the contents sent to the model, computed by
+ReplayKey. Only what a provider sees and what the records transcript
+preserves is hashed: RoleLog contents and reasoning parts (Thought,
+ThoughtSignature, CacheBreakpoint, Usage, FinishReason) are skipped, the
+assistant role is folded into the model role, and whitespace is ignored, so
+part and streaming-chunk boundaries — which the merged State and the
+records transcript render differently — do not change the key. Function
+calls, call results and files are hashed by their transcript rendering.
+
+Recordings come from two sources. A cassette is a JSON Lines file with one
+interaction per line, written by record mode: when a spec of any other type
+sets Cassette, GetGenerator wraps its generator in Recording, which captures
+every content the generator appends during a successful Generate and
+appends the interaction to the file. Cassettes preserve every part
+exactly, except provider-specific FuncCall.Origin values and the ToSpec of
+a GeneratorFallback, of which only the identity and the prices are kept:
+enough to price the calls the fallback target served (see
+TheoryOfPricing), without the API key. Failed calls are
+not recorded; the retry that follows records its own interaction. A records
+session is the other source: a replay spec with ReplaySession loads the
+interactions from the interaction database through ReplaySessions, which
+the records package's Recorder implements and commands fork in like
+EventRecorder. The transcript is lossy, so sessions replay text, thoughts,
+usage and finish reasons only; function calls, and rounds retried from an
+earlier state, need a cassette.
+
+Replay.Generate computes the key of the incoming State and serves the first
+unserved interaction recorded under it, appending its contents through
+AppendContent exactly as the recorded generator did, so state wrappers
+(function execution, parsers, recorders) see the same stream. Identical
+requests are served in recording order. A request without a recording is a
+divergence: the pipeline no longer sends what was recorded, and serving
+anything else would silently test a different conversation, so Generate
+fails with ErrReplayDivergence, naming the key, the call number, and the
+next unserved recording. The error is neither retryable nor a fallback
+error. Tokens are counted with the BPE counter, so context budgeting is
+deterministic across replays.
+`
+
+// ErrReplayDivergence is returned by Replay when a request has no
+// recorded response. See TheoryOfReplay.
+var ErrReplayDivergence = errors.New("replay divergence")
+
+// ReplayInteraction is one recorded generation: the key of the request and
+// the contents appended in response. See TheoryOfReplay.
+type ReplayInteraction struct {
+	Key      string
+	Contents []*Content
+	// Source describes where the interaction was recorded, for
+	// divergence errors.
+	Source string
+	// Err, when set, is returned instead of serving the contents. Sources
+	// set it for interactions they cannot reproduce faithfully.
+	Err error
+}
+
+// ReplaySessions loads the interactions of a recorded session. The records
+// package's Recorder implements it; commands fork the ReplaySessions
+// provider with the recorder like EventRecorder. See TheoryOfReplay.
+type ReplaySessions interface {
+	ReplayInteractions(sessionID int64) ([]ReplayInteraction, error)
+}
+
+// ReplaySessions provides the default: no interaction database.
+func (Module) ReplaySessions() ReplaySessions {
+	return nil
+}
+
+// ReplayKey returns the key of a request with the system prompt and
+// contents. See TheoryOfReplay.
+func ReplayKey(systemPrompt string, contents iter.Seq[*Content]) string {
+	h := sha256.New()
+	writeKeyText(h, systemPrompt)
+	var lastRole Role
+	for content := range contents {
+		role := content.Role
+		switch role {
+		case RoleLog:
+			continue
+		case RoleAssistant:
+			role = RoleModel
+		}
+		if role != lastRole {
+			// roles are delimited so moving text between turns changes the key
+			fmt.Fprintf(h, "\x00%s\x00", role)
+			lastRole = role
+		}
+		for _, part := range content.Parts {
+			writeKeyText(h, partKeyText(part))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// partKeyText renders a part as the records transcript does, or returns
+// the empty string for parts not hashed by ReplayKey.
+func partKeyText(part Part) string {
+	switch p := part.(type) {
+	case Text:
+		return string(p)
+	case FuncCall:
+		return fmt.Sprintf("[function call] %s(%v)", p.Name, p.Arguments)
+	case CallResult:
+		return fmt.Sprintf("[call result] %s(%v)", p.Name, p.Results)
+	case FileURL:
+		return "[file] " + string(p)
+	case FileContent:
+		return fmt.Sprintf("[file content: %s, base64]\n%s",
+			p.MimeType, base64.StdEncoding.EncodeToString(p.Content))
+	}
+	return ""
+}
+
+func writeKeyText(w io.Writer, text string) {
+	var buf [utf8.UTFMax]byte
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		n := utf8.EncodeRune(buf[:], r)
+		w.Write(buf[:n])
+	}
+}
+
+// Replay serves recorded responses. See TheoryOfReplay.
+type Replay struct {
+	spec Spec
+	load func() ([]ReplayInteraction, error)
+
+	mu           sync.Mutex
+	loaded       bool
+	loadErr      error
+	interactions []ReplayInteraction
+	served       []bool
+	calls        int
+
+	Count dscope.Inject[BPETokenCounter]
+}
+
+var _ Generator = new(Replay)
+
+type NewReplay func(spec Spec) *Replay
+
+func (Module) NewReplay(
+	inject dscope.InjectStruct,
+	sessions ReplaySessions,
+) NewReplay {
+	return func(spec Spec) *Replay {
+		ret := &Replay{
+			spec: spec,
+		}
+		switch {
+		case spec.ReplaySession != 0:
+			ret.load = func() ([]ReplayInteraction, error) {
+				if sessions == nil {
+					return nil, fmt.Errorf("replay session %d: interaction database not available", spec.ReplaySession)
+				}
+				return sessions.ReplayInteractions(spec.ReplaySession)
+			}
+		case spec.Cassette != "":
+			ret.load = func() ([]ReplayInteraction, error) {
+				return ReadCassette(spec.Cassette)
+			}
+		default:
+			ret.load = func() ([]ReplayInteraction, error) {
+				return nil, fmt.Errorf("replay generator %s: neither cassette nor replay_session is set", spec.Name)
+			}
+		}
+		inject(&ret)
+		return ret
+	}
+}
+
+func (r *Replay) Spec() Spec {
+	return r.spec
+}
+
+func (r *Replay) CountTokens(text string) (int, error) {
+	return r.Count()(text)
+}
+
+func (r *Replay) Generate(ctx context.Context, state State, options *GenerateOptions) (State, error) {
+	interaction, err := r.next(ReplayKey(state.SystemPrompt(), state.Contents()), CountContents(state))
+	if err != nil {
+		return state, err
+	}
+	for _, content := range interaction.Contents {
+		if err := ctx.Err(); err != nil {
+			return state, err
+		}
+		state, err = state.AppendContent(content)
+		if err != nil {
+			return state, err
+		}
+	}
+	return state, nil
+}
+
+// next marks and returns the first unserved interaction recorded under key.
+func (r *Replay) next(key string, numContents int) (*ReplayInteraction, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.loaded {
+		r.loaded = true
+		r.interactions, r.loadErr = r.load()
+		r.served = make([]bool, len(r.interactions))
+	}
+	if r.loadErr != nil {
+		return nil, r.loadErr
+	}
+	r.calls++
+	for i, interaction := range r.interactions {
+		if r.served[i] || interaction.Key != key {
+			continue
+		}
+		r.served[i] = true
+		if interaction.Err != nil {
+			return nil, fmt.Errorf("replay %s: %s: %w", r.spec.Name, interaction.Source, interaction.Err)
+		}
+		return &interaction, nil
+	}
+	expected := "no unserved recording is left"
+	for i, interaction := range r.interactions {
+		if !r.served[i] {
+			expected = fmt.Sprintf("the next unserved recording is %s (%s)", interaction.Key, interaction.Source)
+			break
+		}
+	}
+	return nil, fmt.Errorf("%w: replay %s: no recorded response for request %s (call %d, %d contents); %s",
+		ErrReplayDivergence, r.spec.Name, key, r.calls, numContents, expected)
+}
+
+// Recording wraps a generator and appends every successful interaction to
+// a cassette file. See TheoryOfReplay.
+type Recording struct {
+	upstream Generator
+	path     string
+	mu       sync.Mutex
+}
+
+var _ Generator = new(Recording)
+
+// NewRecording wraps gen in record mode, appending to the cassette at path.
+func NewRecording(gen Generator, path string) *Recording {
+	return &Recording{
+		upstream: gen,
+		path:     path,
+	}
+}
+
+func (r *Recording) Spec() Spec {
+	return r.upstream.Spec()
+}
+
+func (r *Recording) CountTokens(text string) (int, error) {
+	return r.upstream.CountTokens(text)
+}
+
+var _ ContextTokenCounter = new(Recording)
+
+func (r *Recording) CountTokensContext(ctx context.Context, text string) (int, error) {
+	return CountTokensFunc(ctx, r.upstream)(text)
+}
+
+func (r *Recording) Generate(ctx context.Context, state State, options *GenerateOptions) (State, error) {
+	key := ReplayKey(state.SystemPrompt(), state.Contents())
+	captured := new([]*Content)
+	ret, err := r.upstream.Generate(ctx, &capturingState{
+		upstream: state,
+		captured: captured,
+	}, options)
+	if w, ok := ret.(*capturingState); ok {
+		ret = w.upstream
+	}
+	if err != nil {
+		return ret, err
+	}
+	if err := r.write(key, *captured); err != nil {
+		return ret, fmt.Errorf("write cassette %s: %w", r.path, err)
+	}
+	return ret, nil
+}
+
+func (r *Recording) write(key string, contents []*Content) error {
+	line := cassetteLine{
+		Key: key,
+	}
+	for _, content := range contents {
+		line.Contents = append(line.Contents, toCassetteContent(content))
+	}
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// capturingState wraps the State passed to a recorded generator to capture
+// every appended content. The wrapper is removed from the returned State.
+type capturingState struct {
+	upstream State
+	captured *[]*Content // shared by all states derived from the wrapper
+}
+
+var _ State = new(capturingState)
+
+func (c *capturingState) Contents() iter.Seq[*Content] {
+	return c.upstream.Contents()
+}
+
+func (c *capturingState) AppendContent(content *Content) (State, error) {
+	ret := *c
+	var err error
+	ret.upstream, err = c.upstream.AppendContent(content)
+	if err != nil {
+		return &ret, err
+	}
+	*c.captured = append(*c.captured, content)
+	return &ret, nil
+}
+
+func (c *capturingState) SystemPrompt() string {
+	return c.upstream.SystemPrompt()
+}
+
+func (c *capturingState) Functions() iter.Seq[*Function] {
+	return c.upstream.Functions()
+}
+
+func (c *capturingState) Flush() (State, error) {
+	ret := *c
+	var err error
+	ret.upstream, err = c.upstream.Flush()
+	if err != nil {
+		return &ret, err
+	}
+	return &ret, nil
+}
+
+func (c *capturingState) Unwrap() State {
+	return c.upstream
+}
+
+// cassetteLine is one line of a cassette file.
+type cassetteLine struct {
+	Key      string            `json:"key"`
+	Contents []cassetteContent `json:"contents"`
+}
+
+type cassetteContent struct {
+	Role  Role           `json:"role"`
+	Parts []cassettePart `json:"parts"`
+}
+
+// cassettePart encodes one Part, discriminated by Type.
+type cassettePart struct {
+	Type      string         `json:"type"`
+	Text      string         `json:"text,omitempty"`
+	ID        string         `json:"id,omitempty"`
+	Name      string         `json:"name,omitempty"`
+	Arguments map[string]any `json:"arguments,omitempty"`
+	Provider  string         `json:"provider,omitempty"`
+	Signature string         `json:"signature,omitempty"`
+	Redacted  string         `json:"redacted,omitempty"`
+	MimeType  string         `json:"mime_type,omitempty"`
+	Data      []byte         `json:"data,omitempty"`
+	Usage     *Usage         `json:"usage,omitempty"`
+	From      string         `json:"from,omitempty"`
+	To        string         `json:"to,omitempty"`
+	Spec      *Spec          `json:"spec,omitempty"`
+}
+
+func toCassetteContent(content *Content) cassetteContent {
+	ret := cassetteContent{
+		Role: content.Role,
+	}
+	for _, part := range content.Parts {
+		var p cassettePart
+		switch part := part.(type) {
+		case Text:
+			p = cassettePart{Type: "text", Text: string(part)}
+		case Thought:
+			p = cassettePart{Type: "thought", Text: string(part)}
+		case ThoughtSignature:
+			p = cassettePart{Type: "thought_signature", ID: part.ID, Provider: part.Provider, Signature: part.Signature, Redacted: part.Redacted}
+		case CacheBreakpoint:
+			p = cassettePart{Type: "cache_breakpoint"}
+		case FileURL:
+			p = cassettePart{Type: "file_url", Text: string(part)}
+		case FileContent:
+			p = cassettePart{Type: "file_content", MimeType: part.MimeType, Data: part.Content}
+		case FuncCall:
+			p = cassettePart{Type: "func_call", ID: part.ID, Name: part.Name, Arguments: part.Arguments}
+		case CallResult:
+			p = cassettePart{Type: "call_result", ID: part.ID, Name: part.Name, Arguments: part.Results}
+		case FinishReason:
+			p = cassettePart{Type: "finish_reason", Text: string(part)}
+		case Usage:
+			p = cassettePart{Type: "usage", Usage: &part}
+		case Error:
+			p = cassettePart{Type: "error"}
+			if part.Error != nil {
+				p.Text = part.Error.Error()
+			}
+		case GeneratorFallback:
+			p = cassettePart{Type: "fallback", From: part.From, To: part.To, Text: part.Error, Spec: cassetteSpec(part.ToSpec)}
+		default:
+			continue
+		}
+		ret.Parts = append(ret.Parts, p)
+	}
+	return ret
+}
+
+// cassetteSpec returns the identity and prices of spec, the part of a
+// fallback target's spec that cassettes keep.
+func cassetteSpec(spec Spec) *Spec {
+	return &Spec{
+		Name:             spec.Name,
+		Type:             spec.Type,
+		Model:            spec.Model,
+		Family:           spec.Family,
+		InputPrice:       spec.InputPrice,
+		CachedInputPrice: spec.CachedInputPrice,
+		OutputPrice:      spec.OutputPrice,
+		ThinkingPrice:    spec.ThinkingPrice,
+	}
+}
+
+func (c cassetteContent) toContent() (*Content, error) {
+	ret := &Content{
+		Role: c.Role,
+	}
+	for _, p := range c.Parts {
+		var part Part
+		switch p.Type {
+		case "text":
+			part = Text(p.Text)
+		case "thought":
+			part = Thought(p.Text)
+		case "thought_signature":
+			part = ThoughtSignature{ID: p.ID, Provider: p.Provider, Signature: p.Signature, Redacted: p.Redacted}
+		case "cache_breakpoint":
+			part = CacheBreakpoint{}
+		case "file_url":
+			part = FileURL(p.Text)
+		case "file_content":
+			part = FileContent{MimeType: p.MimeType, Content: p.Data}
+		case "func_call":
+			part = FuncCall{ID: p.ID, Name: p.Name, Arguments: p.Arguments}
+		case "call_result":
+			part = CallResult{ID: p.ID, Name: p.Name, Results: p.Arguments}
+		case "finish_reason":
+			part = FinishReason(p.Text)
+		case "usage":
+			if p.Usage != nil {
+				part = *p.Usage
+			} else {
+				part = Usage{}
+			}
+		case "error":
+			part = Error{Error: errors.New(p.Text)}
+		case "fallback":
+			fallback := GeneratorFallback{From: p.From, To: p.To, Error: p.Text}
+			if p.Spec != nil {
+				fallback.ToSpec = *p.Spec
+			}
+			part = fallback
+		default:
+			return nil, fmt.Errorf("unknown part type: %q", p.Type)
+		}
+		ret.Parts = append(ret.Parts, part)
+	}
+	return ret, nil
+}
+
+// EncodeContents encodes contents in the cassette format. Session
+// checkpoints persist the conversation with it (see
+// codes.TheoryOfCheckpoint).
+func EncodeContents(contents iter.Seq[*Content]) ([]byte, error) {
+	ret := []cassetteContent{}
+	for content := range contents {
+		ret = append(ret, toCassetteContent(content))
+	}
+	return json.Marshal(ret)
+}
+
+// DecodeContents decodes contents encoded by EncodeContents.
+func DecodeContents(data []byte) ([]*Content, error) {
+	var encoded []cassetteContent
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return nil, err
+	}
+	ret := make([]*Content, 0, len(encoded))
+	for _, c := range encoded {
+		content, err := c.toContent()
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, content)
+	}
+	return ret, nil
+}
+
+// ReadCassette reads the interactions of a cassette file. See
+// TheoryOfReplay.
+func ReadCassette(path string) ([]ReplayInteraction, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readCassette(f, path)
+}
+
+func readCassette(r io.Reader, path string) (ret []ReplayInteraction, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<30)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		data := strings.TrimSpace(scanner.Text())
+		if data == "" {
+			continue
+		}
+		var line cassetteLine
+		if err := json.Unmarshal([]byte(data), &line); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+		interaction := ReplayInteraction{
+			Key:    line.Key,
+			Source: fmt.Sprintf("%s:%d", path, lineNumber),
+		}
+		for _, c := range line.Contents {
+			content, err := c.toContent()
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+			}
+			interaction.Contents = append(interaction.Contents, content)
+		}
+		ret = append(ret, interaction)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
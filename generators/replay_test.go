@@ -0,0 +1,238 @@
+package generators
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/reusee/dscope"
+	"github.com/reusee/tai/configs"
+	"github.com/reusee/tai/modes"
+	"github.com/reusee/tai/nets"
+)
+
+func TestReplayKey(t *testing.T) {
+	merged := []*Content{
+		{Role: RoleUser, Parts: []Part{Text("hello"), Text("world")}},
+		{Role: RoleModel, Parts: []Part{Thought("hmm"), Text("hi there")}},
+		{Role: RoleLog, Parts: []Part{FinishReason("stop")}},
+	}
+	// the records transcript joins parts with newlines and keeps chunks apart
+	chunked := []*Content{
+		{Role: RoleUser, Parts: []Part{Text("hello\nworld")}},
+		{Role: RoleAssistant, Parts: []Part{Thought("hmm")}},
+		{Role: RoleAssistant, Parts: []Part{Text("hi ")}},
+		{Role: RoleAssistant, Parts: []Part{Text("there")}},
+	}
+	if ReplayKey("system", slices.Values(merged)) != ReplayKey("system", slices.Values(chunked)) {
+		t.Fatal("part and chunk boundaries must not change the key")
+	}
+	if ReplayKey("system", slices.Values(merged)) == ReplayKey("other", slices.Values(merged)) {
+		t.Fatal("the system prompt must change the key")
+	}
+	moved := []*Content{
+		{Role: RoleUser, Parts: []Part{Text("hello")}},
+		{Role: RoleModel, Parts: []Part{Text("world hi there")}},
+	}
+	if ReplayKey("system", slices.Values(merged)) == ReplayKey("system", slices.Values(moved)) {
+		t.Fatal("moving text between turns must change the key")
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, anthropicSSE(
+			`{"type":"message_start","message":{"id":"msg_1","role":"assistant","content":[],"usage":{"input_tokens":3,"output_tokens":1}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			fmt.Sprintf(`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"answer %d"}}`, calls),
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":2}}`,
+			`{"type":"message_stop"}`,
+		))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	cassette := filepath.Join(dir, "cassette.jsonl")
+	configPath := filepath.Join(dir, "config.cue")
+	configContent := fmt.Sprintf(`generators: [
+  {
+    name: "claude"
+    type: "anthropic"
+    model: "claude-test"
+    base_url: %q
+    cassette: %q
+  },
+  {
+    name: "replayed"
+    type: "replay"
+    cassette: %q
+  },
+]
+`, server.URL, cassette, cassette)
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Fork(
+		func() configs.Loader {
+			return configs.NewLoader([]string{configPath}, configs.LoaderConfig{})
+		},
+		func() nets.HTTPClient {
+			return nets.HTTPClient{Client: server.Client()}
+		},
+	).Call(func(get GetGenerator) {
+		first := NewPrompts("system", []*Content{
+			{Role: RoleUser, Parts: []Part{Text("first")}},
+		})
+		second := NewPrompts("system", []*Content{
+			{Role: RoleUser, Parts: []Part{Text("second")}},
+		})
+
+		modelText := func(state State) (ret string) {
+			for c := range state.Contents() {
+				if c.Role != RoleModel && c.Role != RoleAssistant {
+					continue
+				}
+				for _, p := range c.Parts {
+					if text, ok := p.(Text); ok {
+						ret += string(text)
+					}
+				}
+			}
+			return
+		}
+
+		recorder, err := get("claude")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var recorded []string
+		for _, state := range []State{first, second} {
+			ret, err := recorder.Generate(context.Background(), state, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			recorded = append(recorded, modelText(ret))
+		}
+		if recorded[0] != "answer 1" || recorded[1] != "answer 2" {
+			t.Fatalf("got %q", recorded)
+		}
+
+		replay, err := get("replayed")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// served by key, not by order
+		for i, state := range []State{second, first} {
+			ret, err := replay.Generate(context.Background(), state, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := modelText(ret), recorded[1-i]; got != want {
+				t.Fatalf("replayed %q, recorded %q", got, want)
+			}
+		}
+		if calls != 2 {
+			t.Fatalf("replay must not call the API, got %d calls", calls)
+		}
+
+		// each recording is served once
+		_, err = replay.Generate(context.Background(), first, nil)
+		if !errors.Is(err, ErrReplayDivergence) {
+			t.Fatalf("expected divergence, got %v", err)
+		}
+		diverged := NewPrompts("system", []*Content{
+			{Role: RoleUser, Parts: []Part{Text("changed")}},
+		})
+		_, err = replay.Generate(context.Background(), diverged, nil)
+		if !errors.Is(err, ErrReplayDivergence) {
+			t.Fatalf("expected divergence, got %v", err)
+		}
+	})
+}
+
+func TestCassettePartRoundTrip(t *testing.T) {
+	var usage Usage
+	usage.Prompt.TokenCount = 10
+	usage.Candidates.TokenCount = 2
+	content := &Content{
+		Role: RoleModel,
+		Parts: []Part{
+			Thought("reasoning"),
+			ThoughtSignature{Provider: SignatureProviderAnthropic, Signature: "sig"},
+			Text("text"),
+			FuncCall{ID: "1", Name: "f", Arguments: map[string]any{"a": "b"}},
+			FileContent{MimeType: "image/png", Content: []byte{1, 2}},
+			FinishReason("stop"),
+			usage,
+		},
+	}
+	ret, err := toCassetteContent(content).toContent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%#v", ret) != fmt.Sprintf("%#v", content) {
+		t.Fatalf("got %#v", ret)
+	}
+}
+
+func TestCassetteFallbackSpec(t *testing.T) {
+	// the fallback target prices the calls it serves after a resume, a
+	// replay or a cache hit
+	price := 2.0
+	content := &Content{
+		Role: RoleLog,
+		Parts: []Part{
+			GeneratorFallback{
+				From:  "a",
+				To:    "b",
+				Error: "down",
+				ToSpec: Spec{
+					Name:       "b",
+					Model:      "model-b",
+					APIKey:     "secret",
+					InputPrice: &price,
+				},
+			},
+		},
+	}
+	data, err := json.Marshal(toCassetteContent(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Fatalf("API key encoded: %s", data)
+	}
+	var cc cassetteContent
+	if err := json.Unmarshal(data, &cc); err != nil {
+		t.Fatal(err)
+	}
+	ret, err := cc.toContent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fallback := ret.Parts[0].(GeneratorFallback)
+	if fallback.To != "b" || fallback.ToSpec.Model != "model-b" {
+		t.Fatalf("got %+v", fallback)
+	}
+	var usage Usage
+	usage.Prompt.TokenCount = 1e6
+	if cost, ok := fallback.ToSpec.Cost(usage); !ok || cost != 2 {
+		t.Fatalf("got %v %v", cost, ok)
+	}
+}
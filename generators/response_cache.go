@@ -0,0 +1,529 @@
+package generators
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cuelang.org/go/cue"
+	"github.com/reusee/tai/configs"
+	"github.com/reusee/tai/flags"
+	"github.com/reusee/tai/logs"
+)
+
+const TheoryOfResponseCache = `
+Context construction is deterministic, so rerunning a command with the same
+files and chat sends byte-identical requests, and every rerun is paid for.
+The response cache is an opt-in on-disk cache of generator responses keyed
+by the exact request, enabled by -response-cache or response_cache.enabled.
+
+The key is a SHA-256 hash of everything that determines the response: the
+resolved spec of the requested generator (without the API key and replay
+settings, which do not change the output), the effort and temperature
+flags that override it, the GenerateOptions, the system prompt, the
+declarations of the State's functions, and every content the provider
+sees, encoded like cassette lines (see TheoryOfReplay). Like the replay
+key, RoleLog contents are skipped: usage, finish reasons and other log
+parts are never sent, and a hit drops its Usage parts, so hashing them
+would make every round after the first cached one a miss on rerun. The
+spec is taken when the generator is wrapped, not per call, since a
+fallback chain reports the spec of its active target after a switch.
+Unlike the replay key nothing else is normalized: any byte of difference
+in what the provider sees is a miss.
+
+On a miss the wrapped generator runs and every content it appends is
+captured like in record mode; a successful response is stored as one file
+per key under the cache directory. On a hit the stored contents are
+appended through AppendContent in order, so ParserState, Output and every
+other State wrapper see the same stream as from the provider. Usage parts
+are dropped on a hit, since nothing was spent: round statistics, costs and
+session budgets count only real calls. Hits are logged and recorded as
+"response_cache" events. Failed calls are never stored.
+
+Entries older than the TTL (response_cache.ttl, default 24h, zero for no
+expiry) are misses and are deleted. After each store, when the directory
+exceeds the size limit (response_cache.max_size_mb, default 512, zero for
+no limit), the least recently used entries are deleted until it fits; a hit
+refreshes the entry's modification time. Cache errors never fail
+generation: an unreadable entry is a miss and a failed store is logged.
+
+GetGenerator wraps every generator resolved from a spec, the built-in
+shortcuts included, outside the fallback chain and inside record mode, so a
+cassette records cached responses too. The targets of a fallback chain are
+not wrapped again: a response is stored once, under the key of the
+requested generator. Replay generators are not cached.
+`
+
+// ResponseCache stores generator responses on disk. A nil ResponseCache
+// is disabled. See TheoryOfResponseCache.
+type ResponseCache struct {
+	dir     string
+	ttl     time.Duration
+	maxSize int64
+	now     func() time.Time
+	logger  logs.Logger
+	rec     EventRecorder
+	effort  EffortFlag
+	temp    TemperatureFlag
+
+	mu sync.Mutex
+}
+
+func (Module) ResponseCache(
+	enabled ResponseCacheEnabled,
+	dir ResponseCacheDir,
+	ttl ResponseCacheTTL,
+	maxSize ResponseCacheMaxSize,
+	logger logs.Logger,
+	rec EventRecorder,
+	effort EffortFlag,
+	temp TemperatureFlag,
+) *ResponseCache {
+	if !enabled {
+		return nil
+	}
+	path := string(dir)
+	if path == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			logger.Warn("response cache disabled", "error", err)
+			return nil
+		}
+		path = filepath.Join(cacheDir, "tai", "responses")
+	}
+	return &ResponseCache{
+		dir:     path,
+		ttl:     time.Duration(ttl),
+		maxSize: int64(maxSize) * 1024 * 1024,
+		now:     time.Now,
+		logger:  logger,
+		rec:     rec,
+		effort:  effort,
+		temp:    temp,
+	}
+}
+
+// Wrap returns gen with cached responses, or gen itself when the cache is
+// disabled.
+func (c *ResponseCache) Wrap(gen Generator) Generator {
+	if c == nil {
+		return gen
+	}
+	return &cachedGenerator{
+		upstream: gen,
+		spec:     gen.Spec(),
+		cache:    c,
+	}
+}
+
+// cacheEntry is the content of one cache file.
+type cacheEntry struct {
+	Created  time.Time         `json:"created"`
+	Contents []cassetteContent `json:"contents"`
+}
+
+// key returns the cache key of a request. See TheoryOfResponseCache.
+func (c *ResponseCache) key(spec Spec, state State, options *GenerateOptions) (string, error) {
+	spec.APIKey = ""
+	spec.Cassette = ""
+	spec.ReplaySession = 0
+	spec.Variants = nil
+	request := struct {
+		Spec         Spec              `json:"spec"`
+		Effort       EffortFlag        `json:"effort,omitempty"`
+		Temperature  *float32          `json:"temperature,omitempty"`
+		Options      *GenerateOptions  `json:"options,omitempty"`
+		SystemPrompt string            `json:"system_prompt"`
+		Functions    []FuncDecl        `json:"functions,omitempty"`
+		Contents     []cassetteContent `json:"contents"`
+	}{
+		Spec:         spec,
+		Effort:       c.effort,
+		Temperature:  c.temp.Value,
+		Options:      options,
+		SystemPrompt: state.SystemPrompt(),
+	}
+	for fn := range state.Functions() {
+		request.Functions = append(request.Functions, fn.Decl)
+	}
+	for content := range state.Contents() {
+		if content.Role == RoleLog {
+			// not sent to the provider
+			continue
+		}
+		request.Contents = append(request.Contents, toCassetteContent(content))
+	}
+	data, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (c *ResponseCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".json")
+}
+
+// get returns the contents stored under key, or false on a miss.
+func (c *ResponseCache) get(key string) ([]*Content, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	path := c.path(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		os.Remove(path)
+		return nil, false
+	}
+	now := c.now()
+	if c.ttl > 0 && now.Sub(entry.Created) > c.ttl {
+		os.Remove(path)
+		return nil, false
+	}
+	var ret []*Content
+	for _, cc := range entry.Contents {
+		content, err := cc.toContent()
+		if err != nil {
+			os.Remove(path)
+			return nil, false
+		}
+		content.Parts = slices.DeleteFunc(content.Parts, func(part Part) bool {
+			_, ok := part.(Usage)
+			return ok
+		})
+		if len(content.Parts) > 0 {
+			ret = append(ret, content)
+		}
+	}
+	// refresh for least-recently-used eviction
+	os.Chtimes(path, now, now)
+	return ret, true
+}
+
+// put stores contents under key and evicts entries over the size limit.
+func (c *ResponseCache) put(key string, contents []*Content) error {
+	entry := cacheEntry{
+		Created: c.now(),
+	}
+	for _, content := range contents {
+		entry.Contents = append(entry.Contents, toCassetteContent(content))
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	os.Chtimes(path, entry.Created, entry.Created)
+	return c.evictLocked()
+}
+
+// evictLocked deletes the least recently used entries until the cache
+// fits the size limit.
+func (c *ResponseCache) evictLocked() error {
+	if c.maxSize <= 0 {
+		return nil
+	}
+	type file struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+	var total int64
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".json") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, file{
+			path:    path,
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(files, func(a, b file) int {
+		return a.modTime.Compare(b.modTime)
+	})
+	for _, f := range files {
+		if total <= c.maxSize {
+			break
+		}
+		if err := os.Remove(f.path); err == nil {
+			total -= f.size
+		}
+	}
+	return nil
+}
+
+// cachedGenerator serves a generator's responses from a ResponseCache.
+type cachedGenerator struct {
+	upstream Generator
+	// spec of the requested generator, fixed at wrap time
+	spec  Spec
+	cache *ResponseCache
+}
+
+var _ Generator = new(cachedGenerator)
+
+func (g *cachedGenerator) Spec() Spec {
+	return g.upstream.Spec()
+}
+
+func (g *cachedGenerator) CountTokens(text string) (int, error) {
+	return g.upstream.CountTokens(text)
+}
+
+var _ ContextTokenCounter = new(cachedGenerator)
+
+func (g *cachedGenerator) CountTokensContext(ctx context.Context, text string) (int, error) {
+	return CountTokensFunc(ctx, g.upstream)(text)
+}
+
+func (g *cachedGenerator) Generate(ctx context.Context, state State, options *GenerateOptions) (State, error) {
+	spec := g.spec
+	key, err := g.cache.key(spec, state, options)
+	if err != nil {
+		g.cache.logger.WarnContext(ctx, "response cache key", "error", err)
+		return g.upstream.Generate(ctx, state, options)
+	}
+
+	if contents, ok := g.cache.get(key); ok {
+		g.cache.logger.InfoContext(ctx, "response cache hit", "name", spec.Name, "key", key)
+		if rec := g.cache.rec; rec != nil && rec.Enabled() {
+			rec.Event("response_cache", fmt.Sprintf("hit %s for %s", key, spec.Name))
+		}
+		for _, content := range contents {
+			state, err = state.AppendContent(content)
+			if err != nil {
+				return state, err
+			}
+		}
+		return state, nil
+	}
+
+	captured := new([]*Content)
+	ret, err := g.upstream.Generate(ctx, &capturingState{
+		upstream: state,
+		captured: captured,
+	}, options)
+	if w, ok := ret.(*capturingState); ok {
+		ret = w.upstream
+	}
+	if err != nil {
+		return ret, err
+	}
+	if err := g.cache.put(key, *captured); err != nil {
+		g.cache.logger.WarnContext(ctx, "response cache store", "error", err)
+	}
+	return ret, nil
+}
+
+// ResponseCacheEnabled enables the response cache. See
+// TheoryOfResponseCache.
+type ResponseCacheEnabled bool
+
+func (Module) ResponseCacheEnabled() ResponseCacheEnabled {
+	return false
+}
+
+var _ flags.Flag = ResponseCacheEnabled(false)
+
+func (r ResponseCacheEnabled) Handle(key string, args []string) (newDef any, remainArgs []string, err error) {
+	switch key {
+	case "-response-cache":
+		ret := ResponseCacheEnabled(true)
+		return &ret, args, nil
+	case "-no-response-cache":
+		ret := ResponseCacheEnabled(false)
+		return &ret, args, nil
+	}
+	panic("key not handle: " + key)
+}
+
+func (r ResponseCacheEnabled) Keys() map[string]string {
+	return map[string]string{
+		"-response-cache":    "Serve identical requests from the on-disk response cache",
+		"-no-response-cache": "Disable the response cache",
+	}
+}
+
+var _ configs.Config = ResponseCacheEnabled(false)
+
+func (r ResponseCacheEnabled) ConfigPaths() []string {
+	return []string{"response_cache.enabled"}
+}
+
+func (r ResponseCacheEnabled) HandleConfig(path string, values []*cue.Value) (any, error) {
+	var b bool
+	if err := values[0].Decode(&b); err != nil {
+		return nil, err
+	}
+	ret := ResponseCacheEnabled(b)
+	return &ret, nil
+}
+
+// ResponseCacheDir is the response cache directory. Empty means tai/responses
+// in the user cache directory. See TheoryOfResponseCache.
+type ResponseCacheDir string
+
+func (Module) ResponseCacheDir() ResponseCacheDir {
+	return ""
+}
+
+var _ flags.Flag = ResponseCacheDir("")
+
+func (r ResponseCacheDir) Handle(key string, args []string) (newDef any, remainArgs []string, err error) {
+	if len(args) == 0 {
+		return nil, nil, fmt.Errorf("expecting directory argument, got empty")
+	}
+	ret := ResponseCacheDir(args[0])
+	return &ret, args[1:], nil
+}
+
+func (r ResponseCacheDir) Keys() map[string]string {
+	return map[string]string{
+		"-response-cache-dir": "Directory of the response cache",
+	}
+}
+
+var _ configs.Config = ResponseCacheDir("")
+
+func (r ResponseCacheDir) ConfigPaths() []string {
+	return []string{"response_cache.dir"}
+}
+
+func (r ResponseCacheDir) HandleConfig(path string, values []*cue.Value) (any, error) {
+	var s string
+	if err := values[0].Decode(&s); err != nil {
+		return nil, err
+	}
+	ret := ResponseCacheDir(s)
+	return &ret, nil
+}
+
+// ResponseCacheTTL is how long cached responses are served. Zero means no
+// expiry. See TheoryOfResponseCache.
+type ResponseCacheTTL time.Duration
+
+func (Module) ResponseCacheTTL() ResponseCacheTTL {
+	return ResponseCacheTTL(24 * time.Hour)
+}
+
+var _ flags.Flag = ResponseCacheTTL(0)
+
+func (r ResponseCacheTTL) Handle(key string, args []string) (newDef any, remainArgs []string, err error) {
+	if len(args) == 0 {
+		return nil, nil, fmt.Errorf("expecting duration argument, got empty")
+	}
+	d, err := time.ParseDuration(args[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	ret := ResponseCacheTTL(d)
+	return &ret, args[1:], nil
+}
+
+func (r ResponseCacheTTL) Keys() map[string]string {
+	return map[string]string{
+		"-response-cache-ttl": "Expire cached responses after this duration (e.g. 24h, 0 for never)",
+	}
+}
+
+var _ configs.Config = ResponseCacheTTL(0)
+
+func (r ResponseCacheTTL) ConfigPaths() []string {
+	return []string{"response_cache.ttl"}
+}
+
+func (r ResponseCacheTTL) HandleConfig(path string, values []*cue.Value) (any, error) {
+	var s string
+	if err := values[0].Decode(&s); err != nil {
+		return nil, err
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return nil, err
+	}
+	ret := ResponseCacheTTL(d)
+	return &ret, nil
+}
+
+// ResponseCacheMaxSize limits the response cache size in megabytes. Zero
+// means no limit. See TheoryOfResponseCache.
+type ResponseCacheMaxSize int
+
+func (Module) ResponseCacheMaxSize() ResponseCacheMaxSize {
+	return 512
+}
+
+var _ flags.Flag = ResponseCacheMaxSize(0)
+
+func (r ResponseCacheMaxSize) Handle(key string, args []string) (newDef any, remainArgs []string, err error) {
+	if len(args) == 0 {
+		return nil, nil, fmt.Errorf("expecting int argument, got empty")
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	ret := ResponseCacheMaxSize(n)
+	return &ret, args[1:], nil
+}
+
+func (r ResponseCacheMaxSize) Keys() map[string]string {
+	return map[string]string{
+		"-response-cache-max-size": "Limit the response cache to this many megabytes (0 for no limit)",
+	}
+}
+
+var _ configs.Config = ResponseCacheMaxSize(0)
+
+func (r ResponseCacheMaxSize) ConfigPaths() []string {
+	return []string{"response_cache.max_size_mb"}
+}
+
+func (r ResponseCacheMaxSize) HandleConfig(path string, values []*cue.Value) (any, error) {
+	var n int
+	if err := values[0].Decode(&n); err != nil {
+		return nil, err
+	}
+	ret := ResponseCacheMaxSize(n)
+	return &ret, nil
+}
@@ -0,0 +1,195 @@
+package generators
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/reusee/dscope"
+	"github.com/reusee/tai/modes"
+)
+
+type cacheTestGenerator struct {
+	calls *int
+}
+
+func (g cacheTestGenerator) Spec() Spec {
+	return Spec{Name: "cached", APIKey: "secret"}
+}
+
+func (g cacheTestGenerator) CountTokens(text string) (int, error) {
+	return len(text), nil
+}
+
+func (g cacheTestGenerator) Generate(ctx context.Context, state State, options *GenerateOptions) (State, error) {
+	*g.calls++
+	state, err := state.AppendContent(&Content{
+		Role:  RoleModel,
+		Parts: []Part{Text("response")},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var usage Usage
+	usage.Prompt.TokenCount = 10
+	return state.AppendContent(&Content{
+		Role:  RoleLog,
+		Parts: []Part{usage, FinishReason("stop")},
+	})
+}
+
+func TestResponseCache(t *testing.T) {
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Fork(
+		func() ResponseCacheEnabled {
+			return true
+		},
+		func() ResponseCacheDir {
+			return ResponseCacheDir(t.TempDir())
+		},
+	).Call(func(cache *ResponseCache) {
+		clock := time.Unix(1000, 0)
+		cache.now = func() time.Time {
+			return clock
+		}
+		calls := 0
+		gen := cache.Wrap(cacheTestGenerator{calls: &calls})
+		state := NewPrompts("system", []*Content{
+			{Role: RoleUser, Parts: []Part{Text("question")}},
+		})
+
+		summarize := func(state State) (text string, usages int, finish FinishReason) {
+			for c := range state.Contents() {
+				for _, p := range c.Parts {
+					switch p := p.(type) {
+					case Text:
+						if c.Role == RoleModel {
+							text += string(p)
+						}
+					case Usage:
+						usages++
+					case FinishReason:
+						finish = p
+					}
+				}
+			}
+			return
+		}
+
+		ret, err := gen.Generate(context.Background(), state, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text, usages, _ := summarize(ret); text != "response" || usages != 1 {
+			t.Fatalf("got %q with %d usages", text, usages)
+		}
+
+		ret, err = gen.Generate(context.Background(), state, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if calls != 1 {
+			t.Fatalf("identical request must be served from the cache, got %d calls", calls)
+		}
+		text, usages, finish := summarize(ret)
+		if text != "response" || finish != "stop" {
+			t.Fatalf("got %q %q", text, finish)
+		}
+		if usages != 0 {
+			t.Fatal("a cache hit must not report usage")
+		}
+
+		// any difference is a miss
+		other := NewPrompts("system", []*Content{
+			{Role: RoleUser, Parts: []Part{Text("question ")}},
+		})
+		if _, err := gen.Generate(context.Background(), other, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := gen.Generate(context.Background(), state, &GenerateOptions{NonStreaming: true}); err != nil {
+			t.Fatal(err)
+		}
+		if calls != 3 {
+			t.Fatalf("expected misses, got %d calls", calls)
+		}
+
+		// expired entries are misses
+		clock = clock.Add(cache.ttl + time.Second)
+		if _, err := gen.Generate(context.Background(), state, nil); err != nil {
+			t.Fatal(err)
+		}
+		if calls != 4 {
+			t.Fatalf("expired entry must be a miss, got %d calls", calls)
+		}
+
+		// eviction keeps the most recently used entries
+		cache.maxSize = 1
+		if err := cache.put("ff00", nil); err != nil {
+			t.Fatal(err)
+		}
+		var files []string
+		filepath.WalkDir(cache.dir, func(path string, d os.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if len(files) != 0 {
+			t.Fatalf("entries over the size limit must be evicted, got %v", files)
+		}
+	})
+}
+
+func TestResponseCacheMultiRound(t *testing.T) {
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Fork(
+		func() ResponseCacheEnabled {
+			return true
+		},
+		func() ResponseCacheDir {
+			return ResponseCacheDir(t.TempDir())
+		},
+	).Call(func(cache *ResponseCache) {
+		calls := 0
+		gen := cache.Wrap(cacheTestGenerator{calls: &calls})
+
+		session := func() {
+			state := State(NewPrompts("system", []*Content{
+				{Role: RoleUser, Parts: []Part{Text("question")}},
+			}))
+			for _, followUp := range []string{"more", "again"} {
+				var err error
+				state, err = gen.Generate(context.Background(), state, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				state, err = state.AppendContent(&Content{
+					Role:  RoleUser,
+					Parts: []Part{Text(followUp)},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := gen.Generate(context.Background(), state, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		session()
+		if calls != 3 {
+			t.Fatalf("expected 3 calls, got %d", calls)
+		}
+		// a rerun hits on every round, although hits carry no usage
+		session()
+		if calls != 3 {
+			t.Fatalf("rerun must be served from the cache, got %d calls", calls)
+		}
+	})
+}
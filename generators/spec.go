@@ -1,7 +1,7 @@
 package generators
 
 const TheoryOfSpec = `
-Spec merging uses pointer values for optional booleans (DisableSearch, DisableTools, IsOpenRouter, IsAzure, NoProxy, PreservedThinking, ZeroDataRetention)
+Spec merging uses pointer values for optional booleans (DisableSearch, DisableTools, NativeTools, IsOpenRouter, IsAzure, NoProxy, PreservedThinking, ZeroDataRetention, CacheControl)
 to distinguish between "explicitly set to false" and "not provided". This allows a child spec to disable a feature
 that a parent spec enabled.
 Variants allow hierarchical organization of specs where child specs are nested under their parent.
@@ -16,6 +16,15 @@ RandomRedirect provides load balancing by randomly selecting one of several redi
 spec in the path has RandomRedirect set and Redirect is not set, one entry is randomly chosen and applied as
 a redirect, following the same relative/absolute path resolution rules as Redirect. Redirect takes precedence
 over RandomRedirect when both are set. Like Redirect, RandomRedirect is not merged from parent to child.
+RandomRedirectWeights weights the RandomRedirect entries, keyed by the entry as written: an entry is picked
+with probability proportional to its weight, unlisted entries weigh 1, and entries with a non-positive weight
+are never picked. Targets whose circuit breaker is open are excluded from the pick (see
+TheoryOfCircuitBreaker). Like RandomRedirect, the weights are taken only from the final spec in the path.
+Fallback lists specs to switch to when the resolved generator fails persistently (see TheoryOfFallback).
+Entries follow the same relative/absolute path rules as Redirect and, like Redirect, are taken only from
+the final spec in the path. Fallbacks of every spec visited through redirects are kept in visit order,
+so a spec that redirects elsewhere still contributes its own fallbacks. The resolved Spec carries them
+as full paths.
 PreservedThinking controls whether reasoning thoughts from previous model responses are sent back to the
 server in subsequent requests. When not set or false, thoughts are stripped from outgoing requests to avoid
 sending reasoning content back to the model. When true, thoughts are included in the request so the model
@@ -23,39 +32,89 @@ can build on prior reasoning context.
 ZeroDataRetention marks a generator whose provider retains no input or output data. It is consumed by
 confidential mode (see TheoryOfConfidentialMode), which rejects any generator that does not set the field
 when enabled. Like the other optional booleans, it is not merged from parent to child unless explicitly set.
+CacheControl makes an OpenAI-compatible generator forward Anthropic-style cache_control markers at the
+prompt's cache breakpoints (see TheoryOfCacheBreakpoints). It is opt-in because providers that do not know
+the field reject the request.
+NativeTools exposes pipeline components (go-src, request-context, go-test) to the model as native function calls
+instead of heredoc blocks (see components.TheoryOfComponentTools). It is opt-in because only models reliable at
+tool use benefit, and DisableTools overrides it, since a spec without tools cannot receive functions.
 
 Provider holds routing preferences forwarded to OpenRouter in the request
 body. It mirrors the OpenRouter "provider" parameter (see
 TheoryOfProviderRouting). It is merged field-wise from parent to child:
 a child's provider fields override the parent's, and unset child fields
 preserve the parent's values.
+
+Capabilities declares what the model accepts (vision, pdf, audio, tools,
+json_schema, reasoning, system_prompt). It is merged field-wise like
+Provider, and unset fields fall back to built-in family defaults (see
+TheoryOfCapabilities). The resolved Spec has DisableTools set when the
+model has no tools and the spec does not set DisableTools itself.
+
+InputPrice, CachedInputPrice, OutputPrice and ThinkingPrice are prices per
+million tokens used to compute the cost of each round (see TheoryOfPricing).
+Like the optional booleans they are pointers, so an explicit zero price is
+distinct from an unset one, and each is merged from parent to child when set.
+
+RequestsPerMinute and TokensPerMinute describe the provider's quota for
+client-side pacing (see TheoryOfRateLimit). Like ContextTokens they are
+merged from parent to child when non-zero, and zero means no limit.
+
+Tokenizer selects how the spec's prompts are counted for budgets (see
+TheoryOfTokenizers). It is merged from parent to child when set.
+
+Cassette and ReplaySession select recorded responses (see TheoryOfReplay).
+A "replay" spec serves the session ReplaySession when set, otherwise the
+cassette file; a spec of any other type with Cassette records its
+interactions to the file. Both are merged from parent to child when set.
 `
 
 type Spec struct {
-	Name              string         `json:"name"`
-	Type              string         `json:"type"`
-	BaseURL           string         `json:"base_url"`
-	APIKey            string         `json:"api_key"`
-	Model             string         `json:"model"`
-	Family            string         `json:"family"`
-	ContextTokens     int            `json:"context_tokens"`
-	MaxGenerateTokens *int           `json:"max_generate_tokens"`
-	MaxThinkingTokens *int           `json:"max_thinking_tokens,omitempty"`
-	Temperature       *float32       `json:"temperature"`
-	DisableSearch     *bool          `json:"disable_search,omitempty"`
-	DisableTools      *bool          `json:"disable_tools,omitempty"`
-	ExtraArguments    map[string]any `json:"extra_arguments"`
-	IsOpenRouter      *bool          `json:"is_open_router,omitempty"`
-	APIVersion        string         `json:"api_version"`
-	IsAzure           *bool          `json:"is_azure,omitempty"`
-	ServiceTier       string         `json:"service_tier"`
-	ReasoningEffort   string         `json:"reasoning_effort"`
-	Aliases           []string       `json:"aliases"`
-	Redirect          string         `json:"redirect,omitempty"`
-	RandomRedirect    []string       `json:"random_redirect,omitempty"`
-	NoProxy           *bool          `json:"no_proxy,omitempty"`
-	PreservedThinking *bool          `json:"preserved_thinking,omitempty"`
-	ZeroDataRetention *bool          `json:"zero_data_retention,omitempty"`
-	Provider          *Provider      `json:"provider,omitempty"`
-	Variants          []Spec         `json:"variants,omitempty"`
+	Name                  string             `json:"name"`
+	Type                  string             `json:"type"`
+	BaseURL               string             `json:"base_url"`
+	APIKey                string             `json:"api_key"`
+	Model                 string             `json:"model"`
+	Family                string             `json:"family"`
+	ContextTokens         int                `json:"context_tokens"`
+	Tokenizer             string             `json:"tokenizer,omitempty"`
+	RequestsPerMinute     int                `json:"requests_per_minute,omitempty"`
+	TokensPerMinute       int                `json:"tokens_per_minute,omitempty"`
+	MaxGenerateTokens     *int               `json:"max_generate_tokens"`
+	MaxThinkingTokens     *int               `json:"max_thinking_tokens,omitempty"`
+	Temperature           *float32           `json:"temperature"`
+	DisableSearch         *bool              `json:"disable_search,omitempty"`
+	DisableTools          *bool              `json:"disable_tools,omitempty"`
+	NativeTools           *bool              `json:"native_tools,omitempty"`
+	ExtraArguments        map[string]any     `json:"extra_arguments"`
+	IsOpenRouter          *bool              `json:"is_open_router,omitempty"`
+	APIVersion            string             `json:"api_version"`
+	IsAzure               *bool              `json:"is_azure,omitempty"`
+	ServiceTier           string             `json:"service_tier"`
+	ReasoningEffort       string             `json:"reasoning_effort"`
+	Aliases               []string           `json:"aliases"`
+	Redirect              string             `json:"redirect,omitempty"`
+	RandomRedirect        []string           `json:"random_redirect,omitempty"`
+	RandomRedirectWeights map[string]float64 `json:"random_redirect_weights,omitempty"`
+	Fallback              []string           `json:"fallback,omitempty"`
+	NoProxy               *bool              `json:"no_proxy,omitempty"`
+	PreservedThinking     *bool              `json:"preserved_thinking,omitempty"`
+	ZeroDataRetention     *bool              `json:"zero_data_retention,omitempty"`
+	CacheControl          *bool              `json:"cache_control,omitempty"`
+	Provider              *Provider          `json:"provider,omitempty"`
+	Capabilities          *Capabilities      `json:"capabilities,omitempty"`
+	InputPrice            *float64           `json:"input_price,omitempty"`
+	CachedInputPrice      *float64           `json:"cached_input_price,omitempty"`
+	OutputPrice           *float64           `json:"output_price,omitempty"`
+	ThinkingPrice         *float64           `json:"thinking_price,omitempty"`
+	Cassette              string             `json:"cassette,omitempty"`
+	ReplaySession         int64              `json:"replay_session,omitempty"`
+	Variants              []Spec             `json:"variants,omitempty"`
+}
+
+// NativeToolsEnabled reports whether the spec opts into native tools and
+// does not disable tools. See components.TheoryOfComponentTools.
+func (s Spec) NativeToolsEnabled() bool {
+	return s.NativeTools != nil && *s.NativeTools &&
+		(s.DisableTools == nil || !*s.DisableTools)
 }
@@ -152,3 +152,15 @@ func TestSpecRandomRedirectJSON(t *testing.T) {
 		t.Errorf("RandomRedirect not restored correctly: %+v", restored)
 	}
 }
+
+func TestSpecNativeToolsEnabled(t *testing.T) {
+	if (Spec{}).NativeToolsEnabled() {
+		t.Fatal("native tools must be opt-in")
+	}
+	if !(Spec{NativeTools: new(true)}).NativeToolsEnabled() {
+		t.Fatal("expected native tools")
+	}
+	if (Spec{NativeTools: new(true), DisableTools: new(true)}).NativeToolsEnabled() {
+		t.Fatal("DisableTools must override NativeTools")
+	}
+}
@@ -0,0 +1,116 @@
+package generators
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	"github.com/tiktoken-go/tokenizer"
+)
+
+const TheoryOfTokenizers = `
+Budgets are computed from generator CountTokens, and by default every
+OpenAI-compatible generator counts with the o200k_base BPE, which is far
+off for models with their own vocabularies (DeepSeek, Qwen, GLM). Spec
+Tokenizer selects the counter per spec:
+
+- "" keeps the generator's default: the local Gemini tokenizer for Gemini,
+  the character heuristic for DeepSeek, o200k_base for the others.
+- "o200k_base", "cl100k_base", "p50k_base" and "r50k_base" select a
+  tiktoken encoding.
+- "gemini" selects the local Gemini tokenizer and "deepseek" the character
+  heuristic, for models served through other providers.
+- "api" asks the provider's count-tokens endpoint (Gemini countTokens,
+  Anthropic messages/count_tokens). It is exact but costs a request per
+  text, so results are cached in memory by the spec and a hash of the text;
+  providers without an endpoint keep their default counter. Callers
+  count a whole request as one text, so a request costs one count. Each
+  count is paced by a count-tokens bucket apart from the generation
+  buckets (see TheoryOfRateLimit) and follows the caller's context:
+  generators with an endpoint implement ContextTokenCounter, and callers
+  holding a context count through CountTokensFunc, which the wrapping
+  generators forward.
+
+Whatever the counter, its error against what the provider bills is
+measured and corrected by the calibration store (see
+TheoryOfTokenCalibration).
+`
+
+// ContextTokenCounter is implemented by generators whose token counts may
+// call the provider, and by the generators wrapping them. See
+// TheoryOfTokenizers.
+type ContextTokenCounter interface {
+	CountTokensContext(ctx context.Context, text string) (int, error)
+}
+
+// CountTokensFunc returns the token counter of gen bound to ctx.
+func CountTokensFunc(ctx context.Context, gen Generator) TokenCounter {
+	if c, ok := gen.(ContextTokenCounter); ok {
+		return func(text string) (int, error) {
+			return c.CountTokensContext(ctx, text)
+		}
+	}
+	return gen.CountTokens
+}
+
+// Tokenizers returns the token counter selected by spec.Tokenizer.
+// fallback is the generator's default counter and api its count-tokens
+// endpoint, nil when the provider has none. See TheoryOfTokenizers.
+type Tokenizers func(spec Spec, fallback TokenCounter, api TokenCounter) TokenCounter
+
+func (Module) Tokenizers(
+	geminiCounter GeminiTokenCounter,
+) Tokenizers {
+	var codecs sync.Map    // encoding -> tokenizer.Codec
+	var apiCounts sync.Map // spec key and text hash -> int
+
+	return func(spec Spec, fallback TokenCounter, api TokenCounter) TokenCounter {
+		switch spec.Tokenizer {
+		case "":
+			return fallback
+
+		case "api":
+			if api == nil {
+				return fallback
+			}
+			return func(text string) (int, error) {
+				key := fmt.Sprintf("%s\x00%x", spec.rateLimitKey(), sha256.Sum256([]byte(text)))
+				if v, ok := apiCounts.Load(key); ok {
+					return v.(int), nil
+				}
+				n, err := api(text)
+				if err != nil {
+					return 0, err
+				}
+				apiCounts.Store(key, n)
+				return n, nil
+			}
+
+		case "gemini":
+			return geminiCounter(spec.Model)
+
+		case "deepseek":
+			return DeepseekTokenCounterFn
+
+		case string(tokenizer.O200kBase), string(tokenizer.Cl100kBase),
+			string(tokenizer.P50kBase), string(tokenizer.R50kBase):
+			encoding := tokenizer.Encoding(spec.Tokenizer)
+			return func(text string) (int, error) {
+				v, ok := codecs.Load(encoding)
+				if !ok {
+					codec, err := tokenizer.Get(encoding)
+					if err != nil {
+						return 0, err
+					}
+					v, _ = codecs.LoadOrStore(encoding, codec)
+				}
+				return v.(tokenizer.Codec).Count(text)
+			}
+		}
+
+		return func(string) (int, error) {
+			return 0, fmt.Errorf("unknown tokenizer: %q", spec.Tokenizer)
+		}
+	}
+}
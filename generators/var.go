@@ -118,3 +118,48 @@ func (v Var) ToOpenAI() map[string]any {
 	}
 	return ret
 }
+
+// ToOpenAIStrict returns the schema of v in the form OpenAI's strict
+// structured outputs accept: every object lists all of its properties as
+// required and forbids additional properties, and an optional value is
+// nullable instead of omittable. See TheoryOfStructuredOutput.
+func (v Var) ToOpenAIStrict() map[string]any {
+	ret := map[string]any{}
+	if v.Description != "" {
+		ret["description"] = v.Description
+	}
+	var typ string
+	switch v.Type {
+	case TypeString:
+		typ = "string"
+	case TypeNumber:
+		typ = "number"
+	case TypeInteger:
+		typ = "integer"
+	case TypeBoolean:
+		typ = "boolean"
+	case TypeArray:
+		typ = "array"
+		ret["items"] = v.ItemType.ToOpenAIStrict()
+	case TypeObject:
+		typ = "object"
+		props := make(map[string]any)
+		required := []string{}
+		for _, prop := range v.Properties {
+			props[prop.Name] = prop.ToOpenAIStrict()
+			required = append(required, prop.Name)
+		}
+		sort.Strings(required)
+		ret["properties"] = props
+		ret["required"] = required
+		ret["additionalProperties"] = false
+	default:
+		panic(fmt.Errorf("unknown type: %v", v.Type))
+	}
+	if v.Optional {
+		ret["type"] = []string{typ, "null"}
+	} else {
+		ret["type"] = typ
+	}
+	return ret
+}
@@ -261,10 +261,20 @@ func (c CodeProvider) Parts(
 	}
 
 	// Add project files first — these form the stable prefix for LLM caching.
+	// A CacheBreakpoint is emitted at each tier boundary of the three-tier
+	// file ordering and after the last project file, so providers with
+	// explicit prompt caching can cache each stable prefix separately. See
+	// TheoryOfFileOrdering and generators.TheoryOfCacheBreakpoints.
+	prevTier := -1
 	for _, file := range files {
 		if len(file.Confirmed.Content) == 0 {
 			panic(fmt.Errorf("empty file: %+v", file))
 		}
+		tier := fileCacheTier(file)
+		if prevTier >= 0 && tier != prevTier {
+			parts = append(parts, generators.CacheBreakpoint{})
+		}
+		prevTier = tier
 		if c.ShowTokenCounts() {
 			c.Logger().Info("final file", "path", file.Path, "tokens", file.Confirmed.NumTokens)
 		}
@@ -276,6 +286,9 @@ func (c CodeProvider) Parts(
 		}
 		parts = append(parts, generators.Text(file.Confirmed.Content))
 	}
+	if len(parts) > 0 {
+		parts = append(parts, generators.CacheBreakpoint{})
+	}
 
 	// Add extra files after project files — these form the volatile suffix.
 	// Extra files vary by request pattern; placing them last ensures they
@@ -367,6 +380,20 @@ func (c CodeProvider) Parts(
 	return
 }
 
+// fileCacheTier returns the tier of a project file in the three-tier file
+// ordering: 0 for non-root-module files, 1 for root-module context files,
+// and 2 for root-package focus files. See TheoryOfFileOrdering.
+func fileCacheTier(file *File) int {
+	switch {
+	case !file.ModuleIsRoot:
+		return 0
+	case !file.PackageIsRoot:
+		return 1
+	default:
+		return 2
+	}
+}
+
 const TheoryOfExclusionPatterns = `
 Exclusion patterns (prefixed with "!") filter files from the context provided
 to the model. A non-glob pattern like "pkg" matches both a file named "pkg"
@@ -44,8 +44,13 @@ func TestContextPrompt(t *testing.T) {
 		}
 
 		var foundDep1, foundATxt, foundFocusDoc bool
+		breakpoints := 0
 		for _, part := range parts {
 			t.Logf("%s\n", part)
+			if _, ok := part.(generators.CacheBreakpoint); ok {
+				breakpoints++
+				continue
+			}
 			text, ok := part.(generators.Text)
 			if !ok {
 				t.Fatalf("got %#v", part)
@@ -73,6 +78,12 @@ func TestContextPrompt(t *testing.T) {
 		if !foundFocusDoc {
 			t.Errorf("focus package documentation not found")
 		}
+		// Context files and the focus file are separate tiers, each
+		// ending with a breakpoint. See
+		// generators.TheoryOfCacheBreakpoints.
+		if breakpoints != 2 {
+			t.Errorf("expected 2 cache breakpoints, got %d", breakpoints)
+		}
 
 	})
 
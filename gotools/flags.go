@@ -43,3 +43,77 @@ func (s ShowTokenCounts) HandleConfig(path string, values []*cue.Value) (any, er
 	ret := ShowTokenCounts(b)
 	return &ret, nil
 }
+
+// TypeCheck type-checks the packages a round changes before the changes
+// are flushed. See TheoryOfTypeCheck.
+
+var _ configs.Config = TypeCheck(false)
+
+type TypeCheck bool
+
+func (Module) TypeCheck() TypeCheck {
+	return false
+}
+
+var _ flags.Flag = TypeCheck(true)
+
+func (t TypeCheck) Handle(key string, args []string) (newDef any, remainArgs []string, err error) {
+	ret := TypeCheck(true)
+	return &ret, args, nil
+}
+
+func (t TypeCheck) Keys() map[string]string {
+	return map[string]string{
+		"-typecheck": "Type-check the Go packages a round changes before writing them",
+	}
+}
+
+func (t TypeCheck) ConfigPaths() []string {
+	return []string{"go.typecheck"}
+}
+
+func (t TypeCheck) HandleConfig(path string, values []*cue.Value) (any, error) {
+	var b bool
+	if err := values[0].Decode(&b); err != nil {
+		return nil, err
+	}
+	ret := TypeCheck(b)
+	return &ret, nil
+}
+
+// TestThenCommit makes the flush of a round with go-test blocks wait for
+// its tests. See codes.TheoryOfTestThenCommit.
+
+var _ configs.Config = TestThenCommit(false)
+
+type TestThenCommit bool
+
+func (Module) TestThenCommit() TestThenCommit {
+	return false
+}
+
+var _ flags.Flag = TestThenCommit(true)
+
+func (t TestThenCommit) Handle(key string, args []string) (newDef any, remainArgs []string, err error) {
+	ret := TestThenCommit(true)
+	return &ret, args, nil
+}
+
+func (t TestThenCommit) Keys() map[string]string {
+	return map[string]string{
+		"-test-then-commit": "Write the changes of a round with go-test blocks only when the tests pass",
+	}
+}
+
+func (t TestThenCommit) ConfigPaths() []string {
+	return []string{"go.test_then_commit"}
+}
+
+func (t TestThenCommit) HandleConfig(path string, values []*cue.Value) (any, error) {
+	var b bool
+	if err := values[0].Decode(&b); err != nil {
+		return nil, err
+	}
+	ret := TestThenCommit(b)
+	return &ret, nil
+}
@@ -3,13 +3,18 @@ package gotools
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"maps"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/reusee/tai/blocks"
+	"github.com/reusee/tai/changes"
 	"github.com/reusee/tai/generators"
 )
 
@@ -71,6 +76,18 @@ continue; withholding output on pass causes the system to exit prematurely when
 the model intended to proceed. By always feeding back stdout and stderr, the model
 can see pass results and continue its workflow, or see failure output and debug
 the issues.
+
+The tests run against the session's changes, not only against what has
+been flushed: ProcessGoTestBlocks takes the session's changes.MemoryStore and
+runs go test with an Overlay of it (StoreOverlay) — every file the store
+changed, pending or flushed, and, for a store layered on another
+MemoryStore like a best-of-N candidate's (see codes.TheoryOfCandidates),
+every file the stores below it changed. go test -overlay replaces the listed
+files with their in-memory contents (or hides deleted ones) for the build,
+leaving the working tree untouched; the contents are written to a temporary
+directory that is removed after the run. Testing the round's changes before
+they are written is what lets a round's flush wait for its tests (see
+codes.TheoryOfTestThenCommit).
 `
 
 const GoTestBlockSystemPrompt = `
@@ -100,6 +117,86 @@ const GoTestBlockRestatePrompt = `- After making code changes, emit a go-test bl
 
 const goTestTimeout = 120 * time.Second
 
+// Overlay maps file paths to the contents go test builds with instead of
+// the files on disk. A nil content hides the file. Relative paths are
+// relative to the working directory. See TheoryOfGoTestBlocks.
+type Overlay map[string][]byte
+
+// write writes the overlay contents and the -overlay JSON file under dir,
+// returning the JSON file path.
+func (o Overlay) write(dir string, workDir string) (string, error) {
+	replace := make(map[string]string, len(o))
+	i := 0
+	for path, content := range o {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(workDir, path)
+		}
+		if content == nil {
+			replace[path] = ""
+			continue
+		}
+		file := filepath.Join(dir, fmt.Sprintf("%d%s", i, filepath.Ext(path)))
+		i++
+		if err := os.WriteFile(file, content, 0644); err != nil {
+			return "", err
+		}
+		replace[path] = file
+	}
+	data, err := json.Marshal(map[string]any{
+		"Replace": replace,
+	})
+	if err != nil {
+		return "", err
+	}
+	file := filepath.Join(dir, "overlay.json")
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		return "", err
+	}
+	return file, nil
+}
+
+// DiffsOverlay returns the current contents of diffs as an Overlay,
+// hiding the deleted files.
+func DiffsOverlay(diffs []changes.FileDiff) Overlay {
+	overlay := make(Overlay, len(diffs))
+	for _, diff := range diffs {
+		if !diff.CurrentExists {
+			overlay[diff.Path] = nil
+			continue
+		}
+		// an empty file is not a deleted one
+		overlay[diff.Path] = append([]byte{}, diff.Current...)
+	}
+	return overlay
+}
+
+// StoreOverlay returns the files store changed, pending or flushed, and
+// the files changed by the MemoryStores it is layered on, as an Overlay.
+// A nil store has no overlay. See TheoryOfGoTestBlocks.
+func StoreOverlay(store *changes.MemoryStore) Overlay {
+	var stores []*changes.MemoryStore
+	for store != nil {
+		stores = append(stores, store)
+		store, _ = store.Underlying().(*changes.MemoryStore)
+	}
+	if len(stores) == 0 {
+		return nil
+	}
+	overlay := Overlay{}
+	// the upper stores' contents win
+	for _, store := range slices.Backward(stores) {
+		maps.Copy(overlay, DiffsOverlay(store.Diffs()))
+	}
+	return overlay
+}
+
+// RunGoTest runs go test with the newline-separated args against the
+// working directory with overlay applied, returning the output and whether
+// the tests failed. See TheoryOfGoTestBlocks.
+func RunGoTest(ctx context.Context, args string, overlay Overlay) (string, bool) {
+	return executeGoTest(ctx, args, overlay)
+}
+
 // executeGoTest runs `go test` with the given arguments and returns the
 // output and whether the tests failed. The working directory is determined
 // via os.Getwd and included in the output so the model can construct
@@ -108,7 +205,7 @@ const goTestTimeout = 120 * time.Second
 // Arguments are parsed from a newline-separated list: each non-empty line
 // becomes a separate argument passed directly to the go binary via
 // exec.Command, bypassing the shell entirely to avoid injection.
-func executeGoTest(ctx context.Context, args string) (string, bool) {
+func executeGoTest(ctx context.Context, args string, overlay Overlay) (string, bool) {
 	cmdCtx, cancel := context.WithTimeout(ctx, goTestTimeout)
 	defer cancel()
 
@@ -137,8 +234,20 @@ func executeGoTest(ctx context.Context, args string) (string, bool) {
 		workDir = "(unknown)"
 	}
 
-	fullArgs := make([]string, 0, len(testArgs)+1)
+	fullArgs := make([]string, 0, len(testArgs)+2)
 	fullArgs = append(fullArgs, "test")
+	if len(overlay) > 0 {
+		dir, err := os.MkdirTemp("", "tai-overlay-")
+		if err != nil {
+			return fmt.Sprintf("Working directory: %s\n\nWriting test overlay failed: %v", workDir, err), true
+		}
+		defer os.RemoveAll(dir)
+		file, err := overlay.write(dir, workDir)
+		if err != nil {
+			return fmt.Sprintf("Working directory: %s\n\nWriting test overlay failed: %v", workDir, err), true
+		}
+		fullArgs = append(fullArgs, "-overlay", file)
+	}
 	fullArgs = append(fullArgs, testArgs...)
 	cmd := exec.CommandContext(cmdCtx, "go", fullArgs...)
 	if dirErr == nil {
@@ -158,28 +267,30 @@ func executeGoTest(ctx context.Context, args string) (string, bool) {
 		workDir, cmdStr, stdout.String(), stderr.String()), false
 }
 
-// ProcessGoTestBlocks runs Go tests for all go-test blocks and returns the
-// outputs as generator parts. Only blocks with Kind "go-test" are processed.
-// Test output (stdout and stderr) is always returned, regardless of whether
-// tests pass or fail; the go-test component feeds it back as user content,
-// always triggering a new round so the model can see the results and
-// continue. Withholding output on pass causes some models to exit
-// prematurely when they intended to proceed after seeing the test results.
-// See TheoryOfGoTestBlocks.
-func ProcessGoTestBlocks(bs []blocks.Block, ctx context.Context) ([]generators.Part, error) {
-	if len(bs) == 0 {
-		return nil, nil
+// ProcessGoTestBlocks runs Go tests for all go-test blocks against the
+// changes of store (see StoreOverlay; a nil store tests the working tree)
+// and returns the outputs as generator parts, reporting whether any run
+// failed. Only blocks with Kind "go-test" are processed. Test output
+// (stdout and stderr) is always returned, regardless of whether tests pass
+// or fail; the go-test component feeds it back as user content, always
+// triggering a new round so the model can see the results and continue.
+// Withholding output on pass causes some models to exit prematurely when
+// they intended to proceed after seeing the test results. See
+// TheoryOfGoTestBlocks.
+func ProcessGoTestBlocks(bs []blocks.Block, ctx context.Context, store *changes.MemoryStore) (parts []generators.Part, failed bool, err error) {
+	if !slices.ContainsFunc(bs, func(block blocks.Block) bool {
+		return block.Kind == "go-test"
+	}) {
+		return nil, false, nil
 	}
-	var parts []generators.Part
+	overlay := StoreOverlay(store)
 	for _, block := range bs {
 		if block.Kind != "go-test" {
 			continue
 		}
-		output, _ := executeGoTest(ctx, block.Body)
+		output, blockFailed := executeGoTest(ctx, block.Body, overlay)
 		parts = append(parts, generators.Text(output))
+		failed = failed || blockFailed
 	}
-	if len(parts) == 0 {
-		return nil, nil
-	}
-	return parts, nil
+	return parts, failed, nil
 }
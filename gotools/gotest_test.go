@@ -2,10 +2,14 @@ package gotools
 
 import (
 	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/reusee/tai/blocks"
+	"github.com/reusee/tai/changes"
 	"github.com/reusee/tai/generators"
 )
 
@@ -39,12 +43,12 @@ func TestProcessGoTestBlocks(t *testing.T) {
 		bs := []blocks.Block{
 			{Kind: "go-test", Body: "-run\nTest["},
 		}
-		parts, err := ProcessGoTestBlocks(bs, context.Background())
+		parts, failed, err := ProcessGoTestBlocks(bs, context.Background(), nil)
 		if err != nil {
 			t.Fatalf("ProcessGoTestBlocks failed: %v", err)
 		}
-		if len(parts) != 1 {
-			t.Fatalf("expected 1 part for failing tests, got %d", len(parts))
+		if len(parts) != 1 || !failed {
+			t.Fatalf("expected 1 part for failing tests, got %d, failed %v", len(parts), failed)
 		}
 		output := string(parts[0].(generators.Text))
 		if !strings.Contains(output, "Working directory:") {
@@ -65,12 +69,12 @@ func TestProcessGoTestBlocks(t *testing.T) {
 		bs := []blocks.Block{
 			{Kind: "go-test", Body: "-run\n___nonexistent___"},
 		}
-		parts, err := ProcessGoTestBlocks(bs, context.Background())
+		parts, failed, err := ProcessGoTestBlocks(bs, context.Background(), nil)
 		if err != nil {
 			t.Fatalf("ProcessGoTestBlocks failed: %v", err)
 		}
-		if len(parts) != 1 {
-			t.Fatalf("expected 1 part for passing tests, got %d", len(parts))
+		if len(parts) != 1 || failed {
+			t.Fatalf("expected 1 part for passing tests, got %d, failed %v", len(parts), failed)
 		}
 		output := string(parts[0].(generators.Text))
 		if !strings.Contains(output, "Working directory:") {
@@ -83,7 +87,7 @@ func TestProcessGoTestBlocks(t *testing.T) {
 }
 
 func TestProcessGoTestBlocksEmpty(t *testing.T) {
-	parts, err := ProcessGoTestBlocks(nil, context.Background())
+	parts, _, err := ProcessGoTestBlocks(nil, context.Background(), nil)
 	if err != nil {
 		t.Fatalf("ProcessGoTestBlocks failed: %v", err)
 	}
@@ -91,3 +95,89 @@ func TestProcessGoTestBlocksEmpty(t *testing.T) {
 		t.Fatalf("expected 0 parts, got %d", len(parts))
 	}
 }
+
+func TestOverlayWrite(t *testing.T) {
+	dir := t.TempDir()
+	file, err := Overlay{
+		"a.go":          []byte("package a\n"),
+		"/abs/b.go":     nil,
+		"sub/c_test.go": []byte("package sub\n"),
+	}.write(dir, "/work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var overlay struct {
+		Replace map[string]string
+	}
+	if err := json.Unmarshal(data, &overlay); err != nil {
+		t.Fatal(err)
+	}
+	if len(overlay.Replace) != 3 {
+		t.Fatalf("unexpected overlay: %s", data)
+	}
+	// deleted files are hidden
+	if path, ok := overlay.Replace["/abs/b.go"]; !ok || path != "" {
+		t.Fatalf("expected a hidden file: %s", data)
+	}
+	// relative paths resolve against the working directory
+	content, err := os.ReadFile(overlay.Replace["/work/sub/c_test.go"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "package sub\n" {
+		t.Fatalf("unexpected content %q", content)
+	}
+	if filepath.Ext(overlay.Replace["/work/sub/c_test.go"]) != ".go" {
+		t.Fatalf("expected the extension to be kept: %s", data)
+	}
+}
+
+func TestStoreOverlay(t *testing.T) {
+	if StoreOverlay(nil) != nil {
+		t.Fatal("expecting no overlay for a nil store")
+	}
+	root, err := os.OpenRoot(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	if err := root.WriteFile("a.go", []byte("package a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// a candidate store with a flushed round, and a session store on it
+	// with a pending change
+	candidate := changes.NewMemoryStore(changes.NewRootStore(root))
+	session := changes.NewMemoryStore(candidate)
+	if err := session.WriteFile("b.go", []byte("package b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	session.Reset()
+	if err := session.WriteFile("b.go", []byte("package b2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Remove("a.go"); err != nil {
+		t.Fatal(err)
+	}
+
+	overlay := StoreOverlay(session)
+	if len(overlay) != 2 || string(overlay["b.go"]) != "package b2\n" {
+		t.Fatalf("got %q", overlay)
+	}
+	if content, ok := overlay["a.go"]; !ok || content != nil {
+		t.Fatalf("expecting a.go hidden, got %q", overlay)
+	}
+
+	// the candidate's own changes are seen by a later session on it
+	overlay = StoreOverlay(changes.NewMemoryStore(candidate))
+	if len(overlay) != 1 || string(overlay["b.go"]) != "package b\n" {
+		t.Fatalf("got %q", overlay)
+	}
+}
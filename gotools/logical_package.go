@@ -27,7 +27,9 @@ Package categorization determines the minimum visibility and priority:
   package unless explicitly requested via -pkg or -ctx (in which case it is
   categorized as focus or context). See TheoryOfStdLibExclusion in files.go.
 
-Priority ordering: category (higher first), distance (shorter first),
+Priority ordering: category (higher first), relevance to the chat input
+(higher first, zero unless an embedding model is configured; see
+TheoryOfSemanticRelevance in relevance.go), distance (shorter first),
 package path (ascending). The water-filling algorithm upgrades packages
 from their minimum visibility to higher levels as the budget allows.
 See TheoryOfVisibilityAllocation in visibility.go.
@@ -94,6 +96,10 @@ type LogicalPackage struct {
 	Visibility    VisibilityLevel
 	ChangeCount   int
 
+	// Relevance is the quantized similarity of the package to the chat
+	// input. See TheoryOfSemanticRelevance.
+	Relevance int
+
 	// Pre-computed rendered files and token counts at each visibility level.
 	RenderedFiles [5][]renderedFile
 	TokensByLevel [5]int
@@ -329,13 +335,17 @@ func computeDistances(logicalPkgs []*LogicalPackage) {
 
 // sortPackagesByPriority sorts logical packages by priority:
 // 1. Category (lower value = higher priority, Focus first)
-// 2. Distance (shorter = higher priority)
-// 3. Package path (ascending)
+// 2. Relevance (higher = higher priority; see TheoryOfSemanticRelevance)
+// 3. Distance (shorter = higher priority)
+// 4. Package path (ascending)
 func sortPackagesByPriority(logicalPkgs []*LogicalPackage) {
 	slices.SortStableFunc(logicalPkgs, func(a, b *LogicalPackage) int {
 		if a.Category != b.Category {
 			return cmp.Compare(a.Category, b.Category)
 		}
+		if a.Relevance != b.Relevance {
+			return -cmp.Compare(a.Relevance, b.Relevance)
+		}
 		if a.Distance != b.Distance {
 			return cmp.Compare(a.Distance, b.Distance)
 		}
@@ -0,0 +1,203 @@
+package gotools
+
+import (
+	"context"
+	"go/ast"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/reusee/tai/flags"
+	"github.com/reusee/tai/generators"
+	"github.com/reusee/tai/logs"
+)
+
+const TheoryOfSemanticRelevance = `
+Import distance and git change counts rank packages by how they are wired
+to the focus packages, not by what the task is about: a package the chat
+asks about but the focus packages do not import ranks like any other
+package of its category. When an embedding model is configured (see
+generators.TheoryOfEmbedding), each non-focus, non-context package is
+summarized as text — its path, package comment and exported top-level
+declarations with the first sentence of their comments, the surface go doc
+would show — and embedded together with the chat input. The cosine
+similarity of a package to the chat input, quantized into
+relevanceBuckets levels, is the package's Relevance.
+
+Relevance is a priority key after the category and before the import
+distance (see TheoryOfLogicalPackages), so within a category the
+allocation (TheoryOfVisibilityAllocation) shows related packages first and
+more fully, while the category minimum visibilities, and with them every
+guarantee of the allocation, are unchanged. Quantization makes the key
+coarse: packages whose similarities differ by noise fall into the same
+level and keep their distance and path order.
+
+The output stays deterministic for identical inputs: the summaries are
+derived from file contents, the vectors are served from the content-keyed
+embedding cache once computed, and ties are broken by the existing keys.
+Without an embedding model, without chat input, or when embedding fails
+(logged), every Relevance is zero and the ordering is the one before
+this signal existed.
+`
+
+// relevanceBuckets is the number of Relevance levels a similarity in
+// [0, 1] is quantized into.
+const relevanceBuckets = 10
+
+// maxPackageSummaryLength bounds the text embedded for one package.
+const maxPackageSummaryLength = 8 * 1024
+
+// ScoreRelevance sets the Relevance of logical packages from the similarity
+// of their summaries to the chat input. See TheoryOfSemanticRelevance.
+type ScoreRelevance func(logicalPkgs []*LogicalPackage)
+
+func (Module) ScoreRelevance(
+	getEmbedder generators.GetEmbedder,
+	chats flags.Chats,
+	logger logs.Logger,
+) ScoreRelevance {
+	return func(logicalPkgs []*LogicalPackage) {
+		query := strings.TrimSpace(strings.Join(chats, "\n"))
+		if query == "" {
+			return
+		}
+		embedder, err := getEmbedder()
+		if err != nil {
+			logger.Warn("semantic relevance disabled", "error", err)
+			return
+		}
+		if embedder == nil {
+			return
+		}
+
+		var scored []*LogicalPackage
+		texts := []string{query}
+		for _, lp := range logicalPkgs {
+			if lp.Category == CategoryFocus || lp.Category == CategoryContext {
+				continue
+			}
+			summary := packageSummary(lp)
+			if summary == "" {
+				continue
+			}
+			scored = append(scored, lp)
+			texts = append(texts, summary)
+		}
+		if len(scored) == 0 {
+			return
+		}
+
+		vectors, err := embedder.Embed(context.Background(), texts)
+		if err != nil {
+			logger.Warn("semantic relevance disabled", "error", err)
+			return
+		}
+		for i, lp := range scored {
+			lp.Relevance = quantizeRelevance(generators.CosineSimilarity(vectors[0], vectors[i+1]))
+		}
+		logger.Info("semantic relevance scored",
+			"model", embedder.EmbeddingModel(),
+			"packages", len(scored),
+		)
+	}
+}
+
+// quantizeRelevance maps a similarity to one of relevanceBuckets levels.
+func quantizeRelevance(similarity float64) int {
+	level := int(math.Floor(similarity * relevanceBuckets))
+	return max(0, min(level, relevanceBuckets-1))
+}
+
+// packageSummary renders the text embedded for a package: its path,
+// package comment, and exported top-level declarations with the first
+// sentence of their comments. Test files are skipped; files are visited in
+// path order so the text is deterministic.
+func packageSummary(lp *LogicalPackage) string {
+	files := slices.Clone(lp.Files)
+	slices.SortFunc(files, func(a, b *File) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	var doc strings.Builder
+	var decls strings.Builder
+	for _, f := range files {
+		if f.IsTestFile || f.AstFile == nil {
+			continue
+		}
+		if f.AstFile.Doc != nil {
+			doc.WriteString(f.AstFile.Doc.Text())
+		}
+		for _, decl := range f.AstFile.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if !decl.Name.IsExported() {
+					continue
+				}
+				name := decl.Name.Name
+				if decl.Recv != nil && len(decl.Recv.List) > 0 {
+					name = receiverName(decl.Recv.List[0].Type) + "." + name
+				}
+				writeSummaryDecl(&decls, name, decl.Doc)
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					comment := decl.Doc
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						if spec.Doc != nil {
+							comment = spec.Doc
+						}
+						if spec.Name.IsExported() {
+							writeSummaryDecl(&decls, spec.Name.Name, comment)
+						}
+					case *ast.ValueSpec:
+						if spec.Doc != nil {
+							comment = spec.Doc
+						}
+						for _, name := range spec.Names {
+							if name.IsExported() {
+								writeSummaryDecl(&decls, name.Name, comment)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	if doc.Len() == 0 && decls.Len() == 0 {
+		return ""
+	}
+	text := "package " + lp.PkgPath + "\n" + doc.String() + decls.String()
+	if len(text) > maxPackageSummaryLength {
+		text = strings.ToValidUTF8(text[:maxPackageSummaryLength], "")
+	}
+	return text
+}
+
+func writeSummaryDecl(b *strings.Builder, name string, comment *ast.CommentGroup) {
+	b.WriteString(name)
+	if comment != nil {
+		text := strings.Join(strings.Fields(comment.Text()), " ")
+		if i := strings.Index(text, ". "); i >= 0 {
+			text = text[:i+1]
+		}
+		if text != "" {
+			b.WriteString(": " + text)
+		}
+	}
+	b.WriteString("\n")
+}
+
+// receiverName returns the type name of a method receiver expression.
+func receiverName(expr ast.Expr) string {
+	switch expr := expr.(type) {
+	case *ast.StarExpr:
+		return receiverName(expr.X)
+	case *ast.IndexExpr:
+		return receiverName(expr.X)
+	case *ast.IndexListExpr:
+		return receiverName(expr.X)
+	case *ast.Ident:
+		return expr.Name
+	}
+	return ""
+}
@@ -0,0 +1,147 @@
+package gotools
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/reusee/dscope"
+	"github.com/reusee/tai/flags"
+	"github.com/reusee/tai/generators"
+	"github.com/reusee/tai/modes"
+)
+
+func relevanceTestPackage(t *testing.T, pkgPath string, category PackageCategory, src string) *LogicalPackage {
+	t.Helper()
+	astFile, err := parser.ParseFile(token.NewFileSet(), pkgPath+".go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &LogicalPackage{
+		PkgPath:  pkgPath,
+		Category: category,
+		Distance: 1,
+		Files: []*File{
+			{
+				Path:     pkgPath + ".go",
+				IsGoFile: true,
+				AstFile:  astFile,
+			},
+		},
+	}
+}
+
+func TestPackageSummary(t *testing.T) {
+	lp := relevanceTestPackage(t, "example.com/cache", CategorySameModule, `// Package cache stores responses.
+package cache
+
+// Store keeps entries on disk. It is safe for concurrent use.
+type Store struct{}
+
+// Get returns an entry.
+func (s *Store) Get() {}
+
+func helper() {}
+
+// MaxSize bounds the store.
+const MaxSize = 1
+`)
+	summary := packageSummary(lp)
+	for _, want := range []string{
+		"package example.com/cache",
+		"Package cache stores responses.",
+		"Store: Store keeps entries on disk.",
+		"Store.Get: Get returns an entry.",
+		"MaxSize: MaxSize bounds the store.",
+	} {
+		if !strings.Contains(summary, want) {
+			t.Fatalf("summary missing %q:\n%s", want, summary)
+		}
+	}
+	if strings.Contains(summary, "helper") || strings.Contains(summary, "concurrent") {
+		t.Fatalf("unexpected content:\n%s", summary)
+	}
+}
+
+func TestScoreRelevanceOrdersWithinCategory(t *testing.T) {
+	newPkgs := func() []*LogicalPackage {
+		return []*LogicalPackage{
+			relevanceTestPackage(t, "example.com/colors", CategorySameModule, `// Package colors renders terminal colors.
+package colors
+
+// Render renders colored text.
+func Render() {}
+`),
+			relevanceTestPackage(t, "example.com/embeddings", CategorySameModule, `// Package embeddings computes text embedding vectors for similarity ranking.
+package embeddings
+
+// Similarity returns the similarity of embedding vectors.
+func Similarity() {}
+`),
+			relevanceTestPackage(t, "example.com/direct", CategoryDirectImport, `// Package direct embeds embedding vectors.
+package direct
+`),
+		}
+	}
+
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Fork(
+		func() generators.EmbeddingModel {
+			return "hash"
+		},
+		func() generators.EmbeddingCacheDir {
+			return generators.EmbeddingCacheDir(t.TempDir())
+		},
+		func() flags.Chats {
+			return flags.Chats{"rank packages by embedding vectors similarity"}
+		},
+	).Call(func(scoreRelevance ScoreRelevance) {
+		pkgs := newPkgs()
+		scoreRelevance(pkgs)
+		sortPackagesByPriority(pkgs)
+		var paths []string
+		for _, lp := range pkgs {
+			paths = append(paths, lp.PkgPath)
+		}
+		// relevance reorders within the category; the category still comes
+		// first
+		want := []string{"example.com/embeddings", "example.com/colors", "example.com/direct"}
+		if strings.Join(paths, " ") != strings.Join(want, " ") {
+			t.Fatalf("got %v, want %v", paths, want)
+		}
+		if pkgs[0].Relevance <= pkgs[1].Relevance {
+			t.Fatalf("expected higher relevance, got %d <= %d", pkgs[0].Relevance, pkgs[1].Relevance)
+		}
+
+		// identical inputs score identically
+		again := newPkgs()
+		scoreRelevance(again)
+		sortPackagesByPriority(again)
+		for i := range pkgs {
+			if again[i].PkgPath != pkgs[i].PkgPath || again[i].Relevance != pkgs[i].Relevance {
+				t.Fatal("scoring is not deterministic")
+			}
+		}
+	})
+
+	// without an embedding model nothing is scored
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Fork(
+		func() flags.Chats {
+			return flags.Chats{"rank packages by embedding vectors similarity"}
+		},
+	).Call(func(scoreRelevance ScoreRelevance) {
+		pkgs := newPkgs()
+		scoreRelevance(pkgs)
+		for _, lp := range pkgs {
+			if lp.Relevance != 0 {
+				t.Fatalf("%s: unexpected relevance %d", lp.PkgPath, lp.Relevance)
+			}
+		}
+	})
+}
@@ -64,6 +64,7 @@ func (Module) SimplifyFiles(
 	getRootPackages GetRootPackages,
 	getContextPackages GetContextPackages,
 	getGitChangeCounts GetGitChangeCounts,
+	scoreRelevance ScoreRelevance,
 	logger logs.Logger,
 	debug Debug,
 	loadDir LoadDir,
@@ -140,7 +141,11 @@ func (Module) SimplifyFiles(
 		// 3. Compute distances via BFS from focus packages
 		computeDistances(logicalPkgs)
 
-		// 4. Sort by priority (category, distance, path)
+		// 3.5. Score relevance to the chat input, a priority key when an
+		// embedding model is configured. See TheoryOfSemanticRelevance.
+		scoreRelevance(logicalPkgs)
+
+		// 4. Sort by priority (category, relevance, distance, path)
 		sortPackagesByPriority(logicalPkgs)
 
 		// 5. Pre-compute per-file token counts at the code and full
@@ -0,0 +1,218 @@
+package gotools
+
+import (
+	"context"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+const TheoryOfTypeCheck = `
+A change block that parses but breaks compilation would be flushed to disk
+and only discovered if the model emits a go-test block. With -typecheck, the
+packages of the Go files a round changes are type-checked before the round's
+changes are flushed (see loops.RunOptions.VerifyRound): CheckTypes loads the
+packages, tests included, with go/packages and an overlay of the pending
+contents, so nothing is written for the check. A failure is returned as a
+changes.VerifyError carrying the compiler diagnostics, which triggers a
+retry like a changes.ApplyError: the attempt's change blocks are discarded
+and the model re-emits them with the errors fixed.
+
+Only the packages of the changed files are checked, not their dependents:
+loading every reverse dependency would cost a full module load per round,
+and a broken dependent is still found by go-test blocks. The go/packages
+overlay can replace files but not hide them, so a deleted file is overlaid
+with its header up to the package clause — build constraints and package
+name kept, declarations removed. The diagnostics are capped at
+maxTypeCheckDiagnostics so a cascade of errors does not flood the prompt.
+
+Only the diagnostics the round introduces fail it. A package already broken
+on disk, or being fixed by the model across rounds, would otherwise fail
+every attempt until the retries run out. When the overlaid load has
+diagnostics, the packages are loaded again without the overlay, and a
+diagnostic is dropped when the load from disk has one in the same file with
+the same message; positions are not compared, since the round's edits move
+them. Each diagnostic on disk excuses one occurrence.
+`
+
+const maxTypeCheckDiagnostics = 30
+
+// TypeCheckError reports the diagnostics of the packages that fail to
+// type-check. See TheoryOfTypeCheck.
+type TypeCheckError struct {
+	Diagnostics []string
+}
+
+func (e *TypeCheckError) Error() string {
+	var b strings.Builder
+	b.WriteString("type check failed:\n")
+	for i, diagnostic := range e.Diagnostics {
+		if i == maxTypeCheckDiagnostics {
+			fmt.Fprintf(&b, "... and %d more\n", len(e.Diagnostics)-i)
+			break
+		}
+		b.WriteString(diagnostic + "\n")
+	}
+	return b.String()
+}
+
+// CheckTypes type-checks the packages of files, with tests, against the
+// working directory with overlay applied. It returns a *TypeCheckError
+// when a package has errors. See TheoryOfTypeCheck.
+func CheckTypes(ctx context.Context, files []string, overlay Overlay) error {
+	workDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	abs := func(path string) string {
+		if filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(workDir, path)
+	}
+
+	var dirs []string
+	for _, file := range files {
+		if filepath.Ext(file) != ".go" {
+			continue
+		}
+		dir := filepath.Dir(abs(file))
+		if !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	if len(dirs) == 0 {
+		return nil
+	}
+
+	contents := make(map[string][]byte, len(overlay))
+	for path, content := range overlay {
+		path = abs(path)
+		if content == nil {
+			// deleted
+			if filepath.Ext(path) != ".go" {
+				continue
+			}
+			content = packageHeader(path)
+			if content == nil {
+				continue
+			}
+		}
+		contents[path] = content
+	}
+
+	errs, err := loadPackageErrors(ctx, workDir, dirs, contents)
+	if err != nil {
+		return err
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+
+	// drop the diagnostics already present on disk
+	if onDisk, err := loadPackageErrors(ctx, workDir, dirs, nil); err == nil {
+		excused := make(map[string]int)
+		for _, e := range onDisk {
+			excused[diagnosticKey(e)]++
+		}
+		errs = slices.DeleteFunc(errs, func(e packages.Error) bool {
+			key := diagnosticKey(e)
+			if excused[key] > 0 {
+				excused[key]--
+				return true
+			}
+			return false
+		})
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+
+	var diagnostics []string
+	for _, e := range errs {
+		diagnostics = append(diagnostics, e.Error())
+	}
+	return &TypeCheckError{
+		Diagnostics: diagnostics,
+	}
+}
+
+// loadPackageErrors loads the packages of dirs, with tests, and returns
+// their errors.
+func loadPackageErrors(ctx context.Context, workDir string, dirs []string, overlay map[string][]byte) ([]packages.Error, error) {
+	pkgs, err := packages.Load(&packages.Config{
+		Context: ctx,
+		Mode: packages.NeedName |
+			packages.NeedFiles |
+			packages.NeedSyntax |
+			packages.NeedTypes |
+			packages.NeedTypesInfo,
+		Tests:   true,
+		Dir:     workDir,
+		Overlay: overlay,
+	}, dirs...)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs, listErrs []packages.Error
+	for _, pkg := range pkgs {
+		for _, err := range pkg.Errors {
+			// go list repeats the compiler output of a package whose
+			// export data fails to build; the parse and type errors of
+			// go/packages carry the same diagnostics
+			ptr := &errs
+			if err.Kind == packages.ListError {
+				ptr = &listErrs
+			}
+			// a package and its test variant report the same errors
+			if !slices.ContainsFunc(*ptr, func(e packages.Error) bool {
+				return e.Error() == err.Error()
+			}) {
+				*ptr = append(*ptr, err)
+			}
+		}
+	}
+	if len(errs) == 0 {
+		errs = listErrs
+	}
+	return errs, nil
+}
+
+// diagnosticKey identifies a diagnostic by its file and message, without
+// the line and column.
+func diagnosticKey(e packages.Error) string {
+	file := e.Pos
+	// file:line:col or file:line
+	for range 2 {
+		if i := strings.LastIndexByte(file, ':'); i >= 0 {
+			if _, err := strconv.Atoi(file[i+1:]); err == nil {
+				file = file[:i]
+			}
+		}
+	}
+	return file + "\x00" + e.Msg
+}
+
+// packageHeader returns the file on disk up to its package clause, or nil
+// when it cannot be parsed.
+func packageHeader(path string) []byte {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, src, parser.PackageClauseOnly|parser.ParseComments)
+	if err != nil {
+		return nil
+	}
+	end := fset.Position(file.Name.End()).Offset
+	return append(src[:end:end], '\n')
+}
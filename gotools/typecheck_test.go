@@ -0,0 +1,89 @@
+package gotools
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckTypes(t *testing.T) {
+	dir := t.TempDir()
+	for path, content := range map[string]string{
+		"go.mod":  "module m\n\ngo 1.24\n",
+		"p/p.go":  "package p\n\nfunc F() int { return 1 }\n",
+		"p/g.go":  "package p\n\nfunc G() int { return F() }\n",
+		"p/x.txt": "x\n",
+	} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, path), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(dir)
+	ctx := context.Background()
+
+	// the overlay is checked, not the files on disk
+	if err := CheckTypes(ctx, []string{"p/p.go"}, Overlay{
+		"p/p.go": []byte("package p\n\nfunc F() int { return 2 }\n"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	err := CheckTypes(ctx, []string{"p/p.go"}, Overlay{
+		"p/p.go": []byte("package p\n\nfunc F() string { return \"\" }\n"),
+	})
+	typeErr, ok := errors.AsType[*TypeCheckError](err)
+	if !ok || len(typeErr.Diagnostics) != 1 || !strings.Contains(typeErr.Diagnostics[0], "g.go:3:23") {
+		t.Fatalf("got %v", err)
+	}
+	if content, _ := os.ReadFile("p/p.go"); string(content) != "package p\n\nfunc F() int { return 1 }\n" {
+		t.Fatalf("disk modified: %q", content)
+	}
+
+	// a deleted file is hidden
+	err = CheckTypes(ctx, []string{"p/p.go"}, Overlay{
+		"p/p.go": nil,
+	})
+	if err == nil || !strings.Contains(err.Error(), "undefined: F") {
+		t.Fatalf("got %v", err)
+	}
+
+	// a new package
+	err = CheckTypes(ctx, []string{"q/q.go"}, Overlay{
+		"q/q.go": []byte("package q\n\nvar x int = \"\"\n"),
+	})
+	if err == nil || !strings.Contains(err.Error(), "q.go:3:13") {
+		t.Fatalf("got %v", err)
+	}
+
+	// errors already on disk do not fail the check, even when moved
+	if err := os.MkdirAll("r", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("r/r.go", []byte("package r\n\nvar y int = \"\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckTypes(ctx, []string{"r/r.go"}, Overlay{
+		"r/r.go": []byte("package r\n\nvar z = 1\n\nvar y int = \"\"\n"),
+	}); err != nil {
+		t.Fatalf("got %v", err)
+	}
+	err = CheckTypes(ctx, []string{"r/r.go"}, Overlay{
+		"r/r.go": []byte("package r\n\nvar z string = 1\n\nvar y int = \"\"\n"),
+	})
+	typeErr, ok = errors.AsType[*TypeCheckError](err)
+	if !ok || len(typeErr.Diagnostics) != 1 || !strings.Contains(typeErr.Diagnostics[0], "r.go:3:") {
+		t.Fatalf("expected only the new error, got %v", err)
+	}
+
+	// no Go files changed
+	if err := CheckTypes(ctx, []string{"p/x.txt"}, Overlay{
+		"p/x.txt": []byte("y\n"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
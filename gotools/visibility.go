@@ -112,12 +112,14 @@ requests with the same focus, preserving the LLM prefix cache.
 
 The visibility allocation uses a water-filling algorithm that upgrades
 packages from their minimum visibility to higher levels as the budget
-allows. Packages are processed in priority order (highest first). Each
-step upgrades the leftmost (highest priority) affordable package by one
-level. A package that cannot afford a level is skipped rather than
-blocking lower-priority packages, so a single unaffordable package
-cannot blank out the entire context: the budget is shared, and every
-package gets an independent chance to reach its minimum visibility.
+allows. Packages are processed in priority order (highest first; with an
+embedding model the order includes relevance to the chat input, see
+TheoryOfSemanticRelevance). Each step upgrades the leftmost (highest
+priority) affordable package by one level. A package that cannot afford a
+level is skipped rather than blocking lower-priority packages, so a
+single unaffordable package cannot blank out the entire context: the
+budget is shared, and every package gets an independent chance to reach
+its minimum visibility.
 
 Short doc is the cheapest documentation level: go doc without -all, the
 package overview and top-level symbol index without per-symbol
@@ -251,8 +253,10 @@ const contextTokenBudgetUnit = 32 << 10
 // budget — so context files are simplified to the same level across
 // requests with the same focus, preserving the LLM prefix cache. Large
 // repositories with big focus packages receive proportionally larger
-// budgets so that context packages are not starved. See
-// TheoryOfVisibilityAllocation.
+// budgets so that context packages are not starved. focusTokens come from
+// the generator's counter, which applies the per-spec calibration ratio
+// (see generators.TheoryOfTokenCalibration), so the budget is in the
+// provider's tokens. See TheoryOfVisibilityAllocation.
 func calculateMaxContextTokens(focusTokens int) int {
 	quarter := focusTokens / 4
 	rounded := ((quarter + contextTokenBudgetUnit/2) / contextTokenBudgetUnit) * contextTokenBudgetUnit
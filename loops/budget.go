@@ -0,0 +1,239 @@
+package loops
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"cuelang.org/go/cue"
+	"github.com/reusee/tai/configs"
+	"github.com/reusee/tai/flags"
+	"github.com/reusee/tai/generators"
+)
+
+const TheoryOfSessionBudget = `
+MaxRounds bounds the number of rounds, but not what they spend: a goal run
+can burn through every iteration with large prompts before anyone notices.
+A session budget bounds the tokens and the cost spent by a session, set by
+-max-session-tokens and -max-session-cost (or the max_session_tokens and
+max_session_cost config paths). Zero means no limit.
+
+Budget accumulates spending. Run adds the usage of every Generate call of
+every round, the same usage logged per round (see TheoryOfUsageLogging):
+tokens are the prompt, completion, and thought token counts, and the cost
+of each call is priced with the Spec of the generator that served it, the
+spec switched to by a fallback included (see generators.TheoryOfPricing and
+generators.TheoryOfFallback). A cost limit on a
+generator without prices is never reached, so Run warns about it once.
+
+The budget is checked between rounds, never within one: the current round
+finishes, its OnRoundSuccess flushes the MemoryStore, and only then does Run
+stop instead of starting the next round, so no round's changes are
+half-applied. A run stopped by the budget ends without an error and sets
+Result.BudgetExhausted, so callers can tell it from a natural end.
+
+RunOptions.Budget selects the budget; when nil, the Budget provider is used,
+so every command enforces the flags. The goal command resolves one Budget
+and forks it into every goal loop's reset scope, so spending accumulates
+across goal iterations and the goal stops when the budget is exhausted
+instead of running the remaining iterations. Generations outside Run, like
+the scoring of best-of-N candidates, are charged through a generator
+wrapped with Charge.
+`
+
+// Budget tracks the tokens and cost spent by a session against optional
+// limits. A nil Budget has no limits. See TheoryOfSessionBudget.
+type Budget struct {
+	MaxTokens int
+	MaxCost   float64
+
+	mu     sync.Mutex
+	tokens int
+	cost   float64
+}
+
+func (Module) Budget(
+	maxTokens MaxSessionTokens,
+	maxCost MaxSessionCost,
+) *Budget {
+	return &Budget{
+		MaxTokens: int(maxTokens),
+		MaxCost:   float64(maxCost),
+	}
+}
+
+// Add records spending.
+func (b *Budget) Add(tokens int, cost float64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += tokens
+	b.cost += cost
+}
+
+// Exhausted reports whether any limit has been reached.
+func (b *Budget) Exhausted() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return (b.MaxTokens > 0 && b.tokens >= b.MaxTokens) ||
+		(b.MaxCost > 0 && b.cost >= b.MaxCost)
+}
+
+// String describes the spending against the limits.
+func (b *Budget) String() string {
+	if b == nil {
+		return "no budget"
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ret := fmt.Sprintf("tokens %d", b.tokens)
+	if b.MaxTokens > 0 {
+		ret += fmt.Sprintf("/%d", b.MaxTokens)
+	}
+	ret += fmt.Sprintf(", cost %.4f", b.cost)
+	if b.MaxCost > 0 {
+		ret += fmt.Sprintf("/%.4f", b.MaxCost)
+	}
+	return ret
+}
+
+// addCalls records the spending of a round's Generate calls, each priced
+// with the spec that served it.
+func (b *Budget) addCalls(calls []RoundCall) {
+	for _, call := range calls {
+		cost, _ := call.Spec.Cost(call.Usage)
+		b.Add(call.Usage.Prompt.TokenCount+call.Usage.Candidates.TokenCount+call.Usage.Thoughts.TokenCount, cost)
+	}
+}
+
+// Charge wraps generator so the usage of its calls is added to the budget.
+// It is for generations outside Run, which charges its own. See
+// TheoryOfSessionBudget.
+func (b *Budget) Charge(generator generators.Generator) generators.Generator {
+	if b == nil {
+		return generator
+	}
+	return &chargedGenerator{
+		Generator: generator,
+		budget:    b,
+	}
+}
+
+type chargedGenerator struct {
+	generators.Generator
+	budget *Budget
+}
+
+var _ generators.ContextTokenCounter = new(chargedGenerator)
+
+func (g *chargedGenerator) CountTokensContext(ctx context.Context, text string) (int, error) {
+	return generators.CountTokensFunc(ctx, g.Generator)(text)
+}
+
+func (g *chargedGenerator) Generate(ctx context.Context, state generators.State, options *generators.GenerateOptions) (generators.State, error) {
+	numContents := generators.CountContents(state)
+	spec := g.Spec()
+	ret, err := g.Generator.Generate(ctx, state, options)
+	if ret != nil {
+		g.budget.addCalls(RoundCalls(ret, numContents, spec))
+	}
+	return ret, err
+}
+
+// MaxSessionTokens limits the tokens spent by a session. Zero means no
+// limit. See TheoryOfSessionBudget.
+type MaxSessionTokens int
+
+func (Module) MaxSessionTokens() MaxSessionTokens {
+	return 0
+}
+
+var _ flags.Flag = MaxSessionTokens(0)
+
+func (m MaxSessionTokens) Keys() map[string]string {
+	return map[string]string{
+		"-max-session-tokens": "Stop the session after this many prompt, completion and thought tokens",
+	}
+}
+
+func (m MaxSessionTokens) Handle(key string, args []string) (newDef any, remainArgs []string, err error) {
+	if len(args) == 0 {
+		return nil, nil, fmt.Errorf("expecting int argument, got empty")
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	ret := MaxSessionTokens(n)
+	return &ret, args[1:], nil
+}
+
+// MaxSessionTokens configs.Config implementation. See
+// flags.TheoryOfConfigFlagParity.
+
+var _ configs.Config = MaxSessionTokens(0)
+
+func (m MaxSessionTokens) ConfigPaths() []string {
+	return []string{"max_session_tokens"}
+}
+
+func (m MaxSessionTokens) HandleConfig(path string, values []*cue.Value) (any, error) {
+	var n int
+	if err := values[0].Decode(&n); err != nil {
+		return nil, err
+	}
+	ret := MaxSessionTokens(n)
+	return &ret, nil
+}
+
+// MaxSessionCost limits the cost spent by a session, in the currency of
+// the generator prices. Zero means no limit. See TheoryOfSessionBudget.
+type MaxSessionCost float64
+
+func (Module) MaxSessionCost() MaxSessionCost {
+	return 0
+}
+
+var _ flags.Flag = MaxSessionCost(0)
+
+func (m MaxSessionCost) Keys() map[string]string {
+	return map[string]string{
+		"-max-session-cost": "Stop the session after spending this much, priced with the generator prices",
+	}
+}
+
+func (m MaxSessionCost) Handle(key string, args []string) (newDef any, remainArgs []string, err error) {
+	if len(args) == 0 {
+		return nil, nil, fmt.Errorf("expecting float argument, got empty")
+	}
+	f, err := strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		return nil, nil, err
+	}
+	ret := MaxSessionCost(f)
+	return &ret, args[1:], nil
+}
+
+// MaxSessionCost configs.Config implementation. See
+// flags.TheoryOfConfigFlagParity.
+
+var _ configs.Config = MaxSessionCost(0)
+
+func (m MaxSessionCost) ConfigPaths() []string {
+	return []string{"max_session_cost"}
+}
+
+func (m MaxSessionCost) HandleConfig(path string, values []*cue.Value) (any, error) {
+	var f float64
+	if err := values[0].Decode(&f); err != nil {
+		return nil, err
+	}
+	ret := MaxSessionCost(f)
+	return &ret, nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"iter"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/reusee/tai/blocks"
@@ -123,7 +124,9 @@ statistics and the TUI's Summary tab.
 
 Retry on error: an error after content output retries from the state that includes
 the partial output, appending the error context and the handoff summary as user content.
-Errors before any content output do not retry.
+Errors before any content output do not retry. A round's changes that fail
+the caller's verification (RunOptions.VerifyRound, e.g., the type check of
+-typecheck) are retried the same way, so they are never flushed.
 
 Retry feedback states the current attempt number (e.g., "retry attempt 1 of 3") so
 the model knows how much budget remains and can prioritize correcting the error.
@@ -141,9 +144,13 @@ every attempt, including retries. Rounds that record no token usage emit no
 record.
 
 The usage is extracted by scanning the state's contents appended since the
-start of the round and taking the final Usage part, rather than summing
-intermediate usage snapshots that may be emitted by streaming providers
-(e.g., Gemini's streaming UsageMetadata).
+start of the round. A round makes one Generate call, plus one per answer to
+native function calls (see phases.TheoryOfToolCalls), and each call reports
+its own usage. A streaming provider may report several usage snapshots for
+one call (e.g., Gemini's streaming UsageMetadata), so the final snapshot of
+each call is taken, and the calls are summed. A call ends at its usage: a
+Usage part following any other content than RoleLog content belongs to the
+next call.
 `
 
 const errorRetryPrefix = "[System note: An error occurred: %s. This is retry attempt %d of %d. The failed attempt's output was discarded — its structured blocks were NOT applied. If the intended modifications are extensive, partition the work across multiple rounds using continue blocks rather than emitting all changes at once. Re-emit every block you intend to take effect, then correct the issue and continue.]\n\n"
@@ -311,6 +318,13 @@ func (ls *loopState) runRound() (roundResult, error) {
 			phaseState = wrappedState
 		}
 
+		// Verify the attempt's changes before the round can succeed. A
+		// verification failure is retried like any other error. See
+		// TheoryOfLoops.
+		if roundErr == nil && ls.opts.VerifyRound != nil {
+			roundErr = ls.opts.VerifyRound()
+		}
+
 		if roundErr != nil {
 			// Retry on any error when content was output during
 			// the round. The loop summarizes the incomplete
@@ -352,6 +366,13 @@ func (ls *loopState) runRound() (roundResult, error) {
 						retryParts = append(retryParts, generators.Text(
 							"\nThe change block that caused the error was NOT applied, and this retry discards ALL change blocks from the failed attempt. Re-emit every intended change block, correcting the one that caused the error.\n"))
 					}
+					// Likewise for changes that failed
+					// verification: none of them were written.
+					var verifyErr *changes.VerifyError
+					if errors.As(roundErr, &verifyErr) {
+						retryParts = append(retryParts, generators.Text(
+							"\nThe change blocks of the failed attempt failed verification and were NOT written, and this retry discards ALL of them. Re-emit every intended change block, fixing the reported errors.\n"))
+					}
 
 					summary := ""
 					retryPrompt := ""
@@ -566,13 +587,23 @@ func (ls *loopState) runRound() (roundResult, error) {
 		}
 	}
 
+	// Caller feedback.
+	var feedbackParts []generators.Part
+	if ls.opts.RoundFeedback != nil {
+		feedbackParts = ls.opts.RoundFeedback()
+	}
+	if len(feedbackParts) > 0 && ls.rec != nil && ls.rec.Enabled() {
+		ls.rec.Event("decision", fmt.Sprintf("round feedback: %d user part(s) fed back to the model", len(feedbackParts)))
+	}
+	leadingParts := slices.Concat(parseErrorParts, feedbackParts)
+
 	// Single-shot mode: no component processing.
 	if len(ls.opts.Components) == 0 {
-		if len(parseErrorParts) > 0 {
+		if len(leadingParts) > 0 {
 			var aerr error
 			ls.state, aerr = ls.state.AppendContent(&generators.Content{
 				Role:  generators.RoleUser,
-				Parts: parseErrorParts,
+				Parts: leadingParts,
 			})
 			if aerr != nil {
 				ls.recordRoundError(aerr)
@@ -606,8 +637,8 @@ func (ls *loopState) runRound() (roundResult, error) {
 	}
 	ls.remainingBlocks = append(ls.remainingBlocks, roundRemaining...)
 
-	if len(parseErrorParts) > 0 {
-		combinedParts = append(parseErrorParts, combinedParts...)
+	if len(leadingParts) > 0 {
+		combinedParts = append(leadingParts, combinedParts...)
 		triggered = true
 	}
 
@@ -659,21 +690,7 @@ func (ls *loopState) runRound() (roundResult, error) {
 	}, nil
 }
 
-func (ls *loopState) logRoundUsage(state generators.State, roundBaseCount int, roundNumber int, outcome string) {
-	var usage generators.Usage
-	i := 0
-	for c := range state.Contents() {
-		if i < roundBaseCount {
-			i++
-			continue
-		}
-		for _, p := range c.Parts {
-			if u, ok := p.(generators.Usage); ok {
-				usage = u
-			}
-		}
-		i++
-	}
+func (ls *loopState) logRoundUsage(usage generators.Usage, roundNumber int, outcome string) {
 	if usage.Prompt.TokenCount == 0 &&
 		usage.Prompt.TokenCountCached == 0 &&
 		usage.Candidates.TokenCount == 0 &&
@@ -693,6 +710,86 @@ func (ls *loopState) logRoundUsage(state generators.State, roundBaseCount int, r
 	ls.logger.InfoContext(ls.ctx, "usage", args...)
 }
 
+// RoundCall is the final usage of one Generate call of a round, and the
+// spec of the generator that served it.
+type RoundCall struct {
+	Usage generators.Usage
+	Spec  generators.Spec
+}
+
+// RoundCalls returns the Generate calls among the contents appended since
+// roundBaseCount. The calls are served by spec until a GeneratorFallback
+// switches to another one. See TheoryOfUsageLogging.
+func RoundCalls(state generators.State, roundBaseCount int, spec generators.Spec) []RoundCall {
+	var calls []RoundCall
+	// whether the last Usage part is a snapshot of a call still reporting
+	open := false
+	i := 0
+	for c := range state.Contents() {
+		if i < roundBaseCount {
+			i++
+			continue
+		}
+		i++
+		if c.Role != generators.RoleLog {
+			open = false
+			continue
+		}
+		for _, p := range c.Parts {
+			switch p := p.(type) {
+			case generators.Usage:
+				if open {
+					calls[len(calls)-1].Usage = p
+				} else {
+					calls = append(calls, RoundCall{
+						Usage: p,
+						Spec:  spec,
+					})
+					open = true
+				}
+			case generators.GeneratorFallback:
+				spec = p.ToSpec
+				open = false
+			}
+		}
+	}
+	return calls
+}
+
+// RoundUsage sums the usage of the calls. See TheoryOfUsageLogging.
+func RoundUsage(calls []RoundCall) generators.Usage {
+	var usage generators.Usage
+	for _, call := range calls {
+		usage.Prompt.TokenCount += call.Usage.Prompt.TokenCount
+		usage.Prompt.TokenCountCached += call.Usage.Prompt.TokenCountCached
+		usage.Prompt.TokenCountCacheWrite += call.Usage.Prompt.TokenCountCacheWrite
+		usage.Candidates.TokenCount += call.Usage.Candidates.TokenCount
+		usage.Thoughts.TokenCount += call.Usage.Thoughts.TokenCount
+		usage.Batch = usage.Batch || call.Usage.Batch
+	}
+	return usage
+}
+
+// spec returns the spec of the generator in use, or the zero Spec when
+// the run has no generator.
+func (ls *loopState) spec() generators.Spec {
+	if ls.opts.Generator == nil {
+		return generators.Spec{}
+	}
+	return ls.opts.Generator.Spec()
+}
+
+// finishBudgetExhausted ends the run between rounds because the session
+// budget is exhausted. See TheoryOfSessionBudget.
+func (ls *loopState) finishBudgetExhausted() {
+	ls.logger.WarnContext(ls.ctx, "session budget exhausted", "spent", ls.opts.Budget.String())
+	if ls.rec != nil && ls.rec.Enabled() {
+		ls.rec.Event("decision", fmt.Sprintf("session budget exhausted, stopping: %s", ls.opts.Budget))
+	}
+	ls.result.BudgetExhausted = true
+	ls.finish(ls.state, ls.remainingBlocks)
+}
+
 // recordRoundError reports a failed round to the interaction recorder
 // when recording is active.
 func (ls *loopState) recordRoundError(err error) {
@@ -793,6 +890,9 @@ type RunOptions struct {
 	HTTPClient nets.HTTPClient
 	// MaxRounds limits the number of rounds. 0 means unlimited.
 	MaxRounds int
+	// Budget limits the tokens and cost spent by the session. When nil,
+	// the Budget provider default is used. See TheoryOfSessionBudget.
+	Budget *Budget
 
 	// InteractionRecorder receives generation events (contents, blocks,
 	// round lifecycle) for interaction recording and self-improvement
@@ -811,6 +911,14 @@ type RunOptions struct {
 	// Used to reset per-round state (e.g., MemoryStore.Reset).
 	OnRoundStart func()
 
+	// VerifyRound is called after an attempt's phases complete, before
+	// the round can succeed. A non-nil error, typically a
+	// *changes.VerifyError, is handled like an error of the phases:
+	// retried under RetryOnError, the round's error otherwise. Used to
+	// type-check a round's changes before they are flushed (see
+	// gotools.TheoryOfTypeCheck).
+	VerifyRound func() error
+
 	// OnRoundSuccess is called after a successful round, before
 	// component processing. If it returns an error, the loop stops.
 	// Used to flush per-round state (e.g., MemoryStore.Flush) and
@@ -818,6 +926,20 @@ type RunOptions struct {
 	// summaries contains summary block bodies extracted from the round.
 	OnRoundSuccess func(state generators.State, summaries []string) error
 
+	// RoundFeedback is called after OnRoundSuccess. Non-empty parts are
+	// appended to the state as user content, with the component
+	// feedback, and start the next round like a component trigger. Used
+	// to report changes the user declined before the flush (see
+	// codes.TheoryOfHunkApproval).
+	RoundFeedback func() []generators.Part
+
+	// OnRoundEnd is called after a completed round that continues with
+	// another round, with the state the next round starts from: the
+	// round's output and the component feedback. If it returns an error,
+	// the loop stops. Used to checkpoint the session (see
+	// codes.TheoryOfCheckpoint).
+	OnRoundEnd func(state generators.State) error
+
 	// OnRoundTruncated is called when a round is truncated (no summary
 	// block or abnormal finish reason) and will be retried. It receives
 	// the state with the truncated output, the state that will be the
@@ -900,6 +1022,9 @@ type Result struct {
 	// loop to present the changes to a second model. See
 	// TheoryOfReviewLoop in codes/generate.go.
 	Diffs []changes.FileDiff
+	// BudgetExhausted reports that the run stopped between rounds because
+	// the session budget was exhausted. See TheoryOfSessionBudget.
+	BudgetExhausted bool
 }
 
 // RecordState reports the given state's system prompt and contents to the
@@ -968,6 +1093,7 @@ func (s recordedState) AppendContent(content *generators.Content) (generators.St
 func (Module) Run(
 	recorder InteractionRecorder,
 	logger logs.Logger,
+	budget *Budget,
 ) Run {
 	return func(ctx context.Context, opts RunOptions, result *Result) iter.Seq[error] {
 		if result == nil {
@@ -983,6 +1109,12 @@ func (Module) Run(
 				rec = recorder
 			}
 			opts.InteractionRecorder = rec
+			// Likewise the session budget defaults to the provider's, so
+			// the budget flags apply to every command. See
+			// TheoryOfSessionBudget.
+			if opts.Budget == nil {
+				opts.Budget = budget
+			}
 
 			// The loop state carries the mutable state of the run.
 			ls := &loopState{
@@ -1051,24 +1183,53 @@ func (Module) Run(
 			// count at the start of the current round so the round's
 			// aggregated token usage can be isolated from the contents
 			// appended during the round. See TheoryOfLoops.
+			if opts.Budget != nil && opts.Budget.MaxCost > 0 && opts.Generator != nil {
+				if _, ok := opts.Generator.Spec().Cost(generators.Usage{}); !ok {
+					logger.WarnContext(ctx, "session cost budget is set but the generator has no prices",
+						"generator", opts.Generator.Spec().Name)
+				}
+			}
+
 			prevRoundContentCount := generators.CountContents(ls.state)
 			for round := 0; opts.MaxRounds == 0 || round < opts.MaxRounds; round++ {
+				// The budget is checked between rounds, so the previous
+				// round has finished and flushed its changes. See
+				// TheoryOfSessionBudget.
+				if opts.Budget.Exhausted() {
+					ls.finishBudgetExhausted()
+					return
+				}
+				// the generator in use at the start of the round serves
+				// its calls until a fallback switch
+				roundSpec := ls.spec()
 				outcome, err := ls.runRound()
 				if err != nil {
 					// Log the failed round's token usage so token
 					// consumption is traceable for every attempt, including
 					// rounds that end with an error. See TheoryOfUsageLogging.
-					ls.logRoundUsage(outcome.state, prevRoundContentCount, round+1, "error")
+					// Its spending counts toward the session budget.
+					calls := RoundCalls(outcome.state, prevRoundContentCount, roundSpec)
+					opts.Budget.addCalls(calls)
+					ls.logRoundUsage(RoundUsage(calls), round+1, "error")
 					ls.finishWithError(err, outcome.state)
 					return
 				}
 				// Log the round's aggregated token usage to the logger so
 				// token consumption is visible in log output and in the
 				// TUI's Logs pane, not only in the end-of-session statistics
-				// table. See TheoryOfUsageLogging.
-				ls.logRoundUsage(outcome.state, prevRoundContentCount, round+1, "")
+				// table. See TheoryOfUsageLogging. The same usage counts
+				// toward the session budget. See TheoryOfSessionBudget.
+				calls := RoundCalls(outcome.state, prevRoundContentCount, roundSpec)
+				opts.Budget.addCalls(calls)
+				ls.logRoundUsage(RoundUsage(calls), round+1, "")
 				prevRoundContentCount = generators.CountContents(outcome.state)
 				if outcome.continueNext {
+					if opts.OnRoundEnd != nil {
+						if err := opts.OnRoundEnd(outcome.state); err != nil {
+							ls.finishWithError(err, outcome.state)
+							return
+						}
+					}
 					continue
 				}
 				if outcome.finalBlocks != nil {
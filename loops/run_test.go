@@ -765,6 +765,118 @@ func TestRunOnRoundSuccessCalled(t *testing.T) {
 	})
 }
 
+func TestRunOnRoundEndCalled(t *testing.T) {
+	// OnRoundEnd receives the state the next round starts from, with the
+	// component feedback, and is not called after the last round.
+	withRun(t, func(run Run) {
+		var endStates []generators.State
+		comps := components.ComponentSet{
+			{
+				Kind: "shell",
+				Process: func(ctx context.Context, pctx *components.ProcessContext) components.ProcessResult {
+					return components.ProcessResult{
+						Parts: []generators.Part{generators.Text("shell output")},
+					}
+				},
+			},
+		}
+
+		round := 0
+		_, err := runOnce(run, RunOptions{
+			InitialState: generators.NewPrompts("", nil),
+			Components:   comps,
+			OnRoundEnd: func(state generators.State) error {
+				endStates = append(endStates, state)
+				return nil
+			},
+			PhaseBuilder: func(g generators.Generator) phases.Phase {
+				round++
+				if round == 1 {
+					return appendPhase("<<龘靐 shell\necho hi\n龘靐\n<<龘靐 summary\nRan.\n龘靐\n")
+				}
+				return appendPhase("<<龘靐 summary\nDone.\n龘靐\n")
+			},
+			HTTPClient: nets.HTTPClient{},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(endStates) != 1 {
+			t.Fatalf("expected 1 OnRoundEnd call, got %d", len(endStates))
+		}
+		var last *generators.Content
+		for content := range endStates[0].Contents() {
+			last = content
+		}
+		var text strings.Builder
+		for _, part := range last.Parts {
+			if t, ok := part.(generators.Text); ok {
+				text.WriteString(string(t))
+			}
+		}
+		if last.Role != generators.RoleUser || !strings.Contains(text.String(), "shell output") {
+			t.Fatalf("expected the component feedback last, got %+v", last)
+		}
+	})
+}
+
+func TestRunRoundFeedbackStartsRound(t *testing.T) {
+	// Parts returned by RoundFeedback are fed back as user content and
+	// start the next round even when no component triggers.
+	withRun(t, func(run Run) {
+		comps := components.ComponentSet{
+			{
+				Kind: "shell",
+				Process: func(ctx context.Context, pctx *components.ProcessContext) components.ProcessResult {
+					return components.ProcessResult{}
+				},
+			},
+		}
+
+		round := 0
+		feedbacks := 0
+		var secondInput string
+		_, err := runOnce(run, RunOptions{
+			InitialState: generators.NewPrompts("", nil),
+			Components:   comps,
+			RoundFeedback: func() []generators.Part {
+				feedbacks++
+				if feedbacks == 1 {
+					return []generators.Part{generators.Text("declined")}
+				}
+				return nil
+			},
+			PhaseBuilder: func(g generators.Generator) phases.Phase {
+				round++
+				return func(ctx context.Context, state generators.State) (phases.Phase, generators.State, error) {
+					if round == 2 {
+						var last *generators.Content
+						for content := range state.Contents() {
+							last = content
+						}
+						if last != nil && last.Role == generators.RoleUser && len(last.Parts) > 0 {
+							if text, ok := last.Parts[0].(generators.Text); ok {
+								secondInput = string(text)
+							}
+						}
+					}
+					return appendPhase("<<龘靐 summary\nDone.\n龘靐\n")(ctx, state)
+				}
+			},
+			HTTPClient: nets.HTTPClient{},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if round != 2 {
+			t.Fatalf("expected 2 rounds, got %d", round)
+		}
+		if secondInput != "declined" {
+			t.Fatalf("expected the feedback as the second round's input, got %q", secondInput)
+		}
+	})
+}
+
 func TestRunLogsRoundUsage(t *testing.T) {
 	// The Run loop must record the aggregated token usage of each round
 	// to the logger, so token consumption is visible in log output and in
@@ -817,7 +929,7 @@ func TestRunLogsRoundUsage(t *testing.T) {
 
 func TestRunLogsRoundUsageMultipleUsageParts(t *testing.T) {
 	// If a generator emits multiple Usage parts during streaming (e.g. Gemini),
-	// logRoundUsage must take the final Usage snapshot rather than summing them.
+	// RoundCalls must take the final Usage snapshot rather than summing them.
 	// The logger is forked directly so the test controls the output sink;
 	// forking the logs.Writer would be ignored when the logger provider
 	// detects a systemd service. See TheoryOfUsageLogging.
@@ -844,15 +956,15 @@ func TestRunLogsRoundUsageMultipleUsageParts(t *testing.T) {
 			PhaseBuilder: func(g generators.Generator) phases.Phase {
 				return func(ctx context.Context, state generators.State) (phases.Phase, generators.State, error) {
 					s, err := state.AppendContent(&generators.Content{
-						Role:  generators.RoleLog,
-						Parts: []generators.Part{usage1},
+						Role:  generators.RoleAssistant,
+						Parts: []generators.Part{generators.Text("output")},
 					})
 					if err != nil {
 						return nil, state, err
 					}
 					s, err = s.AppendContent(&generators.Content{
-						Role:  generators.RoleAssistant,
-						Parts: []generators.Part{generators.Text("output")},
+						Role:  generators.RoleLog,
+						Parts: []generators.Part{usage1},
 					})
 					if err != nil {
 						return nil, state, err
@@ -1097,6 +1209,57 @@ func TestRunRetryOnApplyErrorGuidance(t *testing.T) {
 	})
 }
 
+func TestRunRetryOnVerifyError(t *testing.T) {
+	withRun(t, func(run Run) {
+		callCount := 0
+		phaseBuilder := func(g generators.Generator) phases.Phase {
+			callCount++
+			return appendPhase("<<龘靐 summary\nDone.\n龘靐\n")
+		}
+		verifications := 0
+		successes := 0
+
+		result, err := runOnce(run, RunOptions{
+			InitialState: generators.NewPrompts("", nil),
+			PhaseBuilder: phaseBuilder,
+			RetryOnError: true,
+			MaxRetries:   3,
+			VerifyRound: func() error {
+				verifications++
+				if verifications == 1 {
+					return &changes.VerifyError{Err: errors.New("a.go:1:1: undefined: x")}
+				}
+				return nil
+			},
+			OnRoundSuccess: func(state generators.State, summaries []string) error {
+				successes++
+				return nil
+			},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if callCount != 2 || successes != 1 {
+			t.Fatalf("expected a retry and one success, got %d calls, %d successes", callCount, successes)
+		}
+
+		var feedback string
+		for c := range result.FinalState.Contents() {
+			if c.Role != generators.RoleUser {
+				continue
+			}
+			for _, p := range c.Parts {
+				if text, ok := p.(generators.Text); ok {
+					feedback += string(text)
+				}
+			}
+		}
+		if !strings.Contains(feedback, "undefined: x") || !strings.Contains(feedback, "failed verification") {
+			t.Fatalf("expected the diagnostics and guidance in retry feedback, got %q", feedback)
+		}
+	})
+}
+
 func TestRunRetryOnErrorMaxRetries(t *testing.T) {
 	withRun(t, func(run Run) {
 		callCount := 0
@@ -1893,3 +2056,148 @@ func (s testObservingState) Flush() (generators.State, error) {
 func (s testObservingState) Unwrap() generators.State {
 	return s.upstream
 }
+
+func TestRunBudgetExhausted(t *testing.T) {
+	withRun(t, func(run Run) {
+		usage := generators.Usage{}
+		usage.Prompt.TokenCount = 100
+		usage.Candidates.TokenCount = 50
+		comps := components.ComponentSet{
+			{
+				Kind: "shell",
+				Process: func(ctx context.Context, pctx *components.ProcessContext) components.ProcessResult {
+					return components.ProcessResult{
+						Parts: []generators.Part{generators.Text("output")},
+					}
+				},
+			},
+		}
+
+		budget := &Budget{
+			MaxTokens: 250,
+		}
+		roundsFlushed := 0
+		result, err := runOnce(run, RunOptions{
+			Generator:    nil,
+			InitialState: generators.NewPrompts("", nil),
+			Components:   comps,
+			Budget:       budget,
+			OnRoundSuccess: func(state generators.State, summaries []string) error {
+				roundsFlushed++
+				return nil
+			},
+			PhaseBuilder: func(g generators.Generator) phases.Phase {
+				return appendPhaseWithUsage("<<龘靐 shell\necho hi\n龘靐\n", usage)
+			},
+			HTTPClient: nets.HTTPClient{},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !result.BudgetExhausted {
+			t.Fatal("expected the run to stop on the budget")
+		}
+		// 150 tokens per round: the second round reaches the limit and
+		// finishes before the run stops.
+		if roundsFlushed != 2 {
+			t.Fatalf("expected 2 finished rounds, got %d", roundsFlushed)
+		}
+		if !budget.Exhausted() || !strings.Contains(budget.String(), "tokens 300/250") {
+			t.Fatalf("unexpected budget state: %s", budget)
+		}
+
+		// A shared budget stops later runs before their first round.
+		phaseCalled := false
+		result, err = runOnce(run, RunOptions{
+			Generator:    nil,
+			InitialState: generators.NewPrompts("", nil),
+			Budget:       budget,
+			PhaseBuilder: func(g generators.Generator) phases.Phase {
+				phaseCalled = true
+				return appendPhase("hello")
+			},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if phaseCalled || !result.BudgetExhausted {
+			t.Fatal("exhausted budget must stop the next run")
+		}
+	})
+}
+
+func TestBudgetCost(t *testing.T) {
+	budget := &Budget{
+		MaxCost: 1,
+	}
+	usage := generators.Usage{}
+	usage.Prompt.TokenCount = 400_000
+	spec := generators.Spec{
+		InputPrice: new(2.0),
+	}
+	calls := []RoundCall{
+		{Usage: usage, Spec: spec},
+	}
+	budget.addCalls(calls)
+	if budget.Exhausted() {
+		t.Fatal("budget must not be exhausted below the limit")
+	}
+	budget.addCalls(calls)
+	if !budget.Exhausted() {
+		t.Fatalf("budget must be exhausted, got %s", budget)
+	}
+	var unlimited *Budget
+	unlimited.Add(1, 1)
+	if unlimited.Exhausted() {
+		t.Fatal("nil budget has no limits")
+	}
+}
+
+func TestRoundCalls(t *testing.T) {
+	// Every Generate call of a round is counted with its final usage
+	// snapshot, and priced with the spec that served it. See
+	// TheoryOfUsageLogging.
+	usage := func(prompt, completion int) generators.Usage {
+		var u generators.Usage
+		u.Prompt.TokenCount = prompt
+		u.Candidates.TokenCount = completion
+		return u
+	}
+	primary := generators.Spec{Name: "primary", InputPrice: new(1.0)}
+	fallback := generators.Spec{Name: "fallback", InputPrice: new(2.0)}
+	var state generators.State = generators.NewPrompts("", nil)
+	for _, content := range []*generators.Content{
+		{Role: generators.RoleUser, Parts: []generators.Part{generators.Text("before the round")}},
+		// a call answered with a function call, with two snapshots
+		{Role: generators.RoleAssistant, Parts: []generators.Part{generators.Text("call")}},
+		{Role: generators.RoleLog, Parts: []generators.Part{usage(100, 5)}},
+		{Role: generators.RoleLog, Parts: []generators.Part{usage(100, 10)}},
+		{Role: generators.RoleTool, Parts: []generators.Part{generators.Text("result")}},
+		// the next call switches to the fallback
+		{Role: generators.RoleLog, Parts: []generators.Part{generators.GeneratorFallback{From: "primary", To: "fallback", ToSpec: fallback}}},
+		{Role: generators.RoleAssistant, Parts: []generators.Part{generators.Text("answer")}},
+		{Role: generators.RoleLog, Parts: []generators.Part{usage(200, 20)}},
+	} {
+		var err error
+		state, err = state.AppendContent(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	calls := RoundCalls(state, 1, primary)
+	if len(calls) != 2 ||
+		calls[0].Usage.Candidates.TokenCount != 10 || calls[0].Spec.Name != "primary" ||
+		calls[1].Usage.Prompt.TokenCount != 200 || calls[1].Spec.Name != "fallback" {
+		t.Fatalf("got %+v", calls)
+	}
+	if total := RoundUsage(calls); total.Prompt.TokenCount != 300 || total.Candidates.TokenCount != 30 {
+		t.Fatalf("got %+v", total)
+	}
+
+	budget := new(Budget)
+	budget.addCalls(calls)
+	if got := budget.String(); got != "tokens 330, cost 0.0005" {
+		t.Fatalf("got %s", got)
+	}
+}
@@ -0,0 +1,392 @@
+package mcps
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// ProtocolVersion is the MCP protocol version requested at initialization.
+const ProtocolVersion = "2025-06-18"
+
+// closeGracePeriod is how long Close waits for a server to exit after its
+// stdin is closed before killing it.
+const closeGracePeriod = 2 * time.Second
+
+// stderrTailSize bounds the server stderr kept for error messages.
+const stderrTailSize = 4096
+
+// Client is a JSON-RPC connection to one MCP server process over stdio.
+// See TheoryOfMCPClient.
+type Client struct {
+	name  string
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]chan rpcResponse
+	err     error // set when the connection is gone
+
+	done   chan struct{}
+	stderr *tailBuffer
+}
+
+type rpcRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      *int64 `json:"id,omitempty"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+type rpcResponse struct {
+	result json.RawMessage
+	err    error
+}
+
+// RPCError is a JSON-RPC error returned by a server.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// StartClient launches the server and performs the initialization
+// handshake.
+func StartClient(ctx context.Context, name string, config ServerConfig) (*Client, error) {
+	if len(config.Command) == 0 {
+		return nil, fmt.Errorf("mcp server %s: empty command", name)
+	}
+	cmd := exec.Command(config.Command[0], config.Command[1:]...)
+	cmd.Dir = config.Dir
+	if len(config.Env) > 0 {
+		cmd.Env = os.Environ()
+		for k, v := range config.Env {
+			cmd.Env = append(cmd.Env, k+"="+v)
+		}
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr := &tailBuffer{}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("mcp server %s: %w", name, err)
+	}
+
+	c := &Client{
+		name:    name,
+		cmd:     cmd,
+		stdin:   stdin,
+		pending: make(map[int64]chan rpcResponse),
+		done:    make(chan struct{}),
+		stderr:  stderr,
+	}
+	go c.readLoop(stdout)
+
+	if err := c.initialize(ctx); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) initialize(ctx context.Context) error {
+	var result struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	if err := c.Call(ctx, "initialize", map[string]any{
+		"protocolVersion": ProtocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo": map[string]any{
+			"name":    "tai",
+			"version": "0",
+		},
+	}, &result); err != nil {
+		return fmt.Errorf("mcp server %s: initialize: %w", c.name, err)
+	}
+	return c.notify("notifications/initialized", nil)
+}
+
+// Call sends a request and decodes its result into result, which may be nil.
+func (c *Client) Call(ctx context.Context, method string, params any, result any) error {
+	ch := make(chan rpcResponse, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return err
+	}
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.write(rpcRequest{
+		JSONRPC: "2.0",
+		ID:      &id,
+		Method:  method,
+		Params:  params,
+	}); err != nil {
+		return err
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.err != nil {
+			return resp.err
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(resp.result, result)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Client) notify(method string, params any) error {
+	return c.write(rpcRequest{
+		JSONRPC: "2.0",
+		Method:  method,
+		Params:  params,
+	})
+}
+
+func (c *Client) write(msg any) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.stdin.Write(data); err != nil {
+		return fmt.Errorf("mcp server %s: %w", c.name, err)
+	}
+	return nil
+}
+
+func (c *Client) readLoop(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var msg rpcMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			// Servers may print non-protocol lines; skip them.
+			continue
+		}
+		if msg.Method != "" {
+			if len(msg.ID) > 0 {
+				c.answerServerRequest(msg)
+			}
+			// Notifications from the server are not used.
+			continue
+		}
+		var id int64
+		if err := json.Unmarshal(msg.ID, &id); err != nil {
+			continue
+		}
+		c.mu.Lock()
+		ch, ok := c.pending[id]
+		c.mu.Unlock()
+		if !ok {
+			continue
+		}
+		if msg.Error != nil {
+			deliver(ch, rpcResponse{err: msg.Error})
+		} else {
+			deliver(ch, rpcResponse{result: msg.Result})
+		}
+	}
+
+	err := scanner.Err()
+	waitErr := c.cmd.Wait()
+	if err == nil {
+		err = waitErr
+	}
+	if err == nil {
+		err = io.EOF
+	}
+	err = fmt.Errorf("mcp server %s exited: %w%s", c.name, err, c.stderr.suffix())
+	c.mu.Lock()
+	c.err = err
+	for _, ch := range c.pending {
+		deliver(ch, rpcResponse{err: err})
+	}
+	c.mu.Unlock()
+	close(c.done)
+}
+
+// deliver sends a response to a waiting call without blocking; a call
+// receives at most one response.
+func deliver(ch chan rpcResponse, resp rpcResponse) {
+	select {
+	case ch <- resp:
+	default:
+	}
+}
+
+// answerServerRequest answers requests sent by the server. Only ping is
+// supported; the client declares no capabilities.
+func (c *Client) answerServerRequest(msg rpcMessage) {
+	resp := map[string]any{
+		"jsonrpc": "2.0",
+		"id":      msg.ID,
+	}
+	if msg.Method == "ping" {
+		resp["result"] = map[string]any{}
+	} else {
+		resp["error"] = RPCError{
+			Code:    -32601,
+			Message: "method not found: " + msg.Method,
+		}
+	}
+	c.write(resp)
+}
+
+// Close closes the server's stdin and waits for it to exit, killing it
+// after a grace period.
+func (c *Client) Close() error {
+	c.stdin.Close()
+	select {
+	case <-c.done:
+		return nil
+	case <-time.After(closeGracePeriod):
+	}
+	if err := c.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+	<-c.done
+	return nil
+}
+
+// Tool is a tool listed by a server.
+type Tool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	InputSchema map[string]any `json:"inputSchema,omitempty"`
+}
+
+// ListTools returns all tools of the server, following pagination cursors.
+func (c *Client) ListTools(ctx context.Context) ([]Tool, error) {
+	var ret []Tool
+	cursor := ""
+	for {
+		var params any
+		if cursor != "" {
+			params = map[string]any{
+				"cursor": cursor,
+			}
+		}
+		var result struct {
+			Tools      []Tool `json:"tools"`
+			NextCursor string `json:"nextCursor,omitempty"`
+		}
+		if err := c.Call(ctx, "tools/list", params, &result); err != nil {
+			return nil, fmt.Errorf("mcp server %s: tools/list: %w", c.name, err)
+		}
+		ret = append(ret, result.Tools...)
+		if result.NextCursor == "" || result.NextCursor == cursor {
+			return ret, nil
+		}
+		cursor = result.NextCursor
+	}
+}
+
+// ToolResult is the result of a tools/call request.
+type ToolResult struct {
+	Content           []ToolContent `json:"content"`
+	StructuredContent any           `json:"structuredContent,omitempty"`
+	IsError           bool          `json:"isError,omitempty"`
+}
+
+// ToolContent is one content item of a tool result.
+type ToolContent struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	MimeType string `json:"mimeType,omitempty"`
+	Data     string `json:"data,omitempty"`
+	URI      string `json:"uri,omitempty"`
+	Resource *struct {
+		URI      string `json:"uri"`
+		MimeType string `json:"mimeType,omitempty"`
+		Text     string `json:"text,omitempty"`
+	} `json:"resource,omitempty"`
+}
+
+// CallTool invokes a tool.
+func (c *Client) CallTool(ctx context.Context, name string, arguments map[string]any) (*ToolResult, error) {
+	if arguments == nil {
+		arguments = map[string]any{}
+	}
+	var result ToolResult
+	if err := c.Call(ctx, "tools/call", map[string]any{
+		"name":      name,
+		"arguments": arguments,
+	}, &result); err != nil {
+		return nil, fmt.Errorf("mcp server %s: tools/call %s: %w", c.name, name, err)
+	}
+	return &result, nil
+}
+
+// tailBuffer keeps the last stderrTailSize bytes written to it.
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > stderrTailSize {
+		t.buf = t.buf[len(t.buf)-stderrTailSize:]
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) suffix() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	text := bytes.TrimSpace(t.buf)
+	if len(text) == 0 {
+		return ""
+	}
+	return "\nstderr:\n" + string(text)
+}
@@ -0,0 +1,215 @@
+package mcps
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/reusee/tai/generators"
+	"github.com/reusee/tai/logs"
+)
+
+// startTimeout bounds the start, initialization and tool listing of one
+// server.
+const startTimeout = 30 * time.Second
+
+// maxRecordedResultLength bounds the tool output kept in an mcp_call event.
+const maxRecordedResultLength = 4096
+
+// Connection holds the running servers of a command and the functions
+// exposing their tools. A nil Connection has no servers.
+// See TheoryOfMCPClient.
+type Connection struct {
+	clients   []*Client
+	functions []*generators.Function
+}
+
+// Connect starts the configured servers and lists their tools. The
+// returned Connection is nil when no server is configured. The context
+// bounds the tool calls made through the functions.
+type Connect func(ctx context.Context) (*Connection, error)
+
+func (Module) Connect(
+	servers Servers,
+	logger logs.Logger,
+	recorder generators.EventRecorder,
+) Connect {
+	return func(ctx context.Context) (*Connection, error) {
+		if len(servers) == 0 {
+			return nil, nil
+		}
+		record := func(typ, detail string) {
+			if recorder != nil && recorder.Enabled() {
+				recorder.Event(typ, detail)
+			}
+		}
+
+		names := make([]string, 0, len(servers))
+		for name := range servers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		conn := new(Connection)
+		for _, name := range names {
+			client, tools, err := startServer(ctx, name, servers[name])
+			if err != nil {
+				// An unavailable server does not block generation with the
+				// others. See TheoryOfMCPClient.
+				logger.Warn("mcp server unavailable", "server", name, "error", err)
+				record("mcp_error", err.Error())
+				continue
+			}
+			conn.clients = append(conn.clients, client)
+			for _, tool := range tools {
+				conn.functions = append(conn.functions, toolFunction(ctx, client, tool, record))
+			}
+			logger.Info("mcp server connected", "server", name, "tools", len(tools))
+		}
+		return conn, nil
+	}
+}
+
+func startServer(ctx context.Context, name string, config ServerConfig) (*Client, []Tool, error) {
+	ctx, cancel := context.WithTimeout(ctx, startTimeout)
+	defer cancel()
+	client, err := StartClient(ctx, name, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	tools, err := client.ListTools(ctx)
+	if err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+	return client, tools, nil
+}
+
+func toolFunction(ctx context.Context, client *Client, tool Tool, record func(typ, detail string)) *generators.Function {
+	return &generators.Function{
+		Decl: generators.FuncDecl{
+			Name:        functionName(client.name, tool.Name),
+			Description: tool.Description,
+			Params:      schemaToParams(tool.InputSchema),
+			Returns: generators.Vars{
+				{
+					Name: "content",
+					Type: generators.TypeString,
+				},
+				{
+					Name:     "structured",
+					Type:     generators.TypeObject,
+					Optional: true,
+				},
+				{
+					Name:     "error",
+					Type:     generators.TypeString,
+					Optional: true,
+				},
+			},
+		},
+		Func: func(args map[string]any) (ret map[string]any, err error) {
+			start := time.Now()
+			defer func() {
+				record("mcp_call", callDetail(client.name, tool.Name, args, time.Since(start), ret, err))
+			}()
+			result, err := client.CallTool(ctx, tool.Name, args)
+			if err != nil {
+				return nil, err
+			}
+			return toolResults(result), nil
+		},
+	}
+}
+
+// toolResults converts a tool result to function results. See
+// TheoryOfMCPClient.
+func toolResults(result *ToolResult) map[string]any {
+	var texts []string
+	for _, content := range result.Content {
+		switch content.Type {
+		case "text":
+			texts = append(texts, content.Text)
+		case "resource":
+			if content.Resource != nil {
+				if content.Resource.Text != "" {
+					texts = append(texts, content.Resource.Text)
+				} else {
+					texts = append(texts, fmt.Sprintf("[resource] %s", content.Resource.URI))
+				}
+			}
+		case "resource_link":
+			texts = append(texts, fmt.Sprintf("[resource] %s", content.URI))
+		default:
+			texts = append(texts, fmt.Sprintf("[%s content omitted: %s]", content.Type, content.MimeType))
+		}
+	}
+	text := strings.Join(texts, "\n")
+	if result.IsError {
+		if text == "" {
+			text = "tool reported an error"
+		}
+		return map[string]any{
+			"error": text,
+		}
+	}
+	ret := map[string]any{
+		"content": text,
+	}
+	if result.StructuredContent != nil {
+		ret["structured"] = result.StructuredContent
+	}
+	return ret
+}
+
+func callDetail(server, tool string, args map[string]any, duration time.Duration, ret map[string]any, err error) string {
+	argsJSON, _ := json.Marshal(args)
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "server=%s tool=%s duration=%s arguments=%s", server, tool, duration.Round(time.Millisecond), argsJSON)
+	if err != nil {
+		fmt.Fprintf(b, "\n[error] %v", err)
+		return b.String()
+	}
+	retJSON, _ := json.Marshal(ret)
+	result := string(retJSON)
+	if len(result) > maxRecordedResultLength {
+		result = result[:maxRecordedResultLength] + "..."
+	}
+	fmt.Fprintf(b, "\n%s", result)
+	return b.String()
+}
+
+// Functions returns the functions exposing the tools of the connected
+// servers.
+func (c *Connection) Functions() []*generators.Function {
+	if c == nil {
+		return nil
+	}
+	return slices.Clone(c.functions)
+}
+
+// Decls returns the declarations of Functions.
+func (c *Connection) Decls() []generators.FuncDecl {
+	var ret []generators.FuncDecl
+	for _, fn := range c.Functions() {
+		ret = append(ret, fn.Decl)
+	}
+	return ret
+}
+
+// Close stops the servers.
+func (c *Connection) Close() error {
+	if c == nil {
+		return nil
+	}
+	var errs []error
+	for _, client := range c.clients {
+		errs = append(errs, client.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,149 @@
+package mcps
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// fakeServerEnv makes the test binary run as a fake MCP stdio server
+// instead of running tests.
+const fakeServerEnv = "TAI_MCP_FAKE_SERVER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeServerEnv) != "" {
+		runFakeServer()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// fakeServerConfig returns the config launching the fake server.
+func fakeServerConfig(t *testing.T) ServerConfig {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ServerConfig{
+		Command: []string{exe},
+		Env: map[string]string{
+			fakeServerEnv: "1",
+		},
+	}
+}
+
+func runFakeServer() {
+	// Servers may log to stdout before speaking the protocol.
+	fmt.Println("fake server starting")
+	encoder := json.NewEncoder(os.Stdout)
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+			Params struct {
+				Cursor    string         `json:"cursor"`
+				Name      string         `json:"name"`
+				Arguments map[string]any `json:"arguments"`
+			} `json:"params"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			continue
+		}
+		if req.ID == nil {
+			continue
+		}
+		var result any
+		switch req.Method {
+		case "initialize":
+			result = map[string]any{
+				"protocolVersion": ProtocolVersion,
+				"capabilities": map[string]any{
+					"tools": map[string]any{},
+				},
+				"serverInfo": map[string]any{
+					"name": "fake",
+				},
+			}
+		case "tools/list":
+			// two pages
+			if req.Params.Cursor == "" {
+				result = map[string]any{
+					"tools": []any{
+						map[string]any{
+							"name":        "echo",
+							"description": "Echo the text.",
+							"inputSchema": map[string]any{
+								"type": "object",
+								"properties": map[string]any{
+									"text": map[string]any{
+										"type": "string",
+									},
+									"times": map[string]any{
+										"type": []any{"integer", "null"},
+									},
+								},
+								"required": []any{"text"},
+							},
+						},
+					},
+					"nextCursor": "page2",
+				}
+			} else {
+				result = map[string]any{
+					"tools": []any{
+						map[string]any{
+							"name": "fail",
+							"inputSchema": map[string]any{
+								"type": "object",
+							},
+						},
+					},
+				}
+			}
+		case "tools/call":
+			switch req.Params.Name {
+			case "echo":
+				result = map[string]any{
+					"content": []any{
+						map[string]any{
+							"type": "text",
+							"text": fmt.Sprint(req.Params.Arguments["text"]),
+						},
+					},
+					"structuredContent": map[string]any{
+						"length": len(fmt.Sprint(req.Params.Arguments["text"])),
+					},
+				}
+			case "fail":
+				result = map[string]any{
+					"content": []any{
+						map[string]any{
+							"type": "text",
+							"text": "no such issue",
+						},
+					},
+					"isError": true,
+				}
+			}
+		}
+		if result == nil {
+			encoder.Encode(map[string]any{
+				"jsonrpc": "2.0",
+				"id":      req.ID,
+				"error": map[string]any{
+					"code":    -32601,
+					"message": "unknown method",
+				},
+			})
+			continue
+		}
+		encoder.Encode(map[string]any{
+			"jsonrpc": "2.0",
+			"id":      req.ID,
+			"result":  result,
+		})
+	}
+}
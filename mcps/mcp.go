@@ -0,0 +1,87 @@
+package mcps
+
+import (
+	"maps"
+
+	"cuelang.org/go/cue"
+	"github.com/reusee/tai/configs"
+)
+
+const TheoryOfMCPClient = `
+Teams keep tools behind Model Context Protocol servers (issue trackers,
+schema registries), and a model can only use what the generator declares.
+The mcp_servers config path names the servers to launch: each entry gives
+the command line, and optionally extra environment variables and a working
+directory. Config files merge by server name, later files overriding
+earlier ones.
+
+Connect starts every configured server as a child process and speaks
+JSON-RPC 2.0 to it over stdin and stdout, one message per line, as the MCP
+stdio transport specifies: an initialize request, the initialized
+notification, then tools/list until no cursor remains. Each listed tool
+becomes a generators.Function. The tool's JSON schema is converted to
+generators.Vars (see schemaToVar); what Vars cannot express, such as enums
+and unions, is folded into descriptions or narrowed to the first
+non-null alternative, since the provider reading the declaration is the
+only consumer. Function names are "<server>__<tool>", reduced to the
+characters every provider accepts, so tools of different servers cannot
+collide.
+
+The functions are registered with generators.WithFunctions, so they are
+declared to the model like configured functions and executed by the
+generate phase, which sends each result back as a CallResult within the
+round (see phases.TheoryOfToolCalls). A call sends tools/call: text
+content becomes the "content" result, structured content the
+"structured" result, and a tool reporting isError becomes the "error"
+result. A transport failure is returned as the function's error, which
+the generate phase also sends back to the model. Every invocation is
+recorded as an "mcp_call" event through the generators-level
+EventRecorder, with its arguments, duration and outcome, so sessions in
+the records database show what the tools did.
+
+A server that fails to start or to list its tools is logged, recorded as
+an "mcp_error" event and skipped: one unavailable server does not block
+generation with the others. Servers are only started for generators that
+accept tools (see generators.Spec DisableTools), and the Connection is
+closed when the command's generation ends, which closes the servers'
+stdin and kills any server still running after a grace period.
+`
+
+// ServerConfig configures one MCP server. See TheoryOfMCPClient.
+type ServerConfig struct {
+	// Command is the program and its arguments.
+	Command []string `json:"command"`
+	// Env holds extra environment variables for the server process.
+	Env map[string]string `json:"env,omitempty"`
+	// Dir is the working directory of the server process. Empty means the
+	// current directory.
+	Dir string `json:"dir,omitempty"`
+}
+
+// Servers maps server names to their configurations.
+type Servers map[string]ServerConfig
+
+func (Module) Servers() Servers {
+	return nil
+}
+
+var _ configs.Config = Servers{}
+
+func (s Servers) ConfigPaths() []string {
+	return []string{"mcp_servers"}
+}
+
+func (s Servers) HandleConfig(path string, values []*cue.Value) (any, error) {
+	ret := maps.Clone(s)
+	for _, value := range values {
+		var servers Servers
+		if err := value.Decode(&servers); err != nil {
+			return nil, err
+		}
+		if ret == nil {
+			ret = make(Servers)
+		}
+		maps.Copy(ret, servers)
+	}
+	return &ret, nil
+}
@@ -0,0 +1,144 @@
+package mcps
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/reusee/dscope"
+	"github.com/reusee/tai/generators"
+	"github.com/reusee/tai/modes"
+)
+
+type testRecorder struct {
+	events *[]string
+}
+
+func (r testRecorder) Enabled() bool {
+	return true
+}
+
+func (r testRecorder) Event(typ string, detail string) {
+	*r.events = append(*r.events, typ+": "+detail)
+}
+
+func TestConnect(t *testing.T) {
+	var events []string
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+	).Fork(
+		func() Servers {
+			return Servers{
+				"tracker": fakeServerConfig(t),
+				"broken": {
+					Command: []string{"/nonexistent/mcp-server"},
+				},
+			}
+		},
+		func() generators.EventRecorder {
+			return testRecorder{events: &events}
+		},
+	).Call(func(connect Connect) {
+		conn, err := connect(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := conn.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}()
+
+		fns := conn.Functions()
+		if len(fns) != 2 {
+			t.Fatalf("expected the tools of both pages, got %d", len(fns))
+		}
+		echo, fail := fns[0], fns[1]
+		if echo.Decl.Name != "tracker__echo" || fail.Decl.Name != "tracker__fail" {
+			t.Fatalf("got %s %s", echo.Decl.Name, fail.Decl.Name)
+		}
+		params := echo.Decl.Params
+		if len(params) != 2 ||
+			params[0].Name != "text" || params[0].Type != generators.TypeString || params[0].Optional ||
+			params[1].Name != "times" || params[1].Type != generators.TypeInteger || !params[1].Optional {
+			t.Fatalf("got %+v", params)
+		}
+
+		ret, err := echo.Func(map[string]any{"text": "hello"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ret["content"] != "hello" {
+			t.Fatalf("got %+v", ret)
+		}
+		if structured, ok := ret["structured"].(map[string]any); !ok || structured["length"] != float64(5) {
+			t.Fatalf("got %+v", ret)
+		}
+
+		ret, err = fail.Func(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ret["error"] != "no such issue" {
+			t.Fatalf("tool errors must become the error result, got %+v", ret)
+		}
+
+		var calls, errs int
+		for _, event := range events {
+			switch {
+			case strings.HasPrefix(event, "mcp_call: server=tracker tool=echo"):
+				if !strings.Contains(event, `arguments={"text":"hello"}`) {
+					t.Fatalf("got %q", event)
+				}
+				calls++
+			case strings.HasPrefix(event, "mcp_call: "):
+				calls++
+			case strings.HasPrefix(event, "mcp_error: "):
+				errs++
+			}
+		}
+		if calls != 2 || errs != 1 {
+			t.Fatalf("got %q", events)
+		}
+	})
+}
+
+func TestSchemaToVar(t *testing.T) {
+	v := schemaToVar("filter", map[string]any{
+		"description": "Issue filter.",
+		"anyOf": []any{
+			map[string]any{"type": "null"},
+			map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"state": map[string]any{
+						"type": "string",
+						"enum": []any{"open", "closed"},
+					},
+					"labels": map[string]any{
+						"items": map[string]any{"type": "string"},
+					},
+				},
+				"required": []any{"state"},
+			},
+		},
+	})
+	if v.Type != generators.TypeObject || !v.Optional || v.Description != "Issue filter." {
+		t.Fatalf("got %+v", v)
+	}
+	if len(v.Properties) != 2 {
+		t.Fatalf("got %+v", v.Properties)
+	}
+	labels, state := v.Properties[0], v.Properties[1]
+	if labels.Type != generators.TypeArray || labels.ItemType.Type != generators.TypeString || !labels.Optional {
+		t.Fatalf("got %+v", labels)
+	}
+	if state.Optional || state.Description != "One of: open, closed." {
+		t.Fatalf("got %+v", state)
+	}
+
+	if got := functionName("issue tracker", "get.issue"); got != "issue_tracker__get_issue" {
+		t.Fatalf("got %q", got)
+	}
+}
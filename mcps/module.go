@@ -0,0 +1,15 @@
+package mcps
+
+import (
+	"github.com/reusee/dscope"
+	"github.com/reusee/tai/generators"
+	"github.com/reusee/tai/logs"
+)
+
+// Module is the dscope module for the mcps package.
+// See TheoryOfMCPClient.
+type Module struct {
+	dscope.Module
+	Generators generators.Module
+	Logs       logs.Module
+}
@@ -0,0 +1,172 @@
+package mcps
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/reusee/tai/generators"
+)
+
+// schemaToParams converts the properties of a JSON object schema, such as
+// a tool's input schema, to Vars. Properties are sorted by name to keep
+// declarations deterministic (see generators.TheoryOfPrefixCaching). See
+// TheoryOfMCPClient.
+func schemaToParams(schema map[string]any) generators.Vars {
+	props, _ := schema["properties"].(map[string]any)
+	required := make(map[string]bool)
+	if list, ok := schema["required"].([]any); ok {
+		for _, name := range list {
+			if name, ok := name.(string); ok {
+				required[name] = true
+			}
+		}
+	}
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var ret generators.Vars
+	for _, name := range names {
+		prop, _ := props[name].(map[string]any)
+		v := schemaToVar(name, prop)
+		v.Optional = v.Optional || !required[name]
+		ret = append(ret, v)
+	}
+	return ret
+}
+
+// schemaToVar converts one JSON schema to a Var. Unions (anyOf, oneOf and
+// type lists) are narrowed to their first non-null alternative, a null
+// alternative making the Var optional; enums and defaults are folded into
+// the description.
+func schemaToVar(name string, schema map[string]any) generators.Var {
+	v := generators.Var{
+		Name: name,
+	}
+	if schema == nil {
+		v.Type = generators.TypeString
+		return v
+	}
+	description, _ := schema["description"].(string)
+	if description == "" {
+		description, _ = schema["title"].(string)
+	}
+
+	for _, key := range []string{"anyOf", "oneOf"} {
+		alternatives, ok := schema[key].([]any)
+		if !ok {
+			continue
+		}
+		for _, alt := range alternatives {
+			alt, ok := alt.(map[string]any)
+			if !ok {
+				continue
+			}
+			if alt["type"] == "null" {
+				v.Optional = true
+				continue
+			}
+			if v.Type == generators.TypeNone {
+				inner := schemaToVar(name, alt)
+				v.Type = inner.Type
+				v.ItemType = inner.ItemType
+				v.Properties = inner.Properties
+				if description == "" {
+					description = inner.Description
+				}
+			}
+		}
+	}
+
+	if v.Type == generators.TypeNone {
+		switch typ := schema["type"].(type) {
+		case string:
+			v.Type = schemaType(typ)
+		case []any:
+			for _, t := range typ {
+				t, _ := t.(string)
+				if t == "null" {
+					v.Optional = true
+					continue
+				}
+				if v.Type == generators.TypeNone {
+					v.Type = schemaType(t)
+				}
+			}
+		}
+	}
+	if v.Type == generators.TypeNone {
+		switch {
+		case schema["properties"] != nil:
+			v.Type = generators.TypeObject
+		case schema["items"] != nil:
+			v.Type = generators.TypeArray
+		default:
+			v.Type = generators.TypeString
+		}
+	}
+
+	switch v.Type {
+	case generators.TypeArray:
+		items, _ := schema["items"].(map[string]any)
+		item := schemaToVar("item", items)
+		v.ItemType = &item
+	case generators.TypeObject:
+		if v.Properties == nil {
+			v.Properties = schemaToParams(schema)
+		}
+	}
+
+	if values, ok := schema["enum"].([]any); ok && len(values) > 0 {
+		strs := make([]string, 0, len(values))
+		for _, value := range values {
+			strs = append(strs, fmt.Sprint(value))
+		}
+		description = joinDescription(description, "One of: "+strings.Join(strs, ", ")+".")
+	}
+	if value, ok := schema["default"]; ok {
+		description = joinDescription(description, fmt.Sprintf("Default: %v.", value))
+	}
+	v.Description = description
+	return v
+}
+
+func schemaType(typ string) generators.Type {
+	switch typ {
+	case "string":
+		return generators.TypeString
+	case "number":
+		return generators.TypeNumber
+	case "integer":
+		return generators.TypeInteger
+	case "boolean":
+		return generators.TypeBoolean
+	case "array":
+		return generators.TypeArray
+	case "object":
+		return generators.TypeObject
+	}
+	return generators.TypeNone
+}
+
+func joinDescription(description, note string) string {
+	if description == "" {
+		return note
+	}
+	return strings.TrimRight(description, " ") + " " + note
+}
+
+// functionName returns the function name of a server's tool: the names
+// joined by "__", restricted to the characters and length every provider
+// accepts. See TheoryOfMCPClient.
+func functionName(server, tool string) string {
+	name := []rune(server + "__" + tool)
+	for i, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			name[i] = '_'
+		}
+	}
+	return string(name[:min(len(name), 64)])
+}
@@ -0,0 +1,274 @@
+package mcps
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/reusee/tai/generators"
+)
+
+const TheoryOfMCPServer = `
+The server side of the protocol lets editors and other agents call tai's
+tools without its model loop. Server serves generators.Functions as MCP
+tools over stdio, the same representation the client produces from server
+tools (see TheoryOfMCPClient), so a tool is written once as a Function and
+can be declared to a model or served to an MCP client alike.
+
+The tool schema is the JSON schema form of the declaration's Params
+(varsToSchema, the inverse of schemaToParams). A tools/call runs the
+Function with the call's arguments. The results map to the MCP result by a
+fixed convention: the "output" result is the text content, an "error"
+result marks the result isError and is appended to the text, and the whole
+result map is the structured content. A Function error is reported the
+same way, as a tool error rather than a protocol error, so the calling
+model sees it.
+
+Requests are handled one at a time in arrival order: tools share one
+working tree, and a preview followed by a write must observe each other.
+Serve returns when the input ends.
+`
+
+// Server serves functions as MCP tools. See TheoryOfMCPServer.
+type Server struct {
+	name      string
+	functions []*generators.Function
+
+	writeMu sync.Mutex
+}
+
+// NewServer returns a server named name serving functions.
+func NewServer(name string, functions ...*generators.Function) *Server {
+	return &Server{
+		name:      name,
+		functions: functions,
+	}
+}
+
+// Serve reads requests from r and writes responses to w until r ends.
+func (s *Server) Serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		if err := json.Unmarshal(line, &req); err != nil {
+			if err := s.write(w, map[string]any{
+				"jsonrpc": "2.0",
+				"id":      nil,
+				"error": RPCError{
+					Code:    -32700,
+					Message: "parse error: " + err.Error(),
+				},
+			}); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(req.ID) == 0 {
+			// notifications need no response
+			continue
+		}
+		result, rpcErr := s.handle(req.Method, req.Params)
+		resp := map[string]any{
+			"jsonrpc": "2.0",
+			"id":      req.ID,
+		}
+		if rpcErr != nil {
+			resp["error"] = rpcErr
+		} else {
+			resp["result"] = result
+		}
+		if err := s.write(w, resp); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (s *Server) write(w io.Writer, msg any) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_, err = w.Write(data)
+	return err
+}
+
+func (s *Server) handle(method string, params json.RawMessage) (any, *RPCError) {
+	switch method {
+
+	case "initialize":
+		var p struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		json.Unmarshal(params, &p)
+		version := p.ProtocolVersion
+		if version == "" {
+			version = ProtocolVersion
+		}
+		return map[string]any{
+			"protocolVersion": version,
+			"capabilities": map[string]any{
+				"tools": map[string]any{},
+			},
+			"serverInfo": map[string]any{
+				"name":    s.name,
+				"version": "0",
+			},
+		}, nil
+
+	case "ping":
+		return map[string]any{}, nil
+
+	case "tools/list":
+		tools := make([]any, 0, len(s.functions))
+		for _, fn := range s.functions {
+			tools = append(tools, map[string]any{
+				"name":        fn.Decl.Name,
+				"description": fn.Decl.Description,
+				"inputSchema": varsToSchema(fn.Decl.Params),
+			})
+		}
+		return map[string]any{
+			"tools": tools,
+		}, nil
+
+	case "tools/call":
+		var p struct {
+			Name      string         `json:"name"`
+			Arguments map[string]any `json:"arguments"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &RPCError{
+				Code:    -32602,
+				Message: err.Error(),
+			}
+		}
+		idx := slices.IndexFunc(s.functions, func(fn *generators.Function) bool {
+			return fn.Decl.Name == p.Name
+		})
+		if idx < 0 {
+			return nil, &RPCError{
+				Code:    -32602,
+				Message: "unknown tool: " + p.Name,
+			}
+		}
+		if p.Arguments == nil {
+			p.Arguments = map[string]any{}
+		}
+		results, err := s.functions[idx].Func(p.Arguments)
+		if err != nil {
+			results = map[string]any{
+				"error": err.Error(),
+			}
+		}
+		return callResult(results), nil
+
+	}
+
+	return nil, &RPCError{
+		Code:    -32601,
+		Message: "method not found: " + method,
+	}
+}
+
+// callResult converts function results to a tools/call result. See
+// TheoryOfMCPServer.
+func callResult(results map[string]any) map[string]any {
+	var texts []string
+	if output, ok := results["output"]; ok && output != "" {
+		texts = append(texts, fmt.Sprint(output))
+	}
+	errValue, isError := results["error"]
+	if isError {
+		texts = append(texts, fmt.Sprintf("error: %v", errValue))
+	}
+	content := make([]any, 0, len(texts))
+	for _, text := range texts {
+		content = append(content, map[string]any{
+			"type": "text",
+			"text": text,
+		})
+	}
+	ret := map[string]any{
+		"content":           content,
+		"structuredContent": results,
+	}
+	if isError {
+		ret["isError"] = true
+	}
+	return ret
+}
+
+// varsToSchema converts Vars to the JSON schema of an object with the
+// Vars as properties.
+func varsToSchema(vars generators.Vars) map[string]any {
+	props := make(map[string]any, len(vars))
+	required := []string{}
+	for _, v := range vars {
+		props[v.Name] = varToSchema(v)
+		if !v.Optional {
+			required = append(required, v.Name)
+		}
+	}
+	slices.Sort(required)
+	return map[string]any{
+		"type":       "object",
+		"properties": props,
+		"required":   required,
+	}
+}
+
+func varToSchema(v generators.Var) map[string]any {
+	var ret map[string]any
+	switch v.Type {
+	case generators.TypeArray:
+		ret = map[string]any{
+			"type": "array",
+		}
+		if v.ItemType != nil {
+			ret["items"] = varToSchema(*v.ItemType)
+		}
+	case generators.TypeObject:
+		ret = varsToSchema(v.Properties)
+	case generators.TypeNumber:
+		ret = map[string]any{
+			"type": "number",
+		}
+	case generators.TypeInteger:
+		ret = map[string]any{
+			"type": "integer",
+		}
+	case generators.TypeBoolean:
+		ret = map[string]any{
+			"type": "boolean",
+		}
+	default:
+		ret = map[string]any{
+			"type": "string",
+		}
+	}
+	if v.Description != "" {
+		ret["description"] = v.Description
+	}
+	return ret
+}
@@ -0,0 +1,98 @@
+package mcps
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/reusee/tai/generators"
+)
+
+func TestServer(t *testing.T) {
+	server := NewServer("test",
+		&generators.Function{
+			Decl: generators.FuncDecl{
+				Name:        "greet",
+				Description: "Greet someone.",
+				Params: generators.Vars{
+					{Name: "name", Type: generators.TypeString},
+					{Name: "loud", Type: generators.TypeBoolean, Optional: true},
+				},
+			},
+			Func: func(args map[string]any) (map[string]any, error) {
+				if args["name"] == "" {
+					return nil, errors.New("empty name")
+				}
+				return map[string]any{
+					"output": "hello " + args["name"].(string),
+				}, nil
+			},
+		},
+	)
+
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26"}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"greet","arguments":{"name":"tai"}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"greet","arguments":{"name":""}}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"missing"}}`,
+	}, "\n")
+	output := new(strings.Builder)
+	if err := server.Serve(context.Background(), strings.NewReader(input), output); err != nil {
+		t.Fatal(err)
+	}
+
+	var responses []map[string]any
+	scanner := bufio.NewScanner(strings.NewReader(output.String()))
+	for scanner.Scan() {
+		var resp map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		responses = append(responses, resp)
+	}
+	if len(responses) != 5 {
+		t.Fatalf("notifications must not be answered, got %d responses", len(responses))
+	}
+	result := func(i int) map[string]any {
+		ret, _ := responses[i]["result"].(map[string]any)
+		return ret
+	}
+
+	if result(0)["protocolVersion"] != "2025-03-26" {
+		t.Fatalf("got %v", responses[0])
+	}
+
+	tools := result(1)["tools"].([]any)
+	schema := tools[0].(map[string]any)["inputSchema"].(map[string]any)
+	if !reflect.DeepEqual(schema["required"], []any{"name"}) {
+		t.Fatalf("got %v", schema)
+	}
+	// the schema converts back to the declared params
+	var decoded map[string]any
+	data, _ := json.Marshal(schema)
+	json.Unmarshal(data, &decoded)
+	params := schemaToParams(decoded)
+	if len(params) != 2 || params[0].Name != "loud" || !params[0].Optional ||
+		params[1].Name != "name" || params[1].Type != generators.TypeString || params[1].Optional {
+		t.Fatalf("got %+v", params)
+	}
+
+	content := result(2)["content"].([]any)
+	if content[0].(map[string]any)["text"] != "hello tai" || result(2)["isError"] != nil {
+		t.Fatalf("got %v", responses[2])
+	}
+
+	if result(3)["isError"] != true {
+		t.Fatalf("function errors must be tool errors, got %v", responses[3])
+	}
+
+	if responses[4]["error"] == nil {
+		t.Fatalf("unknown tools must be protocol errors, got %v", responses[4])
+	}
+}
@@ -36,6 +36,12 @@ them. Unclosed blocks are also skipped during scanning. The pseudo-call
 fallback extracts textual update_user_profile(...) calls when the model fails
 to use the memory block format.
 
+The items are parsed from the output the model already produced, not
+requested with generators.GenerateJSON: the memory block shares the
+response with prose and other blocks, so no response schema can describe
+it, and a separate structured call would spend a generation per round to
+restate items the parser reads deterministically.
+
 Memory updates are merged additively: new items are appended to the existing
 item list, and a deduplication step prevents the same item from being recorded
 twice. The merge never prunes items.
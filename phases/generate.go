@@ -22,6 +22,30 @@ phase returns the input state so that callers like loops.Run can pass a valid
 state to OnPhaseError.
 `
 
+const TheoryOfToolCalls = `
+A generator only reports the functions the model calls; something must run
+them and send the results back before the model can continue. When the
+State declares functions with implementations (generators.WithFunctions),
+the generate phase does so: after each Generate it collects the FuncCall
+parts of the newly appended contents that name such a function and were
+not already handled by a FuncMap layer, runs them in order, appends their
+CallResult parts as one RoleTool content, and calls Generate again on the
+extended State. The loop ends when a response makes no such call, so the
+whole exchange is one round and its output is parsed like any other.
+
+A function error is sent back as the call's result ({"error": ...}) instead
+of failing the round, so the model can correct the call. Calls to declared
+functions without an implementation are left alone, as before. The loop is
+bounded by maxToolCallRounds; a model still calling tools after that ends
+the phase with the pending calls unanswered, which the loop treats like
+any round without a completion signal. Each Generate in the loop keeps the
+retry semantics of TheoryOfGenerateRetry.
+`
+
+// maxToolCallRounds bounds the Generate calls made to answer function
+// calls within one phase. See TheoryOfToolCalls.
+const maxToolCallRounds = 32
+
 type BuildGenerate func(generator generators.Generator, options *generators.GenerateOptions) PhaseBuilder
 
 func (Module) BuildGenerate() BuildGenerate {
@@ -31,42 +55,116 @@ func (Module) BuildGenerate() BuildGenerate {
 
 				state0 := state
 
-				const maxRetries = 3
-				var lastErr error
-				for range maxRetries {
-					newState, err := generator.Generate(ctx, state, options)
-					if err != nil {
-						lastErr = err
-						if errors.Is(err, generators.ErrRetryable) {
-							continue
-						}
-						// If the generator produced partial output before the
-						// error, return that state so the caller (loops.Run)
-						// can detect the content increase and trigger a retry
-						// with summarization. See TheoryOfGenerateRetry.
-						if newState != nil && generators.CountContents(newState) > generators.CountContents(state) {
-							return nil, newState, err
+				generate := func(state generators.State) (generators.State, error) {
+					const maxRetries = 3
+					var lastErr error
+					for range maxRetries {
+						newState, err := generator.Generate(ctx, state, options)
+						if err != nil {
+							lastErr = err
+							if errors.Is(err, generators.ErrRetryable) {
+								continue
+							}
+							// If the generator produced partial output before the
+							// error, return that state so the caller (loops.Run)
+							// can detect the content increase and trigger a retry
+							// with summarization. See TheoryOfGenerateRetry.
+							if newState != nil && generators.CountContents(newState) > generators.CountContents(state) {
+								return newState, err
+							}
+							// Return the input state (not nil) so callers like
+							// loops.Run can pass a valid state to OnPhaseError.
+							return state, err
 						}
-						// Return the input state (not nil) so callers like
-						// loops.Run can pass a valid state to OnPhaseError.
+						return newState, nil
+					}
+
+					// All retries exhausted. Use %v (not %w) to convert lastErr
+					// to a string, stripping ErrRetryable from the error chain
+					// so callers do not re-trigger retries. Return the input
+					// state (not nil) so callers like loops.Run can pass a
+					// valid state to OnPhaseError.
+					return state, fmt.Errorf("generate failed after %d retries: %v", maxRetries, lastErr)
+				}
+
+				numContents := generators.CountContents(state)
+				state, err := generate(state)
+				if err != nil {
+					return nil, state, err
+				}
+
+				// Answer function calls. See TheoryOfToolCalls.
+				for range maxToolCallRounds {
+					results := callFunctions(state, numContents)
+					if len(results) == 0 {
+						break
+					}
+					state, err = state.AppendContent(&generators.Content{
+						Role:  generators.RoleTool,
+						Parts: results,
+					})
+					if err != nil {
+						return nil, state, err
+					}
+					numContents = generators.CountContents(state)
+					state, err = generate(state)
+					if err != nil {
 						return nil, state, err
 					}
-					state = newState
-					return cont, RedoCheckpoint{
-						upstream:  state,
-						state0:    state0,
-						generator: generator,
-					}, nil
 				}
 
-				// All retries exhausted. Use %v (not %w) to convert lastErr
-				// to a string, stripping ErrRetryable from the error chain
-				// so callers do not re-trigger retries. Return the input
-				// state (not nil) so callers like loops.Run can pass a
-				// valid state to OnPhaseError.
-				return nil, state, fmt.Errorf("generate failed after %d retries: %v", maxRetries, lastErr)
+				return cont, RedoCheckpoint{
+					upstream:  state,
+					state0:    state0,
+					generator: generator,
+				}, nil
+
+			}
+		}
+	}
+}
 
+// callFunctions runs the functions called in the contents of state after
+// the first skip contents and returns their results. See
+// TheoryOfToolCalls.
+func callFunctions(state generators.State, skip int) []generators.Part {
+	funcs := make(map[string]*generators.Function)
+	for fn := range state.Functions() {
+		if fn != nil && fn.Func != nil {
+			funcs[fn.Decl.Name] = fn
+		}
+	}
+	if len(funcs) == 0 {
+		return nil
+	}
+	var results []generators.Part
+	i := 0
+	for content := range state.Contents() {
+		i++
+		if i <= skip {
+			continue
+		}
+		for _, part := range content.Parts {
+			call, ok := part.(generators.FuncCall)
+			if !ok || call.Handled {
+				continue
+			}
+			fn, ok := funcs[call.Name]
+			if !ok {
+				continue
+			}
+			res, err := fn.Func(call.Arguments)
+			if err != nil {
+				res = map[string]any{
+					"error": err.Error(),
+				}
 			}
+			results = append(results, generators.CallResult{
+				ID:      call.ID,
+				Name:    call.Name,
+				Results: res,
+			})
 		}
 	}
+	return results
 }
@@ -178,3 +178,89 @@ func TestBuildGenerateNonRetryableErrorPreservesPartialOutput(t *testing.T) {
 		}
 	})
 }
+
+type toolCallingGenerator struct {
+	calls *int
+}
+
+func (g *toolCallingGenerator) Spec() generators.Spec           { return generators.Spec{} }
+func (g *toolCallingGenerator) CountTokens(string) (int, error) { return 0, nil }
+func (g *toolCallingGenerator) Generate(ctx context.Context, state generators.State, options *generators.GenerateOptions) (generators.State, error) {
+	*g.calls++
+	var parts []generators.Part
+	switch *g.calls {
+	case 1:
+		parts = []generators.Part{
+			generators.FuncCall{ID: "1", Name: "echo", Arguments: map[string]any{"v": "x"}},
+			generators.FuncCall{ID: "2", Name: "fail"},
+			generators.FuncCall{ID: "3", Name: "undefined"},
+		}
+	default:
+		parts = []generators.Part{generators.Text("done")}
+	}
+	return state.AppendContent(&generators.Content{
+		Role:  generators.RoleModel,
+		Parts: parts,
+	})
+}
+
+func TestBuildGenerateToolCalls(t *testing.T) {
+	calls := 0
+	gen := &toolCallingGenerator{calls: &calls}
+
+	dscope.New(
+		new(Module),
+		new(debugs.Module),
+	).Call(func(
+		buildGenerate BuildGenerate,
+	) {
+		phase := buildGenerate(gen, nil)(nil)
+
+		state := generators.WithFunctions(
+			generators.NewPrompts("", nil),
+			&generators.Function{
+				Decl: generators.FuncDecl{Name: "echo"},
+				Func: func(args map[string]any) (map[string]any, error) {
+					return map[string]any{"v": args["v"]}, nil
+				},
+			},
+			&generators.Function{
+				Decl: generators.FuncDecl{Name: "fail"},
+				Func: func(args map[string]any) (map[string]any, error) {
+					return nil, errors.New("failed")
+				},
+			},
+		)
+
+		_, state, err := phase(context.Background(), state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if calls != 2 {
+			t.Fatalf("expected a second generate after the tool results, got %d calls", calls)
+		}
+
+		var results []generators.CallResult
+		var roles []generators.Role
+		for content := range state.Contents() {
+			roles = append(roles, content.Role)
+			for _, part := range content.Parts {
+				if result, ok := part.(generators.CallResult); ok {
+					results = append(results, result)
+				}
+			}
+		}
+		if len(roles) != 3 || roles[1] != generators.RoleTool {
+			t.Fatalf("got roles %v", roles)
+		}
+		if len(results) != 2 {
+			t.Fatalf("calls without an implementation must be left alone, got %+v", results)
+		}
+		if results[0].ID != "1" || results[0].Results["v"] != "x" {
+			t.Fatalf("got %+v", results[0])
+		}
+		if results[1].ID != "2" || results[1].Results["error"] != "failed" {
+			t.Fatalf("function errors must be sent back, got %+v", results[1])
+		}
+	})
+}
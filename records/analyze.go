@@ -1,42 +1,76 @@
 package records
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
+	"sync"
 
 	"github.com/reusee/tai/generators"
-	"github.com/reusee/tai/phases"
 )
 
 const analysisSystemPrompt = `你是一个AI工具交互分析器。tai是一个AI辅助编码工具，其工作方式为：模型输出带有边界分隔符的结构化块——change块用于修改代码文件，shell块用于执行shell命令，go-test块用于运行Go测试，continue块用于触发下一轮生成，summary块用于标记当前轮次正常结束，request-context块用于请求更多上下文。一次生成过程按"轮次"组织：每轮模型输出内容，系统解析其中的块并处理（应用代码修改、执行命令、运行测试），随后根据continue块和组件结果决定是否进入下一轮。整个过程可能发生重试（输出截断、解析错误、应用失败）。
 
 下面是一次完整的交互记录，包含会话元信息、每轮的时间戳、用户输入、模型输出、思考过程、生成的块、错误与重试，以及过程中的事件流：接口调用与接口错误（api_call、api_error）、重试决策、解析错误修正、组件触发新轮次等流程事件（decision）。
 
-请分析这次交互，输出一份改进报告，包含以下部分：
+请分析这次交互，输出一份改进报告，按给定的JSON结构填写以下部分：
 
-1. 交互概要：本次交互的目标、经历的主要阶段（轮次）、最终结果。
-2. 做得好的地方：指出模型或用户的有效行为，以及工具机制中运转良好的部分。
-3. 问题清单：列出所有问题——错误、重试、接口调用错误、格式错误的块、未被应用的修改、浪费的轮次、低效的交互。
-4. 根因分析：对每个问题，分析其最可能的根因：系统提示词不清晰、工具行为缺陷、模型策略失误、还是用户指令问题。
-5. 改进建议：给出具体、可操作的改进建议。每一项必须说明：改进什么、应该改在哪里（哪个系统提示词、哪个工具机制、哪种使用方式）、预期效果。
+1. 交互概要（overview）：本次交互的目标、经历的主要阶段（轮次）、最终结果。
+2. 做得好的地方（strengths）：指出模型或用户的有效行为，以及工具机制中运转良好的部分，每项一条。
+3. 问题清单（problems）：列出所有问题——错误、重试、接口调用错误、格式错误的块、未被应用的修改、浪费的轮次、低效的交互，每个问题一项，填写 problem 字段。
+4. 根因分析（problems[].root_cause）：对每个问题，分析其最可能的根因：系统提示词不清晰、工具行为缺陷、模型策略失误、还是用户指令问题。
+5. 改进建议（problems[].suggestion）：给出具体、可操作的改进建议。每一项必须说明：改进什么、应该改在哪里（哪个系统提示词、哪个工具机制、哪种使用方式）、预期效果。
 
 要求：
 - 具体：引用记录中的实际事件（轮次、块类型、错误信息），不要泛泛而谈。
-- 优先分析反复出现或阻碍进展的问题。
-- 使用简体中文，输出易读的纯文本，不要使用markdown格式符号，不要生成表格。`
+- 优先分析反复出现或阻碍进展的问题，按重要程度排列。
+- 使用简体中文，字段内容为易读的纯文本，不要使用markdown格式符号，不要生成表格。`
+
+// analysisReport is the structured analysis requested from the model with
+// generators.GenerateJSON.
+type analysisReport struct {
+	Overview  string            `json:"overview"`
+	Strengths []string          `json:"strengths"`
+	Problems  []analysisProblem `json:"problems"`
+}
+
+type analysisProblem struct {
+	Problem    string `json:"problem"`
+	RootCause  string `json:"root_cause"`
+	Suggestion string `json:"suggestion"`
+}
+
+// writeAnalysisReport renders the report as plain text in the section order
+// of analysisSystemPrompt.
+func writeAnalysisReport(w io.Writer, report analysisReport) error {
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "交互概要\n%s\n\n", report.Overview)
+	b.WriteString("做得好的地方\n")
+	for i, s := range report.Strengths {
+		fmt.Fprintf(b, "%d. %s\n", i+1, s)
+	}
+	b.WriteString("\n问题清单\n")
+	for i, p := range report.Problems {
+		fmt.Fprintf(b, "%d. %s\n   根因分析：%s\n   改进建议：%s\n", i+1, p.Problem, p.RootCause, p.Suggestion)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
 
 // runAnalysis renders the selected session as a transcript and sends it to
 // the model with the analysis system prompt. A session id of 0 selects the
-// most recent session. The generation runs as a single round using the
-// provided generator and phase builder; the analysis is written to output.
+// most recent session. The report is generated as JSON with
+// generators.GenerateJSON and written to output as plain text.
 // See TheoryOfInteractionRecording.
 func runAnalysis(
 	ctx context.Context,
 	generator generators.Generator,
-	buildGenerate phases.BuildGenerate,
 	recorder *Recorder,
 	sessionID int64,
+	options *generators.GenerateOptions,
 	output io.Writer,
 ) error {
 	if sessionID == 0 {
@@ -54,8 +88,7 @@ func runAnalysis(
 		return err
 	}
 
-	var state generators.State
-	state = generators.NewPrompts(
+	state := generators.NewPrompts(
 		analysisSystemPrompt,
 		[]*generators.Content{
 			{
@@ -66,35 +99,99 @@ func runAnalysis(
 			},
 		},
 	)
-	state = generators.NewOutput(state, output, true)
+	report, err := generators.GenerateJSON[analysisReport](ctx, generator, state, options)
+	if err != nil {
+		return err
+	}
+	return writeAnalysisReport(output, report)
+}
 
-	phase := buildGenerate(generator, nil)(nil)
-	for phase != nil {
-		var err error
-		phase, state, err = phase(ctx, state)
-		if err != nil {
+// runAnalyses analyzes several sessions concurrently, so that with batch
+// execution the requests share a batch job (see generators.TheoryOfBatch),
+// and writes the reports in the given order, each under a session header.
+// A failed session is reported in its section and does not stop the others.
+func runAnalyses(
+	ctx context.Context,
+	generator generators.Generator,
+	recorder *Recorder,
+	sessionIDs []int64,
+	options *generators.GenerateOptions,
+	output io.Writer,
+) error {
+	outputs := make([]bytes.Buffer, len(sessionIDs))
+	errs := make([]error, len(sessionIDs))
+	var wg sync.WaitGroup
+	for i, id := range sessionIDs {
+		wg.Go(func() {
+			errs[i] = runAnalysis(ctx, generator, recorder, id, options, &outputs[i])
+		})
+	}
+	wg.Wait()
+
+	for i, id := range sessionIDs {
+		if i > 0 {
+			if _, err := io.WriteString(output, "\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(output, "=== 会话 %d ===\n", id); err != nil {
+			return err
+		}
+		if errs[i] != nil {
+			errs[i] = fmt.Errorf("session %d: %w", id, errs[i])
+			if _, err := fmt.Fprintf(output, "分析失败：%v\n", errs[i]); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := output.Write(outputs[i].Bytes()); err != nil {
 			return err
 		}
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
-// RunAnalysis analyzes a recorded session with the model to seek
-// improvements. The generator, phase builder, and recorder are bound from
+// RunAnalysis analyzes recorded sessions with the model to seek
+// improvements. The generator and recorder are bound from
 // the dscope scope, so callers pass only the runtime values (context, the
-// session id, and the output writer). See TheoryOfInteractionRecording.
-type RunAnalysis func(ctx context.Context, sessionID int64, output io.Writer) error
+// session ids, and the output writer). No ids selects the most recent
+// session; several ids are analyzed together, as batch jobs when
+// generators.Batch is set. See TheoryOfInteractionRecording.
+type RunAnalysis func(ctx context.Context, sessionIDs []int64, output io.Writer) error
 
 func (Module) RunAnalysis(
 	recorder *Recorder,
 	getDefaultGenerator generators.GetDefaultGenerator,
-	buildGenerate phases.BuildGenerate,
+	batch generators.Batch,
 ) RunAnalysis {
-	return func(ctx context.Context, sessionID int64, output io.Writer) error {
+	return func(ctx context.Context, sessionIDs []int64, output io.Writer) error {
 		generator, err := getDefaultGenerator()
 		if err != nil {
 			return err
 		}
-		return runAnalysis(ctx, generator, buildGenerate, recorder, sessionID, output)
+		var options *generators.GenerateOptions
+		if batch {
+			options = &generators.GenerateOptions{
+				Batch: true,
+			}
+		}
+		if len(sessionIDs) <= 1 {
+			var id int64
+			if len(sessionIDs) == 1 {
+				id = sessionIDs[0]
+			}
+			return runAnalysis(ctx, generator, recorder, id, options, output)
+		}
+		return runAnalyses(ctx, generator, recorder, sessionIDs, options, output)
+	}
+}
+
+// RecentSessionIDs returns the ids of the n most recent sessions, most
+// recent first. The recorder is bound from the dscope scope.
+type RecentSessionIDs func(n int) ([]int64, error)
+
+func (Module) RecentSessionIDs(recorder *Recorder) RecentSessionIDs {
+	return func(n int) ([]int64, error) {
+		return recentSessionIDs(recorder, n)
 	}
 }
@@ -3,6 +3,7 @@ package records
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -10,7 +11,6 @@ import (
 	"github.com/reusee/dscope"
 	"github.com/reusee/tai/generators"
 	"github.com/reusee/tai/modes"
-	"github.com/reusee/tai/phases"
 )
 
 type analysisMockGenerator struct{}
@@ -27,7 +27,7 @@ func (analysisMockGenerator) Generate(ctx context.Context, state generators.Stat
 	return state.AppendContent(&generators.Content{
 		Role: generators.RoleAssistant,
 		Parts: []generators.Part{
-			generators.Text("analysis report output"),
+			generators.Text("```json\n" + `{"overview": "analysis report output", "strengths": ["s1"], "problems": [{"problem": "p1", "root_cause": "r1", "suggestion": "fix1"}]}` + "\n```"),
 		},
 	})
 }
@@ -38,29 +38,13 @@ func TestRunAnalysis(t *testing.T) {
 		new(Module),
 		// RunAnalysis provider 的依赖必须在 new(Module) 同一层已定义，
 		// 否则 dscope.New 校验 records.Module 时会因缺少
-		// generators.GetDefaultGenerator / phases.BuildGenerate 而 panic。
+		// generators.GetDefaultGenerator 而 panic。
 		func() generators.GetDefaultGenerator {
 			return func() (generators.Generator, error) {
 				return analysisMockGenerator{}, nil
 			}
 		},
-		func() phases.BuildGenerate {
-			// The stub's phase must invoke generator.Generate, mirroring
-			// phases.BuildGenerate: runAnalysis drives the returned phase
-			// chain, and the mock generator's output reaches the buffer
-			// through the Output state layer only when Generate runs.
-			return func(generator generators.Generator, options *generators.GenerateOptions) phases.PhaseBuilder {
-				return func(cont phases.Phase) phases.Phase {
-					return func(ctx context.Context, state generators.State) (phases.Phase, generators.State, error) {
-						newState, err := generator.Generate(ctx, state, options)
-						if err != nil {
-							return nil, state, err
-						}
-						return cont, newState, nil
-					}
-				}
-			}
-		},
+		stubBatch,
 	).Fork(
 		// 覆盖 defs 位于独立 Fork 层，避免与 new(Module) 同层重复定义。
 		func() DBPath {
@@ -88,11 +72,84 @@ func TestRunAnalysis(t *testing.T) {
 		}
 
 		var buf bytes.Buffer
-		if err := runAnalysis(context.Background(), id, &buf); err != nil {
+		if err := runAnalysis(context.Background(), []int64{id}, &buf); err != nil {
 			t.Fatal(err)
 		}
-		if !strings.Contains(buf.String(), "analysis report output") {
-			t.Fatalf("expected analysis output, got: %s", buf.String())
+		for _, want := range []string{
+			"analysis report output",
+			"1. s1",
+			"1. p1",
+			"根因分析：r1",
+			"改进建议：fix1",
+		} {
+			if !strings.Contains(buf.String(), want) {
+				t.Fatalf("expected %q in analysis output, got: %s", want, buf.String())
+			}
+		}
+	})
+}
+
+// batchCheckGenerator fails requests that are not batch requests.
+type batchCheckGenerator struct {
+	analysisMockGenerator
+}
+
+func (g batchCheckGenerator) Generate(ctx context.Context, state generators.State, options *generators.GenerateOptions) (generators.State, error) {
+	if options == nil || !options.Batch {
+		return nil, fmt.Errorf("expecting a batch request")
+	}
+	return g.analysisMockGenerator.Generate(ctx, state, options)
+}
+
+func TestRunAnalysisRecentSessions(t *testing.T) {
+	dscope.New(
+		modes.ForTest(t),
+		new(Module),
+		func() generators.GetDefaultGenerator {
+			return func() (generators.Generator, error) {
+				return batchCheckGenerator{}, nil
+			}
+		},
+		stubBatch,
+	).Fork(
+		func() DBPath {
+			return DBPath(filepath.Join(t.TempDir(), "test.db"))
+		},
+		func() Enabled {
+			return Enabled(true)
+		},
+		func() generators.Batch {
+			return true
+		},
+	).Call(func(recorder *Recorder, runAnalysis RunAnalysis, recentSessionIDs RecentSessionIDs) {
+		for range 3 {
+			recorder.StartSession("test")
+			recorder.EndSession(nil)
+		}
+
+		ids, err := recentSessionIDs(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ids) != 2 || ids[0] <= ids[1] {
+			t.Fatalf("expecting the 2 most recent sessions, most recent first, got %v", ids)
+		}
+
+		var buf bytes.Buffer
+		if err := runAnalysis(context.Background(), append(ids, 999), &buf); err == nil {
+			t.Fatal("expecting error for the missing session")
+		}
+		out := buf.String()
+		first := strings.Index(out, fmt.Sprintf("=== 会话 %d ===", ids[0]))
+		second := strings.Index(out, fmt.Sprintf("=== 会话 %d ===", ids[1]))
+		if first < 0 || second < first {
+			t.Fatalf("expecting reports in order, got: %s", out)
+		}
+		if strings.Count(out, "analysis report output") != 2 {
+			t.Fatalf("expecting two reports, got: %s", out)
+		}
+		if !strings.Contains(out, "=== 会话 999 ===\n分析失败") {
+			t.Fatalf("expecting the failure in its section, got: %s", out)
 		}
 	})
 }
@@ -110,3 +167,18 @@ func TestAnalysisSystemPromptContent(t *testing.T) {
 		}
 	}
 }
+
+func TestLastSessionsFlag(t *testing.T) {
+	for _, arg := range []string{"0", "-1"} {
+		if _, _, err := LastSessions(0).Handle("-last", []string{arg}); err == nil {
+			t.Fatalf("expected error for %s", arg)
+		}
+	}
+	v, rest, err := LastSessions(0).Handle("-last", []string{"3", "next"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *v.(*LastSessions) != 3 || len(rest) != 1 {
+		t.Fatalf("got %v %v", v, rest)
+	}
+}
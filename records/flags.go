@@ -82,3 +82,34 @@ func (l SessionLimit) Handle(key string, args []string) (newDef any, remainArgs
 	ret := SessionLimit(n)
 	return &ret, args[1:], nil
 }
+
+// LastSessions makes the analysis mode analyze the n most recent sessions
+// instead of a single one. Zero analyzes the session selected by SessionID.
+type LastSessions int
+
+func (Module) LastSessions() LastSessions {
+	return 0
+}
+
+var _ flags.Flag = LastSessions(0)
+
+func (l LastSessions) Keys() map[string]string {
+	return map[string]string{
+		"-last": "Analyze the N most recent sessions (with -analyze)",
+	}
+}
+
+func (l LastSessions) Handle(key string, args []string) (newDef any, remainArgs []string, err error) {
+	if len(args) == 0 {
+		return nil, nil, fmt.Errorf("expecting int argument, got empty")
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	if n < 1 {
+		return nil, nil, fmt.Errorf("-last expects a positive number of sessions, got %d", n)
+	}
+	ret := LastSessions(n)
+	return &ret, args[1:], nil
+}
@@ -16,6 +16,9 @@ type SessionInfo struct {
 	Status     string
 	Error      string
 	EventCount int
+	// Cost is the sum of the session's recorded round costs, or nil when
+	// no cost was recorded. See generators.TheoryOfPricing.
+	Cost *float64
 }
 
 // listSessions writes a table of recent sessions, most recent first, to
@@ -25,7 +28,8 @@ func listSessions(recorder *Recorder, limit int, output io.Writer) error {
 		return fmt.Errorf("interaction database not available")
 	}
 	rows, err := recorder.db.Query(`
-SELECT s.id, s.command, s.start_time, COALESCE(s.end_time, ''), s.status, COALESCE(s.error, ''), COUNT(e.id)
+SELECT s.id, s.command, s.start_time, COALESCE(s.end_time, ''), s.status, COALESCE(s.error, ''), COUNT(e.id),
+    SUM(CASE WHEN e.type = 'cost' THEN CAST(e.detail AS REAL) END)
 FROM sessions s
 LEFT JOIN events e ON e.session_id = s.id
 GROUP BY s.id
@@ -35,13 +39,19 @@ LIMIT ?`, limit)
 		return err
 	}
 	defer rows.Close()
-	fmt.Fprintf(output, "%-6s %-10s %-20s %-8s %6s\n", "ID", "Command", "Start", "Status", "Events")
+	fmt.Fprintf(output, "%-6s %-10s %-20s %-8s %6s %10s\n", "ID", "Command", "Start", "Status", "Events", "Cost")
 	for rows.Next() {
 		var info SessionInfo
-		if err := rows.Scan(&info.ID, &info.Command, &info.StartTime, &info.EndTime, &info.Status, &info.Error, &info.EventCount); err != nil {
+		var cost sql.NullFloat64
+		if err := rows.Scan(&info.ID, &info.Command, &info.StartTime, &info.EndTime, &info.Status, &info.Error, &info.EventCount, &cost); err != nil {
 			return err
 		}
-		fmt.Fprintf(output, "%-6d %-10s %-20s %-8s %6d\n", info.ID, info.Command, info.StartTime, info.Status, info.EventCount)
+		costText := "-"
+		if cost.Valid {
+			info.Cost = &cost.Float64
+			costText = fmt.Sprintf("%.4f", cost.Float64)
+		}
+		fmt.Fprintf(output, "%-6d %-10s %-20s %-8s %6d %10s\n", info.ID, info.Command, info.StartTime, info.Status, info.EventCount, costText)
 	}
 	return rows.Err()
 }
@@ -56,10 +66,13 @@ func Transcript(recorder *Recorder, sessionID int64) (string, error) {
 		return "", fmt.Errorf("interaction database not available")
 	}
 	var command, startTime, endTime, status, errMsg string
+	var cost sql.NullFloat64
 	err := recorder.db.QueryRow(
-		`SELECT command, start_time, COALESCE(end_time, ''), status, COALESCE(error, '') FROM sessions WHERE id = ?`,
+		`SELECT command, start_time, COALESCE(end_time, ''), status, COALESCE(error, ''),
+    (SELECT SUM(CAST(detail AS REAL)) FROM events WHERE session_id = sessions.id AND type = 'cost')
+FROM sessions WHERE id = ?`,
 		sessionID,
-	).Scan(&command, &startTime, &endTime, &status, &errMsg)
+	).Scan(&command, &startTime, &endTime, &status, &errMsg, &cost)
 	if err != nil {
 		return "", err
 	}
@@ -82,6 +95,9 @@ func Transcript(recorder *Recorder, sessionID int64) (string, error) {
 	if errMsg != "" {
 		fmt.Fprintf(&b, "error: %s\n", errMsg)
 	}
+	if cost.Valid {
+		fmt.Fprintf(&b, "cost: %.4f\n", cost.Float64)
+	}
 
 	for rows.Next() {
 		var round int
@@ -131,6 +147,28 @@ func latestSessionID(recorder *Recorder) (int64, error) {
 	return id, err
 }
 
+// recentSessionIDs returns the ids of the n most recent sessions, most
+// recent first.
+func recentSessionIDs(recorder *Recorder, n int) ([]int64, error) {
+	if recorder == nil || recorder.db == nil {
+		return nil, fmt.Errorf("interaction database not available")
+	}
+	rows, err := recorder.db.Query(`SELECT id FROM sessions ORDER BY id DESC LIMIT ?`, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
 // ShowSession writes the transcript of a session to output. The recorder
 // is bound from the dscope scope, so callers pass only the runtime values
 // (the session id and the output writer). See
@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -71,12 +72,13 @@ The event stream is open-ended: Recorder.Event captures arbitrary typed
 events (type + detail) alongside the structured lifecycle events. The
 generation loop records flow decisions — retries with attempt counts,
 parse-error corrections, component-triggered rounds, generator selection,
-and the command line — and generator implementations record API-level
-events (api_call, api_error) through the dscope-injected
-generators.EventRecorder (see generators.TheoryOfEventRecorder). Together
-these capture errors returned by model APIs and important pipeline
-decisions that would otherwise be visible only in logs, giving the
-analysis pass a complete view of what happened during the interaction.
+the command line, and the cost of each priced round — and generator
+implementations record API-level events (api_call, api_error) through the
+dscope-injected generators.EventRecorder (see
+generators.TheoryOfEventRecorder). Together these capture errors returned
+by model APIs and important pipeline decisions that would otherwise be
+visible only in logs, giving the analysis pass a complete view of what
+happened during the interaction.
 `
 
 // DBPath is the path of the interaction sqlite database file. The default
@@ -389,6 +391,18 @@ func (r *Recorder) Event(typ string, detail string) {
 	r.insertEventLocked(r.round, typ, detail)
 }
 
+// Cost records the cost of the current round as a "cost" event whose detail
+// is the decimal amount, so listings can sum the spend of a session. See
+// generators.TheoryOfPricing.
+func (r *Recorder) Cost(cost float64) {
+	if !r.Enabled() {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.insertEventLocked(r.round, "cost", strconv.FormatFloat(cost, 'f', -1, 64))
+}
+
 func (r *Recorder) insertEventLocked(round int, typ, detail string) {
 	if r.db == nil || r.sessionID == 0 {
 		return
@@ -430,6 +444,8 @@ func contentDetail(content *generators.Content) string {
 			if p.Error != nil {
 				parts = append(parts, "[error] "+p.Error.Error())
 			}
+		case generators.GeneratorFallback:
+			parts = append(parts, fmt.Sprintf("[fallback] %s -> %s: %s", p.From, p.To, p.Error))
 		case generators.FileURL:
 			parts = append(parts, "[file] "+string(p))
 		case generators.FileContent:
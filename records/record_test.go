@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/base64"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
@@ -11,7 +12,6 @@ import (
 	"github.com/reusee/tai/blocks"
 	"github.com/reusee/tai/generators"
 	"github.com/reusee/tai/modes"
-	"github.com/reusee/tai/phases"
 )
 
 // stubGetDefaultGenerator satisfies dscope.New validation for
@@ -25,16 +25,10 @@ func stubGetDefaultGenerator() generators.GetDefaultGenerator {
 	}
 }
 
-// stubBuildGenerate satisfies dscope.New validation for records.Module's
-// RunAnalysis provider, which depends on phases.BuildGenerate. The stub
-// returns a pass-through phase; it is never invoked by non-analysis
-// tests. See TheoryOfInteractionRecording.
-func stubBuildGenerate() phases.BuildGenerate {
-	return func(generator generators.Generator, options *generators.GenerateOptions) phases.PhaseBuilder {
-		return func(cont phases.Phase) phases.Phase {
-			return cont
-		}
-	}
+// stubBatch satisfies the generators.Batch dependency of the RunAnalysis
+// provider, like stubGetDefaultGenerator.
+func stubBatch() generators.Batch {
+	return false
 }
 
 func withRecorder(t *testing.T, enabled bool, fn func(*Recorder)) {
@@ -43,7 +37,7 @@ func withRecorder(t *testing.T, enabled bool, fn func(*Recorder)) {
 		modes.ForTest(t),
 		new(Module),
 		stubGetDefaultGenerator,
-		stubBuildGenerate,
+		stubBatch,
 	).Fork(
 		func() DBPath {
 			return DBPath(filepath.Join(t.TempDir(), "test.db"))
@@ -270,7 +264,7 @@ func TestRecorderNilWhenDBUnavailable(t *testing.T) {
 		modes.ForTest(t),
 		new(Module),
 		stubGetDefaultGenerator,
-		stubBuildGenerate,
+		stubBatch,
 	).Fork(
 		func() DBPath { return "" },
 		func() Enabled { return Enabled(true) },
@@ -318,7 +312,7 @@ func TestListSessions(t *testing.T) {
 			t.Fatal(err)
 		}
 		out := buf.String()
-		for _, want := range []string{"first", "second", "ID", "Command", "Status"} {
+		for _, want := range []string{"first", "second", "ID", "Command", "Status", "Cost"} {
 			if !strings.Contains(out, want) {
 				t.Fatalf("listing missing %q:\n%s", want, out)
 			}
@@ -326,6 +320,36 @@ func TestListSessions(t *testing.T) {
 	})
 }
 
+func TestRecorderCost(t *testing.T) {
+	withRecorder(t, true, func(recorder *Recorder) {
+		recorder.StartSession("priced")
+		recorder.RoundStart()
+		recorder.Cost(0.125)
+		recorder.RoundSuccess(nil)
+		recorder.RoundStart()
+		recorder.Cost(0.25)
+		recorder.RoundSuccess(nil)
+		recorder.EndSession(nil)
+
+		var buf bytes.Buffer
+		if err := listSessions(recorder, 10, &buf); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), "0.3750") {
+			t.Fatalf("listing must show the session spend:\n%s", buf.String())
+		}
+		text, err := Transcript(recorder, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{"cost: 0.3750", "round 2 [cost]"} {
+			if !strings.Contains(text, want) {
+				t.Fatalf("transcript missing %q:\n%s", want, text)
+			}
+		}
+	})
+}
+
 func TestLatestSessionID(t *testing.T) {
 	withRecorder(t, true, func(recorder *Recorder) {
 		id, err := latestSessionID(recorder)
@@ -388,3 +412,72 @@ func TestRecorderEvent(t *testing.T) {
 		}
 	})
 }
+
+func TestReplayInteractions(t *testing.T) {
+	withRecorder(t, true, func(recorder *Recorder) {
+		recorder.StartSession("test")
+		recorder.SystemPrompt("system")
+		question := &generators.Content{
+			Role:  generators.RoleUser,
+			Parts: []generators.Part{generators.Text("question"), generators.Text("details")},
+		}
+		recorder.Content(question)
+		recorder.RoundStart()
+		recorder.Content(&generators.Content{Role: generators.RoleModel, Parts: []generators.Part{generators.Thought("thinking")}})
+		recorder.Content(&generators.Content{Role: generators.RoleModel, Parts: []generators.Part{generators.Text("first ")}})
+		recorder.Content(&generators.Content{Role: generators.RoleModel, Parts: []generators.Part{generators.Text("answer")}})
+		var usage generators.Usage
+		usage.Prompt.TokenCount = 5
+		recorder.Content(&generators.Content{Role: generators.RoleLog, Parts: []generators.Part{usage}})
+		recorder.Content(&generators.Content{Role: generators.RoleLog, Parts: []generators.Part{generators.FinishReason("stop")}})
+		followUp := &generators.Content{
+			Role:  generators.RoleUser,
+			Parts: []generators.Part{generators.Text("more")},
+		}
+		recorder.Content(followUp)
+		recorder.RoundStart()
+		recorder.Content(&generators.Content{Role: generators.RoleModel, Parts: []generators.Part{
+			generators.FuncCall{Name: "f", Arguments: map[string]any{"a": 1}},
+		}})
+		recorder.EndSession(nil)
+
+		interactions, err := recorder.ReplayInteractions(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(interactions) != 2 {
+			t.Fatalf("got %d interactions", len(interactions))
+		}
+
+		// keys match the merged state the live generator saw
+		live := []*generators.Content{question}
+		if interactions[0].Key != generators.ReplayKey("system", slices.Values(live)) {
+			t.Fatal("first key does not match the live request")
+		}
+		first := interactions[0]
+		if first.Err != nil || len(first.Contents) != 5 {
+			t.Fatalf("got %+v", first)
+		}
+		if first.Contents[0].Parts[0] != generators.Thought("thinking") ||
+			first.Contents[2].Parts[0] != generators.Text("answer") ||
+			first.Contents[3].Parts[0] != usage ||
+			first.Contents[4].Parts[0] != generators.FinishReason("stop") {
+			t.Fatalf("got %+v", first.Contents)
+		}
+
+		live = append(live,
+			&generators.Content{Role: generators.RoleModel, Parts: []generators.Part{
+				generators.Thought("thinking"),
+				generators.Text("first answer"),
+			}},
+			followUp,
+		)
+		second := interactions[1]
+		if second.Key != generators.ReplayKey("system", slices.Values(live)) {
+			t.Fatal("second key does not match the live request")
+		}
+		if second.Err == nil {
+			t.Fatal("function calls must not be replayable from a transcript")
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package records
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/reusee/tai/generators"
+)
+
+var _ generators.ReplaySessions = (*Recorder)(nil)
+
+var errNotReplayable = errors.New("the transcript does not preserve function calls; record a cassette to replay them")
+
+// ReplayInteractions reconstructs the generator interactions of a session
+// from its content events, so a replay generator can serve them. Each run
+// of model content recorded in a round is the response to the contents
+// recorded before it; a system_prompt event starts a new conversation.
+// Contents recorded before the first round are session context, never
+// responses. See generators.TheoryOfReplay.
+func (r *Recorder) ReplayInteractions(sessionID int64) ([]generators.ReplayInteraction, error) {
+	if r == nil || r.db == nil {
+		return nil, fmt.Errorf("interaction database not available")
+	}
+	rows, err := r.db.Query(
+		`SELECT round, type, detail FROM events
+WHERE session_id = ? AND (type = 'system_prompt' OR type LIKE 'content_%')
+ORDER BY id`,
+		sessionID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ret []generators.ReplayInteraction
+	var systemPrompt string
+	var contents []*generators.Content
+	var current *generators.ReplayInteraction
+	closeCurrent := func() {
+		if current == nil {
+			return
+		}
+		ret = append(ret, *current)
+		contents = append(contents, current.Contents...)
+		current = nil
+	}
+
+	for rows.Next() {
+		var round int
+		var typ, detail string
+		if err := rows.Scan(&round, &typ, &detail); err != nil {
+			return nil, err
+		}
+		if typ == "system_prompt" {
+			closeCurrent()
+			systemPrompt = detail
+			contents = nil
+			continue
+		}
+		role := generators.Role(strings.TrimPrefix(typ, "content_"))
+		content, replayable := parseContentDetail(role, detail)
+		isResponse := round > 0 &&
+			(role == generators.RoleModel || role == generators.RoleAssistant ||
+				(role == generators.RoleLog && current != nil))
+		if !isResponse {
+			closeCurrent()
+			contents = append(contents, content)
+			continue
+		}
+		if current == nil {
+			current = &generators.ReplayInteraction{
+				Key:    generators.ReplayKey(systemPrompt, slices.Values(contents)),
+				Source: fmt.Sprintf("session %d round %d", sessionID, round),
+			}
+		}
+		current.Contents = append(current.Contents, content)
+		if !replayable {
+			current.Err = errNotReplayable
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	closeCurrent()
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("session %d has no recorded responses", sessionID)
+	}
+	return ret, nil
+}
+
+// parseContentDetail reverses contentDetail as far as the transcript
+// allows: thoughts, trailing finish reasons and usage are restored as their
+// parts, errors and fallback notes are dropped, and everything else is
+// text. replayable is false when the detail holds a function call, whose
+// arguments the transcript does not preserve.
+func parseContentDetail(role generators.Role, detail string) (content *generators.Content, replayable bool) {
+	content = &generators.Content{
+		Role: role,
+	}
+	replayable = !strings.HasPrefix(detail, "[function call] ") &&
+		!strings.Contains(detail, "\n[function call] ")
+	if thought, ok := strings.CutPrefix(detail, "[thought]\n"); ok {
+		content.Parts = append(content.Parts, generators.Thought(thought))
+		return
+	}
+	lines := strings.Split(detail, "\n")
+	var trailing []generators.Part
+	for len(lines) > 0 {
+		line := lines[len(lines)-1]
+		if reason, ok := strings.CutPrefix(line, "[finish] "); ok {
+			trailing = append(trailing, generators.FinishReason(reason))
+		} else if strings.HasPrefix(line, "[usage] ") {
+			var usage generators.Usage
+			if _, err := fmt.Sscanf(line, "[usage] prompt=%d cached=%d completion=%d thoughts=%d",
+				&usage.Prompt.TokenCount, &usage.Prompt.TokenCountCached,
+				&usage.Candidates.TokenCount, &usage.Thoughts.TokenCount); err != nil {
+				break
+			}
+			trailing = append(trailing, usage)
+		} else if !strings.HasPrefix(line, "[error] ") && !strings.HasPrefix(line, "[fallback] ") {
+			break
+		}
+		lines = lines[:len(lines)-1]
+	}
+	if text := strings.Join(lines, "\n"); text != "" {
+		content.Parts = append(content.Parts, generators.Text(text))
+	}
+	slices.Reverse(trailing)
+	content.Parts = append(content.Parts, trailing...)
+	return
+}
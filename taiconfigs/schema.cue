@@ -66,6 +66,10 @@ temperature?: float
 // apply controls whether change blocks are applied to the working tree during generation.
 apply?: bool
 
+// approve asks for the approval of each hunk of a round's changes before
+// they are written to the working tree.
+approve?: bool
+
 // plan enables mandatory planning and multi-round generation.
 plan?: bool
 
@@ -84,12 +88,31 @@ record?: bool
 // models when enabled.
 confidential_mode?: bool
 
+// max_session_tokens stops a session, including every loop of a goal run,
+// after this many prompt, completion and thought tokens. 0 means no limit.
+max_session_tokens?: int & >=0
+
+// max_session_cost stops a session after spending this much, priced with
+// the generator prices (input_price, output_price, ...). 0 means no limit.
+max_session_cost?: number & >=0
+
 // review enables a review loop after generation to review and fix changes.
 review?: bool
 
 // review_models lists the models used for the review loop, in order.
 review_models?: [...string]
 
+// review_batch runs the review sessions as provider batch jobs.
+review_batch?: bool
+
+// batch runs requests that can wait (record analysis, queued review) as
+// provider batch jobs at batch prices.
+batch?: bool
+
+// candidates runs one generation per model concurrently and keeps the
+// result that passes the tests with the best reviewer score.
+candidates?: [...string]
+
 // ignore excludes files or patterns from the context.
 ignore?: [...string]
 
@@ -134,6 +157,14 @@ go?: {
 	// show_token_counts, if true, displays token counts for each included file.
 	show_token_counts?: bool
 
+	// typecheck, if true, type-checks the packages a round changes before
+	// the changes are written, retrying the round on errors.
+	typecheck?: bool
+
+	// test_then_commit, if true, writes the changes of a round with go-test
+	// blocks only when the tests pass.
+	test_then_commit?: bool
+
 	// envs provides additional environment variables for the 'go list' command.
 	envs?: [...string]
 // extra_system_prompt provides Go-specific additional instructions to
@@ -154,6 +185,7 @@ go_envs?: [...string]
 // debug flags for individual modules.
 debug_gemini?: bool
 debug_openai?: bool
+debug_anthropic?: bool
 tap_openai?: bool
 debug_codes?: bool
 debug_gotools?: bool
@@ -164,7 +196,7 @@ debug_anytexts?: bool
 _gen: {
 	// name is the unique identifier for the generator.
 	name: string
-	// type specifies the generator type (e.g., "gemini", "openai", "deepseek").
+	// type specifies the generator type (e.g., "gemini", "openai", "responses", "deepseek", "anthropic").
 	type: string
 	// base_url is the API endpoint for the model.
 	base_url?: string
@@ -176,6 +208,17 @@ _gen: {
 	family?: string
 	// context_tokens is the maximum context window size for the model.
 	context_tokens?: int
+	// tokenizer selects how prompts are counted for context budgets:
+	// "o200k_base", "cl100k_base", "p50k_base" or "r50k_base" (tiktoken
+	// encodings), "gemini", "deepseek", or "api" for the provider's
+	// count-tokens endpoint (Gemini, Anthropic). Empty uses the provider's
+	// default.
+	tokenizer?: "" | "o200k_base" | "cl100k_base" | "p50k_base" | "r50k_base" | "gemini" | "deepseek" | "api"
+	// requests_per_minute and tokens_per_minute pace requests on the client
+	// side to stay within the provider's quota, shared by every generator of
+	// the spec in the process.
+	requests_per_minute?: int & >=0
+	tokens_per_minute?:   int & >=0
 	// max_generate_tokens is the maximum number of tokens to generate.
 	max_generate_tokens?: int
 	// max_thinking_tokens is the maximum number of tokens for reasoning/thinking.
@@ -186,6 +229,9 @@ _gen: {
 	disable_search?: bool
 	// disable_tools, if true, disables tool usage for the model.
 	disable_tools?: bool
+	// native_tools, if true, exposes go-src, request-context and go-test as
+	// native function calls instead of heredoc blocks. disable_tools wins.
+	native_tools?: bool
 	// is_open_router, if true, uses OpenRouter-specific request formatting.
 	is_open_router?: bool
 	// api_version specifies the API version for Azure deployments.
@@ -200,6 +246,13 @@ _gen: {
 	aliases?: [...string]
 	// redirect extends the resolved path with additional components.
 	redirect?: string
+	// random_redirect picks one of several redirect targets for load balancing.
+	random_redirect?: [...string]
+	// random_redirect_weights weights random_redirect entries, keyed by entry (default 1).
+	random_redirect_weights?: {[string]: number}
+	// fallback lists specs tried in order when this generator fails
+	// persistently, resolved with the same path rules as redirect.
+	fallback?: [...string]
 	// no_proxy, if true, bypasses the proxy for this generator.
 	no_proxy?: bool
 	// preserved_thinking, if true, sends reasoning thoughts back to the model in subsequent requests.
@@ -207,12 +260,83 @@ _gen: {
 // zero_data_retention, if true, marks the generator as retaining no input
 	// or output data, permitting its use in confidential mode.
 	zero_data_retention?: bool
+	// cache_control, if true, forwards cache_control markers at prompt cache
+	// breakpoints to OpenAI-compatible providers that accept them.
+	cache_control?: bool
+	// capabilities declares what the model accepts. Unset fields fall back
+	// to built-in defaults for known model families, then to supported.
+	// Unsupported attachments are replaced by text placeholders, a system
+	// prompt moves into the first user turn, and tools, response schemas and
+	// reasoning parameters are not sent.
+	capabilities?: {
+		vision?:        bool
+		pdf?:           bool
+		audio?:         bool
+		tools?:         bool
+		json_schema?:   bool
+		reasoning?:     bool
+		system_prompt?: bool
+	}
+	// input_price, cached_input_price, output_price and thinking_price are
+	// prices per million tokens, used to report the cost of each round.
+	// cached_input_price defaults to input_price and thinking_price to
+	// output_price.
+	input_price?:        number & >=0
+	cached_input_price?: number & >=0
+	output_price?:       number & >=0
+	thinking_price?:     number & >=0
+	// cassette is the recorded-responses file: a "replay" generator serves
+	// it, and a generator of any other type appends its interactions to it.
+	cassette?: string
+	// replay_session makes a "replay" generator serve the responses of a
+	// recorded interaction session instead of a cassette.
+	replay_session?: int & >0
 	// extra_arguments allows for provider-specific parameters.
 	extra_arguments?: {[string]: _}
 	// variants defines nested generator configurations that inherit parent fields.
 	variants?: [..._gen]
 }
 
+// response_cache configures the opt-in on-disk cache serving identical
+// requests without calling the provider.
+response_cache?: {
+	// enabled turns the cache on (also -response-cache).
+	enabled?: bool
+	// dir is the cache directory. Defaults to tai/responses in the user
+	// cache directory.
+	dir?: string
+	// ttl expires entries after a Go duration (e.g. "24h"). "0" never expires.
+	ttl?: string
+	// max_size_mb evicts least recently used entries beyond this size. 0
+	// means no limit. Defaults to 512.
+	max_size_mb?: int & >=0
+}
+
+// embedding_model names the generator spec used to embed package
+// documentation for relevance ranking of context packages (also
+// -embedding-model). "hash" selects a local hashing embedder.
+embedding_model?: string
+
+// token_calibration_file stores the per-generator ratios between the prompt
+// tokens reported by providers and the local estimate, applied to context
+// budgets (also -token-calibration-file). Defaults to
+// tai/token_calibration.json in the user cache directory.
+token_calibration_file?: string
+
+// undo configures the undo journal reverted by tai undo.
+undo?: {
+	// max_sessions is the number of most recent journal sessions kept. 0
+	// means no limit. Defaults to 50.
+	max_sessions?: int & >=0
+}
+
+// checkpoint configures the checkpoints resumed by tai resume.
+checkpoint?: {
+	// max_count is the number of most recent checkpoints kept. 0 means no
+	// limit. Defaults to 20.
+	max_count?: int & >=0
+}
+
 // generators defines a list of available AI model configurations.
 generators?: [..._gen]
 
@@ -258,6 +382,18 @@ functions?: [...{
   returns: [..._var]
 }]
 
+// mcp_servers configures Model Context Protocol servers launched over
+// stdio. Their tools are declared to generators that accept tools, named
+// "<server>__<tool>". Entries from multiple config files merge by name.
+mcp_servers?: [string]: {
+  // command is the program and its arguments.
+  command: [...string] & [_, ...]
+  // env holds extra environment variables for the server.
+  env?: [string]: string
+  // dir is the working directory of the server.
+  dir?: string
+}
+
 // thoughts_summarize_language sets the output language for thought summaries.
 // When empty (the default), no language hint is given to the summarizer.
 // When set (e.g., "zh", "en"), the summarizer is instructed to output